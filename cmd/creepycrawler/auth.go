@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+// redacted stands in for secrets wherever flag values are shown (usage output, and the config saved with a crawl)
+const redacted = "<redacted>"
+
+type authFlags struct {
+	// authFlags are the flags setting up authentication (crawl.AuthOptions).
+	// Every flag holding a secret redacts it from its String(), which is what flagConfig saves, so secrets never reach saved crawls.
+	options           *crawl.AuthOptions
+	loginUrl          *string
+	loginFields       url.Values
+	logoutPattern     *string
+	followLogoutLinks *bool
+
+	// problems are what was wrong with any secret flags' values, which are only reported by apply (see secretFlag)
+	problems []string
+}
+
+func addAuthFlags(flags *flag.FlagSet, options *crawl.AuthOptions) *authFlags {
+	// addAuthFlags registers the auth flags on the given flag set, filling in options as they're parsed.
+	a := &authFlags{options: options}
+
+	a.secretVar(flags, credentialsFlag{&options.Basic}, "basic-auth", "Send HTTP Basic credentials to a host: host=user:password (can be given more than once). "+secretHelp)
+	a.secretVar(flags, credentialsFlag{&options.Digest}, "digest-auth", "Answer a host's HTTP Digest challenges with these credentials: host=user:password (can be given more than once). "+secretHelp)
+	a.secretVar(flags, bearerFlag{&options.Bearer}, "bearer-token", "Send a bearer token to a host: host=token (can be given more than once). "+secretHelp)
+	a.loginUrl = flags.String("login-url", "", "Log in before crawling by posting the -login-field fields to this URL, and keep the session cookies.")
+	a.secretVar(flags, loginFieldFlag{&a.loginFields}, "login-field", "A field to post to -login-url: name=value (can be given more than once). "+secretHelp)
+	a.logoutPattern = flags.String("logout-pattern", crawl.DefaultLogoutPattern.String(), "Don't follow links whose path and query match this regular expression, so the crawl doesn't log itself out.")
+	a.followLogoutLinks = flags.Bool("follow-logout-links", false, "Follow links matching -logout-pattern anyway.")
+
+	return a
+}
+
+func (a *authFlags) secretVar(flags *flag.FlagSet, value flag.Value, name string, usage string) {
+	// secretVar registers a flag whose value holds a secret, wrapped in a secretFlag.
+	flags.Var(secretFlag{Value: value, name: name, problems: &a.problems}, name, usage)
+}
+
+type secretFlag struct {
+	// secretFlag wraps a flag holding a secret, so that a bad value doesn't get echoed back: the flag package prints
+	// the raw value of any flag whose Set fails ("invalid value "host=user:hunter2" for flag..."), so instead of failing,
+	// Set notes what was wrong (without the value), and authFlags.apply reports it once parsing's finished.
+	flag.Value
+	name     string
+	problems *[]string
+}
+
+func (s secretFlag) String() string {
+	if s.Value == nil {
+		return ""
+	}
+	return s.Value.String()
+}
+
+func (s secretFlag) Set(value string) error {
+	if err := s.Value.Set(value); err != nil {
+		*s.problems = append(*s.problems, fmt.Sprintf("-%s: %s", s.name, err))
+	}
+	return nil
+}
+
+// secretHelp explains the env: and file: forms secrets can be given in
+const secretHelp = "Secrets can be given as env:NAME or file:PATH to keep them off the command line."
+
+func (a *authFlags) apply() {
+	// apply fills in the parts of the auth options which can only be worked out once every flag has been parsed,
+	// bailing out if any of them are wrong.
+	for _, problem := range a.problems {
+		log.Printf("☠️ Bad %s", problem)
+	}
+	if len(a.problems) > 0 {
+		os.Exit(1)
+	}
+
+	if *a.loginUrl != "" {
+		a.options.FormLogin = &crawl.FormLogin{Url: *a.loginUrl, Fields: a.loginFields}
+	} else if len(a.loginFields) > 0 {
+		log.Fatalln("☠️ -login-field needs a -login-url to post to")
+	}
+
+	pattern, err := regexp.Compile(*a.logoutPattern)
+	if err != nil {
+		log.Fatalf("☠️ Bad -logout-pattern: %s", err)
+	}
+	a.options.LogoutPattern = pattern
+	a.options.FollowLogoutLinks = *a.followLogoutLinks
+}
+
+func secretValue(value string) (string, error) {
+	// secretValue reads a secret given on the command line: env:NAME reads it from an environment variable,
+	// file:PATH from a file (minus any trailing newline), and anything else is the secret itself.
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		secret, exists := os.LookupEnv(name)
+		if !exists || secret == "" {
+			return "", fmt.Errorf("environment variable %s isn't set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, "file:"):
+		data, err := os.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	return value, nil
+}
+
+func splitHostValue(value string) (string, string, error) {
+	// splitHostValue splits host=value.
+	host, rest, found := strings.Cut(value, "=")
+	if !found || host == "" {
+		return "", "", errors.New("should look like host=...")
+	}
+	return host, rest, nil
+}
+
+type credentialsFlag struct {
+	// credentialsFlag is a repeatable host=user:password flag.
+	credentials *map[string]crawl.Credentials
+}
+
+func (c credentialsFlag) String() string {
+	if c.credentials == nil {
+		return ""
+	}
+	var values []string
+	for host, credentials := range *c.credentials {
+		values = append(values, host+"="+credentials.String())
+	}
+	sort.Strings(values)
+	return strings.Join(values, ", ")
+}
+
+func (c credentialsFlag) Set(value string) error {
+	host, rest, err := splitHostValue(value)
+	if err != nil {
+		return err
+	}
+	username, password, found := strings.Cut(rest, ":")
+	if !found {
+		return errors.New("should look like host=user:password")
+	}
+	if password, err = secretValue(password); err != nil {
+		return err
+	}
+
+	if *c.credentials == nil {
+		*c.credentials = map[string]crawl.Credentials{}
+	}
+	(*c.credentials)[host] = crawl.Credentials{Username: username, Password: password}
+	return nil
+}
+
+type bearerFlag struct {
+	// bearerFlag is a repeatable host=token flag.
+	tokens *map[string]string
+}
+
+func (b bearerFlag) String() string {
+	if b.tokens == nil {
+		return ""
+	}
+	var values []string
+	for host := range *b.tokens {
+		values = append(values, host+"="+redacted)
+	}
+	sort.Strings(values)
+	return strings.Join(values, ", ")
+}
+
+func (b bearerFlag) Set(value string) error {
+	host, token, err := splitHostValue(value)
+	if err != nil {
+		return err
+	}
+	if token, err = secretValue(token); err != nil {
+		return err
+	}
+
+	if *b.tokens == nil {
+		*b.tokens = map[string]string{}
+	}
+	(*b.tokens)[host] = token
+	return nil
+}
+
+type loginFieldFlag struct {
+	// loginFieldFlag is a repeatable name=value flag for -login-url's form fields. Field values are all treated as secret,
+	// since there's no telling which of them is the password.
+	fields *url.Values
+}
+
+func (l loginFieldFlag) String() string {
+	if l.fields == nil {
+		return ""
+	}
+	var values []string
+	for name := range *l.fields {
+		values = append(values, name+"="+redacted)
+	}
+	sort.Strings(values)
+	return strings.Join(values, ", ")
+}
+
+func (l loginFieldFlag) Set(value string) error {
+	name, fieldValue, found := strings.Cut(value, "=")
+	if !found || name == "" {
+		return errors.New("should look like name=value")
+	}
+	fieldValue, err := secretValue(fieldValue)
+	if err != nil {
+		return err
+	}
+
+	if *l.fields == nil {
+		*l.fields = url.Values{}
+	}
+	l.fields.Add(name, fieldValue)
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+type cacheFlags struct {
+	// cacheFlags are the flags for the HTTP cache kept between crawls (crawl.HTTPCache).
+	dir      *string
+	maxBytes *int64
+	disabled *bool
+}
+
+func defaultCacheDir() string {
+	// defaultCacheDir is where the cache lives unless we're told otherwise: the user's cache directory (~/.cache on Linux),
+	// or a temporary directory if there isn't one.
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "creepycrawler", "http")
+}
+
+func addCacheFlags(flags *flag.FlagSet) *cacheFlags {
+	// addCacheFlags registers the cache flags on the given flag set.
+	return &cacheFlags{
+		dir:      flags.String("cache-dir", defaultCacheDir(), "Keep the HTTP cache in this directory. Pages which haven't changed since the last crawl are revalidated instead of downloaded and parsed again."),
+		maxBytes: flags.Int64("cache-size", crawl.DefaultCacheMaxBytes, "Let the HTTP cache grow to at most this many bytes (least recently used pages are dropped first)."),
+		disabled: flags.Bool("no-cache", false, "Don't use the HTTP cache at all: fetch every page in full, and don't store anything."),
+	}
+}
+
+func (c *cacheFlags) open() *crawl.HTTPCache {
+	// open opens the cache the flags describe, or returns nil if it's switched off. A cache which can't be opened
+	// isn't worth stopping the crawl for, so that only gets a warning.
+	if *c.disabled {
+		return nil
+	}
+	cache, err := crawl.OpenHTTPCache(*c.dir, *c.maxBytes)
+	if err != nil {
+		log.Printf("⚠️ Unable to open the HTTP cache in %s, crawling without it: %s", *c.dir, err)
+		return nil
+	}
+	log.Printf("📦 using the HTTP cache in %s (%d pages, %d bytes)", *c.dir, cache.Len(), cache.Size())
+	return cache
+}
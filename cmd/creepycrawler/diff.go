@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/luaduck/creepycrawler/pkg/analysis"
+)
+
+// regressionExitCode is the exit status diff uses when pages became broken. It's distinct from 1 (usage errors, and crawls which
+// couldn't be loaded or written), 2 (bad flags) and -fail-on-error's errorExitCodes, so CI can tell a regression from a broken pipeline.
+const regressionExitCode = 10
+
+func diffCommand(args []string) {
+	// diffCommand is the `diff` subcommand: it compares two crawls saved with -save and reports what changed.
+	// It exits with regressionExitCode if anything regressed (i.e. pages became broken), so that it can gate a deploy in CI.
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "Write the differences as JSON instead of text.")
+	path := flags.String("o", "", "Write output to this file instead of the console.")
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s diff [OPTIONS] OLD NEW\n", os.Args[0])
+		fmt.Printf("Compares two saved crawls, exiting with status %d if any pages became broken (or 1 if it couldn't compare them).\n", regressionExitCode)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	oldCrawl := loadCrawlOrExit(flags.Arg(0))
+	newCrawl := loadCrawlOrExit(flags.Arg(1))
+	diff := analysis.Diff(oldCrawl, newCrawl)
+
+	out := os.Stdout
+	if *path != "" {
+		var err error
+		if out, err = os.Create(*path); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	var err error
+	if *asJSON {
+		err = diff.WriteJSON(out)
+	} else {
+		err = diff.WriteText(out)
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *path != "" {
+		if err := out.Close(); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if diff.HasRegressions() {
+		log.Printf("❌ %d page(s) became broken", len(diff.NewlyBroken))
+		os.Exit(regressionExitCode)
+	}
+}
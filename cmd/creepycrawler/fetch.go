@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+type fetchFlags struct {
+	// fetchFlags are the flags tuning how pages are fetched (crawl.FetchOptions).
+	// They're shared between every command which crawls.
+	options     crawl.FetchOptions
+	cookiesPath *string
+	auth        *authFlags
+
+	dnsServer      *string
+	caBundlePath   *string
+	clientCertPath *string
+	clientKeyPath  *string
+}
+
+type proxyFlag struct {
+	// proxyFlag is the -proxy URL. Any user:password in it is redacted from String(), so it stays out of saved crawls.
+	proxy **url.URL
+}
+
+func (p proxyFlag) String() string {
+	if p.proxy == nil || *p.proxy == nil {
+		return ""
+	}
+	return (*p.proxy).Redacted()
+}
+
+func (p proxyFlag) Set(value string) error {
+	proxyUrl, err := url.Parse(value)
+	if err != nil {
+		// url.Parse quotes the URL in its errors, password and all
+		return errors.New("not a valid URL")
+	}
+	switch proxyUrl.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return errors.New("proxies should be http://, https:// or socks5://")
+	}
+	*p.proxy = proxyUrl
+	return nil
+}
+
+type resolveFlag struct {
+	// resolveFlag is a repeatable curl style -resolve host:port:address flag.
+	resolve *map[string]string
+}
+
+func (r resolveFlag) String() string {
+	if r.resolve == nil {
+		return ""
+	}
+	var overrides []string
+	for hostPort, address := range *r.resolve {
+		if strings.Contains(address, ":") {
+			address = "[" + address + "]"
+		}
+		overrides = append(overrides, hostPort+":"+address)
+	}
+	sort.Strings(overrides)
+	return strings.Join(overrides, ", ")
+}
+
+func (r resolveFlag) Set(value string) error {
+	hostPort, address, err := crawl.ParseResolve(value)
+	if err != nil {
+		return err
+	}
+	if *r.resolve == nil {
+		*r.resolve = map[string]string{}
+	}
+	(*r.resolve)[hostPort] = address
+	return nil
+}
+
+type listFlag struct {
+	// listFlag is a comma separated list flag, which can also be given more than once.
+	list *[]string
+}
+
+func (l listFlag) String() string {
+	if l.list == nil {
+		return ""
+	}
+	return strings.Join(*l.list, ",")
+}
+
+func (l listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l.list = append(*l.list, item)
+		}
+	}
+	return nil
+}
+
+type headerFlag struct {
+	// headerFlag is a repeatable -header "Name: value" flag, which adds to an http.Header.
+	header *http.Header
+}
+
+func (h headerFlag) String() string {
+	if h.header == nil {
+		return ""
+	}
+	var headers []string
+	for name, values := range *h.header {
+		for _, value := range values {
+			if sensitiveHeader(name) {
+				value = redacted
+			}
+			headers = append(headers, name+": "+value)
+		}
+	}
+	// map order is random, and this ends up in saved crawls' config, so keep it stable
+	sort.Strings(headers)
+	return strings.Join(headers, ", ")
+}
+
+func (h headerFlag) Set(value string) error {
+	name, headerValue, found := strings.Cut(value, ":")
+	if !found || strings.TrimSpace(name) == "" {
+		return errors.New(`headers should look like "Name: value"`)
+	}
+	if *h.header == nil {
+		*h.header = http.Header{}
+	}
+	h.header.Add(strings.TrimSpace(name), strings.TrimSpace(headerValue))
+	return nil
+}
+
+func addFetchFlags(flags *flag.FlagSet) *fetchFlags {
+	// addFetchFlags registers the fetch flags on the given flag set. They fill in fetchFlags.options directly,
+	// so once the flags are parsed, fetchOptions() is ready to use.
+	f := &fetchFlags{options: crawl.DefaultFetchOptions}
+	o := &f.options
+
+	flags.IntVar(&o.MaxConnsPerHost, "max-conns-per-host", o.MaxConnsPerHost, "Open at most this many connections to each host at once.")
+	flags.IntVar(&o.MaxIdleConnsPerHost, "max-idle-conns-per-host", o.MaxIdleConnsPerHost, "Keep at most this many idle connections to each host open for reuse.")
+	flags.DurationVar(&o.IdleConnTimeout, "idle-timeout", o.IdleConnTimeout, "Close kept-alive connections after they've been idle this long.")
+	flags.DurationVar(&o.DialTimeout, "dial-timeout", o.DialTimeout, "Give up connecting to a server after this long.")
+	flags.DurationVar(&o.TLSHandshakeTimeout, "tls-timeout", o.TLSHandshakeTimeout, "Give up on a TLS handshake after this long.")
+	flags.DurationVar(&o.HeaderTimeout, "header-timeout", o.HeaderTimeout, "Give up waiting for a response's headers after this long.")
+	flags.DurationVar(&o.BodyTimeout, "body-timeout", o.BodyTimeout, "Give up reading a response's body after this long.")
+	flags.Int64Var(&o.MaxBodyBytes, "max-body-size", o.MaxBodyBytes, "Only read this many bytes of each page (links further down are missed).")
+	flags.BoolVar(&o.DisableHTTP2, "no-http2", false, "Don't use HTTP/2, even with servers which support it.")
+	flags.IntVar(&o.MaxAttempts, "max-attempts", o.MaxAttempts, "Try each page this many times before giving up (only reset or cut off connections, timeouts, 429s and 502/503/504s are retried).")
+	flags.DurationVar(&o.RetryBaseDelay, "retry-delay", o.RetryBaseDelay, "Wait about this long before the first retry, doubling for each retry after that.")
+	flags.DurationVar(&o.RetryMaxDelay, "retry-max-delay", o.RetryMaxDelay, "Never wait longer than this between retries.")
+	flags.StringVar(&o.UserAgent, "user-agent", o.UserAgent, "Send this User-Agent with every request.")
+	flags.Var(headerFlag{&o.Headers}, "header", `Send an extra "Name: value" header with every request (can be given more than once).`)
+	f.cookiesPath = flags.String("cookies", "", "Start the crawl's cookie jar off with the cookies in this Netscape format cookies.txt file.")
+	f.auth = addAuthFlags(flags, &o.Auth)
+	flags.Var(resolveFlag{&o.Resolve}, "resolve", "Connect to host:port at address instead of looking it up, like curl's --resolve: host:port:address (can be given more than once). The Host header and TLS server name stay the same.")
+	f.dnsServer = flags.String("dns-server", "", "Look host names up with the DNS server at this address (host or host:port) instead of the system's resolver.")
+	flags.Var(proxyFlag{&o.Proxy.Url}, "proxy", "Crawl through this proxy: http://, https:// or socks5://host:port, with user:password@ if it needs a login (defaults to the HTTP_PROXY / HTTPS_PROXY environment variables).")
+	flags.Var(listFlag{&o.Proxy.NoProxy}, "no-proxy", "Comma separated hosts to connect to directly instead of through -proxy: host names (including their subdomains), host:port, IP addresses or CIDR ranges.")
+	f.caBundlePath = flags.String("ca-bundle", "", "Trust the CA certificates in this PEM file, as well as the system's (for sites using a private CA).")
+	f.clientCertPath = flags.String("client-cert", "", "Present the client certificate in this PEM file to servers which ask for one (mutual TLS).")
+	f.clientKeyPath = flags.String("client-key", "", "The private key for -client-cert, if it isn't in the same file.")
+	flags.BoolVar(&o.TLS.InsecureSkipVerify, "insecure-skip-verify", false, "DANGER: don't check server certificates at all. Only for test environments with throwaway certificates.")
+
+	return f
+}
+
+func (f *fetchFlags) fetchOptions() crawl.FetchOptions {
+	// fetchOptions returns the crawl.FetchOptions set by the flags, bailing out if the cookies file or certificates can't be
+	// loaded (or the auth flags don't make sense).
+	f.auth.apply()
+	options := f.options
+	if *f.cookiesPath != "" {
+		jar, err := crawl.LoadCookiesFile(*f.cookiesPath)
+		if err != nil {
+			log.Fatalf("☠️ Unable to load cookies from %s: %s", *f.cookiesPath, err)
+		}
+		options.Jar = jar
+	}
+
+	if *f.dnsServer != "" {
+		options.Resolver = crawl.NewDNSResolver(*f.dnsServer)
+	}
+	if *f.caBundlePath != "" {
+		pool, err := crawl.LoadCABundle(*f.caBundlePath)
+		if err != nil {
+			log.Fatalf("☠️ Unable to load CA bundle %s: %s", *f.caBundlePath, err)
+		}
+		options.TLS.RootCAs = pool
+	}
+	if *f.clientCertPath != "" {
+		certificate, err := crawl.LoadClientCertificate(*f.clientCertPath, *f.clientKeyPath)
+		if err != nil {
+			log.Fatalf("☠️ %s", err)
+		}
+		options.TLS.Certificates = append(options.TLS.Certificates, certificate)
+	} else if *f.clientKeyPath != "" {
+		log.Fatalln("☠️ -client-key needs a -client-cert to go with it")
+	}
+	return options
+}
+
+func sensitiveHeader(name string) bool {
+	// sensitiveHeader returns true for headers which are likely to carry credentials, whose values are kept out of saved crawls.
+	name = strings.ToLower(name)
+	if name == "cookie" || strings.HasSuffix(name, "authorization") {
+		return true
+	}
+	for _, word := range []string{"token", "key", "secret", "auth", "session", "password"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return false
+}
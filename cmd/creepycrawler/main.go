@@ -1,23 +1,149 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
 	"os"
 	"net/url"
 	"log"
+	"time"
+
 	"github.com/luaduck/creepycrawler/pkg/crawl"
-	"github.com/luaduck/creepycrawler/pkg/displayTree"
 )
 
 func cmdUsage() {
 	// cmdUsage simply prints how the command should be used.
 	fmt.Printf("Usage: %s [OPTIONS] domain\n", os.Args[0])
+	fmt.Printf("       %s path [OPTIONS] (domain | -load FILE) target\n", os.Args[0])
+	fmt.Printf("       %s show|export|analyse [OPTIONS] FILE\n", os.Args[0])
+	fmt.Printf("       %s diff [OPTIONS] OLD NEW\n", os.Args[0])
+	fmt.Printf("       %s recrawl [OPTIONS] FILE\n", os.Args[0])
+	fmt.Printf("       %s search [OPTIONS] FILE \"query\"\n", os.Args[0])
 	flag.PrintDefaults()
 }
 
+func crawlTarget(target string, options crawl.Options) *crawl.Crawl {
+	// crawlTarget parses the given domain / URL and crawls it, bailing out if it's not a valid URL.
+	targetUrl, err := url.Parse(target)
+
+	if err != nil {
+		// make this fail more gracefully
+		log.Fatalln(err)
+	}
+
+	// any user:password in the target is used to log in, but mustn't be saved along with the crawl
+	seed := *targetUrl
+	seed.User = nil
+
+	result := &crawl.Crawl{Seed: seed.String(), StartedAt: time.Now(), Resolve: options.Fetch.Resolve}
+	if options.Resume != nil {
+		// a resumed crawl started when the original did
+		result.StartedAt = options.Resume.StartedAt
+	}
+	result.Root = crawl.Walk(targetUrl, options)
+	result.FinishedAt = time.Now()
+
+	return result
+}
+
+// errorExitCodes are the exit statuses -fail-on-error uses for each kind of crawl error
+// (1 is already taken by usage errors and the like)
+var errorExitCodes = map[crawl.ErrorKind]int{
+	crawl.ErrorDNS:        3,
+	crawl.ErrorConnection: 4,
+	crawl.ErrorTLS:        5,
+	crawl.ErrorTimeout:    6,
+	crawl.ErrorHTTP:       7,
+	crawl.ErrorParse:      8,
+	crawl.ErrorOther:      9,
+}
+
+func errorExitCode(root *crawl.HtmlPage) int {
+	// errorExitCode picks the exit status for a crawl: 0 if every page was crawled fine, otherwise the code for the
+	// most fundamental kind of error any page had (a DNS failure says more about what's wrong than the 404s it causes, for example).
+	code := 0
+	for _, page := range crawl.ComputeDepths(root) {
+		var kind crawl.ErrorKind
+		switch {
+		case page.CrawlError != nil:
+			kind = crawl.ErrorKindOf(page.CrawlError)
+		case page.Soft404 != "":
+			// a soft 404 is an HTTP error in all but its status
+			kind = crawl.ErrorHTTP
+		default:
+			continue
+		}
+		if pageCode := errorExitCodes[kind]; code == 0 || pageCode < code {
+			code = pageCode
+		}
+	}
+	return code
+}
+
+func resumeCheckpoint(target string, path string) *crawl.Crawl {
+	// resumeCheckpoint loads the checkpoint at path to resume from, as long as it's a crawl of the same target.
+	// If there isn't a checkpoint yet, there's nothing to resume, so it returns nil and the crawl starts from scratch.
+	checkpoint, err := crawl.LoadCrawlFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Printf("🆕 no checkpoint at %s yet, starting from scratch", path)
+		return nil
+	}
+	if err != nil {
+		log.Fatalf("☠️ Unable to load checkpoint %s: %s", path, err)
+	}
+
+	targetUrl, err := url.Parse(target)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	// checkpoints are saved without credentials, so compare (and complain) without them
+	targetUrl.User = nil
+	if checkpoint.Seed != targetUrl.String() {
+		log.Fatalf("☠️ Checkpoint %s is a crawl of %s, not %s", path, checkpoint.Seed, targetUrl)
+	}
+	if !checkpoint.FinishedAt.IsZero() {
+		log.Printf("ℹ️ checkpoint %s is of a crawl which already finished, so there's nothing left to fetch", path)
+	}
+
+	return checkpoint
+}
+
 func main() {
-	displayBackrefs := flag.Bool("show-backrefs", false, "Show references to previously parsed / lower pages in the map tree.")
+	// subcommands get their own flag sets; anything else is a plain crawl
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "path":
+			pathCommand(os.Args[2:])
+			return
+		case "show", "export", "analyse":
+			savedCommand(os.Args[1], os.Args[2:])
+			return
+		case "diff":
+			diffCommand(os.Args[2:])
+			return
+		case "recrawl":
+			recrawlCommand(os.Args[2:])
+			return
+		case "search":
+			searchCommand(os.Args[2:])
+			return
+		}
+	}
+
+	output := addOutputFlags(flag.CommandLine, "tree")
+	savePath := flag.String("save", "", "Save the finished crawl to this file, for show / export / analyse / search / path -load to use later (its search index is saved alongside it).")
+	checkpointPath := flag.String("checkpoint", "", "Regularly save the crawl in progress to this file, so that it can be continued with -resume if it's interrupted.")
+	checkpointInterval := flag.Duration("checkpoint-interval", 30*time.Second, "How often to write the -checkpoint file.")
+	diskStore := flag.String("disk-store", "", "Write finished pages out to this file instead of keeping them all in the page store in memory (it's overwritten).")
+	resume := flag.Bool("resume", false, "Continue the crawl checkpointed in the -checkpoint file (if there is one), without fetching pages it already crawled.")
+	fetch := addFetchFlags(flag.CommandLine)
+	cache := addCacheFlags(flag.CommandLine)
+	traps := addTrapFlags(flag.CommandLine)
+	soft404 := addSoft404Flags(flag.CommandLine)
+	skipDuplicates := flag.Bool("skip-duplicates", false, "Don't follow links from pages whose text exactly duplicates a page already crawled (duplicates are listed in -format report either way).")
+	failOnError := flag.Bool("fail-on-error", false, "Exit with a non-zero status if any page couldn't be crawled: 3 DNS, 4 connection, 5 TLS, 6 timeout, 7 HTTP error status or soft 404, 8 parse, 9 other (the lowest applies).")
 
 	// specify that the flag package should use our custom help handler for usage information
 	// not sure if this is strictly necessary?
@@ -29,17 +155,77 @@ func main() {
 		os.Exit(1)
 	}
 
-	// fire the main scraper code
+	// check the output flags before crawling, rather than finding out they're wrong after a long crawl
+	if err := output.validate(); err != nil {
+		fmt.Println(err)
+		flag.Usage()
+		os.Exit(1)
+	}
 
-	// FIXME whoops, I overrode url here accidentially, would probably declare this with a different name
-	url, err := url.Parse(flag.Args()[0])
+	if *resume && *checkpointPath == "" {
+		fmt.Println("-resume needs a -checkpoint file to resume from")
+		flag.Usage()
+		os.Exit(1)
+	}
 
+	// likewise, open the output file up front so we don't crawl for nothing
+	out, err := output.open()
 	if err != nil {
-		// make this fail more gracefully
 		log.Fatalln(err)
 	}
 
-	scrapedPage := crawl.WalkTarget(url)
+	// fire the main scraper code
+	options := crawl.Options{
+		CheckpointPath:     *checkpointPath,
+		CheckpointInterval: *checkpointInterval,
+		Config:             flagConfig(flag.CommandLine),
+		Fetch:              fetch.fetchOptions(),
+		Cache:              cache.open(),
+		Traps:              traps.options(),
+		SkipDuplicates:     *skipDuplicates,
+		Soft404:            soft404.options(),
+	}
+	if *diskStore != "" {
+		store, err := crawl.OpenDiskStore(*diskStore, 0)
+		if err != nil {
+			log.Fatalf("☠️ Unable to open disk store %s: %s", *diskStore, err)
+		}
+		options.Store = store
+	}
+	if *resume {
+		options.Resume = resumeCheckpoint(flag.Args()[0], *checkpointPath)
+	}
+
+	// the pages' text is written out alongside the saved crawl as it goes, rather than kept in the crawl;
+	// a resumed crawl doesn't fetch its checkpointed pages again, so it adds on to the texts it had
+	var texts *crawl.TextFile
+	if *savePath != "" {
+		carryOnFrom := ""
+		if options.Resume != nil {
+			carryOnFrom = *savePath
+		}
+		texts = openTextFile(*savePath, carryOnFrom)
+		options.Texts = texts
+	}
 
-	fmt.Println(*displayTree.StringPageTree(&scrapedPage, displayBackrefs))
-}
\ No newline at end of file
+	result := crawlTarget(flag.Args()[0], options)
+	result.Config = options.Config
+
+	if *savePath != "" {
+		if err := result.SaveFile(*savePath); err != nil {
+			log.Fatalln(err)
+		}
+		log.Printf("💾 crawl saved to %s", *savePath)
+		closeTextFile(texts, result, *savePath)
+		saveSearchIndex(result, *savePath)
+	}
+
+	output.writeOrExit(result.Root, out)
+
+	if *failOnError {
+		if code := errorExitCode(result.Root); code != 0 {
+			log.Printf("💥 some pages couldn't be crawled, exiting with status %d", code)
+			os.Exit(code)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/luaduck/creepycrawler/pkg/analysis"
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+	"github.com/luaduck/creepycrawler/pkg/displayTree"
+)
+
+type outputFlags struct {
+	// outputFlags are the flags controlling how a crawl is displayed / exported.
+	// They're shared between the plain crawl command and the commands which work on saved crawls.
+	displayBackrefs *bool
+	format          *string
+	path            *string
+	maxNodes        *int
+	viewName        *string
+	showDepth       *bool
+	analyse         *bool
+	analyseTop      *int
+	clusterDepth    *int
+
+	// view is filled in from viewName by validate
+	view displayTree.TreeView
+}
+
+func addOutputFlags(flags *flag.FlagSet, defaultFormat string) *outputFlags {
+	// addOutputFlags registers the output flags on the given flag set.
+	return &outputFlags{
+		displayBackrefs: flags.Bool("show-backrefs", false, "Show references to previously parsed / lower pages in the map tree."),
+		format:          flags.String("format", defaultFormat, "Output format: tree, mermaid, markdown, dot, graphml, gexf or report (a standalone HTML page)."),
+		path:            flags.String("o", "", "Write output to this file instead of the console."),
+		maxNodes:        flags.Int("max-nodes", 0, "Stop tree, mermaid and markdown output after this many pages (0 for no limit)."),
+		viewName:        flags.String("view", "discovery", "How to arrange tree output: discovery (link order), shortest (shortest click path) or path (URL path hierarchy, tree format only)."),
+		showDepth:       flags.Bool("show-depth", false, "Annotate tree, mermaid and markdown entries with their shortest click depth from the root."),
+		analyse:         flags.Bool("analyse", false, "Analyse the link graph: print a summary (PageRank, hubs, dead ends...) after the output, and add per-page metrics to graph exports."),
+		analyseTop:      flags.Int("top", 10, "How many pages to list in each section of the -analyse summary (0 for all)."),
+		clusterDepth:    flags.Int("cluster-depth", 0, "Group graph export nodes by this many leading URL path segments (0 to disable)."),
+	}
+}
+
+func (o *outputFlags) validate() error {
+	// validate checks the output flags make sense, so that we can complain before crawling rather than after.
+	switch *o.format {
+	case "tree", "mermaid", "markdown", "dot", "graphml", "gexf", "report":
+	default:
+		return fmt.Errorf("unknown output format: %s", *o.format)
+	}
+
+	view, err := displayTree.ParseTreeView(*o.viewName)
+	if err != nil {
+		return err
+	}
+	if view == displayTree.PathView && *o.format != "tree" {
+		return errors.New("the path view only works with tree output")
+	}
+	o.view = view
+
+	return nil
+}
+
+func (o *outputFlags) open() (io.WriteCloser, error) {
+	// open opens the output file (or the console, if there isn't one).
+	if *o.path == "" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(*o.path)
+}
+
+type nopCloser struct {
+	// nopCloser stops us closing the console when we're done writing to it.
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func (o *outputFlags) write(out io.Writer, root *crawl.HtmlPage) error {
+	// write displays / exports the crawl rooted at root in the chosen format.
+
+	graphOptions := displayTree.GraphOptions{ClusterDepth: *o.clusterDepth}
+	var report *analysis.Report
+	if *o.analyse {
+		report = analysis.Analyse(root)
+		graphOptions.Analysis = report
+	}
+	treeOptions := displayTree.TreeOptions{View: o.view, DisplayBackrefs: *o.displayBackrefs, ShowDepth: *o.showDepth, MaxNodes: *o.maxNodes}
+
+	var err error
+	switch *o.format {
+	case "tree":
+		_, err = fmt.Fprintln(out, displayTree.StringTree(root, treeOptions))
+	case "mermaid":
+		_, err = fmt.Fprint(out, displayTree.StringMermaid(root, treeOptions))
+	case "markdown":
+		_, err = fmt.Fprint(out, displayTree.StringMarkdown(root, treeOptions))
+	case "dot":
+		err = displayTree.WriteDOT(out, root, graphOptions)
+	case "graphml":
+		err = displayTree.WriteGraphML(out, root, graphOptions)
+	case "gexf":
+		err = displayTree.WriteGEXF(out, root, graphOptions)
+	case "report":
+		err = displayTree.WriteReport(out, root)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// graph exports carry the metrics themselves, so the summary is only added to the human readable formats
+	switch *o.format {
+	case "tree", "mermaid", "markdown":
+		if report != nil {
+			if _, err := fmt.Fprintln(out); err != nil {
+				return err
+			}
+			return report.WriteSummary(out, *o.analyseTop)
+		}
+	}
+
+	return nil
+}
+
+func (o *outputFlags) writeOrExit(root *crawl.HtmlPage, out io.WriteCloser) {
+	// writeOrExit writes the crawl to out, then closes it, bailing out if anything goes wrong.
+	if err := o.write(out, root); err != nil {
+		log.Fatalln(err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func flagConfig(flags *flag.FlagSet) map[string]string {
+	// flagConfig records the value of every flag, for saving alongside a crawl.
+	config := map[string]string{}
+	flags.VisitAll(func(f *flag.Flag) {
+		config[f.Name] = f.Value.String()
+	})
+	return config
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/luaduck/creepycrawler/pkg/analysis"
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+func pathCommand(args []string) {
+	// pathCommand is the `path` subcommand: it crawls a domain (or loads a saved crawl), then prints the shortest click path(s) to a target page,
+	// along with every page that links to it.
+	flags := flag.NewFlagSet("path", flag.ExitOnError)
+	from := flags.String("from", "", "Find paths from this page instead of the crawl root (absolute, or relative to the root).")
+	maxPaths := flags.Int("max-paths", 10, "Show at most this many shortest paths (0 for all of them).")
+	load := flags.String("load", "", "Use a crawl saved with -save instead of crawling a domain.")
+	fetch := addFetchFlags(flags)
+	cache := addCacheFlags(flags)
+	traps := addTrapFlags(flags)
+	soft404 := addSoft404Flags(flags)
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s path [OPTIONS] (domain | -load FILE) target\n", os.Args[0])
+		fmt.Println("Prints the shortest click paths from the crawl root (or -from) to target, and every page linking to target.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	// with -load, the only argument is the target
+	expectedArgs := 2
+	if *load != "" {
+		expectedArgs = 1
+	}
+	if flags.NArg() != expectedArgs {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	var root *crawl.HtmlPage
+	if *load != "" {
+		root = loadCrawlOrExit(*load).Root
+	} else {
+		root = crawlTarget(flags.Arg(0), crawl.Options{Fetch: fetch.fetchOptions(), Cache: cache.open(), Traps: traps.options(), Soft404: soft404.options()}).Root
+	}
+
+	target := findPageOrExit(root, flags.Arg(flags.NArg()-1))
+	source := root
+	if *from != "" {
+		source = findPageOrExit(root, *from)
+	}
+
+	paths := analysis.ShortestPaths(source, target, *maxPaths)
+	if paths == nil {
+		fmt.Printf("🚫 %s can't be reached from %s\n", target.Url.String(), source.Url.String())
+	} else {
+		fmt.Printf("🧭 Shortest click path(s) from %s to %s (%d clicks):\n", source.Url.String(), target.Url.String(), len(paths[0])-1)
+		for i, path := range paths {
+			var steps []string
+			for _, page := range path {
+				steps = append(steps, fmt.Sprintf("%s (%s)", page.Url.String(), page.Title))
+			}
+			fmt.Printf("  %d. %s\n", i+1, strings.Join(steps, " → "))
+		}
+	}
+
+	inlinks := analysis.Inlinks(root, target)
+	fmt.Printf("\n🔗 Pages linking to %s (%d):\n", target.Url.String(), len(inlinks))
+	for _, page := range inlinks {
+		fmt.Printf("  %s (%s)\n", page.Url.String(), page.Title)
+	}
+}
+
+func findPageOrExit(root *crawl.HtmlPage, target string) *crawl.HtmlPage {
+	// findPageOrExit looks up target in the crawl, and exits if it was never found.
+	page := analysis.FindPage(root, target)
+	if page == nil {
+		fmt.Printf("%s wasn't found in the crawl of %s\n", target, root.Url.String())
+		os.Exit(1)
+	}
+	return page
+}
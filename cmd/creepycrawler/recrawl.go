@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+func recrawlCommand(args []string) {
+	// recrawlCommand is the `recrawl` subcommand: it loads a crawl saved with -save, revisits the pages which are likely
+	// to have changed since, and saves the updated crawl (with each page's change history) back over it.
+	flags := flag.NewFlagSet("recrawl", flag.ExitOnError)
+	savePath := flags.String("save", "", "Save the updated crawl to this file instead of back over the one it was loaded from.")
+	minAge := flags.Duration("min-age", crawl.DefaultRecrawlPolicy.MinAge, "Don't revisit pages fetched more recently than this (unless the sitemap says they've changed).")
+	maxAge := flags.Duration("max-age", crawl.DefaultRecrawlPolicy.MaxAge, "Always revisit pages older than this, however rarely they've changed before.")
+	maxPages := flags.Int("max-pages", 0, "Revisit at most this many pages, most likely to have changed first (0 for no limit). Newly found pages don't count.")
+	noSitemap := flags.Bool("no-sitemap", false, "Don't fetch /sitemap.xml for the <lastmod> dates of pages.")
+	fetch := addFetchFlags(flags)
+	cache := addCacheFlags(flags)
+	traps := addTrapFlags(flags)
+	soft404 := addSoft404Flags(flags)
+	skipDuplicates := flags.Bool("skip-duplicates", false, "Don't follow links from pages whose text exactly duplicates a page already crawled.")
+	failOnError := flags.Bool("fail-on-error", false, "Exit with a non-zero status if any page couldn't be crawled (with the same statuses as a plain crawl).")
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s recrawl [OPTIONS] FILE\n", os.Args[0])
+		fmt.Println("Brings a saved crawl up to date, only revisiting the pages likely to have changed, and saves it back.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	previous := loadCrawlOrExit(flags.Arg(0))
+	seedUrl, err := url.Parse(previous.Seed)
+	if err != nil {
+		log.Fatalf("☠️ Saved crawl %s has a bad seed %q: %s", flags.Arg(0), previous.Seed, err)
+	}
+
+	options := crawl.Options{
+		Config:         flagConfig(flags),
+		Fetch:          fetch.fetchOptions(),
+		Cache:          cache.open(),
+		Traps:          traps.options(),
+		SkipDuplicates: *skipDuplicates,
+		Soft404:        soft404.options(),
+		Recrawl:        previous,
+		RecrawlPolicy: crawl.RecrawlPolicy{
+			MinAge:    *minAge,
+			MaxAge:    *maxAge,
+			MaxPages:  *maxPages,
+			NoSitemap: *noSitemap,
+		},
+	}
+	if len(options.Fetch.Resolve) == 0 {
+		// a pre-launch crawl should stay pointed at the same servers, unless we're told otherwise
+		options.Fetch.Resolve = previous.Resolve
+	}
+
+	path := *savePath
+	if path == "" {
+		path = flags.Arg(0)
+	}
+	// the pages which aren't revisited keep the texts they had, so the new ones are added on after those
+	texts := openTextFile(path, flags.Arg(0))
+	options.Texts = texts
+
+	// the saved configuration is the recrawl's own, as that's what the pages in it were last fetched with
+	result := &crawl.Crawl{Seed: previous.Seed, StartedAt: time.Now(), Config: options.Config, Resolve: options.Fetch.Resolve}
+	result.Root = crawl.Walk(seedUrl, options)
+	result.FinishedAt = time.Now()
+
+	if err := result.SaveFile(path); err != nil {
+		log.Fatalln(err)
+	}
+	log.Printf("💾 crawl saved to %s", path)
+	closeTextFile(texts, result, path)
+	saveSearchIndex(result, path)
+
+	if *failOnError {
+		if code := errorExitCode(result.Root); code != 0 {
+			log.Printf("💥 some pages couldn't be crawled, exiting with status %d", code)
+			os.Exit(code)
+		}
+	}
+}
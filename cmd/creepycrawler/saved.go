@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/luaduck/creepycrawler/pkg/analysis"
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+func savedCommand(name string, args []string) {
+	// savedCommand runs the show, export and analyse subcommands, which all work on a crawl saved with -save.
+	// They're the same thing with different defaults: show prints a tree, export writes GraphML,
+	// and analyse prints the link graph analysis summary.
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+
+	defaultFormat := "tree"
+	if name == "export" {
+		defaultFormat = "graphml"
+	}
+	output := addOutputFlags(flags, defaultFormat)
+
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s %s [OPTIONS] FILE\n", os.Args[0], name)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	if name == "analyse" {
+		*output.analyse = true
+	}
+
+	if err := output.validate(); err != nil {
+		fmt.Println(err)
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	saved := loadCrawlOrExit(flags.Arg(0))
+
+	out, err := output.open()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if name == "analyse" && *output.format == "tree" {
+		// analyse only wants the summary, not the tree as well
+		if err := analysis.Analyse(saved.Root).WriteSummary(out, *output.analyseTop); err != nil {
+			log.Fatalln(err)
+		}
+		// a full disk might only show up when the file's closed, so that has to be checked too
+		if err := out.Close(); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	output.writeOrExit(saved.Root, out)
+}
+
+func loadCrawlOrExit(path string) *crawl.Crawl {
+	// loadCrawlOrExit loads a saved crawl, bailing out if it can't be read.
+	saved, err := crawl.LoadCrawlFile(path)
+	if err != nil {
+		log.Fatalf("☠️ Unable to load saved crawl %s: %s", path, err)
+	}
+
+	log.Printf("📂 loaded crawl of %s (%s to %s)", saved.Seed, saved.StartedAt.Format("2006-01-02 15:04:05"), saved.FinishedAt.Format("15:04:05"))
+	for hostPort, address := range saved.Resolve {
+		log.Printf("📌 (this crawl connected to %s at %s, not wherever DNS pointed)", hostPort, address)
+	}
+	return saved
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+	"github.com/luaduck/creepycrawler/pkg/search"
+)
+
+func saveSearchIndex(saved *crawl.Crawl, crawlPath string) *search.Index {
+	// saveSearchIndex builds the crawl's search index, saves it alongside the crawl saved at crawlPath, and returns it.
+	// The pages' text comes from the text file saved alongside the crawl (see crawl.TextPath); if that's missing or broken,
+	// the index is built from just the titles, rather than not at all.
+	// A crawl is still useful without its index (search can rebuild it), so failing to save it isn't fatal.
+	textPath := crawl.TextPath(crawlPath)
+	index, err := search.Build(saved, textPath)
+	if err != nil {
+		log.Printf("⚠️ unable to read the page texts from %s, so only searching the titles: %s", textPath, err)
+		index, _ = search.Build(saved, "")
+	}
+	path := search.IndexPath(crawlPath)
+	if err := index.SaveFile(path); err != nil {
+		log.Printf("⚠️ unable to save the search index to %s: %s", path, err)
+		return index
+	}
+	log.Printf("🔎 search index of %d pages (%d words) saved to %s", len(index.Documents), index.Words(), path)
+	return index
+}
+
+func openTextFile(crawlPath string, carryOnFrom string) *crawl.TextFile {
+	// openTextFile opens the text file for the crawl to be saved at crawlPath (see crawl.TextPath), so the pages' text can be
+	// written to it as they're crawled. If carryOnFrom isn't "", the crawl is carrying on from the one saved (or checkpointed)
+	// there, whose pages' texts are kept: the new texts are added on after them.
+	path := crawl.TextPath(crawlPath)
+	if carryOnFrom == "" {
+		texts, err := crawl.CreateTextFile(path)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		return texts
+	}
+
+	if previous := crawl.TextPath(carryOnFrom); previous != path {
+		if err := copyFile(previous, path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("⚠️ unable to copy the page texts from %s, so starting afresh: %s", previous, err)
+		}
+	}
+	texts, err := crawl.AppendTextFile(path)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return texts
+}
+
+func closeTextFile(texts *crawl.TextFile, saved *crawl.Crawl, crawlPath string) {
+	// closeTextFile closes the text file once the crawl saved at crawlPath is done, and (as it may have been added to crawl after crawl)
+	// compacts it down to the latest text of each page still in the crawl. Like the search index, the crawl is still useful without it.
+	path := crawl.TextPath(crawlPath)
+	if err := texts.Close(); err != nil {
+		log.Printf("⚠️ unable to save the page texts to %s: %s", path, err)
+		return
+	}
+
+	inCrawl := map[string]bool{}
+	for _, page := range crawl.Pages(saved.Root) {
+		inCrawl[page.Url.String()] = true
+	}
+	err := crawl.CompactTextFile(path, func(pageUrl string) bool { return inCrawl[pageUrl] })
+	if err != nil {
+		log.Printf("⚠️ unable to compact the page texts in %s: %s", path, err)
+		return
+	}
+	log.Printf("📝 page texts saved to %s", path)
+}
+
+func copyFile(from string, to string) error {
+	// copyFile copies the file at from to to, a bit at a time (a text file can be big).
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func searchCommand(args []string) {
+	// searchCommand is the `search` subcommand: it searches the text of the pages in a crawl saved with -save,
+	// using the index saved alongside it, and prints the best matches with a snippet of each.
+	flags := flag.NewFlagSet("search", flag.ExitOnError)
+	limit := flags.Int("n", 10, "Show at most this many results (0 for all of them).")
+	snippetWords := flags.Int("snippet-words", search.DefaultSnippetWords, "How many words of each page to show around the words searched for.")
+	flags.Usage = func() {
+		fmt.Printf("Usage: %s search [OPTIONS] FILE \"query\"\n", os.Args[0])
+		fmt.Println("Searches the text of a saved crawl's pages, best match first.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 2 {
+		flags.Usage()
+		os.Exit(1)
+	}
+	// (so the query doesn't have to be quoted)
+	query := strings.Join(flags.Args()[1:], " ")
+	if len(crawl.Words(query)) == 0 {
+		fmt.Println("The query doesn't have any words in it to search for")
+		os.Exit(1)
+	}
+
+	saved := loadCrawlOrExit(flags.Arg(0))
+	index := loadSearchIndex(saved, flags.Arg(0))
+
+	results := index.Search(query, *limit)
+	if len(results) == 0 {
+		fmt.Printf("No pages match %q\n", query)
+		return
+	}
+
+	// snippets come from the pages' text, which is kept in the crawl's text file rather than in the index,
+	// so read through it for just the pages we found (without snippets if it can't be read)
+	texts := map[string]string{}
+	for _, result := range results {
+		texts[result.Url] = ""
+	}
+	err := crawl.ReadTextFile(crawl.TextPath(flags.Arg(0)), func(pageUrl string, text string) error {
+		if _, found := texts[pageUrl]; found {
+			texts[pageUrl] = text
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("⚠️ unable to read the page texts, so there are no snippets: %s", err)
+	}
+	for i, result := range results {
+		fmt.Printf("%d. %s (%.2f)\n", i+1, result.Url, result.Score)
+		if result.Title != "" {
+			fmt.Printf("   %s\n", result.Title)
+		}
+		if snippet := search.Snippet(texts[result.Url], query, *snippetWords); snippet != "" {
+			fmt.Printf("   %s\n", snippet)
+		}
+		fmt.Println()
+	}
+}
+
+func loadSearchIndex(saved *crawl.Crawl, crawlPath string) *search.Index {
+	// loadSearchIndex loads the search index saved alongside the crawl at crawlPath, rebuilding it (and saving it again)
+	// if it's missing, or was built from a different version of the crawl.
+	path := search.IndexPath(crawlPath)
+	index, err := search.LoadIndexFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		log.Printf("🔎 there's no search index at %s, so building one", path)
+	case err != nil:
+		log.Printf("⚠️ unable to load the search index %s, so rebuilding it: %s", path, err)
+	case !index.Matches(saved):
+		log.Printf("🔎 the search index %s is out of date, so rebuilding it", path)
+	default:
+		return index
+	}
+
+	return saveSearchIndex(saved, crawlPath)
+}
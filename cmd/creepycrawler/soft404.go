@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"regexp"
+	"strings"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+type soft404Flags struct {
+	// soft404Flags are the flags tuning soft 404 detection (crawl.Soft404Options).
+	disabled      *bool
+	probe         *bool
+	titlePatterns []*regexp.Regexp
+}
+
+func addSoft404Flags(flags *flag.FlagSet) *soft404Flags {
+	// addSoft404Flags registers the soft 404 flags on the given flag set.
+	var defaults []string
+	for _, pattern := range crawl.DefaultSoft404Options.TitlePatterns {
+		defaults = append(defaults, strings.TrimPrefix(pattern.String(), "(?i)"))
+	}
+
+	s := &soft404Flags{
+		disabled: flags.Bool("no-soft404-detection", false, "Don't look for soft 404s (\"page not found\" pages served with a 200 status)."),
+		probe:    flags.Bool("soft404-probe", false, "Fetch a made up URL from each host to learn what its \"page not found\" page looks like, and flag pages which look the same as soft 404s (off by default, as it's a request the site never linked to)."),
+	}
+	flags.Var(patternFlag{&s.titlePatterns}, "soft404-title", "A regular expression for the titles of soft 404 pages, matched without regard to case (can be given more than once; replaces the defaults, "+strings.Join(defaults, " ")+").")
+	return s
+}
+
+func (s *soft404Flags) options() crawl.Soft404Options {
+	// options turns the flags into crawl.Soft404Options.
+	return crawl.Soft404Options{Disabled: *s.disabled, Probe: *s.probe, TitlePatterns: s.titlePatterns}
+}
+
+type patternFlag struct {
+	// patternFlag is a soft 404 title pattern flag, which can be given more than once.
+	patterns *[]*regexp.Regexp
+}
+
+func (p patternFlag) String() string {
+	if p.patterns == nil {
+		return ""
+	}
+	var patterns []string
+	for _, pattern := range *p.patterns {
+		patterns = append(patterns, pattern.String())
+	}
+	return strings.Join(patterns, " ")
+}
+
+func (p patternFlag) Set(value string) error {
+	compiled, err := crawl.ParseTitlePatterns([]string{value})
+	if err != nil {
+		return err
+	}
+	*p.patterns = append(*p.patterns, compiled...)
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"strings"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+type trapFlags struct {
+	// trapFlags are the flags tuning crawler trap detection (crawl.TrapOptions).
+	disabled           *bool
+	maxUrlLength       *int
+	maxSegmentRepeats  *int
+	maxQueryVariants   *int
+	maxPagesPerPattern *int
+	sessionParams      []string
+}
+
+func addTrapFlags(flags *flag.FlagSet) *trapFlags {
+	// addTrapFlags registers the trap detection flags on the given flag set.
+	defaults := crawl.DefaultTrapOptions
+	t := &trapFlags{
+		disabled:           flags.Bool("no-trap-detection", false, "Follow every link, even ones which look like crawler traps (endless calendars, session IDs and so on)."),
+		maxUrlLength:       flags.Int("max-url-length", defaults.MaxUrlLength, "Quarantine URLs longer than this many characters as suspected traps."),
+		maxSegmentRepeats:  flags.Int("max-segment-repeats", defaults.MaxSegmentRepeats, "Quarantine URLs with the same path segment in them more than this many times (like /a/b/a/b/a/b/a/b/a/b)."),
+		maxQueryVariants:   flags.Int("max-query-variants", defaults.MaxQueryVariants, "Quarantine URLs once a path has had more than this many different query strings."),
+		maxPagesPerPattern: flags.Int("max-pages-per-pattern", defaults.MaxPagesPerPattern, "Quarantine URLs once more than this many share a pattern (the path with its numbers, dates and IDs wildcarded, like /calendar/*/*)."),
+	}
+	flags.Var(listFlag{&t.sessionParams}, "session-params", "Query parameters which carry a session ID, so that URLs with them are quarantined (comma separated; default "+strings.Join(defaults.SessionParams, ",")+").")
+	return t
+}
+
+func (t *trapFlags) options() crawl.TrapOptions {
+	// options turns the flags into crawl.TrapOptions.
+	return crawl.TrapOptions{
+		Disabled:           *t.disabled,
+		MaxUrlLength:       *t.maxUrlLength,
+		MaxSegmentRepeats:  *t.maxSegmentRepeats,
+		MaxQueryVariants:   *t.maxQueryVariants,
+		MaxPagesPerPattern: *t.maxPagesPerPattern,
+		SessionParams:      t.sessionParams,
+	}
+}
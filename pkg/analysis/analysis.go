@@ -0,0 +1,400 @@
+// analysis contains link graph analytics which run over a finished HtmlPage graph:
+// degrees, PageRank, HITS hub / authority scores, strongly connected components, dominators, dead ends and fragile pages.
+
+package analysis
+
+import (
+	"math"
+	"sort"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+type NodeMetrics struct {
+	// NodeMetrics holds everything we know about a single page's place in the link graph.
+
+	// InDegree and OutDegree count distinct internal pages linking to / linked from this page (self links don't count)
+	InDegree  int
+	OutDegree int
+
+	// PageRank is the page's share of internal PageRank; all of the pages' scores add up to 1
+	PageRank float64
+
+	// HubScore and AuthorityScore are the HITS scores for the page (good hubs link to good authorities)
+	HubScore       float64
+	AuthorityScore float64
+
+	// Component is the index of the strongly connected component the page belongs to (see Report.Components)
+	Component int
+
+	// DeadEnd is set if the page was parsed (and isn't broken), but doesn't link to any other internal page.
+	// Pages which couldn't be crawled don't have any links because we never saw them, so they don't count.
+	DeadEnd bool
+
+	// SingleInlink is set if only one other page links here (break that link and the page is orphaned).
+	// The root doesn't count. Note that this isn't the same as there being only one way to get here:
+	// two pages can link here, but both hang off the same page (see SinglePath).
+	SingleInlink bool
+
+	// Dominator is the page's immediate dominator: the closest page which every click path from the root to this page
+	// goes through. It's the root for pages which can be reached in more than one way, and nil for the root itself.
+	Dominator *crawl.HtmlPage
+
+	// SinglePath is set if the page's Dominator isn't the root, so every way of getting to the page from the root
+	// goes through one other page (lose that page, or the links to it, and this one goes too)
+	SinglePath bool
+}
+
+type Report struct {
+	// Report is the result of analysing a HtmlPage graph.
+
+	// Pages is every page reachable from the root, in breadth first order
+	Pages []*crawl.HtmlPage
+
+	// Metrics holds the per page results
+	Metrics map[*crawl.HtmlPage]*NodeMetrics
+
+	// Components is every strongly connected component, largest first
+	// (in a graph where every page links back home, that's usually one big component plus some stragglers)
+	Components [][]*crawl.HtmlPage
+
+	// DeadEnds, SingleInlinks and SinglePaths list the pages with those flags set, in breadth first order
+	DeadEnds      []*crawl.HtmlPage
+	SingleInlinks []*crawl.HtmlPage
+	SinglePaths   []*crawl.HtmlPage
+}
+
+// these are the usual PageRank / HITS settings; there's no real need for them to be configurable
+const (
+	dampingFactor = 0.85
+	maxIterations = 100
+	tolerance     = 1e-9
+)
+
+type linkGraph struct {
+	// linkGraph is an adjacency list version of the HtmlPage graph, using indexes into pages rather than pointers,
+	// with duplicate links and self links removed.
+	pages    []*crawl.HtmlPage
+	outlinks [][]int
+	inlinks  [][]int
+}
+
+func buildLinkGraph(root *crawl.HtmlPage) *linkGraph {
+	// buildLinkGraph flattens the graph reachable from root into a linkGraph.
+	graph := &linkGraph{pages: crawl.Pages(root)}
+
+	index := map[*crawl.HtmlPage]int{}
+	for i, page := range graph.pages {
+		index[page] = i
+	}
+
+	graph.outlinks = make([][]int, len(graph.pages))
+	graph.inlinks = make([][]int, len(graph.pages))
+
+	for i, page := range graph.pages {
+		seen := map[int]bool{i: true}
+		for _, link := range page.LinksTo {
+			target := index[link]
+			if seen[target] {
+				continue
+			}
+			seen[target] = true
+			graph.outlinks[i] = append(graph.outlinks[i], target)
+			graph.inlinks[target] = append(graph.inlinks[target], i)
+		}
+	}
+
+	return graph
+}
+
+func Analyse(root *crawl.HtmlPage) *Report {
+	// Analyse runs every analysis over the graph reachable from root.
+
+	graph := buildLinkGraph(root)
+	report := &Report{Pages: graph.pages, Metrics: map[*crawl.HtmlPage]*NodeMetrics{}}
+
+	pageRank := graph.pageRank()
+	hubs, authorities := graph.hits()
+	components := graph.stronglyConnectedComponents()
+	dominators := graph.immediateDominators()
+
+	for i, page := range graph.pages {
+		metrics := &NodeMetrics{
+			InDegree:       len(graph.inlinks[i]),
+			OutDegree:      len(graph.outlinks[i]),
+			PageRank:       pageRank[i],
+			HubScore:       hubs[i],
+			AuthorityScore: authorities[i],
+			DeadEnd:        len(graph.outlinks[i]) == 0 && page.IsParsed && !page.IsBroken(),
+			SingleInlink:   i != 0 && len(graph.inlinks[i]) == 1,
+			SinglePath:     dominators[i] > 0,
+		}
+		if dominators[i] >= 0 {
+			metrics.Dominator = graph.pages[dominators[i]]
+		}
+		report.Metrics[page] = metrics
+
+		if metrics.DeadEnd {
+			report.DeadEnds = append(report.DeadEnds, page)
+		}
+		if metrics.SingleInlink {
+			report.SingleInlinks = append(report.SingleInlinks, page)
+		}
+		if metrics.SinglePath {
+			report.SinglePaths = append(report.SinglePaths, page)
+		}
+	}
+
+	// biggest components first; ties are broken by whichever component holds the shallower page
+	sort.SliceStable(components, func(i, j int) bool {
+		if len(components[i]) != len(components[j]) {
+			return len(components[i]) > len(components[j])
+		}
+		return components[i][0] < components[j][0]
+	})
+	for c, component := range components {
+		var pages []*crawl.HtmlPage
+		for _, i := range component {
+			report.Metrics[graph.pages[i]].Component = c
+			pages = append(pages, graph.pages[i])
+		}
+		report.Components = append(report.Components, pages)
+	}
+
+	return report
+}
+
+func (g *linkGraph) pageRank() []float64 {
+	// pageRank works out PageRank by power iteration.
+	// Dead ends don't have anywhere to send their rank, so it's spread evenly over every page
+	// (as if the user got bored and typed in a random URL from the site).
+	n := len(g.pages)
+	rank := make([]float64, n)
+	for i := range rank {
+		rank[i] = 1 / float64(n)
+	}
+
+	for iteration := 0; iteration < maxIterations; iteration++ {
+		next := make([]float64, n)
+
+		var dangling float64
+		for i := range g.pages {
+			if len(g.outlinks[i]) == 0 {
+				dangling += rank[i]
+				continue
+			}
+			share := rank[i] / float64(len(g.outlinks[i]))
+			for _, target := range g.outlinks[i] {
+				next[target] += share
+			}
+		}
+
+		var delta float64
+		for i := range next {
+			next[i] = (1-dampingFactor)/float64(n) + dampingFactor*(next[i]+dangling/float64(n))
+			delta += math.Abs(next[i] - rank[i])
+		}
+
+		rank = next
+		if delta < tolerance {
+			break
+		}
+	}
+
+	return rank
+}
+
+func (g *linkGraph) hits() (hubs []float64, authorities []float64) {
+	// hits works out HITS hub and authority scores by power iteration, normalising each round so they don't run away.
+	n := len(g.pages)
+	hubs = make([]float64, n)
+	authorities = make([]float64, n)
+	for i := range hubs {
+		hubs[i] = 1
+		authorities[i] = 1
+	}
+
+	for iteration := 0; iteration < maxIterations; iteration++ {
+		nextAuthorities := make([]float64, n)
+		for i := range g.pages {
+			for _, source := range g.inlinks[i] {
+				nextAuthorities[i] += hubs[source]
+			}
+		}
+		normalise(nextAuthorities)
+
+		nextHubs := make([]float64, n)
+		for i := range g.pages {
+			for _, target := range g.outlinks[i] {
+				nextHubs[i] += nextAuthorities[target]
+			}
+		}
+		normalise(nextHubs)
+
+		var delta float64
+		for i := range hubs {
+			delta += math.Abs(nextHubs[i]-hubs[i]) + math.Abs(nextAuthorities[i]-authorities[i])
+		}
+
+		hubs, authorities = nextHubs, nextAuthorities
+		if delta < tolerance {
+			break
+		}
+	}
+
+	return hubs, authorities
+}
+
+func normalise(scores []float64) {
+	// normalise scales scores so that their squares add up to 1 (leaving all-zero scores alone).
+	var sum float64
+	for _, score := range scores {
+		sum += score * score
+	}
+	if sum == 0 {
+		return
+	}
+	length := math.Sqrt(sum)
+	for i := range scores {
+		scores[i] /= length
+	}
+}
+
+func (g *linkGraph) stronglyConnectedComponents() [][]int {
+	// stronglyConnectedComponents finds strongly connected components with Tarjan's algorithm.
+	// Each component's pages are sorted into breadth first order, so the result is stable between runs.
+	n := len(g.pages)
+	index := make([]int, n)
+	lowlink := make([]int, n)
+	onStack := make([]bool, n)
+	for i := range index {
+		index[i] = -1
+	}
+
+	var stack []int
+	var components [][]int
+	counter := 0
+
+	var connect func(v int)
+	connect = func(v int) {
+		index[v] = counter
+		lowlink[v] = counter
+		counter++
+		stack = append(stack, v)
+		onStack[v] = true
+
+		for _, w := range g.outlinks[v] {
+			if index[w] == -1 {
+				connect(w)
+				if lowlink[w] < lowlink[v] {
+					lowlink[v] = lowlink[w]
+				}
+			} else if onStack[w] && index[w] < lowlink[v] {
+				lowlink[v] = index[w]
+			}
+		}
+
+		if lowlink[v] == index[v] {
+			// v is the root of a component; everything above it on the stack belongs to it
+			var component []int
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				component = append(component, w)
+				if w == v {
+					break
+				}
+			}
+			sort.Ints(component)
+			components = append(components, component)
+		}
+	}
+
+	for v := 0; v < n; v++ {
+		if index[v] == -1 {
+			connect(v)
+		}
+	}
+
+	return components
+}
+
+func (g *linkGraph) immediateDominators() []int {
+	// immediateDominators works out each page's immediate dominator (as an index into pages; -1 for the root),
+	// with Cooper, Harvey and Kennedy's iterative algorithm ("A Simple, Fast Dominance Algorithm").
+	// Every page in the graph is reachable from the root (index 0), which the algorithm relies on.
+	n := len(g.pages)
+	if n == 0 {
+		return nil
+	}
+
+	// number the pages in depth first postorder (without recursion, as a long chain of pages would make for a deep stack)
+	postorder := make([]int, 0, n)
+	position := make([]int, n)
+	visited := make([]bool, n)
+	type frame struct{ page, next int }
+	stack := []frame{{0, 0}}
+	visited[0] = true
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		if top.next < len(g.outlinks[top.page]) {
+			target := g.outlinks[top.page][top.next]
+			top.next++
+			if !visited[target] {
+				visited[target] = true
+				stack = append(stack, frame{target, 0})
+			}
+			continue
+		}
+		position[top.page] = len(postorder)
+		postorder = append(postorder, top.page)
+		stack = stack[:len(stack)-1]
+	}
+
+	dominators := make([]int, n)
+	for i := range dominators {
+		dominators[i] = -1
+	}
+	dominators[0] = 0
+
+	// intersect walks two pages up the dominator tree until they meet, at their closest common dominator
+	intersect := func(a, b int) int {
+		for a != b {
+			for position[a] < position[b] {
+				a = dominators[a]
+			}
+			for position[b] < position[a] {
+				b = dominators[b]
+			}
+		}
+		return a
+	}
+
+	// go over the pages in reverse postorder until nothing changes (which doesn't usually take more than a couple of rounds)
+	for changed := true; changed; {
+		changed = false
+		for i := len(postorder) - 2; i >= 0; i-- {
+			page := postorder[i]
+			dominator := -1
+			for _, source := range g.inlinks[page] {
+				if dominators[source] == -1 {
+					// not worked out yet
+					continue
+				}
+				if dominator == -1 {
+					dominator = source
+				} else {
+					dominator = intersect(source, dominator)
+				}
+			}
+			if dominators[page] != dominator {
+				dominators[page] = dominator
+				changed = true
+			}
+		}
+	}
+
+	dominators[0] = -1
+	return dominators
+}
@@ -0,0 +1,163 @@
+package analysis
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+func genTestGraph() (root, a, b, c *crawl.HtmlPage) {
+	// root -> a, b; a -> b, c; b -> root (and b links to itself, which should be ignored)
+	// c is a dead end, and a and c are both only linked from one page
+	page := func(path string) *crawl.HtmlPage {
+		return &crawl.HtmlPage{Url: &url.URL{Scheme: "https", Host: "testsite.test", Path: path}, Title: path, IsParsed: true}
+	}
+	root, a, b, c = page("/"), page("/a"), page("/b"), page("/c")
+	root.LinksTo = []*crawl.HtmlPage{a, b}
+	// duplicate links shouldn't count twice
+	a.LinksTo = []*crawl.HtmlPage{b, c, b}
+	b.LinksTo = []*crawl.HtmlPage{root, b}
+	return
+}
+
+func TestAnalyseDegrees(t *testing.T) {
+	root, a, b, c := genTestGraph()
+	report := Analyse(root)
+
+	expected := map[*crawl.HtmlPage][2]int{
+		root: {1, 2},
+		a:    {1, 2},
+		b:    {2, 1},
+		c:    {1, 0},
+	}
+	for page, degrees := range expected {
+		metrics := report.Metrics[page]
+		if metrics.InDegree != degrees[0] || metrics.OutDegree != degrees[1] {
+			t.Errorf("%s has the wrong degrees: expected in %d / out %d, got in %d / out %d", page.Url, degrees[0], degrees[1], metrics.InDegree, metrics.OutDegree)
+		}
+	}
+
+	if len(report.DeadEnds) != 1 || report.DeadEnds[0] != c {
+		t.Errorf("dead ends are wrong: expected only /c, got %d pages", len(report.DeadEnds))
+	}
+
+	if len(report.SingleInlinks) != 2 || report.SingleInlinks[0] != a || report.SingleInlinks[1] != c {
+		t.Errorf("single inlink pages are wrong: expected /a and /c, got %d pages", len(report.SingleInlinks))
+	}
+}
+
+func TestAnalyseDominators(t *testing.T) {
+	root, a, b, c := genTestGraph()
+	report := Analyse(root)
+
+	// b can be reached from root directly or through a, but c can only be reached through a
+	expected := map[*crawl.HtmlPage]*crawl.HtmlPage{root: nil, a: root, b: root, c: a}
+	for page, dominator := range expected {
+		if report.Metrics[page].Dominator != dominator {
+			t.Errorf("%s has the wrong dominator", page.Url)
+		}
+	}
+	if len(report.SinglePaths) != 1 || report.SinglePaths[0] != c {
+		t.Errorf("single path pages are wrong: expected only /c, got %d pages", len(report.SinglePaths))
+	}
+
+	// two links in doesn't mean two ways in: x and y both hang off hub, so everything to do with target goes through hub
+	page := func(path string) *crawl.HtmlPage {
+		return &crawl.HtmlPage{Url: &url.URL{Scheme: "https", Host: "testsite.test", Path: path}, Title: path, IsParsed: true}
+	}
+	root, hub, x, y, target := page("/"), page("/hub"), page("/x"), page("/y"), page("/target")
+	root.LinksTo = []*crawl.HtmlPage{hub}
+	hub.LinksTo = []*crawl.HtmlPage{x, y}
+	x.LinksTo = []*crawl.HtmlPage{target}
+	y.LinksTo = []*crawl.HtmlPage{target, root}
+
+	metrics := Analyse(root).Metrics[target]
+	if metrics.SingleInlink || !metrics.SinglePath || metrics.Dominator != hub {
+		t.Errorf("expected /target to be single path through /hub despite its two inlinks")
+	}
+}
+
+func TestAnalyseDeadEndsSkipBrokenPages(t *testing.T) {
+	// pages we couldn't crawl (or which came back as errors) have no links because we never saw any, not because they're dead ends
+	root, a, _, c := genTestGraph()
+	failed := &crawl.HtmlPage{Url: &url.URL{Scheme: "https", Host: "testsite.test", Path: "/failed"}, CrawlError: errors.New("connection refused")}
+	missing := &crawl.HtmlPage{Url: &url.URL{Scheme: "https", Host: "testsite.test", Path: "/missing"}, IsParsed: true, StatusCode: 404}
+	a.LinksTo = append(a.LinksTo, failed, missing)
+
+	report := Analyse(root)
+	if len(report.DeadEnds) != 1 || report.DeadEnds[0] != c {
+		t.Errorf("dead ends are wrong: expected only /c, got %d pages", len(report.DeadEnds))
+	}
+}
+
+func TestAnalyseScores(t *testing.T) {
+	root, a, b, c := genTestGraph()
+	report := Analyse(root)
+
+	var total float64
+	for _, metrics := range report.Metrics {
+		total += metrics.PageRank
+	}
+	if math.Abs(total-1) > 1e-6 {
+		t.Errorf("PageRank scores should add up to 1, got %f", total)
+	}
+
+	// b is linked from both root and a, so it should outrank a
+	if report.Metrics[b].PageRank <= report.Metrics[a].PageRank {
+		t.Errorf("expected /b to outrank /a: got %f vs %f", report.Metrics[b].PageRank, report.Metrics[a].PageRank)
+	}
+
+	// b is the best authority (two hubs point at it), and a is the best hub (it points at b and c)
+	if report.Metrics[b].AuthorityScore <= report.Metrics[c].AuthorityScore {
+		t.Errorf("expected /b to be a better authority than /c: got %f vs %f", report.Metrics[b].AuthorityScore, report.Metrics[c].AuthorityScore)
+	}
+	if report.Metrics[a].HubScore <= report.Metrics[b].HubScore {
+		t.Errorf("expected /a to be a better hub than /b: got %f vs %f", report.Metrics[a].HubScore, report.Metrics[b].HubScore)
+	}
+}
+
+func TestAnalyseComponents(t *testing.T) {
+	root, a, b, c := genTestGraph()
+	report := Analyse(root)
+
+	// root, a and b all reach each other; c doesn't link anywhere
+	if len(report.Components) != 2 || len(report.Components[0]) != 3 || len(report.Components[1]) != 1 {
+		t.Fatalf("unexpected strongly connected components: got %d components", len(report.Components))
+	}
+
+	for _, page := range []*crawl.HtmlPage{root, a, b} {
+		if report.Metrics[page].Component != 0 {
+			t.Errorf("%s should be in component 0, got %d", page.Url, report.Metrics[page].Component)
+		}
+	}
+	if report.Metrics[c].Component != 1 {
+		t.Errorf("/c should be in component 1, got %d", report.Metrics[c].Component)
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	root, _, _, _ := genTestGraph()
+
+	var out bytes.Buffer
+	if err := Analyse(root).WriteSummary(&out, 2); err != nil {
+		t.Fatalf("WriteSummary returned an error: %s", err)
+	}
+
+	summary := out.String()
+	for _, expected := range []string{
+		"4 pages, 5 distinct internal links, 2 strongly connected components (largest has 3 pages)",
+		"Top pages by PageRank:",
+		"Dead ends (no internal links out) (1):",
+		"Linked from only one page (2):",
+		"Only reachable through one other page (1):",
+	} {
+		if !strings.Contains(summary, expected) {
+			t.Errorf("summary is missing %q:\n%s", expected, summary)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+// diff compares two crawls of the same site, to spot what changed in its structure (say, between two deploys).
+
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+type CrawlDiff struct {
+	// CrawlDiff is everything that changed between an old and a new crawl. Pages are matched up by URL.
+	// Every list is sorted by URL, so that diffs of the same crawls always come out the same.
+
+	OldSeed string `json:"oldSeed"`
+	NewSeed string `json:"newSeed"`
+
+	// Added and Removed are pages which only appear in the new / old crawl
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+
+	// NewlyBroken are pages which are broken in the new crawl, and either weren't in the old one or weren't broken in it.
+	// These are the regressions.
+	NewlyBroken []BrokenPage `json:"newlyBroken"`
+
+	// Fixed are pages which were broken in the old crawl, and either aren't any more or have gone altogether
+	// (in which case Status and Error are from the old crawl)
+	Fixed []BrokenPage `json:"fixed"`
+
+	TitleChanges    []TitleChange    `json:"titleChanges"`
+	MetadataChanges []MetadataChange `json:"metadataChanges"`
+	StatusChanges   []StatusChange   `json:"statusChanges"`
+	RedirectChanges []RedirectChange `json:"redirectChanges"`
+	InlinkChanges   []InlinkChange   `json:"inlinkChanges"`
+}
+
+type BrokenPage struct {
+	// BrokenPage is a broken page, along with the pages linking to it.
+	Url     string   `json:"url"`
+	Status  int      `json:"status,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Kind    string   `json:"kind,omitempty"`
+	Sources []string `json:"sources"`
+}
+
+type TitleChange struct {
+	Url string `json:"url"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+type MetadataChange struct {
+	// MetadataChange is a change to one of a page's other bits of metadata: Field is "meta robots", "description" or "canonical".
+	Url   string `json:"url"`
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+type StatusChange struct {
+	Url string `json:"url"`
+	Old int    `json:"old"`
+	New int    `json:"new"`
+}
+
+type RedirectChange struct {
+	// RedirectChange is a page whose redirect chain changed (each hop is "status url").
+	Url string   `json:"url"`
+	Old []string `json:"old"`
+	New []string `json:"new"`
+}
+
+type InlinkChange struct {
+	// InlinkChange is a page that gained or lost inbound links (counted as distinct linking pages).
+	Url string `json:"url"`
+	Old int    `json:"old"`
+	New int    `json:"new"`
+}
+
+type crawlIndex struct {
+	// crawlIndex is a crawl's pages and reverse index, keyed by URL.
+	pages   map[string]*crawl.HtmlPage
+	inlinks map[string][]*crawl.HtmlPage
+	urls    []string
+}
+
+func indexCrawl(c *crawl.Crawl) *crawlIndex {
+	// indexCrawl indexes every page of c by URL.
+	index := &crawlIndex{pages: map[string]*crawl.HtmlPage{}, inlinks: map[string][]*crawl.HtmlPage{}}
+
+	reverse := ReverseIndex(c.Root)
+	for _, page := range crawl.Pages(c.Root) {
+		url := page.Url.String()
+		index.pages[url] = page
+		index.inlinks[url] = reverse[page]
+		index.urls = append(index.urls, url)
+	}
+	sort.Strings(index.urls)
+
+	return index
+}
+
+func Diff(old *crawl.Crawl, new *crawl.Crawl) *CrawlDiff {
+	// Diff compares two crawls.
+	oldIndex, newIndex := indexCrawl(old), indexCrawl(new)
+	// empty lists rather than nil ones, so that the JSON output has [] rather than null
+	diff := &CrawlDiff{
+		OldSeed:         old.Seed,
+		NewSeed:         new.Seed,
+		Added:           []string{},
+		Removed:         []string{},
+		NewlyBroken:     []BrokenPage{},
+		Fixed:           []BrokenPage{},
+		TitleChanges:    []TitleChange{},
+		MetadataChanges: []MetadataChange{},
+		StatusChanges:   []StatusChange{},
+		RedirectChanges: []RedirectChange{},
+		InlinkChanges:   []InlinkChange{},
+	}
+
+	for _, url := range oldIndex.urls {
+		oldPage := oldIndex.pages[url]
+		newPage, stillThere := newIndex.pages[url]
+
+		if !stillThere {
+			diff.Removed = append(diff.Removed, url)
+			if oldPage.IsBroken() {
+				diff.Fixed = append(diff.Fixed, brokenPage(oldPage, oldIndex.inlinks[url]))
+			}
+			continue
+		}
+
+		if oldPage.IsBroken() && !newPage.IsBroken() {
+			diff.Fixed = append(diff.Fixed, brokenPage(oldPage, oldIndex.inlinks[url]))
+		}
+		if !oldPage.IsBroken() && newPage.IsBroken() {
+			diff.NewlyBroken = append(diff.NewlyBroken, brokenPage(newPage, newIndex.inlinks[url]))
+		}
+
+		if oldPage.Title != newPage.Title {
+			diff.TitleChanges = append(diff.TitleChanges, TitleChange{Url: url, Old: oldPage.Title, New: newPage.Title})
+		}
+		diff.MetadataChanges = append(diff.MetadataChanges, metadataChanges(url, oldPage, newPage)...)
+		if oldPage.StatusCode != newPage.StatusCode {
+			diff.StatusChanges = append(diff.StatusChanges, StatusChange{Url: url, Old: oldPage.StatusCode, New: newPage.StatusCode})
+		}
+
+		oldRedirects, newRedirects := redirectChain(oldPage), redirectChain(newPage)
+		if strings.Join(oldRedirects, "\n") != strings.Join(newRedirects, "\n") {
+			diff.RedirectChanges = append(diff.RedirectChanges, RedirectChange{Url: url, Old: oldRedirects, New: newRedirects})
+		}
+
+		if len(oldIndex.inlinks[url]) != len(newIndex.inlinks[url]) {
+			diff.InlinkChanges = append(diff.InlinkChanges, InlinkChange{Url: url, Old: len(oldIndex.inlinks[url]), New: len(newIndex.inlinks[url])})
+		}
+	}
+
+	for _, url := range newIndex.urls {
+		if _, wasThere := oldIndex.pages[url]; wasThere {
+			continue
+		}
+		diff.Added = append(diff.Added, url)
+		if newIndex.pages[url].IsBroken() {
+			diff.NewlyBroken = append(diff.NewlyBroken, brokenPage(newIndex.pages[url], newIndex.inlinks[url]))
+		}
+	}
+
+	return diff
+}
+
+func metadataChanges(url string, oldPage *crawl.HtmlPage, newPage *crawl.HtmlPage) []MetadataChange {
+	// metadataChanges lists the metadata (other than the title) which differs between two crawls of a page, in a fixed order.
+	var changes []MetadataChange
+	for _, field := range []struct {
+		name     string
+		old, new string
+	}{
+		{"meta robots", oldPage.MetaRobots, newPage.MetaRobots},
+		{"description", oldPage.Description, newPage.Description},
+		{"canonical", oldPage.Canonical, newPage.Canonical},
+	} {
+		if field.old != field.new {
+			changes = append(changes, MetadataChange{Url: url, Field: field.name, Old: field.old, New: field.new})
+		}
+	}
+	return changes
+}
+
+func brokenPage(page *crawl.HtmlPage, sources []*crawl.HtmlPage) BrokenPage {
+	// brokenPage describes a broken page and the pages linking to it.
+	broken := BrokenPage{Url: page.Url.String(), Status: page.StatusCode, Sources: []string{}}
+	if page.CrawlError != nil {
+		broken.Error = page.CrawlError.Error()
+		// plain errors (from crawls saved before errors had kinds) don't have one worth reporting
+		if kind := crawl.ErrorKindOf(page.CrawlError); kind != crawl.ErrorOther {
+			broken.Kind = kind.String()
+		}
+	} else if page.Soft404 != "" {
+		broken.Error, broken.Kind = page.Soft404, "soft404"
+	}
+	for _, source := range sources {
+		broken.Sources = append(broken.Sources, source.Url.String())
+	}
+	return broken
+}
+
+func redirectChain(page *crawl.HtmlPage) []string {
+	// redirectChain describes each hop of a page's redirects as "status url".
+	chain := []string{}
+	for _, redirect := range page.Redirects {
+		chain = append(chain, fmt.Sprintf("%d %s", redirect.StatusCode, redirect.Url.String()))
+	}
+	return chain
+}
+
+func (d *CrawlDiff) HasRegressions() bool {
+	// HasRegressions returns true if anything got worse: right now, that means a page became broken.
+	return len(d.NewlyBroken) > 0
+}
+
+func (d *CrawlDiff) IsEmpty() bool {
+	// IsEmpty returns true if nothing changed at all.
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.NewlyBroken) == 0 && len(d.Fixed) == 0 &&
+		len(d.TitleChanges) == 0 && len(d.MetadataChanges) == 0 && len(d.StatusChanges) == 0 && len(d.RedirectChanges) == 0 && len(d.InlinkChanges) == 0
+}
+
+func (d *CrawlDiff) WriteJSON(w io.Writer) error {
+	// WriteJSON writes the diff as indented JSON.
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(d)
+}
+
+func (d *CrawlDiff) WriteText(w io.Writer) error {
+	// WriteText writes the diff in a human readable format, skipping any sections with nothing in them.
+	var out strings.Builder
+
+	if d.IsEmpty() {
+		out.WriteString("✅ no changes\n")
+	}
+
+	section := func(heading string, count int) {
+		fmt.Fprintf(&out, "\n%s (%d):\n", heading, count)
+	}
+
+	if len(d.NewlyBroken) > 0 {
+		section("❌ Newly broken", len(d.NewlyBroken))
+		for _, page := range d.NewlyBroken {
+			fmt.Fprintf(&out, "  %s (%s)\n", page.Url, brokenReason(page))
+			for _, source := range page.Sources {
+				fmt.Fprintf(&out, "    linked from %s\n", source)
+			}
+		}
+	}
+	if len(d.Fixed) > 0 {
+		section("🔧 Fixed", len(d.Fixed))
+		for _, page := range d.Fixed {
+			fmt.Fprintf(&out, "  %s (was %s)\n", page.Url, brokenReason(page))
+		}
+	}
+	if len(d.Added) > 0 {
+		section("➕ Added pages", len(d.Added))
+		for _, url := range d.Added {
+			fmt.Fprintf(&out, "  %s\n", url)
+		}
+	}
+	if len(d.Removed) > 0 {
+		section("➖ Removed pages", len(d.Removed))
+		for _, url := range d.Removed {
+			fmt.Fprintf(&out, "  %s\n", url)
+		}
+	}
+	if len(d.StatusChanges) > 0 {
+		section("🚦 Status changes", len(d.StatusChanges))
+		for _, change := range d.StatusChanges {
+			fmt.Fprintf(&out, "  %s: %d → %d\n", change.Url, change.Old, change.New)
+		}
+	}
+	if len(d.TitleChanges) > 0 {
+		section("📝 Title changes", len(d.TitleChanges))
+		for _, change := range d.TitleChanges {
+			fmt.Fprintf(&out, "  %s: %q → %q\n", change.Url, change.Old, change.New)
+		}
+	}
+	if len(d.MetadataChanges) > 0 {
+		section("🏷️ Metadata changes", len(d.MetadataChanges))
+		for _, change := range d.MetadataChanges {
+			fmt.Fprintf(&out, "  %s %s: %q → %q\n", change.Url, change.Field, change.Old, change.New)
+		}
+	}
+	if len(d.RedirectChanges) > 0 {
+		section("↪️ Redirect changes", len(d.RedirectChanges))
+		for _, change := range d.RedirectChanges {
+			fmt.Fprintf(&out, "  %s: [%s] → [%s]\n", change.Url, strings.Join(change.Old, ", "), strings.Join(change.New, ", "))
+		}
+	}
+	if len(d.InlinkChanges) > 0 {
+		section("🔗 Inbound link count changes", len(d.InlinkChanges))
+		for _, change := range d.InlinkChanges {
+			fmt.Fprintf(&out, "  %s: %d → %d\n", change.Url, change.Old, change.New)
+		}
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+func brokenReason(page BrokenPage) string {
+	// brokenReason describes why a page is broken.
+	if page.Error != "" && page.Kind != "" && page.Kind != "http" {
+		return fmt.Sprintf("%s (%s)", page.Error, page.Kind)
+	}
+	if page.Error != "" {
+		return page.Error
+	}
+	return fmt.Sprintf("HTTP %d", page.Status)
+}
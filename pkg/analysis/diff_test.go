@@ -0,0 +1,168 @@
+package analysis
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+func genTestDiff() (*crawl.Crawl, *crawl.Crawl) {
+	// old: root -> a, b; a -> c (c is a 404)
+	// new: root -> a, d; a -> c (c is fixed, b is gone, d is new and broken, a's title changed and it now redirects)
+	page := func(path string, status int) *crawl.HtmlPage {
+		return &crawl.HtmlPage{Url: &url.URL{Scheme: "https", Host: "testsite.test", Path: path}, Title: path, StatusCode: status}
+	}
+
+	oldRoot, oldA, oldB, oldC := page("/", 200), page("/a", 200), page("/b", 200), page("/c", 404)
+	oldRoot.LinksTo = []*crawl.HtmlPage{oldA, oldB}
+	oldA.LinksTo = []*crawl.HtmlPage{oldC}
+
+	newRoot, newA, newC, newD := page("/", 200), page("/a", 200), page("/c", 200), page("/d", 0)
+	newA.Title = "A, renamed"
+	newA.Redirects = []crawl.Redirect{{StatusCode: 301, Url: &url.URL{Scheme: "https", Host: "testsite.test", Path: "/a/"}}}
+	newD.CrawlError = errors.New("connection refused")
+	newRoot.LinksTo = []*crawl.HtmlPage{newA, newD}
+	newA.LinksTo = []*crawl.HtmlPage{newC}
+	newD.LinksTo = []*crawl.HtmlPage{newC}
+
+	return &crawl.Crawl{Root: oldRoot, Seed: "testsite.test"}, &crawl.Crawl{Root: newRoot, Seed: "testsite.test"}
+}
+
+func TestDiff(t *testing.T) {
+	diff := Diff(genTestDiff())
+
+	if len(diff.Added) != 1 || diff.Added[0] != "https://testsite.test/d" {
+		t.Errorf("Diff found the wrong added pages: %v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0] != "https://testsite.test/b" {
+		t.Errorf("Diff found the wrong removed pages: %v", diff.Removed)
+	}
+
+	if len(diff.NewlyBroken) != 1 || diff.NewlyBroken[0].Url != "https://testsite.test/d" || diff.NewlyBroken[0].Error != "connection refused" {
+		t.Errorf("Diff found the wrong newly broken pages: %+v", diff.NewlyBroken)
+	} else if sources := diff.NewlyBroken[0].Sources; len(sources) != 1 || sources[0] != "https://testsite.test/" {
+		t.Errorf("Diff listed the wrong sources for a newly broken page: %v", sources)
+	}
+	if len(diff.Fixed) != 1 || diff.Fixed[0].Url != "https://testsite.test/c" || diff.Fixed[0].Status != 404 {
+		t.Errorf("Diff found the wrong fixed pages: %+v", diff.Fixed)
+	}
+
+	if len(diff.TitleChanges) != 1 || diff.TitleChanges[0].New != "A, renamed" {
+		t.Errorf("Diff found the wrong title changes: %+v", diff.TitleChanges)
+	}
+	if len(diff.StatusChanges) != 1 || diff.StatusChanges[0].Old != 404 || diff.StatusChanges[0].New != 200 {
+		t.Errorf("Diff found the wrong status changes: %+v", diff.StatusChanges)
+	}
+	if len(diff.RedirectChanges) != 1 || len(diff.RedirectChanges[0].New) != 1 || diff.RedirectChanges[0].New[0] != "301 https://testsite.test/a/" {
+		t.Errorf("Diff found the wrong redirect changes: %+v", diff.RedirectChanges)
+	}
+	// c gained a link from d
+	if len(diff.InlinkChanges) != 1 || diff.InlinkChanges[0].Url != "https://testsite.test/c" || diff.InlinkChanges[0].New != 2 {
+		t.Errorf("Diff found the wrong inlink changes: %+v", diff.InlinkChanges)
+	}
+
+	if !diff.HasRegressions() {
+		t.Error("Diff didn't report a newly broken page as a regression")
+	}
+}
+
+func TestDiffSoft404(t *testing.T) {
+	// a page which turns into a soft 404 is newly broken, even though its status hasn't changed
+	old, new := genTestDiff()
+	newA := new.Root.LinksTo[0]
+	newA.Soft404 = `title "Page not found" matches /not found/`
+
+	diff := Diff(old, new)
+	if len(diff.NewlyBroken) != 2 || diff.NewlyBroken[0].Url != "https://testsite.test/a" || diff.NewlyBroken[0].Kind != "soft404" {
+		t.Errorf("Diff didn't report the soft 404 as newly broken: %+v", diff.NewlyBroken)
+	}
+}
+
+func TestDiffMetadata(t *testing.T) {
+	// changes to a page's robots tag, description and canonical URL are each listed, but an unchanged one isn't
+	old, new := genTestDiff()
+	oldA, newA := old.Root.LinksTo[0], new.Root.LinksTo[0]
+	oldA.MetaRobots, oldA.Description, oldA.Canonical = "", "All about A", "https://testsite.test/a"
+	newA.MetaRobots, newA.Description, newA.Canonical = "noindex", "All about A", "https://testsite.test/a/"
+
+	diff := Diff(old, new)
+	expected := []MetadataChange{
+		{Url: "https://testsite.test/a", Field: "meta robots", Old: "", New: "noindex"},
+		{Url: "https://testsite.test/a", Field: "canonical", Old: "https://testsite.test/a", New: "https://testsite.test/a/"},
+	}
+	if len(diff.MetadataChanges) != len(expected) {
+		t.Fatalf("Diff found the wrong metadata changes: %+v", diff.MetadataChanges)
+	}
+	for i := range expected {
+		if diff.MetadataChanges[i] != expected[i] {
+			t.Errorf("metadata change %d: expected %+v, got %+v", i, expected[i], diff.MetadataChanges[i])
+		}
+	}
+
+	var text bytes.Buffer
+	if err := diff.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"Metadata changes (2)", `https://testsite.test/a meta robots: "" → "noindex"`} {
+		if !strings.Contains(text.String(), expected) {
+			t.Errorf("WriteText output is missing %q:\n%s", expected, text.String())
+		}
+	}
+
+	// metadata changes alone are still changes
+	_, changed := genTestDiff()
+	unchanged, _ := genTestDiff()
+	changed.Root, unchanged.Root = changed.Root.LinksTo[0].LinksTo[0], unchanged.Root.LinksTo[0].LinksTo[0]
+	changed.Root.Description = "Welcome"
+	if Diff(unchanged, changed).IsEmpty() {
+		t.Error("a diff with only a description change was reported as empty")
+	}
+}
+
+func TestDiffOfSameCrawl(t *testing.T) {
+	old, _ := genTestDiff()
+	diff := Diff(old, old)
+
+	if !diff.IsEmpty() || diff.HasRegressions() {
+		t.Errorf("Diff of a crawl against itself wasn't empty: %+v", diff)
+	}
+
+	var out bytes.Buffer
+	if err := diff.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "no changes") {
+		t.Errorf("WriteText of an empty diff didn't say so: %q", out.String())
+	}
+}
+
+func TestDiffOutput(t *testing.T) {
+	diff := Diff(genTestDiff())
+
+	var text bytes.Buffer
+	if err := diff.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"Newly broken (1)", "https://testsite.test/d (connection refused)", "linked from https://testsite.test/", "https://testsite.test/c (was HTTP 404)", "404 → 200"} {
+		if !strings.Contains(text.String(), expected) {
+			t.Errorf("WriteText output is missing %q:\n%s", expected, text.String())
+		}
+	}
+
+	var encoded bytes.Buffer
+	if err := diff.WriteJSON(&encoded); err != nil {
+		t.Fatal(err)
+	}
+	var decoded CrawlDiff
+	if err := json.Unmarshal(encoded.Bytes(), &decoded); err != nil {
+		t.Fatalf("WriteJSON wrote invalid JSON: %s", err)
+	}
+	if len(decoded.NewlyBroken) != 1 || len(decoded.Added) != 1 || decoded.OldSeed != "testsite.test" {
+		t.Errorf("WriteJSON output didn't round trip: %+v", decoded)
+	}
+}
@@ -0,0 +1,130 @@
+// paths answers "how do users get to this page?" - shortest click paths between pages, and which pages link to a page.
+
+package analysis
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+func FindPage(root *crawl.HtmlPage, target string) *crawl.HtmlPage {
+	// FindPage looks up a page in the graph reachable from root by URL.
+	// target can be relative (e.g "/docs/"), in which case it's resolved against the root's URL,
+	// and a missing or extra trailing slash is forgiven if there's no exact match.
+	// It returns nil if there's no such page.
+
+	targetUrl, err := url.Parse(target)
+	if err != nil {
+		return nil
+	}
+	targetUrl = root.Url.ResolveReference(targetUrl)
+	targetUrl.Fragment = ""
+
+	exact := targetUrl.String()
+	var loose *crawl.HtmlPage
+
+	for _, page := range crawl.Pages(root) {
+		candidate := page.Url.String()
+		if candidate == exact {
+			return page
+		}
+		if loose == nil && strings.TrimSuffix(candidate, "/") == strings.TrimSuffix(exact, "/") {
+			loose = page
+		}
+	}
+
+	return loose
+}
+
+func ReverseIndex(root *crawl.HtmlPage) map[*crawl.HtmlPage][]*crawl.HtmlPage {
+	// ReverseIndex returns, for every page reachable from root, the pages which link to it (the reverse of LinksTo).
+	// Each linking page is only listed once, in breadth first order, however many times it links to the page.
+	index := map[*crawl.HtmlPage][]*crawl.HtmlPage{}
+
+	for _, page := range crawl.Pages(root) {
+		seen := map[*crawl.HtmlPage]bool{}
+		for _, link := range page.LinksTo {
+			if seen[link] {
+				continue
+			}
+			seen[link] = true
+			index[link] = append(index[link], page)
+		}
+	}
+
+	return index
+}
+
+func Inlinks(root *crawl.HtmlPage, target *crawl.HtmlPage) []*crawl.HtmlPage {
+	// Inlinks returns every page reachable from root which links to target.
+	return ReverseIndex(root)[target]
+}
+
+func ShortestPaths(from *crawl.HtmlPage, to *crawl.HtmlPage, limit int) [][]*crawl.HtmlPage {
+	// ShortestPaths returns the shortest click paths from one page to another, each starting with from and ending with to.
+	// If there are several equally short paths, all of them are returned (up to limit of them, if limit is above 0).
+	// It returns nil if to can't be reached from from.
+
+	if from == to {
+		return [][]*crawl.HtmlPage{{from}}
+	}
+
+	// breadth first search, remembering every parent that reaches a page at its shortest distance (not just the first)
+	distance := map[*crawl.HtmlPage]int{from: 0}
+	parents := map[*crawl.HtmlPage][]*crawl.HtmlPage{}
+	queue := []*crawl.HtmlPage{from}
+
+	for next := 0; next < len(queue); next++ {
+		current := queue[next]
+
+		// everything left in the queue is at least as far away as to, so stop
+		if _, found := distance[to]; found && distance[current] >= distance[to] {
+			break
+		}
+
+		seen := map[*crawl.HtmlPage]bool{}
+		for _, link := range current.LinksTo {
+			if seen[link] {
+				continue
+			}
+			seen[link] = true
+
+			d, visited := distance[link]
+			if !visited {
+				distance[link] = distance[current] + 1
+				parents[link] = []*crawl.HtmlPage{current}
+				queue = append(queue, link)
+			} else if d == distance[current]+1 {
+				parents[link] = append(parents[link], current)
+			}
+		}
+	}
+
+	if _, found := distance[to]; !found {
+		return nil
+	}
+
+	// walk back up the parents from to, building every path (in reverse)
+	var paths [][]*crawl.HtmlPage
+	var f func(page *crawl.HtmlPage, suffix []*crawl.HtmlPage)
+	f = func(page *crawl.HtmlPage, suffix []*crawl.HtmlPage) {
+		if limit > 0 && len(paths) >= limit {
+			return
+		}
+
+		path := append([]*crawl.HtmlPage{page}, suffix...)
+		if page == from {
+			paths = append(paths, path)
+			return
+		}
+
+		for _, parent := range parents[page] {
+			f(parent, path)
+		}
+	}
+	f(to, nil)
+
+	return paths
+}
@@ -0,0 +1,88 @@
+package analysis
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+func TestFindPage(t *testing.T) {
+	root, a, _, c := genTestGraph()
+
+	tests := map[string]*crawl.HtmlPage{
+		"https://testsite.test/a": a,
+		"/c":                      c,
+		"/c/":                     c,
+		"/a#section":              a,
+		"/nope":                   nil,
+	}
+	for target, expected := range tests {
+		if page := FindPage(root, target); page != expected {
+			t.Errorf("FindPage(%q) returned the wrong page: expected %p, got %p", target, expected, page)
+		}
+	}
+}
+
+func TestInlinks(t *testing.T) {
+	root, a, b, _ := genTestGraph()
+
+	// a links to b twice, but should only be listed once
+	inlinks := Inlinks(root, b)
+	if len(inlinks) != 3 || inlinks[0] != root || inlinks[1] != a || inlinks[2] != b {
+		t.Errorf("Inlinks returned unexpected pages for /b: got %d pages", len(inlinks))
+	}
+}
+
+func TestLookupsLeaveDepthsAlone(t *testing.T) {
+	// finding pages and their inlinks is read only, even from somewhere other than the root: the depths stay as the crawl left them
+	root, a, b, c := genTestGraph()
+	for _, page := range []*crawl.HtmlPage{root, a, b, c} {
+		page.Depth, page.DepthParent = 42, root
+	}
+
+	FindPage(a, "/c")
+	ReverseIndex(a)
+	for _, page := range []*crawl.HtmlPage{root, a, b, c} {
+		if page.Depth != 42 || page.DepthParent != root {
+			t.Errorf("looking pages up changed the depth of %s to %d", page.Url.Path, page.Depth)
+		}
+	}
+}
+
+func TestShortestPaths(t *testing.T) {
+	// root -> a -> d and root -> b -> d are both two clicks; root -> c -> e -> d is three
+	page := func(path string) *crawl.HtmlPage {
+		return &crawl.HtmlPage{Url: &url.URL{Scheme: "https", Host: "testsite.test", Path: path}}
+	}
+	root, a, b, c, d, e := page("/"), page("/a"), page("/b"), page("/c"), page("/d"), page("/e")
+	root.LinksTo = []*crawl.HtmlPage{a, b, c}
+	a.LinksTo = []*crawl.HtmlPage{d}
+	b.LinksTo = []*crawl.HtmlPage{d}
+	c.LinksTo = []*crawl.HtmlPage{e}
+	e.LinksTo = []*crawl.HtmlPage{d}
+
+	paths := ShortestPaths(root, d, 0)
+	if len(paths) != 2 {
+		t.Fatalf("ShortestPaths returned the wrong number of paths: expected %d, got %d", 2, len(paths))
+	}
+	for _, path := range paths {
+		if len(path) != 3 || path[0] != root || path[2] != d {
+			t.Errorf("ShortestPaths returned a path which isn't root -> ? -> d (length %d)", len(path))
+		}
+	}
+
+	if paths := ShortestPaths(root, d, 1); len(paths) != 1 {
+		t.Errorf("ShortestPaths ignored its limit: expected %d path, got %d", 1, len(paths))
+	}
+
+	// from somewhere other than the root
+	if paths := ShortestPaths(c, d, 0); len(paths) != 1 || len(paths[0]) != 3 {
+		t.Errorf("ShortestPaths from /c should find c -> e -> d")
+	}
+
+	// d is a dead end, so there's no way back to root
+	if paths := ShortestPaths(d, root, 0); paths != nil {
+		t.Errorf("ShortestPaths found a path which doesn't exist")
+	}
+}
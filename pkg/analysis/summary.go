@@ -0,0 +1,84 @@
+// summary turns a Report into something a human can read on the console.
+
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+func (r *Report) WriteSummary(w io.Writer, top int) error {
+	// WriteSummary writes a console summary of the report, listing the top pages by each score.
+	// Lists are cut off after top entries (0 means no limit).
+
+	out := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	links := 0
+	for _, metrics := range r.Metrics {
+		links += metrics.OutDegree
+	}
+
+	fmt.Fprintf(out, "📊 %d pages, %d distinct internal links, %d strongly connected components", len(r.Pages), links, len(r.Components))
+	if len(r.Components) > 0 {
+		fmt.Fprintf(out, " (largest has %d pages)", len(r.Components[0]))
+	}
+	fmt.Fprintln(out)
+
+	r.writeRanking(out, "Top pages by PageRank", top, func(m *NodeMetrics) float64 { return m.PageRank })
+	r.writeRanking(out, "Top authorities (HITS)", top, func(m *NodeMetrics) float64 { return m.AuthorityScore })
+	r.writeRanking(out, "Top hubs (HITS)", top, func(m *NodeMetrics) float64 { return m.HubScore })
+	r.writeRanking(out, "Most linked to (in-degree)", top, func(m *NodeMetrics) float64 { return float64(m.InDegree) })
+	r.writeRanking(out, "Most links out (out-degree)", top, func(m *NodeMetrics) float64 { return float64(m.OutDegree) })
+
+	writePageList(out, "Dead ends (no internal links out)", r.DeadEnds, top)
+	writePageList(out, "Linked from only one page", r.SingleInlinks, top)
+	r.writeSinglePaths(out, top)
+
+	return out.Flush()
+}
+
+func (r *Report) writeRanking(out io.Writer, heading string, top int, score func(*NodeMetrics) float64) {
+	// writeRanking writes the pages with the highest score, highest first.
+	// Ties keep breadth first order, so shallower pages win.
+	ranked := make([]*crawl.HtmlPage, len(r.Pages))
+	copy(ranked, r.Pages)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return score(r.Metrics[ranked[i]]) > score(r.Metrics[ranked[j]])
+	})
+	if top > 0 && len(ranked) > top {
+		ranked = ranked[:top]
+	}
+
+	fmt.Fprintf(out, "\n%s:\n", heading)
+	for i, page := range ranked {
+		fmt.Fprintf(out, "  %d.\t%.4g\t%s\t%s\n", i+1, score(r.Metrics[page]), page.Url.String(), page.Title)
+	}
+}
+
+func writePageList(out io.Writer, heading string, pages []*crawl.HtmlPage, top int) {
+	// writePageList writes a heading with a count, then the first top pages.
+	fmt.Fprintf(out, "\n%s (%d):\n", heading, len(pages))
+	for i, page := range pages {
+		if top > 0 && i >= top {
+			fmt.Fprintf(out, "  ... and %d more\n", len(pages)-top)
+			break
+		}
+		fmt.Fprintf(out, "  %s\t%s\n", page.Url.String(), page.Title)
+	}
+}
+
+func (r *Report) writeSinglePaths(out io.Writer, top int) {
+	// writeSinglePaths lists the pages which can only be reached through one other page, along with that page.
+	fmt.Fprintf(out, "\nOnly reachable through one other page (%d):\n", len(r.SinglePaths))
+	for i, page := range r.SinglePaths {
+		if top > 0 && i >= top {
+			fmt.Fprintf(out, "  ... and %d more\n", len(r.SinglePaths)-top)
+			break
+		}
+		fmt.Fprintf(out, "  %s\t%s\tvia %s\n", page.Url.String(), page.Title, r.Metrics[page].Dominator.Url.String())
+	}
+}
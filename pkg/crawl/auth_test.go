@@ -0,0 +1,249 @@
+package crawl
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type recordingTransport struct {
+	// recordingTransport is a RoundTripper which answers everything with a 200, keeping the Authorization header of each request.
+	lock  sync.Mutex
+	auths map[string]string
+}
+
+func (r *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.lock.Lock()
+	r.auths[req.URL.String()] = req.Header.Get("Authorization")
+	r.lock.Unlock()
+	return &http.Response{StatusCode: 200, Header: http.Header{}, Body: http.NoBody, Request: req}, nil
+}
+
+func TestAuthHeaders(t *testing.T) {
+	// credentials should only ever go to the host (and port) they're for
+	base := &recordingTransport{auths: map[string]string{}}
+	transport := newAuthTransport(base, AuthOptions{
+		Basic:  map[string]Credentials{"testsite.test": {"crawler", "hunter2"}},
+		Bearer: map[string]string{"api.testsite.test:8443": "t0k3n"},
+	})
+
+	basic := "Basic " + "Y3Jhd2xlcjpodW50ZXIy" // crawler:hunter2
+	expectations := map[string]string{
+		"http://testsite.test/":           basic,
+		"https://testsite.test:8443/page": basic,
+		"http://www.testsite.test/":       "",
+		"https://api.testsite.test:8443/": "Bearer t0k3n",
+		"https://api.testsite.test/":      "",
+		"http://elsewhere.test/":          "",
+	}
+	for target := range expectations {
+		req, _ := http.NewRequest("GET", target, nil)
+		if _, err := transport.RoundTrip(req); err != nil {
+			t.Fatalf("%s: %s", target, err)
+		}
+		if req.Header.Get("Authorization") != "" {
+			t.Errorf("%s: the transport changed the request it was given", target)
+		}
+	}
+	for target, expected := range expectations {
+		if auth := base.auths[target]; auth != expected {
+			t.Errorf("%s: expected Authorization %q, got %q", target, expected, auth)
+		}
+	}
+}
+
+func TestAuthDigest(t *testing.T) {
+	// a Digest protected site, which changes its nonce partway through the crawl (so we have to cope with stale=true)
+	const realm, username, password = "testsite", "crawler", "hunter2"
+	md5hex := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+
+	var lock sync.Mutex
+	nonce, answered := "first", 0
+	var unauthorised, rejected []string
+
+	handler, _ := genTestSiteHandler()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		header := r.Header.Get("Authorization")
+		params := parseAuthParams(strings.TrimPrefix(header, "Digest "))
+		if !strings.HasPrefix(header, "Digest ") || params["nonce"] != nonce {
+			unauthorised = append(unauthorised, r.URL.Path)
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", nonce="%s", qop="auth,auth-int", stale=%t`, realm, nonce, header != ""))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		ha1 := md5hex(username + ":" + realm + ":" + password)
+		ha2 := md5hex(r.Method + ":" + r.URL.RequestURI())
+		expected := md5hex(strings.Join([]string{ha1, nonce, params["nc"], params["cnonce"], "auth", ha2}, ":"))
+		if params["username"] != username || params["uri"] != r.URL.RequestURI() || params["response"] != expected {
+			rejected = append(rejected, r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		answered++
+		if answered == 3 {
+			nonce = "second"
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	rootUrl, _ := url.Parse(server.URL + "/")
+	root := Walk(rootUrl, Options{Fetch: FetchOptions{
+		Auth: AuthOptions{Digest: map[string]Credentials{rootUrl.Hostname(): {username, password}}},
+	}})
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(rejected) > 0 {
+		t.Errorf("the server rejected our answers to its challenge for %v", rejected)
+	}
+	// one 401 to get the first challenge, and at least one more when the nonce goes stale
+	// (requests already on their way with the old nonce all get one)
+	if len(unauthorised) < 2 {
+		t.Errorf("expected the first challenge and a stale nonce, but only %d requests were unauthorised: %v", len(unauthorised), unauthorised)
+	}
+	for _, page := range ComputeDepths(root) {
+		if page.StatusCode == http.StatusUnauthorized {
+			t.Errorf("%s came back unauthorised", page.Url)
+		}
+	}
+}
+
+func TestFormLoginAndLogoutGuard(t *testing.T) {
+	// a members' site: every page needs Basic auth (given in the crawl's URL) and a session cookie from logging in,
+	// and the logout links would end the session. None of the secrets should turn up in the logs or the saved crawl.
+	var lock sync.Mutex
+	var rejected, loggedOut []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if username, password, _ := r.BasicAuth(); username != "crawler" || password != "hunter2" {
+			rejected = append(rejected, r.URL.Path)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/login":
+			if r.Method != "POST" || r.PostFormValue("user") != "crawler" || r.PostFormValue("pass") != "s3cr3t" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "logged-in"})
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		case "/logout", "/account":
+			loggedOut = append(loggedOut, r.URL.String())
+			http.SetCookie(w, &http.Cookie{Name: "session", MaxAge: -1})
+		}
+
+		if cookie, err := r.Cookie("session"); err != nil || cookie.Value != "logged-in" {
+			rejected = append(rejected, r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `<html><body><a href="/members">Members</a><a href="/logout">Log out</a><a href="/account?action=sign-off">Sign off</a></body></html>`)
+	}))
+	defer server.Close()
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	rootUrl, _ := url.Parse(server.URL + "/")
+	rootUrl.User = url.UserPassword("crawler", "hunter2")
+	checkpointPath := filepath.Join(t.TempDir(), "crawl.json")
+	root := Walk(rootUrl, Options{CheckpointPath: checkpointPath, Fetch: FetchOptions{Auth: AuthOptions{
+		FormLogin: &FormLogin{Url: server.URL + "/login", Fields: url.Values{"user": {"crawler"}, "pass": {"s3cr3t"}}},
+	}}})
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(rejected) > 0 {
+		t.Errorf("the server turned these requests away: %v", rejected)
+	}
+	if len(loggedOut) > 0 {
+		t.Errorf("the crawl followed logout links: %v", loggedOut)
+	}
+
+	pages := ComputeDepths(root)
+	if len(pages) != 2 {
+		t.Errorf("expected the root and /members, got %d pages", len(pages))
+	}
+	for _, page := range pages {
+		if page.Url.User != nil {
+			t.Errorf("%s still has credentials in it", page.Url.Redacted())
+		}
+	}
+
+	saved, err := os.ReadFile(checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"hunter2", "s3cr3t", "logged-in"} {
+		if bytes.Contains(saved, []byte(secret)) {
+			t.Errorf("%q turned up in the saved crawl", secret)
+		}
+		if strings.Contains(logs.String(), secret) {
+			t.Errorf("%q turned up in the logs", secret)
+		}
+	}
+}
+
+func TestAuthOptionsIsLogout(t *testing.T) {
+	expectations := map[string]bool{
+		"/logout":                  true,
+		"/user/log-out":            true,
+		"/Sign_Off":                true,
+		"/index.php?action=logout": true,
+		"/blog/logging-out-tips":   false,
+		"/catalogue":               false,
+	}
+	for path, expected := range expectations {
+		link, _ := url.Parse("http://testsite.test" + path)
+		if logout := (AuthOptions{}).isLogout(link); logout != expected {
+			t.Errorf("%s: expected isLogout to be %t", path, expected)
+		}
+	}
+
+	link, _ := url.Parse("http://testsite.test/logout")
+	if (AuthOptions{FollowLogoutLinks: true}).isLogout(link) {
+		t.Error("FollowLogoutLinks should let logout links through")
+	}
+}
+
+func TestParseAuthParams(t *testing.T) {
+	params := parseAuthParams(`realm="Members, only", nonce="abc\"123", qop=auth, stale=TRUE,opaque="x"`)
+	expected := map[string]string{"realm": "Members, only", "nonce": `abc"123`, "qop": "auth", "stale": "TRUE", "opaque": "x"}
+	for key, value := range expected {
+		if params[key] != value {
+			t.Errorf("%s: expected %q, got %q", key, value, params[key])
+		}
+	}
+	if len(params) != len(expected) {
+		t.Errorf("expected %d params, got %v", len(expected), params)
+	}
+
+	if credentials := fmt.Sprintf("%v %#v", Credentials{"crawler", "hunter2"}, Credentials{"crawler", "hunter2"}); strings.Contains(credentials, "hunter2") {
+		t.Errorf("credentials printed their password: %s", credentials)
+	}
+}
@@ -0,0 +1,379 @@
+// cache is an on-disk HTTP cache shared between crawls. Rather than page bodies, it keeps what was extracted from each page
+// (its title, links and so on) along with the response's validators (ETag and Last-Modified), so that a later crawl can ask
+// "has this changed?" with a conditional request, and reuse the old parse if the answer is 304 Not Modified.
+
+package crawl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheMaxBytes is the size an HTTPCache is trimmed back down to if it isn't given one
+const DefaultCacheMaxBytes = 256 << 20
+
+type CacheStatus int
+
+// CacheStatus says whether a page came out of the HTTP cache
+const (
+	// CacheMiss pages were fetched (and parsed) in full, as usual
+	CacheMiss CacheStatus = iota
+
+	// CacheHit pages were still fresh in the cache (going by their Cache-Control / Expires headers), so weren't fetched at all
+	CacheHit
+
+	// CacheRevalidated pages were fetched with a conditional request, and the server said they hadn't changed (304 Not Modified),
+	// so the cached parse was reused
+	CacheRevalidated
+)
+
+var cacheStatusNames = []string{"", "cached", "revalidated"}
+
+func (s CacheStatus) String() string {
+	if int(s) < len(cacheStatusNames) {
+		return cacheStatusNames[s]
+	}
+	return "unknown"
+}
+
+func ParseCacheStatus(name string) (CacheStatus, error) {
+	// ParseCacheStatus is the reverse of CacheStatus.String().
+	for i, statusName := range cacheStatusNames {
+		if name == statusName {
+			return CacheStatus(i), nil
+		}
+	}
+	return CacheMiss, errors.New("unknown cache status " + strconv.Quote(name))
+}
+
+type cacheEntry struct {
+	// cacheEntry is everything cached about a page. It's stored as JSON, one file per page.
+	Url          string           `json:"url"`
+	StoredAt     time.Time        `json:"storedAt"`
+	FreshUntil   time.Time        `json:"freshUntil,omitempty"`
+	ETag         string           `json:"etag,omitempty"`
+	LastModified string           `json:"lastModified,omitempty"`
+	StatusCode   int              `json:"statusCode"`
+	Redirects    []redirectRecord `json:"redirects,omitempty"`
+	Title        string           `json:"title,omitempty"`
+	Base         string           `json:"base,omitempty"`
+	Robots       string           `json:"robots,omitempty"`
+	Description  string           `json:"description,omitempty"`
+	Canonical    string           `json:"canonical,omitempty"`
+	Refresh      string           `json:"refresh,omitempty"`
+	Links        []string         `json:"links,omitempty"`
+
+	// Text is the page's main text. Unlike the rest of the crawl, the cache does keep it (on disk, within its size limit),
+	// as a page reused from the cache would otherwise never get its text to Options.Texts, or its Fingerprint worked out.
+	Text string `json:"text,omitempty"`
+}
+
+func (e *cacheEntry) fresh(now time.Time) bool {
+	// fresh returns true if the entry can be used without asking the server.
+	return now.Before(e.FreshUntil)
+}
+
+func (e *cacheEntry) pageInfo() *pageInfo {
+	return &pageInfo{title: e.Title, base: e.Base, robots: e.Robots, description: e.Description, canonical: e.Canonical, refresh: e.Refresh, links: e.Links, text: e.Text}
+}
+
+func (e *cacheEntry) redirects() []Redirect {
+	var redirects []Redirect
+	for _, redirect := range e.Redirects {
+		if redirectUrl, err := url.Parse(redirect.Url); err == nil {
+			redirects = append(redirects, Redirect{StatusCode: redirect.StatusCode, Url: redirectUrl})
+		}
+	}
+	return redirects
+}
+
+func (e *cacheEntry) conditionalHeaders() http.Header {
+	// conditionalHeaders are the headers which ask the server to only send the page if it's changed since the entry was stored.
+	header := http.Header{}
+	if e.ETag != "" {
+		header.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		header.Set("If-Modified-Since", e.LastModified)
+	}
+	return header
+}
+
+func (e *cacheEntry) updateFrom(resp *http.Response, now time.Time) bool {
+	// updateFrom takes the validators and freshness from resp (a 200, or a 304 for the entry), returning false if
+	// the response mustn't be cached at all.
+	cacheControl := parseCacheControl(resp.Header.Get("Cache-Control"))
+	if _, noStore := cacheControl["no-store"]; noStore {
+		return false
+	}
+	// private pages are only for whoever asked for them, and the cache is shared between crawls
+	if _, private := cacheControl["private"]; private {
+		return false
+	}
+
+	// a 304 only sends the headers which have changed
+	if etag := resp.Header.Get("ETag"); etag != "" || resp.StatusCode != http.StatusNotModified {
+		e.ETag = etag
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" || resp.StatusCode != http.StatusNotModified {
+		e.LastModified = lastModified
+	}
+
+	e.StoredAt = now
+	e.FreshUntil = time.Time{}
+	if _, noCache := cacheControl["no-cache"]; noCache {
+		// cacheable, but has to be checked with the server every time
+	} else if maxAge, err := strconv.Atoi(cacheControl["max-age"]); err == nil {
+		age, _ := strconv.Atoi(resp.Header.Get("Age"))
+		e.FreshUntil = now.Add(time.Duration(maxAge-age) * time.Second)
+	} else if expires, err := http.ParseTime(resp.Header.Get("Expires")); err == nil {
+		e.FreshUntil = expires
+	}
+
+	// without a validator or a lifetime, there'd be no way to use the entry
+	return e.ETag != "" || e.LastModified != "" || e.fresh(now)
+}
+
+func parseCacheControl(header string) map[string]string {
+	// parseCacheControl splits a Cache-Control header into its directives (lowercased, with any values).
+	directives := map[string]string{}
+	for _, directive := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if name != "" {
+			directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return directives
+}
+
+type HTTPCache struct {
+	// An 'HTTPCache' is an on-disk cache of parsed pages, in a directory of its own. It's safe for concurrent use.
+	// Once it grows past its size limit, the least recently used pages are dropped until it's back under.
+
+	dir      string
+	maxBytes int64
+
+	lock  sync.Mutex
+	files map[string]*cacheFile
+	total int64
+}
+
+type cacheFile struct {
+	size int64
+	used time.Time
+}
+
+func OpenHTTPCache(dir string, maxBytes int64) (*HTTPCache, error) {
+	// OpenHTTPCache opens (or creates) the cache in dir, which can hold up to maxBytes (DefaultCacheMaxBytes if it's 0 or less).
+	if maxBytes <= 0 {
+		maxBytes = DefaultCacheMaxBytes
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	cache := &HTTPCache{dir: dir, maxBytes: maxBytes, files: map[string]*cacheFile{}}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		cache.files[entry.Name()] = &cacheFile{size: info.Size(), used: info.ModTime()}
+		cache.total += info.Size()
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	cache.evict()
+	return cache, nil
+}
+
+func cacheKey(target *url.URL, identity string) string {
+	// cacheKey is the file name a URL's entry is stored under: a hash of the URL, normalised so that trivially different
+	// spellings of the same URL (upper case host, default port, fragment, query parameter order) share an entry,
+	// and of the identity it was fetched as (see cacheIdentity), so that crawls as different users never share one.
+	normalised := *target
+	normalised.Scheme = strings.ToLower(normalised.Scheme)
+	normalised.Host = strings.ToLower(normalised.Host)
+	if port := normalised.Port(); (normalised.Scheme == "http" && port == "80") || (normalised.Scheme == "https" && port == "443") {
+		normalised.Host = normalised.Hostname()
+	}
+	normalised.User = nil
+	normalised.Fragment = ""
+	if normalised.Path == "" {
+		normalised.Path = "/"
+	}
+	if normalised.RawQuery != "" {
+		// Values.Encode sorts by key
+		if query, err := url.ParseQuery(normalised.RawQuery); err == nil {
+			normalised.RawQuery = query.Encode()
+		}
+	}
+
+	// (the identity's always there, even if it's empty, so that entries from before identities were part of the key,
+	// which could have been fetched by anyone, are never found again, and age out of the cache)
+	sum := sha256.Sum256([]byte(normalised.String() + "\n" + identity))
+	return hex.EncodeToString(sum[:]) + ".json"
+}
+
+func cacheIdentity(options FetchOptions) string {
+	// cacheIdentity sums up who a crawl fetches pages as: "" for an anonymous crawl, and otherwise a hash of every credential
+	// and extra header it sends, so that a page fetched while logged in is never handed to a crawl which isn't (or is someone else).
+	var identity strings.Builder
+	for _, credentials := range []struct {
+		kind  string
+		hosts map[string]Credentials
+	}{{"basic", options.Auth.Basic}, {"digest", options.Auth.Digest}} {
+		hosts := make([]string, 0, len(credentials.hosts))
+		for host := range credentials.hosts {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+		for _, host := range hosts {
+			fmt.Fprintf(&identity, "%s %q %q %q\n", credentials.kind, host, credentials.hosts[host].Username, credentials.hosts[host].Password)
+		}
+	}
+	hosts := make([]string, 0, len(options.Auth.Bearer))
+	for host := range options.Auth.Bearer {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		fmt.Fprintf(&identity, "bearer %q %q\n", host, options.Auth.Bearer[host])
+	}
+	if login := options.Auth.FormLogin; login != nil {
+		// (Values.Encode sorts by key)
+		fmt.Fprintf(&identity, "login %q %q\n", login.Url, login.Fields.Encode())
+	}
+	// Header.Write sorts by name
+	options.Headers.Write(&identity)
+
+	if identity.Len() == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(identity.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *HTTPCache) get(target *url.URL, identity string) *cacheEntry {
+	// get returns the cached entry for target as fetched by identity, or nil if there isn't one (or it can't be read).
+	key := cacheKey(target, identity)
+
+	c.lock.Lock()
+	file, exists := c.files[key]
+	if exists {
+		file.used = time.Now()
+	}
+	c.lock.Unlock()
+	if !exists {
+		return nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(c.dir, key))
+	if err != nil {
+		return nil
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil
+	}
+	// mark it used on disk too, so the next crawl knows which entries are stale
+	now := time.Now()
+	os.Chtimes(filepath.Join(c.dir, key), now, now)
+	return &entry
+}
+
+func (c *HTTPCache) put(target *url.URL, identity string, entry *cacheEntry) error {
+	// put stores entry for target as fetched by identity, replacing any entry it already had, then trims the cache back under its size limit.
+	key := cacheKey(target, identity)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// write it somewhere else first, so a crawl reading the entry never sees half of it
+	temporary, err := os.CreateTemp(c.dir, "entry-*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := temporary.Write(data); err != nil {
+		temporary.Close()
+		os.Remove(temporary.Name())
+		return err
+	}
+	if err := temporary.Close(); err != nil {
+		os.Remove(temporary.Name())
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if err := os.Rename(temporary.Name(), filepath.Join(c.dir, key)); err != nil {
+		os.Remove(temporary.Name())
+		return err
+	}
+	if old, exists := c.files[key]; exists {
+		c.total -= old.size
+	}
+	c.files[key] = &cacheFile{size: int64(len(data)), used: time.Now()}
+	c.total += int64(len(data))
+	c.evict()
+	return nil
+}
+
+func (c *HTTPCache) evict() {
+	// evict deletes the least recently used entries until the cache fits in maxBytes. The caller must hold the lock.
+	if c.total <= c.maxBytes {
+		return
+	}
+
+	keys := make([]string, 0, len(c.files))
+	for key := range c.files {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return c.files[keys[i]].used.Before(c.files[keys[j]].used) })
+
+	for _, key := range keys {
+		if c.total <= c.maxBytes {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.dir, key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		c.total -= c.files[key].size
+		delete(c.files, key)
+	}
+}
+
+func (c *HTTPCache) Size() int64 {
+	// Size returns how many bytes the cache is using.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.total
+}
+
+func (c *HTTPCache) Len() int {
+	// Len returns how many pages are cached.
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.files)
+}
@@ -0,0 +1,183 @@
+// checkpoint regularly saves a crawl in progress to disk, so that a big crawl which dies halfway through
+// can be picked back up with Options.Resume instead of starting again from nothing.
+
+package crawl
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultCheckpointInterval is how often checkpoints are written if Options.CheckpointInterval isn't set
+const defaultCheckpointInterval = 30 * time.Second
+
+// snapshotLockWait is the longest a snapshot waits, all told, for the locks of pages which were busy when it first got to them
+const snapshotLockWait = 100 * time.Millisecond
+
+type checkpointer struct {
+	// checkpointer snapshots the crawl rooted at root to path every interval, until finish is called.
+	root     *HtmlPage
+	crawl    Crawl
+	path     string
+	interval time.Duration
+
+	stop chan bool
+	done chan bool
+}
+
+func startCheckpointer(root *HtmlPage, crawl Crawl, path string, interval time.Duration) *checkpointer {
+	// startCheckpointer starts checkpointing in the background.
+	// crawl carries the seed / start time / config to save along with each snapshot (its Root is ignored).
+	if interval <= 0 {
+		interval = defaultCheckpointInterval
+	}
+
+	c := &checkpointer{root: root, crawl: crawl, path: path, interval: interval, stop: make(chan bool), done: make(chan bool)}
+	go c.run()
+	return c
+}
+
+func (c *checkpointer) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.write(false)
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *checkpointer) finish() {
+	// finish stops checkpointing, and writes one last checkpoint of the finished crawl
+	// (which, with FinishedAt set, resumes to exactly the same graph without fetching anything).
+	close(c.stop)
+	<-c.done
+	c.write(true)
+}
+
+func (c *checkpointer) write(finished bool) {
+	// write saves a snapshot of the crawl as it stands right now.
+	// Failing to checkpoint isn't worth stopping the crawl for, so errors are only logged.
+	checkpoint := c.crawl
+	checkpoint.Root = snapshot(c.root)
+	if finished {
+		checkpoint.FinishedAt = time.Now()
+	}
+
+	pages := ComputeDepths(checkpoint.Root)
+	var remaining int
+	for _, page := range pages {
+		if !page.isDone() {
+			remaining++
+		}
+	}
+
+	if err := checkpoint.saveFileAtomic(c.path); err != nil {
+		log.Printf("⚠️ unable to write checkpoint to %s: %s", c.path, err)
+		return
+	}
+	log.Printf("💾 checkpointed %d pages (%d still to crawl) to %s", len(pages), remaining, c.path)
+}
+
+func snapshot(root *HtmlPage) *HtmlPage {
+	// snapshot copies the graph rooted at root while it's still being crawled, and returns the copy's root.
+
+	// Each page is copied while holding its ParseLock, so pages are never caught halfway through being parsed.
+	// A page's lock can be taken for one of two reasons: crawlPages is checking whether it's done (which only takes a moment,
+	// but happens to finished pages every time another page links to them), or it's being fetched right now.
+	// Losing a finished page would lose everything only reachable through it too, so busy pages are put aside and tried again
+	// (for up to snapshotLockWait in all) once everything else is copied. Anything still locked after that is being fetched,
+	// so it goes into the snapshot uncrawled, and will be fetched again on resume; nothing is lost with it, as the pages it
+	// links to are only crawled once it's done.
+
+	// Pages are copied by URL rather than by pointer, as a DiskStore can hand parsers copies of finished pages it read back in,
+	// so the graph can have more than one HtmlPage for a URL until the crawl is over (see rejoinStoredPages). Those copies
+	// have no links, so the original is copied too (for its links) if it turns up after one of them.
+	copies := map[string]*HtmlPage{root.Url.String(): {Url: root.Url}}
+	expanded := map[string]bool{root.Url.String(): !root.reloaded}
+	queue := []*HtmlPage{root}
+	var busy []*HtmlPage
+	deadline := time.Now().Add(snapshotLockWait)
+
+	for len(queue) > 0 || len(busy) > 0 {
+		if len(queue) == 0 {
+			if time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(time.Millisecond)
+			queue, busy = busy, nil
+		}
+		page := queue[0]
+		queue = queue[1:]
+
+		if !page.ParseLock.TryLock() {
+			busy = append(busy, page)
+			continue
+		}
+		copied := copies[page.Url.String()]
+		copied.Title = page.Title
+		copied.StatusCode = page.StatusCode
+		copied.FetchedAt = page.FetchedAt
+		copied.Redirects = append([]Redirect(nil), page.Redirects...)
+		copied.MetaRobots = page.MetaRobots
+		copied.Description = page.Description
+		copied.Canonical = page.Canonical
+		copied.TLS = page.TLS
+		copied.Cache = page.Cache
+		copied.History = append([]Change(nil), page.History...)
+		copied.Trap = page.Trap
+		copied.Fingerprint = page.Fingerprint
+		copied.DuplicateOf = page.DuplicateOf
+		copied.Soft404 = page.Soft404
+		copied.IsParsed = page.IsParsed
+		copied.CrawlError = page.CrawlError
+		copied.Attempts = append([]Attempt(nil), page.Attempts...)
+		links := page.LinksTo
+		page.ParseLock.Unlock()
+
+		for _, link := range links {
+			key := link.Url.String()
+			linkCopy, seen := copies[key]
+			if !seen {
+				linkCopy = &HtmlPage{Url: link.Url}
+				copies[key] = linkCopy
+			}
+			if !seen || (!link.reloaded && !expanded[key]) {
+				expanded[key] = !link.reloaded
+				queue = append(queue, link)
+			}
+			copied.LinksTo = append(copied.LinksTo, linkCopy)
+		}
+	}
+
+	return copies[root.Url.String()]
+}
+
+func (c *Crawl) saveFileAtomic(path string) error {
+	// saveFileAtomic is SaveFile, except that it writes to a temporary file first and then moves it into place,
+	// so a crash partway through writing never leaves a half written checkpoint behind.
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if err := c.Save(file); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,209 @@
+package crawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func genTestSite() (*httptest.Server, func() map[string]int) {
+	// genTestSite starts a small local site, and returns it along with a function that returns (and resets)
+	// how many times each path has been fetched.
+	handler, hits := genTestSiteHandler()
+	return httptest.NewServer(handler), hits
+}
+
+func genTestSiteHandler() (http.Handler, func() map[string]int) {
+	// genTestSiteHandler is genTestSite without starting the server, for tests which need to set the server up themselves.
+	// / -> /a, /b; /a -> /c, /; /b -> /c, /d; /c -> /a; /d -> /missing (which is a 404)
+	links := map[string][]string{
+		"/":  {"/a", "/b"},
+		"/a": {"/c", "/"},
+		"/b": {"/c", "/d"},
+		"/c": {"/a"},
+		"/d": {"/missing"},
+	}
+
+	var lock sync.Mutex
+	hits := map[string]int{}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		hits[r.URL.Path]++
+		lock.Unlock()
+
+		pageLinks, exists := links[r.URL.Path]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		fmt.Fprintf(w, "<html><head><title>Page %s</title></head><body>", r.URL.Path)
+		for _, link := range pageLinks {
+			fmt.Fprintf(w, `<a href="%s">%s</a>`, link, link)
+		}
+		fmt.Fprint(w, "</body></html>")
+	})
+
+	return handler, func() map[string]int {
+		lock.Lock()
+		defer lock.Unlock()
+		result := hits
+		hits = map[string]int{}
+		return result
+	}
+}
+
+func describeGraph(root *HtmlPage) string {
+	// describeGraph writes out everything about a crawl graph that should come out the same however it was crawled.
+	var lines []string
+	for _, page := range ComputeDepths(root) {
+		var links []string
+		for _, link := range page.LinksTo {
+			links = append(links, link.Url.Path)
+		}
+		lines = append(lines, fmt.Sprintf("%s %q %d parsed=%t depth=%d -> %s", page.Url.Path, page.Title, page.StatusCode, page.IsParsed, page.Depth, strings.Join(links, ",")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestCheckpointResume(t *testing.T) {
+	server, takeHits := genTestSite()
+	defer server.Close()
+	rootUrl, _ := url.Parse(server.URL + "/")
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	// first, an uninterrupted crawl; its final checkpoint should be the whole, finished crawl
+	expected := describeGraph(Walk(rootUrl, Options{CheckpointPath: checkpointPath}))
+	takeHits()
+
+	checkpoint, err := LoadCrawlFile(checkpointPath)
+	if err != nil {
+		t.Fatalf("final checkpoint couldn't be loaded: %s", err)
+	}
+	if checkpoint.FinishedAt.IsZero() {
+		t.Error("final checkpoint wasn't marked as finished")
+	}
+	if result := describeGraph(checkpoint.Root); result != expected {
+		t.Errorf("final checkpoint doesn't match the crawl:\nexpected:\n%s\ngot:\n%s", expected, result)
+	}
+
+	// now pretend the crawl died after parsing / and /a: /b and /c go back on the frontier,
+	// and /d and /missing (which were only found through /b) drop out of the graph entirely
+	for _, page := range ComputeDepths(checkpoint.Root) {
+		if page.Url.Path == "/b" || page.Url.Path == "/c" {
+			*page = HtmlPage{Url: page.Url}
+		}
+	}
+	checkpoint.FinishedAt = time.Time{}
+	if err := checkpoint.saveFileAtomic(checkpointPath); err != nil {
+		t.Fatal(err)
+	}
+	interrupted, err := LoadCrawlFile(checkpointPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ComputeDepths(interrupted.Root)) != 4 {
+		t.Fatalf("interrupted checkpoint has the wrong number of pages: expected 4, got %d", len(ComputeDepths(interrupted.Root)))
+	}
+
+	result := describeGraph(Walk(rootUrl, Options{Resume: interrupted}))
+	if result != expected {
+		t.Errorf("resumed crawl doesn't match an uninterrupted one:\nexpected:\n%s\ngot:\n%s", expected, result)
+	}
+
+	// only the pages left on the frontier (and anything found through them) should have been fetched
+	hits := takeHits()
+	for _, path := range []string{"/b", "/c", "/d", "/missing"} {
+		if hits[path] != 1 {
+			t.Errorf("%s should have been fetched once on resume, but was fetched %d times", path, hits[path])
+		}
+	}
+	for _, path := range []string{"/", "/a"} {
+		if hits[path] != 0 {
+			t.Errorf("%s was already crawled, but was fetched %d times on resume", path, hits[path])
+		}
+	}
+}
+
+func TestSnapshotSkipsLockedPages(t *testing.T) {
+	// a page which stays locked is being fetched, so it should be snapshotted as not yet crawled (and without its links)
+	page := func(path string) *HtmlPage {
+		return &HtmlPage{Url: &url.URL{Scheme: "http", Host: "testsite.test", Path: path}, Title: path, IsParsed: true}
+	}
+	root, a, b := page("/"), page("/a"), page("/b")
+	root.LinksTo = []*HtmlPage{a}
+	a.LinksTo = []*HtmlPage{b}
+
+	a.ParseLock.Lock()
+	copied := snapshot(root)
+	a.ParseLock.Unlock()
+
+	if copied == root || !copied.IsParsed || copied.Title != "/" || len(copied.LinksTo) != 1 {
+		t.Fatal("snapshot didn't copy the root properly")
+	}
+	if aCopy := copied.LinksTo[0]; aCopy == a || aCopy.IsParsed || aCopy.Title != "" || len(aCopy.LinksTo) != 0 {
+		t.Errorf("snapshot of a locked page should be uncrawled with no links, got parsed=%t title=%q links=%d", aCopy.IsParsed, aCopy.Title, len(aCopy.LinksTo))
+	}
+
+	// once it's unlocked, the whole graph comes through
+	if pages := ComputeDepths(snapshot(root)); len(pages) != 3 {
+		t.Errorf("snapshot of an unlocked graph has the wrong number of pages: expected 3, got %d", len(pages))
+	}
+}
+
+func TestSnapshotWaitsForBrieflyLockedPages(t *testing.T) {
+	// a finished page which is only locked for a moment (by crawlPages checking it, say) mustn't take the pages only reachable
+	// through it out of the snapshot, or they'd be fetched all over again on resume
+	page := func(path string) *HtmlPage {
+		return &HtmlPage{Url: &url.URL{Scheme: "http", Host: "testsite.test", Path: path}, Title: path, IsParsed: true}
+	}
+	root, a, b, c := page("/"), page("/a"), page("/b"), page("/c")
+	root.LinksTo = []*HtmlPage{a, c}
+	a.LinksTo = []*HtmlPage{b}
+
+	a.ParseLock.Lock()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		a.ParseLock.Unlock()
+	}()
+	copied := snapshot(root)
+
+	pages := Pages(copied)
+	if len(pages) != 4 {
+		t.Fatalf("snapshot lost pages behind a briefly locked one: expected 4, got %d", len(pages))
+	}
+	for _, page := range pages {
+		if !page.IsParsed || page.Title != page.Url.Path {
+			t.Errorf("%s wasn't copied properly: parsed=%t title=%q", page.Url.Path, page.IsParsed, page.Title)
+		}
+	}
+}
+
+func TestSnapshotFollowsOriginalsPastReloadedCopies(t *testing.T) {
+	// a page a DiskStore read back in is a copy without links, so a snapshot which comes across the copy first
+	// still has to go through the original's links (and mustn't end up with two pages for the same URL)
+	page := func(path string) *HtmlPage {
+		return &HtmlPage{Url: &url.URL{Scheme: "http", Host: "testsite.test", Path: path}, Title: path, IsParsed: true}
+	}
+	root, a, b, c := page("/"), page("/a"), page("/b"), page("/c")
+	reloadedA := page("/a")
+	reloadedA.reloaded = true
+	root.LinksTo = []*HtmlPage{reloadedA, b}
+	b.LinksTo = []*HtmlPage{a}
+	a.LinksTo = []*HtmlPage{c}
+
+	var paths []string
+	for _, page := range Pages(snapshot(root)) {
+		paths = append(paths, page.Url.Path)
+	}
+	sort.Strings(paths)
+	if strings.Join(paths, ",") != "/,/a,/b,/c" {
+		t.Errorf("snapshot didn't copy each page once: got %v", paths)
+	}
+}
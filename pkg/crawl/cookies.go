@@ -0,0 +1,107 @@
+// cookies loads cookie jars from Netscape cookies.txt files (the format curl, wget and most browser extensions export),
+// so that a crawl can start out logged in, or already past a WAF's challenge page.
+
+package crawl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// httpOnlyPrefix marks a HttpOnly cookie in cookies.txt (which would otherwise look like a comment)
+const httpOnlyPrefix = "#HttpOnly_"
+
+func NewCookieJar() http.CookieJar {
+	// NewCookieJar returns an empty cookie jar. It uses the public suffix list, so sites can't set cookies for
+	// everything under (say) .co.uk.
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		// cookiejar.New never actually returns an error
+		panic(err)
+	}
+	return jar
+}
+
+func LoadCookies(r io.Reader) (http.CookieJar, error) {
+	// LoadCookies reads a Netscape format cookies.txt into a new cookie jar.
+	// Each line is seven tab separated fields: domain, include subdomains, path, secure, expiry (unix time, 0 for a session cookie), name and value.
+	// Blank lines and comments are skipped, and cookies which have already expired are left out.
+	jar := NewCookieJar()
+	scanner := bufio.NewScanner(r)
+
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		if httpOnly {
+			line = strings.TrimPrefix(line, httpOnlyPrefix)
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("cookies line %d: expected 7 tab separated fields, got %d", lineNumber, len(fields))
+		}
+		domain, includeSubdomains, path, secure, expiry, name, value := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6]
+
+		expires, err := strconv.ParseInt(expiry, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cookies line %d: bad expiry time %q", lineNumber, expiry)
+		}
+
+		cookie := &http.Cookie{
+			Name:     name,
+			Value:    value,
+			Path:     path,
+			Secure:   strings.EqualFold(secure, "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		if expires != 0 {
+			cookie.Expires = time.Unix(expires, 0)
+			if cookie.Expires.Before(time.Now()) {
+				continue
+			}
+		}
+
+		host := strings.TrimPrefix(domain, ".")
+		if strings.EqualFold(includeSubdomains, "TRUE") || strings.HasPrefix(domain, ".") {
+			// a cookie with a Domain is sent to subdomains too; without one, it's only sent to the exact host that set it
+			cookie.Domain = host
+		}
+
+		// the jar only takes cookies as if a response from the site had set them, so make up a URL for that response
+		scheme := "http"
+		if cookie.Secure {
+			scheme = "https"
+		}
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: path}, []*http.Cookie{cookie})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return jar, nil
+}
+
+func LoadCookiesFile(path string) (http.CookieJar, error) {
+	// LoadCookiesFile is LoadCookies, reading from the file at path.
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return LoadCookies(file)
+}
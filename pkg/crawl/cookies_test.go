@@ -0,0 +1,63 @@
+package crawl
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testCookiesFile = "# Netscape HTTP Cookie File\n" +
+	"# This is a generated file!  Do not edit.\n" +
+	"\n" +
+	".testsite.test\tTRUE\t/\tFALSE\t0\tsession\tabc123\n" +
+	"testsite.test\tFALSE\t/admin\tFALSE\t4102444800\tadmin\tyes\n" +
+	"#HttpOnly_secure.testsite.test\tFALSE\t/\tTRUE\t4102444800\twaf_pass\tletmein\r\n" +
+	"testsite.test\tFALSE\t/\tFALSE\t946684800\tstale\tgone\n"
+
+func cookieNames(jar http.CookieJar, rawUrl string) string {
+	// cookieNames lists the names of the cookies jar would send to rawUrl.
+	target, _ := url.Parse(rawUrl)
+	var names []string
+	for _, cookie := range jar.Cookies(target) {
+		names = append(names, cookie.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestLoadCookies(t *testing.T) {
+	jar, err := LoadCookies(strings.NewReader(testCookiesFile))
+	if err != nil {
+		t.Fatalf("LoadCookies returned an error: %s", err)
+	}
+
+	expectations := map[string]string{
+		// the session cookie is for the whole domain, the admin one only for /admin, and the stale one has expired
+		"http://testsite.test/":       "session",
+		"http://testsite.test/admin/": "admin,session",
+		"http://www.testsite.test/":   "session",
+		// the HttpOnly one is secure, and host only
+		"http://secure.testsite.test/":      "session",
+		"https://secure.testsite.test/":     "session,waf_pass",
+		"https://sub.secure.testsite.test/": "session",
+		"http://elsewhere.test/":            "",
+	}
+	for target, expected := range expectations {
+		if names := cookieNames(jar, target); names != expected {
+			t.Errorf("cookies for %s: expected %q, got %q", target, expected, names)
+		}
+	}
+}
+
+func TestLoadCookiesRejectsBadLines(t *testing.T) {
+	for name, file := range map[string]string{
+		"too few fields": "testsite.test\tFALSE\t/\tFALSE\t0\tsession\n",
+		"bad expiry":     "testsite.test\tFALSE\t/\tFALSE\tsoon\tsession\tabc\n",
+	} {
+		if _, err := LoadCookies(strings.NewReader(file)); err == nil {
+			t.Errorf("%s: LoadCookies didn't return an error", name)
+		}
+	}
+}
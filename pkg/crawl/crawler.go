@@ -5,47 +5,269 @@ package crawl
 import (
 	"log"
 	"net/url"
+	"time"
+
+	"golang.org/x/net/html"
 )
 
-func WalkTarget(target *url.URL) HtmlPage {
+type Options struct {
+	// Options tweaks how Walk crawls. The zero value is a plain crawl, exactly like WalkTarget.
+
+	// CheckpointPath, if set, is a file the crawl in progress is saved to every CheckpointInterval (30 seconds if unset),
+	// in the same format as Crawl.SaveFile. A checkpoint is a crawl like any other, except that pages which hadn't
+	// been crawled yet (the frontier) aren't parsed, and FinishedAt is only set once the crawl is complete.
+	CheckpointPath     string
+	CheckpointInterval time.Duration
+
+	// Config is stored in checkpoints as their Crawl.Config
+	Config map[string]string
+
+	// Store is where the crawl keeps track of the pages it's discovered (a MemoryStore if unset).
+	// Walk closes it before returning, whether it was passed in or not.
+	Store Store
+
+	// Resume, if set, is a checkpoint (loaded with LoadCrawlFile) to carry on from instead of starting from scratch.
+	// Pages it has already crawled aren't fetched again; its root is used in place of the target.
+	Resume *Crawl
+
+	// Fetch tunes the HTTP side of the crawl: connection limits, timeouts, the maximum body size and so on.
+	// Anything left unset uses DefaultFetchOptions.
+	Fetch FetchOptions
+
+	// Cache, if set, is an HTTPCache to reuse pages from (and store them in). Pages which have expired in it are fetched
+	// with a conditional request, and only parsed again if they've changed. Entries are kept apart by the credentials and headers
+	// they were fetched with, and a crawl handed a cookie jar (Fetch.Jar) doesn't use the cache at all.
+	Cache *HTTPCache
+
+	// Recrawl, if set, is a previous crawl (loaded with LoadCrawlFile) to bring up to date instead of starting from scratch.
+	// Its pages are revisited according to RecrawlPolicy, most likely to have changed first, and the rest are kept as they were.
+	// Every page the recrawl fetches gets a new entry in its History.
+	Recrawl       *Crawl
+	RecrawlPolicy RecrawlPolicy
+
+	// Traps tunes the crawler trap heuristics. Links which look like a trap (endlessly generated URLs, session IDs and so on)
+	// are quarantined instead of crawled: they're kept in the graph with their Trap set, but never fetched.
+	Traps TrapOptions
+
+	// SkipDuplicates, if set, stops the crawl following links from pages whose main text exactly duplicates a page
+	// which was crawled first (the copies' links are dropped, and their DuplicateOf set). Duplicates are still found
+	// either way (see Duplicates); this just saves crawling mirrored sections of a site over and over.
+	SkipDuplicates bool
+
+	// Soft404 tunes soft 404 detection: pages served with a success status which are really the site's "page not found" page,
+	// going by their titles (and, if Soft404.Probe is set, by comparing them with what each host serves for a made up URL).
+	// They get their Soft404 set, and count as broken.
+	Soft404 Soft404Options
+
+	// Texts, if set, is handed the main text of every page as it's parsed (for building a search index, say).
+	// The text isn't kept anywhere else: pages only keep a Fingerprint of it.
+	Texts TextSink
+
+	// DOM, if set, is handed the whole document tree of every page as it's parsed, for extracting whatever the crawler doesn't.
+	// Pages are parsed with html.Parse instead of being streamed through, which takes a lot more memory on big pages,
+	// and the HTTP cache isn't used (a page reused from it has no document to hand over).
+	DOM DOMExtractor
+}
+
+type DOMExtractor interface {
+	// A 'DOMExtractor' is handed the document tree of every page the crawl parses (see Options.DOM), once the crawler has
+	// filled the page in from it. Like a TextSink, it's called by every worker at once, so it must be safe for concurrent use,
+	// and it mustn't hang on to the tree any longer than it needs to.
+	PageDOM(page *HtmlPage, doc *html.Node)
+}
+
+type crawler struct {
+	// crawler is everything a crawl's workers share, passed down recursively through the tree scraper stack.
+	store   Store
+	fetcher *Fetcher
+	cache   *HTTPCache
+	traps   *trapDetector
+
+	// texts is Options.Texts and dom is Options.DOM (either is nil if there isn't one)
+	texts TextSink
+	dom   DOMExtractor
+
+	// cacheIdentity is who the crawl fetches pages as, which is part of every cache entry's key (see cacheIdentity)
+	cacheIdentity string
+
+	// duplicates is nil unless Options.SkipDuplicates is set, and soft404s is nil if soft 404 detection is disabled
+	duplicates *duplicateDetector
+	soft404s   *soft404Detector
+}
+
+func (c *crawler) pageText(page *HtmlPage, text string) {
+	// pageText hands a newly parsed page's text on to Options.Texts, if there is one.
+	if c.texts != nil {
+		c.texts.PageText(page, text)
+	}
+}
+
+func WalkTarget(target *url.URL) *HtmlPage {
 	// WalkTarget creates a page instance against the specified target, fires the appropriate scraper, then fires goroutines to recurse
+	return Walk(target, Options{})
+}
+
+func Walk(target *url.URL, options Options) *HtmlPage {
+	// Walk is WalkTarget, with Options.
 
-	// allPages is a map of pointers to every page we have trawled
+	// store keeps a pointer to every page we have trawled
 	// we do this to avoid loopbacks (i.e deep pages looping back to root and causing an infinite loop)
 
-	// implementation note: I originally wrote this as just passing through a pointer to this variable to all goroutines
+	// implementation note: I originally wrote this as just passing through a pointer to a map to all goroutines
 	// then I realised that caused massive race conditions when multiple workers are trying to write at the same time,
-	// so I've switched to using read / write channels, which are passed down recursively through the tree scraper stack
-	allPages := make(map[url.URL]*HtmlPage)
+	// so every Store has to be safe for concurrent use, and it's passed down recursively through the tree scraper stack
+	store := options.Store
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			log.Printf("⚠️ unable to close the page store: %s", err)
+		}
+	}()
+
+	// credentials in the target URL are moved into the fetch options, so that they don't end up in every log line and saved crawl
+	options.Fetch.Auth = options.Fetch.Auth.withUrlCredentials(target)
+	target = withoutCredentials(target)
 
-	getPage := make(chan *readPage)
-	setPage := make(chan *writePage)
+	// one Fetcher is shared by every worker, so that connections to the site are kept alive and reused
+	fetcher := NewFetcher(options.Fetch)
+	defer fetcher.Close()
 
-	// Start the Map Storage Provider on the getPage and setPage channels
-	// see the comments inside that function for why it's necessary
-	go mapStorageProvider(allPages, getPage, setPage)
+	if options.Fetch.Auth.FormLogin != nil {
+		if err := fetcher.login(options.Fetch.Auth.FormLogin); err != nil {
+			// crawling a site we meant to be logged into without being logged in would just give us a map of the login page
+			log.Fatalf("☠️ Unable to log in; the following error occured: %s", err)
+		}
+	}
+
+	c := &crawler{store: store, fetcher: fetcher, cache: options.Cache, traps: newTrapDetector(options.Traps)}
+	c.cacheIdentity = cacheIdentity(options.Fetch)
+	c.texts = options.Texts
+	c.dom = options.DOM
+	if c.cache != nil && options.Fetch.Jar != nil {
+		// there's no telling whose cookies are in a jar we've been handed, so pages fetched with them can't be shared
+		log.Println("📦 not using the HTTP cache, as the crawl starts off with cookies")
+		c.cache = nil
+	}
+	if c.cache != nil && c.dom != nil {
+		log.Println("📦 not using the HTTP cache, as every page's document is needed for Options.DOM")
+		c.cache = nil
+	}
+	c.duplicates = newDuplicateDetector(options.SkipDuplicates)
+	c.soft404s = newSoft404Detector(options.Soft404)
 
 	// Seed the root page
-	root := HtmlPage{Url: target}
+	// this has to stay a pointer all the way out: pages which link back to the root hold this exact pointer,
+	// so handing back a copy would leave the graph with two roots
+	root := &HtmlPage{Url: target}
+	checkpoint := Crawl{Seed: target.String(), StartedAt: time.Now(), Config: options.Config, Resolve: options.Fetch.Resolve}
+
+	// when resuming, the checkpointed graph goes into the store wholesale, so that links to pages it already has
+	// (crawled or not) are joined up to them rather than starting over
+	var seed []*HtmlPage
+	var frontier []*HtmlPage
+	var recrawl *recrawlPlan
+	if options.Recrawl != nil {
+		root = options.Recrawl.Root
+		checkpoint.Seed = options.Recrawl.Seed
 
-	// we DON'T add the current page to the allPages index here, because we need to handle redirects, normalisation, and other stuff
+		// the plan has to be made before anything else looks at the graph, as it unlinks the pages it's going to revisit
+		recrawl = planRecrawl(options.Recrawl, options.RecrawlPolicy, fetcher, time.Now())
+		seed = recrawl.pages
+		for _, page := range recrawl.due {
+			if page != root {
+				frontier = append(frontier, page)
+			}
+		}
+	} else if options.Resume != nil {
+		root = options.Resume.Root
+		checkpoint.Seed, checkpoint.StartedAt = options.Resume.Seed, options.Resume.StartedAt
+
+		seed = ComputeDepths(root)
+		for _, page := range seed {
+			if !page.isDone() && page != root {
+				frontier = append(frontier, page)
+			}
+		}
+
+		log.Printf("⏯️ resuming crawl of %s: %d pages known, %d still to crawl", checkpoint.Seed, len(seed), len(frontier))
+	} else {
+		seed = []*HtmlPage{root}
+	}
+
+	// we DON'T add the current page to the store under its final URL here, because we need to handle redirects, normalisation, and other stuff
 	// instead, fetchAndParse handles it for us
-	allPages[*target] = &root
+	for _, page := range seed {
+		if _, _, err := store.GetOrCreate(*page.Url, func() *HtmlPage { return page }); err != nil {
+			log.Fatalf("☠️ Unable to store page %s: %s", page.Url.String(), err)
+		}
+		if page.isDone() {
+			// pages crawled last time are the originals for anything found this time
+			c.duplicates.original(page)
+		}
+	}
 
 	// do fetchAndParse, check that's okay, THEN go into recurse
 	// these are done separately and on the main thread because
 	// we need the root element to complete (and be valid)
 	// before we can start branching out
-	err := root.fetchAndParse(getPage, setPage)
+	freshRoot := !root.IsParsed
+	if freshRoot {
+		err := root.fetchAndParse(c)
+
+		if err != nil && !root.IsParsed {
+			// we were unable to scrape the initial page, so we can't continue!
+			log.Fatalf("☠️ Unable to scrape root document; the following error occured: %s", err)
+		}
+		// (if it was parsed, it's just served with an error status, which won't stop us following its links)
+		root.CrawlError = err
+		c.flagIfSoft404(root)
+		c.duplicates.original(root)
+	}
+
+	var checkpoints *checkpointer
+	if options.CheckpointPath != "" {
+		checkpoints = startCheckpointer(root, checkpoint, options.CheckpointPath, options.CheckpointInterval)
+	}
 
-	if err != nil {
-		// we were unable to scrape the initial page, so we can't continue!
-		log.Fatalf("☠️ Unable to scrape root document; the following error occured: %s", err)
+	if freshRoot {
+		root.recurse(c)
+		// (a recrawl can have pages to revisit which the root no longer leads to by way of unvisited pages)
+		crawlPages(frontier, root.Url, c)
+	} else {
+		// the root was done last time, so pick up with whatever was left on the frontier instead
+		crawlPages(frontier, root.Url, c)
 	}
-	root.recurse(getPage, setPage)
-	// root.recurse()
 
-	log.Println("🙌 crawler finished!")
+	if checkpoints != nil {
+		checkpoints.finish()
+	}
+
+	// a DiskStore hands back copies of pages it's written out, so join links to those up with the originals again
+	rejoinStoredPages(append([]*HtmlPage{root}, frontier...))
+
+	if recrawl != nil {
+		recrawl.record(root)
+	}
+
+	// now that every page is in, work out how far each one really is from the root
+	ComputeDepths(root)
+
+	var total int
+	if err := store.Iterate(func(url.URL, *HtmlPage) bool { total++; return true }); err != nil {
+		log.Printf("⚠️ unable to count pages in the page store: %s", err)
+	}
+	log.Printf("🙌 crawler finished! (%d pages discovered)", total)
+	if traps := Traps(root); len(traps) > 0 {
+		log.Printf("🪤 %d links looked like crawler traps, so weren't followed", len(traps))
+	}
+	if soft404s := Soft404s(root); len(soft404s) > 0 {
+		log.Printf("👻 %d pages look like soft 404s", len(soft404s))
+	}
+	if duplicates := Duplicates(root, -1); len(duplicates) > 0 {
+		log.Printf("♊ %d groups of pages have exactly the same content", len(duplicates))
+	}
 
 	return root
 }
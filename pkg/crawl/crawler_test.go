@@ -1,6 +1,9 @@
 package crawl
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 )
@@ -39,3 +42,101 @@ func TestCrawlOfHttpSite(t *testing.T) {
 	// See parser_test for an example of how I'd iterate through to test that links are correctly identified.
 
 }
+
+func TestCrawlRecordsRedirects(t *testing.T) {
+	// TestCrawlRecordsRedirects crawls a local test server where one link is a redirect,
+	// and checks that the redirect hop is recorded against the page.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Root</title></head><body><a href="/old">Old</a></body></html>`)
+	})
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>New</title></head><body></body></html>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	rootUrl, _ := url.Parse(server.URL + "/")
+	result := WalkTarget(rootUrl)
+
+	if len(result.LinksTo) != 1 {
+		t.Fatalf("Parsing of HTML <a/> tags returned an unexpected number of results: expected 1, got %d", len(result.LinksTo))
+	}
+
+	old := result.LinksTo[0]
+	if len(old.Redirects) != 1 {
+		t.Fatalf("redirect was not recorded: expected 1 hop, got %d", len(old.Redirects))
+	}
+	if old.Redirects[0].StatusCode != http.StatusMovedPermanently || old.Redirects[0].Url.Path != "/new" {
+		t.Errorf("redirect hop recorded incorrectly: expected 301 to /new, got %d to %s", old.Redirects[0].StatusCode, old.Redirects[0].Url.Path)
+	}
+	if old.Title != "New" || old.StatusCode != http.StatusOK {
+		t.Errorf("redirected page was not parsed from its final location: got title %q, status %d", old.Title, old.StatusCode)
+	}
+}
+
+func TestCrawlResolvesLinksAfterRedirects(t *testing.T) {
+	// TestCrawlResolvesLinksAfterRedirects checks that relative links on a redirected page resolve against where it ended up,
+	// rather than the URL it was linked as (and that <base href> resolves against it too).
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Root</title></head><body><a href="/a">A</a><a href="/based">Based</a></body></html>`)
+	})
+	mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/dir/b", http.StatusFound)
+	})
+	mux.HandleFunc("/based", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/moved/based", http.StatusFound)
+	})
+	mux.HandleFunc("/dir/b", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>B</title></head><body><a href="c.html">C</a></body></html>`)
+	})
+	mux.HandleFunc("/moved/based", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Based</title><base href="other/"></head><body><a href="d.html">D</a></body></html>`)
+	})
+	mux.HandleFunc("/dir/c.html", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>C</title></head><body></body></html>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	rootUrl, _ := url.Parse(server.URL + "/")
+	result := WalkTarget(rootUrl)
+
+	if len(result.LinksTo) != 2 || len(result.LinksTo[0].LinksTo) != 1 || len(result.LinksTo[1].LinksTo) != 1 {
+		t.Fatal("crawl of test server returned an unexpected link structure")
+	}
+	if link := result.LinksTo[0].LinksTo[0]; link.Url.Path != "/dir/c.html" || link.Title != "C" {
+		t.Errorf("expected c.html on the redirected page to resolve to /dir/c.html, got %s (title %q)", link.Url.Path, link.Title)
+	}
+	if link := result.LinksTo[1].LinksTo[0]; link.Url.Path != "/moved/other/d.html" {
+		t.Errorf("expected <base href> to resolve against the redirected URL, giving /moved/other/d.html, got %s", link.Url.Path)
+	}
+}
+
+func TestCrawlKeepsRootIdentity(t *testing.T) {
+	// TestCrawlKeepsRootIdentity checks that a page linking back to the root links to the same HtmlPage WalkTarget returns
+	// (rather than a copy of it), otherwise the graph ends up with two roots.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Root</title></head><body><a href="/child">Child</a></body></html>`)
+	})
+	mux.HandleFunc("/child", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>Child</title></head><body><a href="/">Home</a></body></html>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	rootUrl, _ := url.Parse(server.URL + "/")
+	result := WalkTarget(rootUrl)
+
+	if len(result.LinksTo) != 1 || len(result.LinksTo[0].LinksTo) != 1 {
+		t.Fatal("crawl of test server returned an unexpected link structure")
+	}
+	if backlink := result.LinksTo[0].LinksTo[0]; backlink != result {
+		t.Errorf("child links back to a different root: expected %p, got %p", result, backlink)
+	}
+}
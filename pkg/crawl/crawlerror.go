@@ -0,0 +1,157 @@
+// crawlerror contains CrawlError, which says what went wrong crawling a page (and whether it's worth trying again).
+
+package crawl
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+)
+
+type ErrorKind int
+
+const (
+	// ErrorOther is anything which doesn't fit one of the other kinds
+	ErrorOther ErrorKind = iota
+	// ErrorDNS means the host name couldn't be looked up
+	ErrorDNS
+	// ErrorConnection means connecting to the server failed, or the connection dropped partway through
+	ErrorConnection
+	// ErrorTLS means the TLS handshake failed (usually because of a bad certificate)
+	ErrorTLS
+	// ErrorTimeout means the server took too long to connect, respond or send the page
+	ErrorTimeout
+	// ErrorHTTP means the page was served with an HTTP error status (400 or above)
+	ErrorHTTP
+	// ErrorParse means the page was fetched fine, but couldn't be parsed
+	ErrorParse
+)
+
+var errorKindNames = []string{"other", "dns", "connection", "tls", "timeout", "http", "parse"}
+
+func (k ErrorKind) String() string {
+	if int(k) < 0 || int(k) >= len(errorKindNames) {
+		return fmt.Sprintf("ErrorKind(%d)", int(k))
+	}
+	return errorKindNames[k]
+}
+
+func ParseErrorKind(name string) (ErrorKind, error) {
+	// ParseErrorKind is the reverse of ErrorKind.String.
+	for i, kindName := range errorKindNames {
+		if kindName == name {
+			return ErrorKind(i), nil
+		}
+	}
+	return ErrorOther, fmt.Errorf("unknown error kind: %s", name)
+}
+
+type CrawlError struct {
+	// A 'CrawlError' is what HtmlPage.CrawlError is set to when crawling a page fails.
+	// Use errors.As (or ErrorKindOf) to get at it, since pages loaded from old saved crawls can still have plain errors.
+
+	// Kind says broadly what went wrong
+	Kind ErrorKind
+
+	// StatusCode is the HTTP status, for ErrorHTTP
+	StatusCode int
+
+	// Temporary is set if the failure looks like it might go away by itself (a dropped connection, a timeout,
+	// or a 429 / 502 / 503 / 504), so it's worth retrying
+	Temporary bool
+
+	// Err is the underlying error (nil for ErrorHTTP, where the status says it all)
+	Err error
+}
+
+func (e *CrawlError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	if e.Kind == ErrorHTTP {
+		return fmt.Sprintf("HTTP %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return e.Kind.String() + " error"
+}
+
+func (e *CrawlError) Unwrap() error {
+	return e.Err
+}
+
+func ErrorKindOf(err error) ErrorKind {
+	// ErrorKindOf returns the kind of a page's CrawlError (ErrorOther if it isn't a *CrawlError at all).
+	var crawlError *CrawlError
+	if errors.As(err, &crawlError) {
+		return crawlError.Kind
+	}
+	return ErrorOther
+}
+
+func statusError(statusCode int) *CrawlError {
+	// statusError is the error for a page served with an HTTP error status.
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return &CrawlError{Kind: ErrorHTTP, StatusCode: statusCode, Temporary: true}
+	}
+	return &CrawlError{Kind: ErrorHTTP, StatusCode: statusCode}
+}
+
+func classifyError(err error) *CrawlError {
+	// classifyError works out what kind of failure a fetch error was, by digging through the layers of wrapping
+	// net/http puts around it. Anything unrecognised is ErrorOther.
+	var crawlError *CrawlError
+	if errors.As(err, &crawlError) {
+		return crawlError
+	}
+
+	// DNS first, because lookups can time out too, and "couldn't find the host" is the more useful thing to say
+	var dnsError *net.DNSError
+	if errors.As(err, &dnsError) {
+		return &CrawlError{Kind: ErrorDNS, Temporary: dnsError.IsTimeout || dnsError.IsTemporary, Err: err}
+	}
+
+	var netError net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &netError) && netError.Timeout()) {
+		return &CrawlError{Kind: ErrorTimeout, Temporary: true, Err: err}
+	}
+
+	var recordHeaderError tls.RecordHeaderError
+	var certificateError *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameError x509.HostnameError
+	var invalidCertificate x509.CertificateInvalidError
+	var alertError tls.AlertError
+	if errors.As(err, &recordHeaderError) || errors.As(err, &certificateError) || errors.As(err, &unknownAuthority) ||
+		errors.As(err, &hostnameError) || errors.As(err, &invalidCertificate) || errors.As(err, &alertError) {
+		return &CrawlError{Kind: ErrorTLS, Err: err}
+	}
+
+	// the connection being reset, or cut off partway through the response, is worth another go; being refused outright isn't,
+	// and nor is the server cleanly closing the connection without answering at all (it'll most likely do the same again)
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return &CrawlError{Kind: ErrorConnection, Temporary: true, Err: err}
+	}
+	var opError *net.OpError
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.EOF) || errors.As(err, &opError) {
+		return &CrawlError{Kind: ErrorConnection, Err: err}
+	}
+
+	return &CrawlError{Kind: ErrorOther, Err: err}
+}
+
+func classifyParseError(err error) *CrawlError {
+	// classifyParseError is classifyError for errors which came out of parsing a page. The tokenizer doesn't mind bad markup,
+	// so these are nearly always the body failing to download, but anything else counts as a parse error.
+	crawlError := classifyError(err)
+	if crawlError.Kind == ErrorOther {
+		crawlError.Kind = ErrorParse
+	}
+	return crawlError
+}
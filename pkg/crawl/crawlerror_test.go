@@ -0,0 +1,103 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyError(t *testing.T) {
+	// errors come out of net/http wrapped in a *url.Error (and often more), so classification has to dig through the layers
+	wrap := func(err error) error {
+		return &url.Error{Op: "Get", URL: "http://testsite.test/", Err: err}
+	}
+
+	cases := []struct {
+		name      string
+		err       error
+		kind      ErrorKind
+		temporary bool
+	}{
+		{"dns", wrap(&net.DNSError{Err: "no such host", Name: "testsite.test", IsNotFound: true}), ErrorDNS, false},
+		{"dns timeout", wrap(&net.DNSError{Err: "i/o timeout", Name: "testsite.test", IsTimeout: true}), ErrorDNS, true},
+		{"deadline", wrap(context.DeadlineExceeded), ErrorTimeout, true},
+		{"body timeout", errBodyTimeout, ErrorTimeout, true},
+		{"reset", wrap(&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}), ErrorConnection, true},
+		{"broken pipe", wrap(&net.OpError{Op: "write", Net: "tcp", Err: syscall.EPIPE}), ErrorConnection, true},
+		{"cut off", wrap(io.ErrUnexpectedEOF), ErrorConnection, true},
+		{"closed without answering", wrap(io.EOF), ErrorConnection, false},
+		{"refused", wrap(&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}), ErrorConnection, false},
+		{"other", errors.New("something odd"), ErrorOther, false},
+		{"already classified", fmt.Errorf("wrapped: %w", statusError(503)), ErrorHTTP, true},
+	}
+
+	for _, c := range cases {
+		result := classifyError(c.err)
+		if result.Kind != c.kind || result.Temporary != c.temporary {
+			t.Errorf("%s: expected %s (temporary=%t), got %s (temporary=%t)", c.name, c.kind, c.temporary, result.Kind, result.Temporary)
+		}
+		if !errors.Is(result, c.err) && c.name != "already classified" {
+			t.Errorf("%s: classified error doesn't unwrap to the original", c.name)
+		}
+	}
+
+	if kind := classifyParseError(errors.New("bad markup")).Kind; kind != ErrorParse {
+		t.Errorf("an unrecognised parse error should be ErrorParse, got %s", kind)
+	}
+	if kind := classifyParseError(errBodyTimeout).Kind; kind != ErrorTimeout {
+		t.Errorf("a body timeout while parsing should be ErrorTimeout, got %s", kind)
+	}
+}
+
+func TestClassifyErrorTLS(t *testing.T) {
+	// a self signed certificate should be a TLS error, not a connection one
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	fetcher := NewFetcher(FetchOptions{})
+	defer fetcher.Close()
+
+	pageUrl, _ := url.Parse(server.URL + "/")
+	_, err := fetcher.fetch(&HtmlPage{Url: pageUrl})
+	if err == nil {
+		t.Fatal("fetching from a server with an untrusted certificate succeeded")
+	}
+	if result := classifyError(err); result.Kind != ErrorTLS || result.Temporary {
+		t.Errorf("expected a permanent TLS error, got %s (temporary=%t): %s", result.Kind, result.Temporary, err)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	for status, temporary := range map[int]bool{404: false, 500: false, 429: true, 502: true, 503: true, 504: true} {
+		err := statusError(status)
+		if err.Kind != ErrorHTTP || err.Temporary != temporary || err.StatusCode != status {
+			t.Errorf("statusError(%d) returned %+v", status, err)
+		}
+	}
+
+	if message := statusError(404).Error(); message != "HTTP 404 Not Found" {
+		t.Errorf("unexpected message for a 404: %q", message)
+	}
+}
+
+func TestErrorKindNames(t *testing.T) {
+	for kind := ErrorOther; kind <= ErrorParse; kind++ {
+		parsed, err := ParseErrorKind(kind.String())
+		if err != nil || parsed != kind {
+			t.Errorf("ErrorKind %d didn't survive a round trip through its name %q", kind, kind.String())
+		}
+	}
+	if _, err := ParseErrorKind("gremlins"); err == nil {
+		t.Error("ParseErrorKind accepted a kind that doesn't exist")
+	}
+	if ErrorKindOf(errors.New("plain")) != ErrorOther {
+		t.Error("a plain error should be ErrorOther")
+	}
+}
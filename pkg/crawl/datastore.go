@@ -1,34 +1,106 @@
+// datastore contains the page store: the index of every page discovered during a crawl, keyed by URL.
+
 package crawl
 
-import "net/url"
+import (
+	"errors"
+	"net/url"
+	"sync"
+)
+
+// errStoreClosed is returned by stores which are used after being closed
+var errStoreClosed = errors.New("page store is closed")
+
+type Store interface {
+	// A 'Store' keeps track of every page discovered during a crawl, so that each URL only ever gets one HtmlPage
+	// (and is only crawled once), however many pages link to it.
+
+	// Stores are used by lots of parser goroutines at once, so every method must be safe for concurrent use.
+
+	// Get returns the page stored under key, or nil if there isn't one.
+	Get(key url.URL) (*HtmlPage, error)
+
+	// GetOrCreate returns the page stored under key. If there isn't one, it calls create and stores the page it returns,
+	// and created is true. Checking and storing happen as one atomic step, so however many parsers find the same new URL
+	// at the same moment, exactly one of them creates its page and everybody gets that same pointer back.
+	// create is called with the store locked, so it mustn't use the store itself.
+	GetOrCreate(key url.URL, create func() *HtmlPage) (page *HtmlPage, created bool, err error)
+
+	// Iterate calls fn with every stored page, stopping early if fn returns false.
+	// Pages stored while iterating may or may not be included.
+	Iterate(fn func(key url.URL, page *HtmlPage) bool) error
 
-type readPage struct {
-	// readPage is a request to the allPages store for information about a page.
-	// It's designed to be sent over a channel.
-	key      url.URL
-	response chan *HtmlPage
+	// Close releases anything the store holds on to. The store can't be used afterwards.
+	Close() error
 }
 
-type writePage struct {
-	// readPage is a request to the allPages store to write information about a page.
-	// It's designed to be sent over a channel.
-	key      url.URL
-	value    *HtmlPage
-	response chan bool
+type MemoryStore struct {
+	// MemoryStore is a Store which keeps everything in a map. It's the default.
+
+	// implementation note: this used to be a goroutine (mapStorageProvider) serving read / write requests over channels,
+	// which stopped parsers racing each other, but the goroutine never exited. A plain mutex does the same job.
+	lock   sync.Mutex
+	pages  map[url.URL]*HtmlPage
+	closed bool
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{pages: make(map[url.URL]*HtmlPage)}
 }
 
-func mapStorageProvider(allPages map[url.URL]*HtmlPage, getPage chan *readPage, setPage chan *writePage) {
-	// mapStorageProvider is a storage provider that provides access to the given allPages map.
-	// It blocks on channel requests, ensuring that reads and writes are performed synchronously.
-	// Without this, you can end up in a state where multiple parser threads declare a new page simultaneously,
-	// and are unaware of another thread doing it, causing a race condition / duplication.
-	for {
-		select {
-		case get := <-getPage:
-			get.response <- allPages[get.key]
-		case set := <-setPage:
-			allPages[set.key] = set.value
-			set.response <- true
+func (s *MemoryStore) Get(key url.URL) (*HtmlPage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil, errStoreClosed
+	}
+	return s.pages[key], nil
+}
+
+func (s *MemoryStore) GetOrCreate(key url.URL, create func() *HtmlPage) (*HtmlPage, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil, false, errStoreClosed
+	}
+	if existing := s.pages[key]; existing != nil {
+		return existing, false, nil
+	}
+	page := create()
+	s.pages[key] = page
+	return page, true, nil
+}
+
+func (s *MemoryStore) Iterate(fn func(key url.URL, page *HtmlPage) bool) error {
+	// the pages are copied out first, so that fn is free to use the store itself
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return errStoreClosed
+	}
+	keys := make([]url.URL, 0, len(s.pages))
+	pages := make([]*HtmlPage, 0, len(s.pages))
+	for key, page := range s.pages {
+		keys = append(keys, key)
+		pages = append(pages, page)
+	}
+	s.lock.Unlock()
+
+	for i := range keys {
+		if !fn(keys[i], pages[i]) {
+			break
 		}
 	}
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.closed = true
+	s.pages = nil
+	return nil
 }
@@ -6,97 +6,139 @@ import (
 	"log"
 	"math/rand"
 	"net/url"
+	"path/filepath"
 	"strconv"
 	"testing"
 	"time"
 )
 
+func genTestStores(t *testing.T) map[string]Store {
+	// genTestStores returns one of each kind of Store, so that every test runs against all of them.
+	// (the disk store is given hardly any room, so that it's writing pages out and reading them back in all the time)
+	disk, err := OpenDiskStore(filepath.Join(t.TempDir(), "pages.jsonl"), 2)
+	if err != nil {
+		t.Fatalf("unable to open disk store: %s", err)
+	}
+	return map[string]Store{"memory": NewMemoryStore(), "disk": disk}
+}
+
 func TestDatastore(t *testing.T) {
-	// TestDatastore simply ensures that the mapStorageProvider can consistently produce a single result.
+	// TestDatastore simply ensures that each store can consistently produce a single result.
 	testUrl, err := url.Parse("http://testsite.test/")
 
 	if err != nil {
 		t.Error("Failed to parse test URL (fault in url library???)")
 	}
 
-	allPages := make(map[url.URL]*HtmlPage)
-	getPage := make(chan *readPage)
-	setPage := make(chan *writePage)
+	for name, store := range genTestStores(t) {
+		testPage := &HtmlPage{Url: testUrl}
 
-	// Start the Map Storage Provider on the getPage and setPage channels
-	// see the comments inside that function for why it's necessary
-	go mapStorageProvider(allPages, getPage, setPage)
+		if missing, err := store.Get(*testUrl); err != nil || missing != nil {
+			t.Errorf("%s store returned something for a page that was never stored: %p (error %v)", name, missing, err)
+		}
 
-	testPage := &HtmlPage{Url: testUrl}
+		stored, created, err := store.GetOrCreate(*testUrl, func() *HtmlPage { return testPage })
+		if err != nil || stored != testPage || !created {
+			t.Errorf("%s store didn't store a new page: expected %p, got %p (created %t, error %v)", name, testPage, stored, created, err)
+		}
 
-	write := &writePage{key: *testUrl, value: testPage, response: make(chan bool)}
+		// asking again should hand back the first page, without creating another
+		stored, created, err = store.GetOrCreate(*testUrl, func() *HtmlPage {
+			t.Errorf("%s store called create for a page it already had", name)
+			return &HtmlPage{Url: testUrl}
+		})
+		if err != nil || stored != testPage || created {
+			t.Errorf("%s store replaced an existing page: expected %p, got %p (created %t, error %v)", name, testPage, stored, created, err)
+		}
 
-	setPage <- write
+		readResponse, err := store.Get(*testUrl)
+		if err != nil || readResponse != testPage {
+			t.Errorf("%s store returned something that wasn't a pointer to our HtmlPage instance: expected %p, got %p (error %v)", name, testPage, readResponse, err)
+		}
 
-	if !<-write.response {
-		t.Error("datastore writer returned failure on first storage event")
+		if err := store.Close(); err != nil {
+			t.Errorf("%s store failed to close: %s", name, err)
+		}
+		if _, err := store.Get(*testUrl); err == nil {
+			t.Errorf("%s store could still be used after being closed", name)
+		}
 	}
+}
 
-	read := &readPage{key: *testUrl, response: make(chan *HtmlPage)}
+func TestDatastoreIterate(t *testing.T) {
+	for name, store := range genTestStores(t) {
+		pages := map[string]*HtmlPage{}
+		for i := 0; i < 20; i++ {
+			pageUrl := &url.URL{Scheme: "http", Host: "testsite.test", Path: fmt.Sprintf("/%d", i)}
+			pages[pageUrl.String()] = &HtmlPage{Url: pageUrl}
+			store.GetOrCreate(*pageUrl, func() *HtmlPage { return pages[pageUrl.String()] })
+		}
 
-	getPage <- read
+		seen := 0
+		err := store.Iterate(func(key url.URL, page *HtmlPage) bool {
+			if pages[key.String()] != page {
+				t.Errorf("%s store iterated over the wrong page for %s", name, key.String())
+			}
+			seen++
+			return true
+		})
+		if err != nil || seen != len(pages) {
+			t.Errorf("%s store iterated over the wrong number of pages: expected %d, got %d (error %v)", name, len(pages), seen, err)
+		}
 
-	readResponse := <-read.response
-	if readResponse != testPage {
-		t.Errorf("datastore reader returned something that wasn't a pointer to our HtmlPage instance: expected %p, got %p", testPage, readResponse)
+		// returning false should stop iteration
+		seen = 0
+		store.Iterate(func(url.URL, *HtmlPage) bool { seen++; return false })
+		if seen != 1 {
+			t.Errorf("%s store didn't stop iterating when asked: visited %d pages", name, seen)
+		}
+
+		store.Close()
 	}
 }
 
 func TestDatastoreSynchro(t *testing.T) {
-	// TestDatastoreSynchro simply ensures that the mapStorageProvider can consistently produce results,
+	// TestDatastoreSynchro simply ensures that each store can consistently produce results,
 	// even when lots of requests are being flung at it.
 	// _LOTS_ of requests (because trees could go very deep, and we could have lots of workers trying to read and write).
-	allPages := make(map[url.URL]*HtmlPage)
-	getPage := make(chan *readPage)
-	setPage := make(chan *writePage)
-
-	chErrors := make(chan error)
-	chComplete := make(chan bool)
-
-	defer close(chComplete)
-	defer close(chErrors)
-
-	// Start the Map Storage Provider on the getPage and setPage channels
-	// see the comments inside that function for why it's necessary
-	go mapStorageProvider(allPages, getPage, setPage)
+	for name, store := range genTestStores(t) {
+		chErrors := make(chan error)
+		chComplete := make(chan bool)
 
-	for totalWorkers := 0; totalWorkers < 300; totalWorkers++ {
-		go testDatastoreWorker(getPage, setPage, chErrors, chComplete)
-	}
+		for totalWorkers := 0; totalWorkers < 300; totalWorkers++ {
+			go testDatastoreWorker(store, chErrors, chComplete)
+		}
 
-	// Hold a lock until all channels complete
-	var breakout bool
-	var errorset []error
-	for waiting := 0; waiting < 300 || breakout; {
-		select {
-		case <-chComplete:
-			// each time a goroutine finishes (and sends us the message through chComplete), increment the finished counter
-			waiting++
-		case returnedError := <-chErrors:
-			// an error occurred! (oh noes!)
-			errorset = append(errorset, returnedError)
-		case <-time.After(5 * time.Second):
-			// print a warning after 5 seconds if all goroutines haven't returned yet
-			log.Printf("5s timeout! (waiting for %d of %d goroutine(s))\n", 300-waiting, 300)
-			breakout = true
+		// Hold a lock until all channels complete
+		var breakout bool
+		var errorset []error
+		for waiting := 0; waiting < 300 && !breakout; {
+			select {
+			case <-chComplete:
+				// each time a goroutine finishes (and sends us the message through chComplete), increment the finished counter
+				waiting++
+			case returnedError := <-chErrors:
+				// an error occurred! (oh noes!)
+				errorset = append(errorset, returnedError)
+			case <-time.After(5 * time.Second):
+				// print a warning after 5 seconds if all goroutines haven't returned yet
+				log.Printf("5s timeout! (waiting for %d of %d goroutine(s))\n", 300-waiting, 300)
+				breakout = true
+			}
 		}
-	}
 
-	if len(errorset) > 0 {
-		for _, v := range errorset {
-			log.Printf("worker error: %s", v)
+		if len(errorset) > 0 {
+			for _, v := range errorset {
+				log.Printf("worker error: %s", v)
+			}
+			t.Errorf("one or more %s store worker errors were reported!", name)
 		}
-		t.Error("one or more worker errors were reported!")
-	}
 
+		store.Close()
+	}
 }
 
-func testDatastoreWorker(getPage chan *readPage, setPage chan *writePage, errorchannel chan error, completed chan bool) {
+func testDatastoreWorker(store Store, errorchannel chan error, completed chan bool) {
 	defer func() { completed <- true }()
 	// randomHostname is just a random 63-bit integer cast to string.
 	// it's a little silly, but this is probably the fastest way to produce random hostnames
@@ -113,20 +155,12 @@ func testDatastoreWorker(getPage chan *readPage, setPage chan *writePage, errorc
 
 	testPage := &HtmlPage{Url: randomUrl}
 
-	write := &writePage{key: *randomUrl, value: testPage, response: make(chan bool)}
-
-	setPage <- write
-
-	if !<-write.response {
+	if stored, created, err := store.GetOrCreate(*randomUrl, func() *HtmlPage { return testPage }); err != nil || stored != testPage || !created {
 		errorchannel <- errors.New("datastore writer returned failure on first storage event")
 	}
 
-	read := &readPage{key: *randomUrl, response: make(chan *HtmlPage)}
-
-	getPage <- read
-
-	readResponse := <-read.response
-	if readResponse != testPage {
+	readResponse, err := store.Get(*randomUrl)
+	if err != nil || readResponse != testPage {
 		errorchannel <- errors.New(fmt.Sprintf("datastore reader returned something that wasn't a pointer to a worker's HtmlPage instance: expected %p, got %p", testPage, readResponse))
 	}
 
@@ -0,0 +1,271 @@
+// diskstore contains DiskStore, a page store which writes finished pages out to a file instead of keeping them all in memory.
+
+package crawl
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"sync"
+)
+
+// defaultDiskStoreLive is how many pages a DiskStore keeps in memory if OpenDiskStore isn't told
+const defaultDiskStoreLive = 10000
+
+// diskStoreEvictTries caps how many live pages a single store call will look at when making room,
+// so that a store full of pages which are still being crawled doesn't make every call crawl through all of them
+const diskStoreEvictTries = 64
+
+type recordSpan struct {
+	// recordSpan is where a page's record is in a DiskStore's file.
+	offset int64
+	length int
+}
+
+type DiskStore struct {
+	// DiskStore is a Store which only keeps up to maxLive pages in memory. It's Bitcask style: once that many are stored,
+	// finished pages are written to an append-only file (one JSON record per line, in the same format as saved crawls)
+	// and dropped, with only their URL and where their record is kept in memory. Asking for one of them again reads it back in.
+
+	// Pages which are still being crawled are never written out, as they're still changing (and parsers are holding on to them).
+	// Finished pages aren't, so a page read back in is a copy, with the same fields as the original but no links,
+	// and with reloaded set; Walk swaps links to copies back to the originals once the crawl is done (see rejoinStoredPages).
+
+	// implementation note: the store can't wait for a page's ParseLock, as parsers hold the lock of the page they're parsing
+	// while they call GetOrCreate. Busy pages are just left in memory until a later call.
+	lock    sync.Mutex
+	file    *os.File
+	size    int64
+	written map[url.URL]recordSpan
+	live    map[url.URL]*HtmlPage
+	queue   []url.URL
+	maxLive int
+	closed  bool
+}
+
+func OpenDiskStore(path string, maxLive int) (*DiskStore, error) {
+	// OpenDiskStore creates a DiskStore which writes pages out to path, overwriting anything already there.
+	// It keeps up to maxLive pages in memory (defaultDiskStoreLive if maxLive isn't positive).
+	// The file is left behind when the store is closed.
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if maxLive <= 0 {
+		maxLive = defaultDiskStoreLive
+	}
+
+	return &DiskStore{
+		file:    file,
+		written: make(map[url.URL]recordSpan),
+		live:    make(map[url.URL]*HtmlPage),
+		maxLive: maxLive,
+	}, nil
+}
+
+func (s *DiskStore) Get(key url.URL) (*HtmlPage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil, errStoreClosed
+	}
+	return s.lookup(key)
+}
+
+func (s *DiskStore) GetOrCreate(key url.URL, create func() *HtmlPage) (*HtmlPage, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil, false, errStoreClosed
+	}
+	if existing, err := s.lookup(key); existing != nil || err != nil {
+		return existing, false, err
+	}
+	page := create()
+	return page, true, s.keep(key, page)
+}
+
+func (s *DiskStore) lookup(key url.URL) (*HtmlPage, error) {
+	// lookup returns the page stored under key (reading it back in if it's been written out), or nil if there isn't one.
+	// The caller must hold the store's lock.
+	if page := s.live[key]; page != nil {
+		return page, nil
+	}
+	span, written := s.written[key]
+	if !written {
+		return nil, nil
+	}
+	page, err := s.read(span)
+	if err != nil {
+		return nil, err
+	}
+	return page, s.keep(key, page)
+}
+
+func (s *DiskStore) keep(key url.URL, page *HtmlPage) error {
+	// keep adds page to the pages in memory, writing out finished pages to make room if there are too many.
+	// The caller must hold the store's lock.
+	s.live[key] = page
+	s.queue = append(s.queue, key)
+
+	for tries := 0; len(s.live) > s.maxLive && tries < diskStoreEvictTries && len(s.queue) > 0; tries++ {
+		oldest := s.queue[0]
+		s.queue = s.queue[1:]
+
+		candidate := s.live[oldest]
+		if !candidate.ParseLock.TryLock() {
+			s.queue = append(s.queue, oldest)
+			continue
+		}
+		if !candidate.isDone() {
+			candidate.ParseLock.Unlock()
+			s.queue = append(s.queue, oldest)
+			continue
+		}
+
+		// finished pages never change, so one written out (and read back in) before doesn't need writing again
+		var err error
+		if _, written := s.written[oldest]; !written {
+			err = s.write(oldest, candidate)
+		}
+		candidate.ParseLock.Unlock()
+		if err != nil {
+			s.queue = append(s.queue, oldest)
+			return err
+		}
+		delete(s.live, oldest)
+	}
+	return nil
+}
+
+func (s *DiskStore) write(key url.URL, page *HtmlPage) error {
+	// write appends page's record to the file. The caller must hold the store's lock, and page's ParseLock.
+	data, err := json.Marshal(newPageRecord(page))
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if _, err := s.file.WriteAt(data, s.size); err != nil {
+		return err
+	}
+	s.written[key] = recordSpan{offset: s.size, length: len(data)}
+	s.size += int64(len(data))
+	return nil
+}
+
+func (s *DiskStore) read(span recordSpan) (*HtmlPage, error) {
+	// read reads back the page written to span, as a copy flagged as reloaded.
+	// Records are never overwritten, so this doesn't need the store's lock.
+	data := make([]byte, span.length)
+	if _, err := s.file.ReadAt(data, span.offset); err != nil {
+		return nil, err
+	}
+
+	var record pageRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	page, err := record.page()
+	if err != nil {
+		return nil, err
+	}
+	page.reloaded = true
+	return page, nil
+}
+
+func (s *DiskStore) Iterate(fn func(key url.URL, page *HtmlPage) bool) error {
+	// Iterate hands fn copies of the pages which have been written out (without keeping them in memory afterwards),
+	// so that going through a big crawl doesn't pull the whole thing back in.
+
+	// the keys are copied out first, so that fn is free to use the store itself
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return errStoreClosed
+	}
+	keys := make([]url.URL, 0, len(s.live)+len(s.written))
+	pages := make([]*HtmlPage, 0, len(s.live)+len(s.written))
+	var spans []recordSpan
+	for key, page := range s.live {
+		keys = append(keys, key)
+		pages = append(pages, page)
+	}
+	for key, span := range s.written {
+		if s.live[key] == nil {
+			keys = append(keys, key)
+			spans = append(spans, span)
+		}
+	}
+	s.lock.Unlock()
+
+	for i := range keys {
+		var page *HtmlPage
+		if i < len(pages) {
+			page = pages[i]
+		} else {
+			var err error
+			if page, err = s.read(spans[i-len(pages)]); err != nil {
+				return err
+			}
+		}
+		if !fn(keys[i], page) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *DiskStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.written, s.live, s.queue = nil, nil, nil
+	return s.file.Close()
+}
+
+func rejoinStoredPages(roots []*HtmlPage) {
+	// rejoinStoredPages swaps every link to a page a DiskStore read back in for a link to the original page,
+	// so that the graph ends up with one HtmlPage per URL again, just as if the pages had never left memory.
+	// Every original is still reachable from roots: pages are only written out once they're finished,
+	// so the page which first linked to each one was still in memory (and its original) when it did.
+	visited := map[*HtmlPage]bool{}
+	originals := map[string]*HtmlPage{}
+	var pages []*HtmlPage
+	var anyReloaded bool
+
+	queue := append([]*HtmlPage(nil), roots...)
+	for len(queue) > 0 {
+		page := queue[0]
+		queue = queue[1:]
+		if visited[page] {
+			continue
+		}
+		visited[page] = true
+		pages = append(pages, page)
+
+		if page.reloaded {
+			anyReloaded = true
+		} else {
+			originals[page.Url.String()] = page
+		}
+		queue = append(queue, page.LinksTo...)
+	}
+
+	if !anyReloaded {
+		return
+	}
+	for _, page := range pages {
+		for i, link := range page.LinksTo {
+			if original := originals[link.Url.String()]; link.reloaded && original != nil {
+				page.LinksTo[i] = original
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package crawl
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskStoreReload(t *testing.T) {
+	// with room for just one page in memory, finished pages should be written out and come back as copies,
+	// while pages still being crawled stay put
+	store, err := OpenDiskStore(filepath.Join(t.TempDir(), "pages.jsonl"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	stored := map[string]*HtmlPage{}
+	add := func(path string, page *HtmlPage) {
+		page.Url = &url.URL{Scheme: "http", Host: "testsite.test", Path: path}
+		stored[path] = page
+		if _, created, err := store.GetOrCreate(*page.Url, func() *HtmlPage { return page }); err != nil || !created {
+			t.Fatalf("disk store didn't store %s (created %t, error %v)", path, created, err)
+		}
+	}
+	add("/pending", &HtmlPage{})
+	add("/a", &HtmlPage{Title: "Page a", StatusCode: 200, IsParsed: true, Fingerprint: Fingerprint{Hash: "abc", SimHash: 42}})
+	add("/missing", &HtmlPage{StatusCode: 404, IsParsed: true, CrawlError: statusError(404)})
+	add("/b", &HtmlPage{Title: "Page b", StatusCode: 200, IsParsed: true})
+
+	for _, path := range []string{"/a", "/missing"} {
+		original := stored[path]
+		page, err := store.Get(*original.Url)
+		if err != nil || page == nil {
+			t.Fatalf("disk store lost %s (error %v)", path, err)
+		}
+		if page == original || !page.reloaded {
+			t.Errorf("%s should have been written out and read back in as a copy", path)
+		}
+		if page.Url.String() != original.Url.String() || page.Title != original.Title || page.StatusCode != original.StatusCode ||
+			page.IsParsed != original.IsParsed || page.Fingerprint != original.Fingerprint || ErrorKindOf(page.CrawlError) != ErrorKindOf(original.CrawlError) {
+			t.Errorf("%s didn't come back the same: expected %+v, got %+v", path, original, page)
+		}
+	}
+
+	// unfinished pages are never written out, however short of room the store is
+	if page, _ := store.Get(*stored["/pending"].Url); page != stored["/pending"] {
+		t.Errorf("disk store didn't keep hold of a page still being crawled: expected %p, got %p", stored["/pending"], page)
+	}
+
+	seen := map[string]bool{}
+	store.Iterate(func(key url.URL, page *HtmlPage) bool {
+		if page.Url.String() != key.String() {
+			t.Errorf("disk store iterated over the wrong page for %s: got %s", key.String(), page.Url.String())
+		}
+		seen[key.Path] = true
+		return true
+	})
+	if len(seen) != len(stored) {
+		t.Errorf("disk store iterated over the wrong pages: expected %d, got %v", len(stored), seen)
+	}
+}
+
+func TestCrawlWithDiskStore(t *testing.T) {
+	// a crawl with nearly every page written out to disk should come out exactly the same as one kept in memory,
+	// without fetching anything twice or leaving copies of pages in the graph
+	server, takeHits := genTestSite()
+	defer server.Close()
+	rootUrl, _ := url.Parse(server.URL + "/")
+
+	expected := describeGraph(Walk(rootUrl, Options{}))
+	takeHits()
+
+	path := filepath.Join(t.TempDir(), "pages.jsonl")
+	store, err := OpenDiskStore(path, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := Walk(rootUrl, Options{Store: store})
+
+	if result := describeGraph(root); result != expected {
+		t.Errorf("crawl with a disk store doesn't match one in memory:\nexpected:\n%s\ngot:\n%s", expected, result)
+	}
+	for path, hits := range takeHits() {
+		if hits != 1 {
+			t.Errorf("%s was fetched %d times", path, hits)
+		}
+	}
+
+	urls := map[string]bool{}
+	for _, page := range Pages(root) {
+		if page.reloaded || urls[page.Url.String()] {
+			t.Errorf("%s was left in the graph more than once (reloaded %t)", page.Url.String(), page.reloaded)
+		}
+		urls[page.Url.String()] = true
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+		t.Errorf("disk store didn't write any pages out (error %v)", err)
+	}
+}
@@ -0,0 +1,265 @@
+// extract pulls the bits of a HTML document the crawler cares about (title, links, <base>, <meta> and canonical <link> directives, and the main text)
+// out of a page body.
+// There are two ways of doing it: a streaming pass over html.Tokenizer tokens, which is what the crawler uses by default,
+// and the original approach of building the full DOM with html.Parse and walking it, which the crawler switches to when it's been given
+// a DOMExtractor (see Options.DOM) that needs the whole tree.
+
+package crawl
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// maxParseBytes is the most of a page body the crawler will read by default (see FetchOptions.MaxBodyBytes); anything after it is ignored
+const maxParseBytes = 10 << 20
+
+type pageInfo struct {
+	// pageInfo is everything extracted from a single HTML document.
+
+	// title is the text of the first <title> element
+	title string
+
+	// links are the href attributes of every <a> element, in document order, exactly as written
+	links []string
+
+	// base is the href of the first <base> element (which relative links resolve against instead of the page URL), if any
+	base string
+
+	// robots is the content of <meta name="robots">, if any
+	robots string
+
+	// description is the content of the first <meta name="description">, with its whitespace collapsed, if any
+	description string
+
+	// canonical is the href of the first <link rel="canonical">, exactly as written, if any
+	canonical string
+
+	// refresh is where a <meta http-equiv="refresh"> sends the browser, if anywhere
+	refresh string
+
+	// text is the page's main text, with runs of whitespace collapsed to single spaces.
+	// The text of boilerplate (navigation, headers and footers) and of things which aren't text at all (scripts, styles) is left out.
+	text string
+
+	// truncated is set if the document was longer than the byte limit, and only the start of it was read
+	truncated bool
+}
+
+// notText are the elements whose contents don't count towards a page's main text:
+// boilerplate which is the same on every page of a site, and things which aren't really text at all
+var notText = map[string]bool{
+	"title": true, "script": true, "style": true, "noscript": true, "template": true, "svg": true, "math": true,
+	"nav": true, "header": true, "footer": true, "aside": true,
+}
+
+// blockElements are the elements which break up text, so that the words either side of them don't run together
+var blockElements = map[string]bool{
+	"address": true, "article": true, "blockquote": true, "br": true, "dd": true, "div": true, "dl": true, "dt": true,
+	"fieldset": true, "figcaption": true, "figure": true, "form": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "hr": true, "li": true, "main": true, "ol": true, "p": true, "pre": true, "section": true,
+	"table": true, "td": true, "th": true, "tr": true, "ul": true,
+}
+
+func collapseSpace(text string) string {
+	// collapseSpace turns every run of whitespace in text into a single space, and trims it off the ends.
+	return strings.Join(strings.Fields(text), " ")
+}
+
+func (info *pageInfo) element(name string, attr func(string) (string, bool)) {
+	// element records whatever's interesting about a start tag. attr looks up an attribute of the tag.
+	switch name {
+	case "a":
+		if href, exists := attr("href"); exists {
+			info.links = append(info.links, href)
+		}
+	case "base":
+		if href, exists := attr("href"); exists && info.base == "" {
+			info.base = href
+		}
+	case "meta":
+		content, _ := attr("content")
+		if metaName, _ := attr("name"); strings.EqualFold(metaName, "robots") {
+			info.robots = content
+		} else if strings.EqualFold(metaName, "description") && info.description == "" {
+			info.description = collapseSpace(content)
+		}
+		if httpEquiv, _ := attr("http-equiv"); strings.EqualFold(httpEquiv, "refresh") {
+			info.refresh = refreshUrl(content)
+		}
+	case "link":
+		rel, _ := attr("rel")
+		for _, token := range strings.Fields(rel) {
+			if href, exists := attr("href"); strings.EqualFold(token, "canonical") && exists && info.canonical == "" {
+				info.canonical = href
+			}
+		}
+	}
+}
+
+func refreshUrl(content string) string {
+	// refreshUrl picks the URL out of a meta refresh directive, which looks like `5; url=/somewhere` (or just `5` to reload the page).
+	_, target, found := strings.Cut(content, ";")
+	if !found {
+		_, target, found = strings.Cut(content, ",")
+	}
+	if !found {
+		return ""
+	}
+
+	// browsers are forgiving about spaces around the =, so we are too
+	target = strings.TrimSpace(target)
+	if len(target) >= 3 && strings.EqualFold(target[:3], "url") {
+		if rest := strings.TrimSpace(target[3:]); strings.HasPrefix(rest, "=") {
+			target = strings.TrimSpace(rest[1:])
+		}
+	}
+	return strings.Trim(target, `"'`)
+}
+
+func extractTokens(r io.Reader, maxBytes int64) (*pageInfo, error) {
+	// extractTokens extracts page information in a single streaming pass over the document's tokens,
+	// so memory use stays flat however big the page is. It stops after maxBytes bytes.
+	limited := &io.LimitedReader{R: r, N: maxBytes}
+	tokenizer := html.NewTokenizer(limited)
+	info := &pageInfo{}
+
+	// inTitle is set between the first <title> and its closing tag,
+	// and notTextDepth counts how many elements we're inside of whose text isn't main text
+	inTitle, seenTitle := false, false
+	notTextDepth := 0
+	var text strings.Builder
+
+	for {
+		switch tokenType := tokenizer.Next(); tokenType {
+		case html.ErrorToken:
+			if tokenizer.Err() != io.EOF {
+				return nil, tokenizer.Err()
+			}
+			info.truncated = limited.N <= 0
+			info.text = collapseSpace(text.String())
+			return info, nil
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			tag := string(name)
+
+			if tag == "title" && !seenTitle {
+				inTitle, seenTitle = true, true
+			}
+			if notText[tag] && tokenType == html.StartTagToken {
+				notTextDepth++
+			}
+			if blockElements[tag] {
+				text.WriteByte(' ')
+			}
+			if tag != "a" && tag != "base" && tag != "meta" && tag != "link" {
+				// nothing else has attributes we care about, so don't bother reading them
+				continue
+			}
+
+			// attributes can only be read once, in order, so pull them all out up front
+			attrs := map[string]string{}
+			for hasAttr {
+				var key, value []byte
+				key, value, hasAttr = tokenizer.TagAttr()
+				if _, exists := attrs[string(key)]; !exists {
+					// like html.Parse, the first of any duplicated attributes wins
+					attrs[string(key)] = string(value)
+				}
+			}
+
+			info.element(tag, func(key string) (string, bool) {
+				value, exists := attrs[key]
+				return value, exists
+			})
+
+		case html.TextToken:
+			if inTitle {
+				info.title += string(tokenizer.Text())
+			}
+			if notTextDepth == 0 {
+				text.Write(tokenizer.Text())
+			}
+
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			tag := string(name)
+			if tag == "title" {
+				inTitle = false
+			}
+			if notText[tag] && notTextDepth > 0 {
+				notTextDepth--
+			}
+			if blockElements[tag] {
+				text.WriteByte(' ')
+			}
+		}
+	}
+}
+
+func extractDOM(r io.Reader, maxBytes int64) (*pageInfo, *html.Node, error) {
+	// extractDOM extracts the same information as extractTokens, but by building the whole document tree first,
+	// which it returns as well. That takes a lot more memory on big pages, but the tree is there for anything which needs it.
+	// Like extractTokens, it stops after maxBytes bytes.
+	limited := &io.LimitedReader{R: r, N: maxBytes}
+	doc, err := html.Parse(limited)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := &pageInfo{}
+	seenTitle := false
+	var text strings.Builder
+
+	// f is a helper function that does the scraping for us (and can, as such, be called recursively)
+	// it must be defined explicitly because otherwise it's not available inside the function during creation
+	// (inText is set while we're somewhere the text counts as main text)
+	var f func(n *html.Node, inText bool)
+	f = func(n *html.Node, inText bool) {
+		if n.Type == html.TextNode && inText {
+			text.WriteString(n.Data)
+		}
+
+		if n.Type == html.ElementNode {
+			if n.Data == "title" && !seenTitle {
+				// It's the page title!
+				seenTitle = true
+				for c := n.FirstChild; c != nil; c = c.NextSibling {
+					info.title += c.Data
+				}
+			}
+
+			info.element(n.Data, func(key string) (string, bool) {
+				for _, a := range n.Attr {
+					if a.Key == key {
+						return a.Val, true
+					}
+				}
+				return "", false
+			})
+
+			inText = inText && !notText[n.Data]
+			if blockElements[n.Data] {
+				text.WriteByte(' ')
+				defer text.WriteByte(' ')
+			}
+		}
+
+		// We don't stop at the above because it's (theoretically) possible to have A's inside A's (even though it's stupid and totally against spec)
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			// Let's recurse as far as possible!
+			f(c, inText)
+		}
+	}
+
+	// Do the scrape!
+	f(doc, true)
+	info.text = collapseSpace(text.String())
+	info.truncated = limited.N <= 0
+
+	return info, doc, nil
+}
@@ -0,0 +1,258 @@
+package crawl
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+var testDataBoilerplate = `<html><head><title>Boilerplate</title><style>body { color: red }</style></head><body>` +
+	`<header><a href="/">Home</a></header><nav><ul><li><a href="/a">A</a></li><li><a href="/b">B</a></li></ul></nav>` +
+	`<main><h1>Welcome</h1><p>Words run   together in &lt;b&gt;bold&lt;/b&gt; and <i>ital</i>ic,</p><p>but not across paragraphs.</p>` +
+	`<script>var notText = "<p>hidden</p>";</script><noscript>Turn JavaScript on</noscript></main>` +
+	`<footer>&copy; Boilerplate Inc</footer></body></html>`
+
+func genLargeDocument(sections int) string {
+	// genLargeDocument builds a big, realistic-ish page: a head with the usual tags, then lots of nested markup,
+	// text, tables, inline scripts (containing things that look like links, but aren't) and links.
+	var doc strings.Builder
+	doc.WriteString(`<!DOCTYPE html><html><head><title>Big &amp; Heavy</title><base href="/docs/">` +
+		`<meta name="robots" content="noindex"><meta charset="utf-8"><link rel="stylesheet" href="/style.css">` +
+		`<meta name="description" content="A big,  heavy page"><link rel="alternate canonical" href="/docs/big"></head><body>`)
+
+	for i := 0; i < sections; i++ {
+		fmt.Fprintf(&doc, `<div class="section" id="s%d"><h2>Section %d</h2><div class="inner"><p>`, i, i)
+		for j := 0; j < 5; j++ {
+			fmt.Fprintf(&doc, `Lorem ipsum dolor sit amet, <em>consectetur</em> adipiscing elit <a href="page-%d-%d.html" class="x">link %d</a>. `, i, j, j)
+		}
+		fmt.Fprintf(&doc, `</p><table><tr><td>%d</td><td><a href="/abs/%d?q=%d#frag">abs</a></td></tr></table>`, i, i, i)
+		fmt.Fprintf(&doc, `<script>var html = '<a href="/not-a-link-%d">';</script></div></div>`, i)
+	}
+
+	doc.WriteString(`</body></html>`)
+	return doc.String()
+}
+
+func TestExtractParity(t *testing.T) {
+	// both extractors should pull exactly the same things out of the same document
+	documents := map[string]string{
+		"single anchor":    testDataSingleAnchor,
+		"duplicate anchor": testDataDuplicateAnchor,
+		"invalid markup":   testDataInvalidAnchorMarkup,
+		"large":            genLargeDocument(50),
+		"empty title":      `<html><head><title></title></head><body><a href="/x">x</a></body></html>`,
+		"unclosed":         `<title>Unclosed <b>bold</b><a href="/1">one<a href="/2">two`,
+		"two titles":       `<html><head><title>First</title></head><body><svg><title>Second</title></svg></body></html>`,
+		"boilerplate":      testDataBoilerplate,
+		"implied close":    `<body><p>one<p>two<li>three<nav>menu<p>still menu</nav>four<br>five</body>`,
+	}
+
+	for name, document := range documents {
+		tokens, err := extractTokens(strings.NewReader(document), maxParseBytes)
+		if err != nil {
+			t.Fatalf("%s: extractTokens failed: %s", name, err)
+		}
+		dom, _, err := extractDOM(strings.NewReader(document), maxParseBytes)
+		if err != nil {
+			t.Fatalf("%s: extractDOM failed: %s", name, err)
+		}
+		if !reflect.DeepEqual(tokens, dom) {
+			t.Errorf("%s: extractors disagree:\ntokens: %+v\nDOM:    %+v", name, tokens, dom)
+		}
+	}
+}
+
+func TestExtractText(t *testing.T) {
+	// only the main text counts: not the title, scripts, styles or the navigation and footer every page has
+	info, err := extractTokens(strings.NewReader(testDataBoilerplate), maxParseBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Welcome Words run together in <b>bold</b> and italic, but not across paragraphs."
+	if info.text != expected {
+		t.Errorf("extractTokens found the wrong main text:\nexpected %q\ngot      %q", expected, info.text)
+	}
+}
+
+func TestExtractDirectives(t *testing.T) {
+	document := `<html><head><title>Caf&eacute;</title><base href="/one/"><base href="/two/">` +
+		`<meta name="Robots" content="noindex, nofollow"><meta http-equiv="Refresh" content="5; URL='/moved'">` +
+		`<meta name="Description" content=" All about&#10; coffee "><meta name="description" content="second">` +
+		`<link rel="stylesheet" href="/style.css"><link rel="Canonical" href="/cafe"><link rel="canonical" href="/second"></head>` +
+		`<body><a>no href</a><a href="">empty</a><a href="/real">real</a>` +
+		`<script>document.write('<a href="/scripted">')</script></body></html>`
+
+	info, err := extractTokens(strings.NewReader(document), maxParseBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &pageInfo{title: "Café", links: []string{"", "/real"}, base: "/one/", robots: "noindex, nofollow", description: "All about coffee", canonical: "/cafe",
+		refresh: "/moved", text: "no hrefemptyreal"}
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("extractTokens returned unexpected results:\nexpected %+v\ngot      %+v", expected, info)
+	}
+
+	refreshes := map[string]string{
+		"0; url=/a":      "/a",
+		"0;URL=\"/b\"":   "/b",
+		"3, url=/c":      "/c",
+		"10":             "",
+		"0; /d":          "/d",
+		" 1 ;  url = /e": "/e",
+		"0; urlish.html": "urlish.html",
+	}
+	for content, expected := range refreshes {
+		if result := refreshUrl(content); result != expected {
+			t.Errorf("refreshUrl(%q) returned %q, expected %q", content, result, expected)
+		}
+	}
+}
+
+func TestExtractTokensLimit(t *testing.T) {
+	// only links within the first maxBytes bytes should be found
+	document := genLargeDocument(100)
+	limit := int64(strings.Index(document, `id="s2"`))
+
+	info, err := extractTokens(strings.NewReader(document), limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.truncated {
+		t.Error("extractTokens didn't report that the document was truncated")
+	}
+	// sections 0 and 1 have 6 links each
+	if len(info.links) != 12 {
+		t.Errorf("extractTokens read past its limit: expected 12 links, got %d", len(info.links))
+	}
+
+	if info, _ := extractTokens(strings.NewReader(document), int64(len(document)+1)); info.truncated {
+		t.Error("extractTokens reported a document which fitted as truncated")
+	}
+}
+
+func TestParseHTMLDirectives(t *testing.T) {
+	// links (and the canonical URL) should resolve against <base>, a meta refresh counts as a link, and the robots directive is kept
+	rootUrl, _ := url.Parse("http://testsite.test/section/page")
+	testPage := HtmlPage{Url: rootUrl}
+	document := `<html><head><base href="/docs/"><meta name="robots" content="nofollow"><meta http-equiv="refresh" content="0; url=moved">` +
+		`<link rel="canonical" href="canonical"></head><body><a href="api">API</a><a href="/abs">Abs</a><a href="http://elsewhere.test/">Elsewhere</a></body></html>`
+	testReader := ioutil.NopCloser(bytes.NewBufferString(document))
+
+	store := NewMemoryStore()
+	defer store.Close()
+
+	if _, err := testPage.parseHTML(&testReader, store, maxParseBytes, nil, nil); err != nil {
+		t.Fatalf("HtmlPage.parseHTML() returned an error during parsing: %s", err)
+	}
+
+	var links []string
+	for _, link := range testPage.LinksTo {
+		links = append(links, link.Url.String())
+	}
+	expected := []string{"http://testsite.test/docs/api", "http://testsite.test/abs", "http://testsite.test/docs/moved"}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("parseHTML found the wrong links:\nexpected %v\ngot      %v", expected, links)
+	}
+	if testPage.MetaRobots != "nofollow" {
+		t.Errorf("parseHTML didn't record the robots directive: got %q", testPage.MetaRobots)
+	}
+	if testPage.Canonical != "http://testsite.test/docs/canonical" {
+		t.Errorf("parseHTML didn't resolve the canonical URL: got %q", testPage.Canonical)
+	}
+}
+
+func benchmarkExtract(b *testing.B, sections int, extract func(document string) (*pageInfo, error)) {
+	document := genLargeDocument(sections)
+	b.SetBytes(int64(len(document)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := extract(document); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkExtract(b *testing.B) {
+	// compares the streaming and DOM extractors on a ~100KB and a ~5MB page
+	// (run with -benchmem; the difference in B/op is the point)
+	for _, sections := range []int{200, 10000} {
+		b.Run(fmt.Sprintf("tokens/%d", sections), func(b *testing.B) {
+			benchmarkExtract(b, sections, func(document string) (*pageInfo, error) {
+				return extractTokens(strings.NewReader(document), maxParseBytes)
+			})
+		})
+		b.Run(fmt.Sprintf("dom/%d", sections), func(b *testing.B) {
+			benchmarkExtract(b, sections, func(document string) (*pageInfo, error) {
+				info, _, err := extractDOM(strings.NewReader(document), maxParseBytes)
+				return info, err
+			})
+		})
+	}
+}
+
+type domCollector struct {
+	// domCollector is a DOMExtractor which keeps the <title> text of every document it's handed, keyed by path.
+	lock   sync.Mutex
+	titles map[string]string
+}
+
+func (c *domCollector) PageDOM(page *HtmlPage, doc *html.Node) {
+	var title string
+	var f func(n *html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "title" && n.FirstChild != nil && title == "" {
+			title = n.FirstChild.Data
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			f(child)
+		}
+	}
+	f(doc)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.titles[page.Url.Path] = title
+}
+
+func TestCrawlDOMExtractor(t *testing.T) {
+	// with a DOMExtractor, every page's document tree is handed over (the same document the page was filled in from),
+	// and the cache is left alone, as it couldn't hand over the documents of the pages it has
+	site := &cachingTestSite{versions: map[string]int{}}
+	server := httptest.NewServer(site.handler())
+	defer server.Close()
+	rootUrl, _ := url.Parse(server.URL + "/")
+	cache, err := OpenHTTPCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, crawl := range []string{"first", "second"} {
+		dom := &domCollector{titles: map[string]string{}}
+		root := Walk(rootUrl, Options{Cache: cache, DOM: dom})
+
+		pages := Pages(root)
+		if len(dom.titles) != len(pages) {
+			t.Errorf("%s crawl: expected the documents of all %d pages, got %d", crawl, len(pages), len(dom.titles))
+		}
+		for _, page := range pages {
+			if title, found := dom.titles[page.Url.Path]; !found || title != page.Title {
+				t.Errorf("%s crawl: %s was titled %q, but its document said %q", crawl, page.Url.Path, page.Title, title)
+			}
+		}
+		if full, notModified := site.takeCounts(); full != len(pages) || notModified != 0 {
+			t.Errorf("%s crawl: expected every page to be fetched in full, got %d fetched and %d revalidated", crawl, full, notModified)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+// fetcher contains the Fetcher, which does all of the crawler's HTTP requests through one shared, tuned client.
+
+package crawl
+
+import (
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultUserAgent identifies us as a crawler to the sites we visit
+const DefaultUserAgent = "Go_CreepyCrawler/1.0"
+
+type FetchOptions struct {
+	// FetchOptions tunes how pages are fetched. Zero values are replaced by the defaults in DefaultFetchOptions,
+	// so you only need to set the ones you care about.
+
+	// MaxConnsPerHost caps how many connections (idle or busy) are open to any one host at once.
+	// Requests beyond it queue up for a free connection, which stops a big crawl hammering a single server.
+	MaxConnsPerHost int
+
+	// MaxIdleConnsPerHost is how many finished connections per host are kept open for reuse
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout is how long an idle kept-alive connection is held before being closed
+	IdleConnTimeout time.Duration
+
+	// DialTimeout is how long connecting to a server can take
+	DialTimeout time.Duration
+
+	// TLSHandshakeTimeout is how long the TLS handshake can take, once connected
+	TLSHandshakeTimeout time.Duration
+
+	// HeaderTimeout is how long to wait for the response headers after sending the request
+	HeaderTimeout time.Duration
+
+	// BodyTimeout is how long reading the whole response body can take, once the headers have arrived
+	BodyTimeout time.Duration
+
+	// MaxBodyBytes is the most of a page body that's read; anything after it is ignored (and a warning logged)
+	MaxBodyBytes int64
+
+	// DisableHTTP2 stops the fetcher negotiating HTTP/2 with servers that support it, so everything goes over HTTP/1.1
+	DisableHTTP2 bool
+
+	// MaxAttempts is how many times a page is tried before giving up on it (1 turns retries off).
+	// Only transient failures are retried: dropped connections, timeouts, and 429 / 502 / 503 / 504 responses.
+	MaxAttempts int
+
+	// RetryBaseDelay is how long to wait before the first retry. Each retry after that waits twice as long as the last
+	// (up to RetryMaxDelay), with some random jitter so that lots of failed pages don't all come back at the same moment.
+	// A Retry-After header on the response is respected, as long as it's no longer than RetryMaxDelay.
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+
+	// UserAgent is sent with every request (DefaultUserAgent if unset)
+	UserAgent string
+
+	// Headers are added to every request, on top of (and overriding) the ones Go and the User-Agent set.
+	// A "Host" header sets the Host the request is sent with (and cookies are then matched against that host, rather than the URL's).
+	Headers http.Header
+
+	// Jar holds cookies for the whole crawl: anything a page sets is sent back with later requests to the same site.
+	// It starts empty if unset; use LoadCookiesFile to start from a cookies.txt instead.
+	Jar http.CookieJar
+
+	// Auth is the credentials to crawl with (see AuthOptions), and the guard against following logout links
+	Auth AuthOptions
+
+	// Resolve overrides DNS for particular hosts, like curl's --resolve: it maps "host:port" to the IP address to connect to instead
+	// (ParseResolve reads curl's host:port:address form). Requests keep their Host header and TLS server name, so a site can be crawled
+	// as served by a new origin before DNS points at it. Overrides don't apply to sites reached through a proxy, since the proxy
+	// does the connecting (put them on the NoProxy list).
+	Resolve map[string]string
+
+	// Resolver looks up the addresses of hosts without a Resolve override (the system's resolver if unset; see NewDNSResolver)
+	Resolver *net.Resolver
+
+	// Proxy is the proxy to crawl through (see ProxyOptions); the environment's proxy settings are used if it's unset
+	Proxy ProxyOptions
+
+	// TLS sets the CAs server certificates are checked against, and client certificates for mutual TLS (see TLSOptions)
+	TLS TLSOptions
+}
+
+// DefaultFetchOptions are the settings used for any FetchOptions left unset
+var DefaultFetchOptions = FetchOptions{
+	MaxConnsPerHost:     8,
+	MaxIdleConnsPerHost: 8,
+	IdleConnTimeout:     90 * time.Second,
+	DialTimeout:         10 * time.Second,
+	TLSHandshakeTimeout: 10 * time.Second,
+	HeaderTimeout:       30 * time.Second,
+	BodyTimeout:         60 * time.Second,
+	MaxBodyBytes:        maxParseBytes,
+	MaxAttempts:         3,
+	RetryBaseDelay:      500 * time.Millisecond,
+	RetryMaxDelay:       30 * time.Second,
+	UserAgent:           DefaultUserAgent,
+}
+
+func (o FetchOptions) withDefaults() FetchOptions {
+	// withDefaults fills in every unset option from DefaultFetchOptions.
+	if o.MaxConnsPerHost <= 0 {
+		o.MaxConnsPerHost = DefaultFetchOptions.MaxConnsPerHost
+	}
+	if o.MaxIdleConnsPerHost <= 0 {
+		o.MaxIdleConnsPerHost = DefaultFetchOptions.MaxIdleConnsPerHost
+	}
+	if o.IdleConnTimeout <= 0 {
+		o.IdleConnTimeout = DefaultFetchOptions.IdleConnTimeout
+	}
+	if o.DialTimeout <= 0 {
+		o.DialTimeout = DefaultFetchOptions.DialTimeout
+	}
+	if o.TLSHandshakeTimeout <= 0 {
+		o.TLSHandshakeTimeout = DefaultFetchOptions.TLSHandshakeTimeout
+	}
+	if o.HeaderTimeout <= 0 {
+		o.HeaderTimeout = DefaultFetchOptions.HeaderTimeout
+	}
+	if o.BodyTimeout <= 0 {
+		o.BodyTimeout = DefaultFetchOptions.BodyTimeout
+	}
+	if o.MaxBodyBytes <= 0 {
+		o.MaxBodyBytes = DefaultFetchOptions.MaxBodyBytes
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = DefaultFetchOptions.MaxAttempts
+	}
+	if o.RetryBaseDelay <= 0 {
+		o.RetryBaseDelay = DefaultFetchOptions.RetryBaseDelay
+	}
+	if o.RetryMaxDelay <= 0 {
+		o.RetryMaxDelay = DefaultFetchOptions.RetryMaxDelay
+	}
+	if o.UserAgent == "" {
+		o.UserAgent = DefaultFetchOptions.UserAgent
+	}
+	if o.Jar == nil {
+		o.Jar = NewCookieJar()
+	}
+	return o
+}
+
+func (o FetchOptions) retryDelay(attempt int, retryAfter time.Duration) time.Duration {
+	// retryDelay is how long to wait after the given (1 based) attempt failed, before trying again.
+	// retryAfter is what the server asked for in a Retry-After header (0 if it didn't).
+	if retryAfter > 0 && retryAfter <= o.RetryMaxDelay {
+		return retryAfter
+	}
+
+	delay := o.RetryBaseDelay
+	for i := 1; i < attempt && delay < o.RetryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > o.RetryMaxDelay {
+		delay = o.RetryMaxDelay
+	}
+
+	// "equal jitter": somewhere between half and all of the delay, so retries spread out but still back off
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func retryAfter(resp *http.Response) time.Duration {
+	// retryAfter reads a response's Retry-After header, which is either a number of seconds or a date.
+	header := resp.Header.Get("Retry-After")
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+type Fetcher struct {
+	// A 'Fetcher' makes HTTP requests on behalf of a crawl. It's safe for concurrent use, and every worker shares one,
+	// so connections are kept alive and reused between pages rather than opened afresh for every request.
+
+	// implementation note: this used to make a new http.Client for every request, because I thought clients weren't safe to share
+	// between goroutines. They are (it says so right there in the net/http docs), and it was costing us a new connection and
+	// TLS handshake per page, with no timeouts at all.
+	options   FetchOptions
+	transport *http.Transport
+	client    *http.Client
+
+	// tlsHosts are the hosts whose TLS details have been logged (see noteTLS)
+	tlsHosts sync.Map
+}
+
+func NewFetcher(options FetchOptions) *Fetcher {
+	// NewFetcher creates a Fetcher using options (with any unset ones defaulted).
+	options = options.withDefaults()
+
+	if options.TLS.InsecureSkipVerify {
+		// this should never be on by accident, so make sure nobody misses it
+		log.Println("⚠️⚠️⚠️ TLS certificate verification is OFF: anyone between us and the site can read and change everything we crawl. Only ever do this against test environments! ⚠️⚠️⚠️")
+	}
+	if options.Proxy.Url != nil {
+		log.Printf("🧦 crawling through the proxy at %s", options.Proxy.Url.Redacted())
+	}
+
+	for hostPort, address := range options.Resolve {
+		log.Printf("📌 %s will be connected to at %s", hostPort, address)
+	}
+
+	dialer := &net.Dialer{Timeout: options.DialTimeout, KeepAlive: 30 * time.Second, Resolver: options.Resolver}
+	transport := &http.Transport{
+		Proxy:                 options.Proxy.proxyFunc(),
+		DialContext:           options.dialContext(dialer),
+		TLSClientConfig:       options.TLS.config(),
+		MaxIdleConns:          100,
+		MaxConnsPerHost:       options.MaxConnsPerHost,
+		MaxIdleConnsPerHost:   options.MaxIdleConnsPerHost,
+		IdleConnTimeout:       options.IdleConnTimeout,
+		TLSHandshakeTimeout:   options.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: options.HeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+		// a Transport with its own DialContext doesn't try HTTP/2 unless it's told to
+		ForceAttemptHTTP2: !options.DisableHTTP2,
+	}
+
+	return &Fetcher{
+		options:   options,
+		transport: transport,
+		client:    &http.Client{Transport: newAuthTransport(transport, options.Auth), CheckRedirect: checkRedirect, Jar: options.Jar},
+	}
+}
+
+func (f *Fetcher) Options() FetchOptions {
+	// Options returns the options the Fetcher is using (including any defaults which were filled in).
+	return f.options
+}
+
+func (f *Fetcher) Close() {
+	// Close closes any kept-alive connections. The Fetcher can still be used afterwards; it'll just have to reconnect.
+	f.transport.CloseIdleConnections()
+}
+
+// redirectPageKey is the context key the page being fetched is stored under, so checkRedirect can record hops against it
+type redirectPageKey struct{}
+
+func (f *Fetcher) fetch(p *HtmlPage) (*http.Response, error) {
+	// fetch GETs the page. On success, the caller must close the response body, and should finish reading it within BodyTimeout
+	// (the body is cut off with an error after that).
+	return f.fetchWithHeaders(p, nil)
+}
+
+func (f *Fetcher) fetchWithHeaders(p *HtmlPage, extra http.Header) (*http.Response, error) {
+	// fetchWithHeaders is fetch, sending extra headers (e.g the conditional ones for a cached page) on top of the usual ones.
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), redirectPageKey{}, p))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", p.getQueryUrl(), nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// set a User-Agent so that we properly identify as a crawler (unless we've been told to be something else)
+	req.Header.Set("User-Agent", f.options.UserAgent)
+	for name, values := range f.options.Headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			// Go ignores Host in the header map, and takes it from here instead
+			req.Host = values[0]
+			continue
+		}
+		req.Header[http.CanonicalHeaderKey(name)] = values
+	}
+	for name, values := range extra {
+		req.Header[name] = values
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// the header timeout is the transport's job, but it has no idea about bodies, so we cancel the request ourselves
+	// if reading the body drags on too long (which also frees the connection up)
+	body := &timedBody{ReadCloser: resp.Body, cancel: cancel}
+	body.timer = time.AfterFunc(f.options.BodyTimeout, body.expire)
+	resp.Body = body
+
+	return resp, nil
+}
+
+// errBodyTimeout is returned when reading a response body takes longer than BodyTimeout
+var errBodyTimeout error = bodyTimeoutError{}
+
+type bodyTimeoutError struct{}
+
+func (bodyTimeoutError) Error() string   { return "timed out reading response body" }
+func (bodyTimeoutError) Timeout() bool   { return true }
+func (bodyTimeoutError) Temporary() bool { return true }
+
+type timedBody struct {
+	// timedBody is a response body which is cut off once its timer expires, and which tidies up the timer and request context
+	// once it's closed.
+	io.ReadCloser
+	timer   *time.Timer
+	cancel  context.CancelFunc
+	expired atomic.Bool
+}
+
+func (b *timedBody) expire() {
+	b.expired.Store(true)
+	b.cancel()
+}
+
+func (b *timedBody) Read(data []byte) (int, error) {
+	n, err := b.ReadCloser.Read(data)
+	if err != nil && b.expired.Load() {
+		// cancelling the request makes the read fail with "context canceled", which doesn't say much
+		err = errBodyTimeout
+	}
+	return n, err
+}
+
+func (b *timedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.timer.Stop()
+	b.cancel()
+	return err
+}
+
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	// checkRedirect is the http.Client CheckRedirect hook. The client is shared, so it finds the page the redirect belongs to
+	// through the request's context, and notes the hop down against it.
+	if p, ok := req.Context().Value(redirectPageKey{}).(*HtmlPage); ok {
+		return p.recordRedirect(req, via)
+	}
+	return nil
+}
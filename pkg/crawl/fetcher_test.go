@@ -0,0 +1,348 @@
+package crawl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFetcherReusesConnections(t *testing.T) {
+	// a whole crawl should go through a handful of kept-alive connections, never more than MaxConnsPerHost at once
+	handler, _ := genTestSiteHandler()
+	server := httptest.NewUnstartedServer(handler)
+
+	var lock sync.Mutex
+	var opened, open, mostOpen int
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		lock.Lock()
+		defer lock.Unlock()
+		switch state {
+		case http.StateNew:
+			opened++
+			open++
+			if open > mostOpen {
+				mostOpen = open
+			}
+		case http.StateClosed, http.StateHijacked:
+			open--
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	rootUrl, _ := url.Parse(server.URL + "/")
+	Walk(rootUrl, Options{Fetch: FetchOptions{MaxConnsPerHost: 2}})
+
+	lock.Lock()
+	defer lock.Unlock()
+	// 6 pages are fetched, so a client per request would have opened 6 connections
+	if opened > 2 {
+		t.Errorf("crawl opened %d connections, expected at most 2", opened)
+	}
+	if mostOpen > 2 {
+		t.Errorf("crawl had %d connections open at once, expected at most 2", mostOpen)
+	}
+}
+
+func TestFetcherTimeouts(t *testing.T) {
+	// slow headers and slow bodies should both give up, rather than hanging the crawl forever
+	release := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow-headers" {
+			<-release
+			return
+		}
+		fmt.Fprint(w, "<html><head><title>Slow</title>")
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	fetcher := NewFetcher(FetchOptions{HeaderTimeout: 50 * time.Millisecond, BodyTimeout: 50 * time.Millisecond})
+	defer fetcher.Close()
+
+	slowHeaders, _ := url.Parse(server.URL + "/slow-headers")
+	if _, err := fetcher.fetch(&HtmlPage{Url: slowHeaders}); err == nil {
+		t.Error("fetch didn't time out waiting for headers")
+	}
+
+	slowBody, _ := url.Parse(server.URL + "/slow-body")
+	resp, err := fetcher.fetch(&HtmlPage{Url: slowBody})
+	if err != nil {
+		t.Fatalf("fetch failed before the body was read: %s", err)
+	}
+	defer resp.Body.Close()
+
+	finished := make(chan error)
+	go func() {
+		_, err := io.ReadAll(resp.Body)
+		finished <- err
+	}()
+	select {
+	case err := <-finished:
+		if err == nil {
+			t.Error("reading a stalled body didn't return an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reading a stalled body didn't time out")
+	}
+}
+
+func TestFetcherMaxBodyBytes(t *testing.T) {
+	// links past MaxBodyBytes shouldn't be found
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			return
+		}
+		fmt.Fprint(w, `<html><body><a href="/early">early</a>`)
+		fmt.Fprint(w, strings.Repeat("<p>padding</p>", 1000))
+		fmt.Fprint(w, `<a href="/late">late</a></body></html>`)
+	}))
+	defer server.Close()
+
+	rootUrl, _ := url.Parse(server.URL + "/")
+	root := Walk(rootUrl, Options{Fetch: FetchOptions{MaxBodyBytes: 1024}})
+
+	if len(root.LinksTo) != 1 || root.LinksTo[0].Url.Path != "/early" {
+		var links []string
+		for _, link := range root.LinksTo {
+			links = append(links, link.Url.Path)
+		}
+		t.Errorf("expected only /early to be found within the first 1024 bytes, got %v", links)
+	}
+}
+
+func TestFetcherHTTP2(t *testing.T) {
+	// HTTP/2 should be used when the server supports it, unless it's switched off
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html></html>")
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	pageUrl, _ := url.Parse(server.URL + "/")
+
+	for _, disable := range []bool{false, true} {
+		fetcher := NewFetcher(FetchOptions{DisableHTTP2: disable})
+		// trust the test server's certificate
+		fetcher.transport.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+
+		resp, err := fetcher.fetch(&HtmlPage{Url: pageUrl})
+		if err != nil {
+			t.Fatalf("fetch failed (DisableHTTP2=%t): %s", disable, err)
+		}
+		resp.Body.Close()
+		fetcher.Close()
+
+		expected := 2
+		if disable {
+			expected = 1
+		}
+		if resp.ProtoMajor != expected {
+			t.Errorf("DisableHTTP2=%t: expected HTTP/%d, got %s", disable, expected, resp.Proto)
+		}
+	}
+}
+
+func TestFetchOptionsDefaults(t *testing.T) {
+	// unset options get defaults, set ones are left alone
+	options := FetchOptions{DialTimeout: time.Second}.withDefaults()
+	expected := DefaultFetchOptions
+	expected.DialTimeout = time.Second
+
+	// an empty cookie jar is made up for every crawl, so there's no default to compare against
+	if options.Jar == nil {
+		t.Error("no cookie jar was set up")
+	}
+	options.Jar = nil
+
+	if !reflect.DeepEqual(options, expected) {
+		t.Errorf("unexpected options:\nexpected %+v\ngot      %+v", expected, options)
+	}
+}
+
+type creationRecordingStore struct {
+	// creationRecordingStore is a Store which remembers the path of every page created in it.
+	Store
+	lock    sync.Mutex
+	created []string
+}
+
+func (s *creationRecordingStore) GetOrCreate(key url.URL, create func() *HtmlPage) (*HtmlPage, bool, error) {
+	page, created, err := s.Store.GetOrCreate(key, create)
+	if created {
+		s.lock.Lock()
+		s.created = append(s.created, key.Path)
+		s.lock.Unlock()
+	}
+	return page, created, err
+}
+
+func TestFetchRetries(t *testing.T) {
+	// transient failures should be retried until they go away, and each attempt recorded
+	var lock sync.Mutex
+	hits := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		hits[r.URL.Path]++
+		hit := hits[r.URL.Path]
+		lock.Unlock()
+
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><body><a href="/flaky">flaky</a><a href="/down">down</a><a href="/missing">missing</a><a href="/hangup">hangup</a></body></html>`)
+		case "/flaky":
+			// fails twice, then works
+			if hit <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, `<html><head><title>Flaky</title></head><body></body></html>`)
+		case "/down":
+			w.WriteHeader(http.StatusBadGateway)
+		case "/hangup":
+			// drops the connection halfway through the body the first time
+			// (hanging up before responding at all doesn't work, because net/http quietly retries that itself)
+			// (after linking to a page the retry doesn't link to, which mustn't end up in the store)
+			if hit == 1 {
+				w.Header().Set("Content-Length", "1000")
+				fmt.Fprint(w, `<html><head><title>Hung up</title></head><body><a href="/only-linked-the-first-time">gone</a><p>Hung`)
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			}
+			fmt.Fprint(w, `<html><head><title>Hung up</title></head><body></body></html>`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	rootUrl, _ := url.Parse(server.URL + "/")
+	store := &creationRecordingStore{Store: NewMemoryStore()}
+	root := Walk(rootUrl, Options{Store: store, Fetch: FetchOptions{MaxAttempts: 3, RetryBaseDelay: time.Millisecond, RetryMaxDelay: 5 * time.Millisecond}})
+
+	// every page the store was asked to create is in the finished graph: failed attempts don't leave any stragglers behind
+	inGraph := map[string]bool{}
+	for _, page := range Pages(root) {
+		inGraph[page.Url.Path] = true
+	}
+	for _, created := range store.created {
+		if !inGraph[created] {
+			t.Errorf("%s was added to the store, but isn't linked from anywhere in the crawl", created)
+		}
+	}
+
+	pages := map[string]*HtmlPage{}
+	for _, page := range root.LinksTo {
+		pages[page.Url.Path] = page
+	}
+
+	flaky := pages["/flaky"]
+	if flaky.CrawlError != nil || flaky.Title != "Flaky" || len(flaky.Attempts) != 3 {
+		t.Errorf("/flaky should have worked on the third attempt: error %v, title %q, %d attempts", flaky.CrawlError, flaky.Title, len(flaky.Attempts))
+	} else if flaky.Attempts[0].StatusCode != 503 || flaky.Attempts[0].Err == nil || flaky.Attempts[2].StatusCode != 200 || flaky.Attempts[2].Err != nil {
+		t.Errorf("/flaky's attempts were recorded wrongly: %+v", flaky.Attempts)
+	}
+
+	down := pages["/down"]
+	var downError *CrawlError
+	if !errors.As(down.CrawlError, &downError) || downError.Kind != ErrorHTTP || downError.StatusCode != 502 || len(down.Attempts) != 3 {
+		t.Errorf("/down should have given up after 3 attempts with a 502: error %v, %d attempts", down.CrawlError, len(down.Attempts))
+	}
+	// (the error page is only parsed on the last attempt, as there's no point parsing one which is about to be retried)
+	if !down.IsParsed {
+		t.Error("/down's error page wasn't parsed after its last attempt")
+	}
+
+	// a 404 isn't going to change, so it shouldn't be retried (but it's still an error)
+	missing := pages["/missing"]
+	if ErrorKindOf(missing.CrawlError) != ErrorHTTP || len(missing.Attempts) != 1 || !missing.IsParsed {
+		t.Errorf("/missing should have failed once with an HTTP error: error %v, %d attempts", missing.CrawlError, len(missing.Attempts))
+	}
+
+	hangup := pages["/hangup"]
+	if hangup.CrawlError != nil || len(hangup.Attempts) != 2 || ErrorKindOf(hangup.Attempts[0].Err) != ErrorConnection {
+		t.Errorf("/hangup should have worked on the second attempt after a connection error: error %v, attempts %+v", hangup.CrawlError, hangup.Attempts)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	// delays double each time (with jitter of up to half), stop growing at the maximum, and respect Retry-After if it's sensible
+	options := FetchOptions{RetryBaseDelay: 100 * time.Millisecond, RetryMaxDelay: time.Second}
+
+	for attempt, full := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 5: time.Second, 50: time.Second} {
+		for i := 0; i < 20; i++ {
+			if delay := options.retryDelay(attempt, 0); delay < full/2 || delay > full {
+				t.Errorf("retry delay after attempt %d was %s, expected between %s and %s", attempt, delay, full/2, full)
+			}
+		}
+	}
+
+	if delay := options.retryDelay(1, 700*time.Millisecond); delay != 700*time.Millisecond {
+		t.Errorf("Retry-After wasn't respected: waited %s", delay)
+	}
+	if delay := options.retryDelay(1, time.Hour); delay > 100*time.Millisecond {
+		t.Errorf("an unreasonable Retry-After was respected: waited %s", delay)
+	}
+
+	resp := &http.Response{Header: http.Header{"Retry-After": {"3"}}}
+	if wait := retryAfter(resp); wait != 3*time.Second {
+		t.Errorf("Retry-After: 3 was read as %s", wait)
+	}
+}
+
+func TestFetchHeadersAndCookies(t *testing.T) {
+	// a site behind a picky WAF: every request needs the right User-Agent and header,
+	// the root sets a cookie which the rest of the site needs, and /members needs a cookie we bring along ourselves
+	var lock sync.Mutex
+	var rejected []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, sessionErr := r.Cookie("visited")
+		_, loginErr := r.Cookie("login")
+		ok := r.UserAgent() == "TestBot/2.0" && r.Header.Get("X-Waf-Token") == "open sesame" && r.Host == "staging.testsite.test" &&
+			(r.URL.Path == "/" || sessionErr == nil) && (r.URL.Path != "/members" || loginErr == nil)
+		if !ok {
+			lock.Lock()
+			rejected = append(rejected, r.URL.Path)
+			lock.Unlock()
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		if r.URL.Path == "/" {
+			http.SetCookie(w, &http.Cookie{Name: "visited", Value: "yes"})
+		}
+		fmt.Fprint(w, `<html><body><a href="/about">About</a><a href="/members">Members</a></body></html>`)
+	}))
+	defer server.Close()
+
+	// with a Host header, cookies go with the Host we say we're talking to, not the address we connect to
+	jar, err := LoadCookies(strings.NewReader("staging.testsite.test\tFALSE\t/\tFALSE\t0\tlogin\tsecret\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootUrl, _ := url.Parse(server.URL + "/")
+	Walk(rootUrl, Options{Fetch: FetchOptions{
+		UserAgent: "TestBot/2.0",
+		Headers:   http.Header{"X-Waf-Token": {"open sesame"}, "Host": {"staging.testsite.test"}},
+		Jar:       jar,
+	}})
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(rejected) > 0 {
+		t.Errorf("the server turned these requests away: %v", rejected)
+	}
+}
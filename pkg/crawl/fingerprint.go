@@ -0,0 +1,259 @@
+// fingerprint works out content fingerprints for pages, and uses them to find pages which are the same as (or nearly the same as)
+// each other: printer friendly versions, the same article under two URLs, listings which only differ in their sort order and so on.
+
+package crawl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/fnv"
+	"log"
+	"math/bits"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// shingleSize is how many words in a row make up each of the features SimHash is worked out from
+const shingleSize = 3
+
+// DefaultNearDuplicateDistance is how many bits two pages' SimHashes can differ by for them to count as near duplicates
+// (changing a single word of a 300 word page usually moves its SimHash by 2 to 6 bits; unrelated pages are about 32 apart)
+const DefaultNearDuplicateDistance = 6
+
+type Fingerprint struct {
+	// A 'Fingerprint' summarises a page's main text (see extract.go), so that duplicate pages can be found
+	// without keeping every page's text around. Pages with no text at all have a zero Fingerprint.
+
+	// Hash is the (hex) SHA-256 of the normalised text (lowercased, punctuation stripped), so pages with the same Hash
+	// have exactly the same words in them.
+	Hash string
+
+	// SimHash is a 64 bit SimHash of the normalised text. The more alike two pages are, the fewer bits their SimHashes differ by.
+	SimHash uint64
+}
+
+func Words(text string) []string {
+	// Words splits text up into lowercased words, leaving out the punctuation and spacing between them.
+	// It's how both fingerprints and the search index see a page's text.
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func newFingerprint(text string) Fingerprint {
+	// newFingerprint fingerprints a page's main text.
+	words := Words(text)
+	if len(words) == 0 {
+		return Fingerprint{}
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join(words, " ")))
+	return Fingerprint{Hash: hex.EncodeToString(hash[:]), SimHash: simHash(words)}
+}
+
+func simHash(words []string) uint64 {
+	// simHash works out the SimHash of a list of words, using overlapping shingles of shingleSize words as its features:
+	// every shingle's hash votes on every bit, and the majority wins.
+	var votes [64]int
+	for i := 0; i+shingleSize <= len(words) || i == 0; i++ {
+		end := i + shingleSize
+		if end > len(words) {
+			// short pages are a single shingle
+			end = len(words)
+		}
+
+		hash := fnv.New64a()
+		hash.Write([]byte(strings.Join(words[i:end], " ")))
+		feature := hash.Sum64()
+		for bit := range votes {
+			if feature&(1<<uint(bit)) != 0 {
+				votes[bit]++
+			} else {
+				votes[bit]--
+			}
+		}
+	}
+
+	var result uint64
+	for bit, vote := range votes {
+		if vote > 0 {
+			result |= 1 << uint(bit)
+		}
+	}
+	return result
+}
+
+func (f Fingerprint) IsZero() bool {
+	// IsZero returns true if there's no fingerprint (because the page wasn't parsed, or had no text).
+	return f.Hash == ""
+}
+
+func (f Fingerprint) Distance(other Fingerprint) int {
+	// Distance returns how many bits the two fingerprints' SimHashes differ by (0 to 64).
+	return bits.OnesCount64(f.SimHash ^ other.SimHash)
+}
+
+type duplicateDetector struct {
+	// duplicateDetector remembers the first page crawled with each fingerprint, so that pages which exactly duplicate it
+	// can be spotted as they're crawled (see Options.SkipDuplicates). A nil duplicateDetector never finds any.
+	lock sync.Mutex
+	seen map[string]*HtmlPage
+}
+
+func newDuplicateDetector(enabled bool) *duplicateDetector {
+	if !enabled {
+		return nil
+	}
+	return &duplicateDetector{seen: map[string]*HtmlPage{}}
+}
+
+func (d *duplicateDetector) original(p *HtmlPage) *HtmlPage {
+	// original returns the page crawled before p whose content p exactly duplicates, or nil if there isn't one
+	// (in which case p is remembered as the original of any later duplicates). The caller must hold p's ParseLock.
+	if d == nil || p.Fingerprint.IsZero() {
+		return nil
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if first, seen := d.seen[p.Fingerprint.Hash]; seen && first != p {
+		return first
+	}
+	d.seen[p.Fingerprint.Hash] = p
+	return nil
+}
+
+func (c *crawler) skipIfDuplicate(p *HtmlPage) {
+	// skipIfDuplicate drops the links of a page which exactly duplicates one already crawled, so that they aren't followed
+	// (they're just more copies of the original's links). The caller must hold p's ParseLock.
+	if original := c.duplicates.original(p); original != nil {
+		log.Printf("♊ (%s) is an exact duplicate of %s, not following its links", p.Url.String(), original.Url.String())
+		p.DuplicateOf = original.Url.String()
+		p.LinksTo = nil
+	}
+}
+
+type DuplicateCluster struct {
+	// A 'DuplicateCluster' is a group of pages with the same, or nearly the same, content.
+
+	// Pages are the pages in the cluster, sorted by URL
+	Pages []*HtmlPage
+
+	// Exact is set if every page in the cluster has exactly the same text; otherwise they're near duplicates,
+	// each within the distance limit of at least one other page in the cluster
+	Exact bool
+}
+
+func Duplicates(root *HtmlPage, maxDistance int) []DuplicateCluster {
+	// Duplicates finds the clusters of duplicate pages in the crawl rooted at root: first the groups of exact duplicates,
+	// then the groups of near duplicates (pages whose SimHashes differ by maxDistance bits or fewer; a negative maxDistance
+	// turns that off). Exact duplicates of each other only show up together once, in the exact cluster,
+	// but any near duplicate cluster they're in lists them all. Bigger clusters come first.
+
+	// group the pages up by their exact hash first, which also dedupes the SimHashes for the near duplicate search
+	byHash := map[string][]*HtmlPage{}
+	var hashes []string
+	for _, page := range ComputeDepths(root) {
+		if page.Fingerprint.IsZero() {
+			continue
+		}
+		if _, seen := byHash[page.Fingerprint.Hash]; !seen {
+			hashes = append(hashes, page.Fingerprint.Hash)
+		}
+		byHash[page.Fingerprint.Hash] = append(byHash[page.Fingerprint.Hash], page)
+	}
+
+	var clusters []DuplicateCluster
+	for _, hash := range hashes {
+		if len(byHash[hash]) > 1 {
+			clusters = append(clusters, DuplicateCluster{Pages: byHash[hash], Exact: true})
+		}
+	}
+
+	if maxDistance >= 0 {
+		for _, group := range nearDuplicates(hashes, byHash, maxDistance) {
+			var pages []*HtmlPage
+			for _, hash := range group {
+				pages = append(pages, byHash[hash]...)
+			}
+			clusters = append(clusters, DuplicateCluster{Pages: pages})
+		}
+	}
+
+	for _, cluster := range clusters {
+		pages := cluster.Pages
+		sort.Slice(pages, func(i, j int) bool { return pages[i].Url.String() < pages[j].Url.String() })
+	}
+	sort.SliceStable(clusters, func(i, j int) bool {
+		if clusters[i].Exact != clusters[j].Exact {
+			return clusters[i].Exact
+		}
+		return len(clusters[i].Pages) > len(clusters[j].Pages)
+	})
+	return clusters
+}
+
+func nearDuplicates(hashes []string, byHash map[string][]*HtmlPage, maxDistance int) [][]string {
+	// nearDuplicates groups up the (distinct) hashes whose pages' SimHashes are within maxDistance bits of each other.
+	// Comparing every pair gets slow on big crawls, so the SimHashes are split into maxDistance+1 bands: two SimHashes
+	// which differ in maxDistance bits or fewer must match exactly in at least one band, so only pages sharing a band
+	// need comparing.
+	fingerprints := make([]Fingerprint, len(hashes))
+	for i, hash := range hashes {
+		fingerprints[i] = byHash[hash][0].Fingerprint
+	}
+
+	// parent is a union-find forest over the indexes into hashes
+	parent := make([]int, len(hashes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	bands := maxDistance + 1
+	if bands > 64 {
+		bands = 64
+	}
+	for band := 0; band < bands; band++ {
+		// band covers bits [from, to)
+		from, to := band*64/bands, (band+1)*64/bands
+		mask := (^uint64(0) >> uint(64-(to-from))) << uint(from)
+
+		buckets := map[uint64][]int{}
+		for i, fingerprint := range fingerprints {
+			key := fingerprint.SimHash & mask
+			for _, other := range buckets[key] {
+				if find(i) != find(other) && fingerprint.Distance(fingerprints[other]) <= maxDistance {
+					parent[find(i)] = find(other)
+				}
+			}
+			buckets[key] = append(buckets[key], i)
+		}
+	}
+
+	groups := map[int][]string{}
+	var roots []int
+	for i, hash := range hashes {
+		root := find(i)
+		if _, seen := groups[root]; !seen {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], hash)
+	}
+
+	var result [][]string
+	for _, root := range roots {
+		if len(groups[root]) > 1 {
+			result = append(result, groups[root])
+		}
+	}
+	return result
+}
@@ -0,0 +1,163 @@
+package crawl
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func genArticle(words int, changed int) string {
+	// genArticle writes a long-ish article, with the changed'th word swapped for something else (or none, if changed is -1).
+	vocabulary := strings.Fields("the crawler walks every page of the site following links it finds along the way and " +
+		"keeps track of what it has already seen so that nothing is fetched twice")
+	var article []string
+	seed := 1
+	for i := 0; i < words; i++ {
+		// (a little linear congruential generator, so the words are jumbled up but always the same)
+		seed = (seed*1103515245 + 12345) % (1 << 31)
+		word := vocabulary[seed%len(vocabulary)]
+		if i == changed {
+			word = "different"
+		}
+		article = append(article, word)
+	}
+	return strings.Join(article, " ")
+}
+
+func TestFingerprint(t *testing.T) {
+	// case, punctuation and spacing don't matter to the exact hash
+	if newFingerprint("Hello, World! It's me.") != newFingerprint("hello world it s   me") {
+		t.Error("fingerprints should ignore case and punctuation")
+	}
+	if newFingerprint("hello world") == newFingerprint("world hello") {
+		t.Error("fingerprints should care about word order")
+	}
+	if !newFingerprint("").IsZero() || !newFingerprint(" -- !! ").IsZero() {
+		t.Error("pages without any words shouldn't get a fingerprint")
+	}
+	if newFingerprint("Café").IsZero() || newFingerprint("a").IsZero() {
+		t.Error("short pages should still get a fingerprint")
+	}
+
+	original := newFingerprint(genArticle(1000, -1))
+	oneWord := newFingerprint(genArticle(1000, 500))
+	if original.Hash == oneWord.Hash {
+		t.Error("changing a word should change the exact hash")
+	}
+	if distance := original.Distance(oneWord); distance > DefaultNearDuplicateDistance {
+		t.Errorf("changing one word in 1000 should leave a near duplicate, but the SimHashes are %d bits apart", distance)
+	}
+	if distance := original.Distance(newFingerprint("something else entirely, about a completely different subject")); distance <= DefaultNearDuplicateDistance {
+		t.Errorf("unrelated text shouldn't be a near duplicate, but the SimHashes are only %d bits apart", distance)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	page := func(path string, text string) *HtmlPage {
+		pageUrl, _ := url.Parse("http://testsite.test" + path)
+		return &HtmlPage{Url: pageUrl, IsParsed: true, Fingerprint: newFingerprint(text)}
+	}
+
+	root := page("/", "home page")
+	article := page("/article", genArticle(1000, -1))
+	printable := page("/article/print", genArticle(1000, -1))
+	amended := page("/article-amended", genArticle(1000, 500))
+	other := page("/other", "something else entirely")
+	empty := page("/empty", "")
+	alsoEmpty := page("/also-empty", "")
+	root.LinksTo = []*HtmlPage{article, printable, amended, other, empty, alsoEmpty}
+
+	clusters := Duplicates(root, DefaultNearDuplicateDistance)
+	if len(clusters) != 2 {
+		t.Fatalf("expected an exact cluster and a near duplicate cluster, got %d clusters", len(clusters))
+	}
+	if !clusters[0].Exact || len(clusters[0].Pages) != 2 || clusters[0].Pages[0] != article || clusters[0].Pages[1] != printable {
+		t.Errorf("expected the article and its print version to be exact duplicates, got %+v", clusters[0])
+	}
+	if clusters[1].Exact || len(clusters[1].Pages) != 3 || clusters[1].Pages[0] != article || clusters[1].Pages[1] != amended {
+		t.Errorf("expected the amended article to be a near duplicate of the other two, got %+v", clusters[1])
+	}
+
+	// with near duplicates turned off, only the exact cluster is left
+	if clusters := Duplicates(root, -1); len(clusters) != 1 || !clusters[0].Exact {
+		t.Errorf("expected only the exact cluster without near duplicates, got %d clusters", len(clusters))
+	}
+}
+
+func TestCrawlSkipsDuplicateLinks(t *testing.T) {
+	// /copy has exactly the same text as /original (which links to it), but different links
+	var lock sync.Mutex
+	hits := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		hits[r.URL.Path]++
+		lock.Unlock()
+
+		fmt.Fprint(w, "<html><head><title>Duplicates</title></head><body>")
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<p>Home</p><a href="/original"></a>`)
+		case "/original":
+			fmt.Fprint(w, `<p>The same words</p><a href="/copy"></a>`)
+		case "/copy":
+			fmt.Fprint(w, `<p>The same words</p><a href="/copy/child"></a>`)
+		}
+		fmt.Fprint(w, "</body></html>")
+	}))
+	defer server.Close()
+	rootUrl, _ := url.Parse(server.URL + "/")
+
+	texts := &textCollector{texts: map[string]string{}}
+	root := Walk(rootUrl, Options{SkipDuplicates: true, Texts: texts})
+
+	lock.Lock()
+	if hits["/copy"] != 1 || hits["/copy/child"] != 0 {
+		t.Errorf("links from the duplicate page shouldn't be followed: %v", hits)
+	}
+	lock.Unlock()
+
+	original := root.LinksTo[0]
+	copied := original.LinksTo[0]
+	if copied.DuplicateOf != original.Url.String() || len(copied.LinksTo) != 0 {
+		t.Errorf("expected %s to be marked as a duplicate of %s without any links, got %q and %d links",
+			copied.Url.String(), original.Url.String(), copied.DuplicateOf, len(copied.LinksTo))
+	}
+	if clusters := Duplicates(root, -1); len(clusters) != 1 || len(clusters[0].Pages) != 2 {
+		t.Errorf("expected one cluster of two exact duplicates, got %d clusters", len(clusters))
+	}
+
+	// fingerprints and duplicates are saved with the crawl
+	var saved bytes.Buffer
+	if err := (&Crawl{Root: root, Seed: rootUrl.String()}).Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+	savedJSON := saved.String()
+	loaded, err := LoadCrawl(&saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loadedCopy := loaded.Root.LinksTo[0].LinksTo[0]
+	if loadedCopy.Fingerprint != copied.Fingerprint || loadedCopy.DuplicateOf != copied.DuplicateOf {
+		t.Error("fingerprints didn't survive saving")
+	}
+	// but the text itself isn't: it goes to Options.Texts instead
+	if text := texts.texts[copied.Url.Path]; text != "The same words" {
+		t.Errorf("expected the page's text to be handed to Options.Texts, got %q", text)
+	}
+	if strings.Contains(savedJSON, "The same words") {
+		t.Error("the page's text shouldn't be saved with the crawl")
+	}
+
+	// without SkipDuplicates, the copy's links are followed like any other
+	Walk(rootUrl, Options{})
+	lock.Lock()
+	if hits["/copy/child"] != 1 {
+		t.Errorf("links from the duplicate page should be followed by default: %v", hits)
+	}
+	lock.Unlock()
+}
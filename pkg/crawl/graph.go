@@ -0,0 +1,55 @@
+// graph contains functions for working with a finished HtmlPage graph as a whole, rather than one page at a time.
+
+package crawl
+
+func ComputeDepths(root *HtmlPage) []*HtmlPage {
+	// ComputeDepths does a breadth first search from root over the finished graph, and stores each page's
+	// shortest click depth (Depth) and the page it's first reached from (DepthParent).
+	// It returns every reachable page in breadth first order.
+
+	// The crawler itself can't work this out as it goes, because which worker reaches a page first
+	// is down to goroutine scheduling rather than click distance.
+
+	// Links are followed in the order they appear on each page, so the result is the same every time for the same graph.
+
+	root.Depth = 0
+	root.DepthParent = nil
+
+	seen := map[*HtmlPage]bool{root: true}
+	order := []*HtmlPage{root}
+
+	// order doubles as the BFS queue; next is the index of the page we're expanding
+	for next := 0; next < len(order); next++ {
+		current := order[next]
+		for _, link := range current.LinksTo {
+			if seen[link] {
+				continue
+			}
+			seen[link] = true
+			link.Depth = current.Depth + 1
+			link.DepthParent = current
+			order = append(order, link)
+		}
+	}
+
+	return order
+}
+
+func Pages(root *HtmlPage) []*HtmlPage {
+	// Pages returns every page reachable from root, in the same breadth first order as ComputeDepths,
+	// but without touching anything on the pages. It's for listing pages out of a graph which might be being read elsewhere
+	// (ComputeDepths rewrites every page's Depth and DepthParent as it goes).
+	seen := map[*HtmlPage]bool{root: true}
+	order := []*HtmlPage{root}
+
+	for next := 0; next < len(order); next++ {
+		for _, link := range order[next].LinksTo {
+			if !seen[link] {
+				seen[link] = true
+				order = append(order, link)
+			}
+		}
+	}
+
+	return order
+}
@@ -0,0 +1,68 @@
+package crawl
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestComputeDepths(t *testing.T) {
+	// root -> a -> b -> c, but also root -> c directly, so c's shortest depth is 1 even though it's at the end of a long chain
+	page := func(path string) *HtmlPage {
+		return &HtmlPage{Url: &url.URL{Scheme: "http", Host: "testsite.test", Path: path}}
+	}
+	root, a, b, c := page("/"), page("/a"), page("/b"), page("/c")
+	root.LinksTo = []*HtmlPage{a, c}
+	a.LinksTo = []*HtmlPage{b}
+	b.LinksTo = []*HtmlPage{c, root}
+
+	// pretend the crawler got there the long way round first
+	c.Depth = 3
+	c.DepthParent = b
+
+	order := ComputeDepths(root)
+
+	if len(order) != 4 {
+		t.Fatalf("ComputeDepths returned the wrong number of pages: expected %d, got %d", 4, len(order))
+	}
+
+	expected := []struct {
+		page   *HtmlPage
+		depth  int
+		parent *HtmlPage
+	}{
+		{root, 0, nil},
+		{a, 1, root},
+		{c, 1, root},
+		{b, 2, a},
+	}
+
+	for i, e := range expected {
+		if order[i] != e.page {
+			t.Errorf("page %d is out of breadth first order: expected %s, got %s", i, e.page.Url, order[i].Url)
+		}
+		if e.page.Depth != e.depth || e.page.DepthParent != e.parent {
+			t.Errorf("%s has the wrong depth / parent: expected %d / %p, got %d / %p", e.page.Url, e.depth, e.parent, e.page.Depth, e.page.DepthParent)
+		}
+	}
+}
+
+func TestPages(t *testing.T) {
+	// the same graph as above: Pages should find the same pages in the same order, but leave their depths alone
+	page := func(path string) *HtmlPage {
+		return &HtmlPage{Url: &url.URL{Scheme: "http", Host: "testsite.test", Path: path}}
+	}
+	root, a, b, c := page("/"), page("/a"), page("/b"), page("/c")
+	root.LinksTo = []*HtmlPage{a, c}
+	a.LinksTo = []*HtmlPage{b}
+	b.LinksTo = []*HtmlPage{c, root}
+	c.Depth = 3
+	c.DepthParent = b
+
+	order := Pages(root)
+	if len(order) != 4 || order[0] != root || order[1] != a || order[2] != c || order[3] != b {
+		t.Fatalf("Pages returned the wrong pages, or in the wrong order")
+	}
+	if c.Depth != 3 || c.DepthParent != b || a.DepthParent != nil {
+		t.Errorf("Pages changed the pages' depths")
+	}
+}
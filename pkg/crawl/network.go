@@ -0,0 +1,260 @@
+// network holds the fetch options for getting to sites at all: host overrides and DNS, going through a proxy, and the TLS setup
+// (private CAs, client certificates), along with the TLS details recorded for each page fetched over HTTPS.
+
+package crawl
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func ParseResolve(spec string) (string, string, error) {
+	// ParseResolve parses a curl style host:port:address override (e.g "www.example.com:443:203.0.113.7") into the
+	// host:port and address for FetchOptions.Resolve. IPv6 addresses go in square brackets, as in a URL.
+	host, rest, found := strings.Cut(spec, ":")
+	port, address, foundPort := strings.Cut(rest, ":")
+	if !found || !foundPort || host == "" {
+		return "", "", fmt.Errorf("%q should look like host:port:address", spec)
+	}
+	if number, err := strconv.Atoi(port); err != nil || number <= 0 || number > 65535 {
+		return "", "", fmt.Errorf("%q has a bad port %q", spec, port)
+	}
+
+	address = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+	if net.ParseIP(address) == nil {
+		return "", "", fmt.Errorf("%q: %q isn't an IP address", spec, address)
+	}
+	return net.JoinHostPort(strings.ToLower(host), port), address, nil
+}
+
+func NewDNSResolver(server string) *net.Resolver {
+	// NewDNSResolver returns a resolver which sends every lookup to the DNS server at server ("10.0.0.53", or "10.0.0.53:5353"
+	// for another port), instead of the system's. It's for FetchOptions.Resolver.
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+	}
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			// network is udp or tcp, as the lookup needs; address is whatever the system's resolv.conf says, which we ignore
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
+
+func (o FetchOptions) dialContext(dialer *net.Dialer) func(ctx context.Context, network string, address string) (net.Conn, error) {
+	// dialContext returns the transport's DialContext hook: dialer's, with any Resolve override for the address swapped in.
+	// The request itself is left alone, so it still has the right Host header, and TLS still checks (and sends SNI for) the real host name.
+	return func(ctx context.Context, network string, address string) (net.Conn, error) {
+		if host, port, err := net.SplitHostPort(address); err == nil {
+			if override, exists := o.Resolve[net.JoinHostPort(strings.ToLower(host), port)]; exists {
+				address = net.JoinHostPort(override, port)
+			}
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
+}
+
+type ProxyOptions struct {
+	// ProxyOptions send the crawl through a proxy. If Url is unset, the usual HTTP_PROXY / HTTPS_PROXY / NO_PROXY
+	// environment variables are used instead (and NoProxy is ignored).
+
+	// Url is the proxy to use: http://, https:// or socks5://, with user:password in it if the proxy needs a login
+	Url *url.URL
+
+	// NoProxy lists hosts which are connected to directly rather than through the proxy. Each one is a host name
+	// (which covers its subdomains too, so "example.com" includes "www.example.com"), a host:port, an IP address,
+	// a CIDR range like "10.0.0.0/8", or "*" for everything.
+	NoProxy []string
+}
+
+func (p ProxyOptions) proxyFunc() func(*http.Request) (*url.URL, error) {
+	// proxyFunc returns the http.Transport Proxy hook for the options.
+	if p.Url == nil {
+		return http.ProxyFromEnvironment
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		if p.bypass(req.URL) {
+			return nil, nil
+		}
+		return p.Url, nil
+	}
+}
+
+func (p ProxyOptions) bypass(target *url.URL) bool {
+	// bypass returns true if target is on the NoProxy list.
+	host, port := strings.ToLower(target.Hostname()), target.Port()
+	ip := net.ParseIP(host)
+
+	for _, entry := range p.NoProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		// an entry with a port only matches that port
+		entryHost := entry
+		if h, entryPort, err := net.SplitHostPort(entry); err == nil {
+			if entryPort != port {
+				continue
+			}
+			entryHost = h
+		}
+		entryHost = strings.TrimPrefix(strings.TrimPrefix(entryHost, "*"), ".")
+
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
+
+type TLSOptions struct {
+	// TLSOptions set up the TLS side of HTTPS connections.
+
+	// RootCAs are the certificate authorities server certificates are checked against (the system's if unset).
+	// LoadCABundle adds a private CA's certificates to the system ones.
+	RootCAs *x509.CertPool
+
+	// Certificates are client certificates, for servers which want us to prove who we are (mutual TLS)
+	Certificates []tls.Certificate
+
+	// InsecureSkipVerify turns off checking server certificates altogether, so anyone in the middle can read and change
+	// everything we fetch. It's only for test environments with throwaway certificates, and a warning is logged whenever it's used.
+	InsecureSkipVerify bool
+}
+
+func (t TLSOptions) config() *tls.Config {
+	// config returns the tls.Config for the options.
+	return &tls.Config{
+		RootCAs:            t.RootCAs,
+		Certificates:       t.Certificates,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+}
+
+func LoadCABundle(path string) (*x509.CertPool, error) {
+	// LoadCABundle returns the system's trusted certificates, plus the PEM encoded CA certificates in the file at path.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		// no system pool (it happens on some minimal containers), so it's just the bundle
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s doesn't contain any PEM certificates", path)
+	}
+	return pool, nil
+}
+
+func LoadClientCertificate(certPath string, keyPath string) (tls.Certificate, error) {
+	// LoadClientCertificate loads a client certificate and its private key (both PEM encoded) for TLSOptions.Certificates.
+	// If keyPath is empty, the key is expected to be in the certificate file too.
+	if keyPath == "" {
+		keyPath = certPath
+	}
+	certificate, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return tls.Certificate{}, errors.New("unable to load client certificate: " + err.Error())
+	}
+	return certificate, nil
+}
+
+type TLSInfo struct {
+	// A 'TLSInfo' describes the TLS connection a page was served over.
+
+	// Version and CipherSuite are what was negotiated, by name (e.g "TLS 1.3", "TLS_AES_128_GCM_SHA256")
+	Version     string
+	CipherSuite string
+
+	// CertSubject and CertIssuer are the common names on the server's certificate, and CertExpiry is when it runs out
+	CertSubject string
+	CertIssuer  string
+	CertExpiry  time.Time
+}
+
+func newTLSInfo(state *tls.ConnectionState) *TLSInfo {
+	// newTLSInfo pulls the interesting parts out of a connection's TLS state (nil for a plain HTTP connection).
+	if state == nil {
+		return nil
+	}
+
+	info := &TLSInfo{Version: tls.VersionName(state.Version), CipherSuite: tls.CipherSuiteName(state.CipherSuite)}
+	if len(state.PeerCertificates) > 0 {
+		// the first certificate is the server's own; the rest are the chain up to its CA
+		certificate := state.PeerCertificates[0]
+		info.CertSubject = certificate.Subject.CommonName
+		info.CertIssuer = certificate.Issuer.CommonName
+		info.CertExpiry = certificate.NotAfter
+	}
+	return info
+}
+
+// certExpiryWarning is how close to expiring a certificate has to be before it gets a warning in the log
+const certExpiryWarning = 30 * 24 * time.Hour
+
+func (f *Fetcher) noteTLS(host string, info *TLSInfo) {
+	// noteTLS logs the TLS details of each host the first time we see them, with a warning if its certificate has expired or
+	// is about to.
+	if info == nil {
+		return
+	}
+	if _, seen := f.tlsHosts.LoadOrStore(host, info); seen {
+		return
+	}
+
+	remaining := time.Until(info.CertExpiry)
+	switch {
+	case info.CertExpiry.IsZero():
+		log.Printf("🔒 %s: %s, no server certificate", host, info.Version)
+	case remaining < 0:
+		log.Printf("⚠️ %s: %s, certificate EXPIRED on %s", host, info.Version, info.CertExpiry.Format("2006-01-02"))
+	case remaining < certExpiryWarning:
+		log.Printf("⚠️ %s: %s, certificate expires soon: %s (%d days)", host, info.Version, info.CertExpiry.Format("2006-01-02"), int(remaining.Hours()/24))
+	default:
+		log.Printf("🔒 %s: %s, certificate expires %s", host, info.Version, info.CertExpiry.Format("2006-01-02"))
+	}
+}
+
+func HostTLS(root *HtmlPage) map[string]TLSInfo {
+	// HostTLS gathers the TLS details recorded for every host in the crawl rooted at root, keyed by host (host:port if it isn't
+	// the default port). Redirected pages count against the host they finally came from.
+	hosts := map[string]TLSInfo{}
+	for _, page := range Pages(root) {
+		if page.TLS == nil {
+			continue
+		}
+		served := page.Url
+		if len(page.Redirects) > 0 {
+			served = page.Redirects[len(page.Redirects)-1].Url
+		}
+		hosts[served.Host] = *page.TLS
+	}
+	return hosts
+}
@@ -0,0 +1,483 @@
+package crawl
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProxyBypass(t *testing.T) {
+	options := ProxyOptions{NoProxy: []string{"internal.test", ".corp.test", "*.dev.test", "staging.test:8443", "10.0.0.0/8", "192.168.1.1"}}
+	expectations := map[string]bool{
+		"http://internal.test/":        true,
+		"http://www.internal.test/":    true,
+		"http://notinternal.test/":     false,
+		"http://corp.test/":            true,
+		"http://wiki.corp.test/":       true,
+		"http://app.dev.test/":         true,
+		"https://staging.test:8443/":   true,
+		"https://staging.test/":        false,
+		"http://10.1.2.3/":             true,
+		"http://11.1.2.3/":             false,
+		"http://192.168.1.1:8080/":     true,
+		"http://testsite.test/":        false,
+		"http://INTERNAL.test/welcome": true,
+	}
+	for target, expected := range expectations {
+		targetUrl, _ := url.Parse(target)
+		if bypass := options.bypass(targetUrl); bypass != expected {
+			t.Errorf("%s: expected bypass to be %t", target, expected)
+		}
+	}
+
+	targetUrl, _ := url.Parse("http://anything.test/")
+	if !(ProxyOptions{NoProxy: []string{"*"}}).bypass(targetUrl) {
+		t.Error("* should bypass the proxy for everything")
+	}
+}
+
+func TestFetchThroughProxy(t *testing.T) {
+	// a forward proxy which serves the test site for any host, so the crawl can only work if it goes through the proxy
+	handler, _ := genTestSiteHandler()
+	var lock sync.Mutex
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		proxied = append(proxied, r.URL.String())
+		lock.Unlock()
+		if r.Header.Get("Proxy-Authorization") != "Basic cHJveHk6cGFzcw==" { // proxy:pass
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	proxyUrl, _ := url.Parse(proxy.URL)
+	proxyUrl.User = url.UserPassword("proxy", "pass")
+	rootUrl, _ := url.Parse("http://testsite.test/")
+
+	root := Walk(rootUrl, Options{Fetch: FetchOptions{Proxy: ProxyOptions{Url: proxyUrl}}})
+	if pages := ComputeDepths(root); len(pages) != 6 {
+		t.Errorf("expected 6 pages crawled through the proxy, got %d", len(pages))
+	}
+	lock.Lock()
+	if len(proxied) != 6 || proxied[0] != "http://testsite.test/" {
+		t.Errorf("expected the proxy to see all 6 requests, starting with the root; it saw %v", proxied)
+	}
+	proxied = nil
+	lock.Unlock()
+
+	// and on the no-proxy list, it goes direct (which fails, as there's no such host)
+	fetcher := NewFetcher(FetchOptions{Proxy: ProxyOptions{Url: proxyUrl, NoProxy: []string{"testsite.test"}}, MaxAttempts: 1, DialTimeout: time.Second})
+	defer fetcher.Close()
+	if resp, err := fetcher.fetch(&HtmlPage{Url: rootUrl}); err == nil {
+		resp.Body.Close()
+		t.Error("a fetch of a host on the no-proxy list went through the proxy")
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(proxied) > 0 {
+		t.Errorf("the proxy saw requests for a host on the no-proxy list: %v", proxied)
+	}
+}
+
+func startSocks5Proxy(t *testing.T, target string) (string, func() []string) {
+	// startSocks5Proxy starts a bare bones SOCKS5 proxy (no auth, CONNECT only) which connects every request to target,
+	// whatever was asked for. It returns its address, and a function listing the hosts it was asked to connect to.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var lock sync.Mutex
+	var requested []string
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		buffer := make([]byte, 262)
+
+		// greeting: version, number of methods, methods; we only do "no auth"
+		if _, err := io.ReadFull(conn, buffer[:2]); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(conn, buffer[:buffer[1]]); err != nil {
+			return
+		}
+		conn.Write([]byte{5, 0})
+
+		// request: version, CONNECT, reserved, address type, address, port
+		if _, err := io.ReadFull(conn, buffer[:4]); err != nil || buffer[1] != 1 {
+			return
+		}
+		var host string
+		switch buffer[3] {
+		case 1:
+			io.ReadFull(conn, buffer[:4])
+			host = net.IP(buffer[:4]).String()
+		case 3:
+			io.ReadFull(conn, buffer[:1])
+			length := int(buffer[0])
+			io.ReadFull(conn, buffer[:length])
+			host = string(buffer[:length])
+		default:
+			return
+		}
+		io.ReadFull(conn, buffer[:2])
+		port := binary.BigEndian.Uint16(buffer[:2])
+
+		lock.Lock()
+		requested = append(requested, net.JoinHostPort(host, strconv.Itoa(int(port))))
+		lock.Unlock()
+
+		upstream, err := net.Dial("tcp", target)
+		if err != nil {
+			conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer upstream.Close()
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+		go io.Copy(upstream, conn)
+		io.Copy(conn, upstream)
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+
+	return listener.Addr().String(), func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), requested...)
+	}
+}
+
+func TestFetchThroughSocks5Proxy(t *testing.T) {
+	server, _ := genTestSite()
+	defer server.Close()
+	proxyAddress, requested := startSocks5Proxy(t, server.Listener.Addr().String())
+
+	proxyUrl, _ := url.Parse("socks5://" + proxyAddress)
+	rootUrl, _ := url.Parse("http://testsite.test:8080/")
+	root := Walk(rootUrl, Options{Fetch: FetchOptions{Proxy: ProxyOptions{Url: proxyUrl}}})
+
+	if pages := ComputeDepths(root); len(pages) != 6 {
+		t.Errorf("expected 6 pages crawled through the proxy, got %d", len(pages))
+	}
+	// the host name is handed to the proxy to look up, rather than resolved on our side
+	hosts := requested()
+	if len(hosts) == 0 || hosts[0] != "testsite.test:8080" {
+		t.Errorf("expected the proxy to be asked for testsite.test:8080, it was asked for %v", hosts)
+	}
+}
+
+type testCertificate struct {
+	// testCertificate is a certificate made up for a test, along with its key.
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	der         []byte
+}
+
+func (c testCertificate) tls() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key, Leaf: c.certificate}
+}
+
+func (c testCertificate) writePEM(t *testing.T, path string, withKey bool) {
+	// writePEM writes the certificate (and optionally its key) to path.
+	var data bytes.Buffer
+	pem.Encode(&data, &pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+	if withKey {
+		key, err := x509.MarshalECPrivateKey(c.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pem.Encode(&data, &pem.Block{Type: "EC PRIVATE KEY", Bytes: key})
+	}
+	if err := os.WriteFile(path, data.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *testCertificate) testCertificate {
+	// newTestCertificate creates a certificate from template, signed by parent (or self signed, if parent is nil).
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+	}
+
+	parentCertificate, parentKey := template, key
+	if parent != nil {
+		parentCertificate, parentKey = parent.certificate, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCertificate, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCertificate{certificate: certificate, key: key, der: der}
+}
+
+func TestFetchPrivateCAAndClientCertificate(t *testing.T) {
+	// an internal site, with a certificate from a private CA, which only lets in clients with a certificate from that CA too
+	ca := newTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Internal CA"},
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+	expiry := time.Now().Add(20 * 24 * time.Hour).Truncate(time.Second).UTC()
+	serverCertificate := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "intranet.test"},
+		NotAfter:    expiry,
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, &ca)
+	clientCertificate := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "creepycrawler"},
+		NotAfter:    time.Now().Add(24 * time.Hour),
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &ca)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.certificate)
+	handler, _ := genTestSiteHandler()
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCertificate.tls()},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS13,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	directory := t.TempDir()
+	caPath, clientPath := filepath.Join(directory, "ca.pem"), filepath.Join(directory, "client.pem")
+	ca.writePEM(t, caPath, false)
+	clientCertificate.writePEM(t, clientPath, true)
+
+	rootCAs, err := LoadCABundle(caPath)
+	if err != nil {
+		t.Fatalf("LoadCABundle failed: %s", err)
+	}
+	client, err := LoadClientCertificate(clientPath, "")
+	if err != nil {
+		t.Fatalf("LoadClientCertificate failed: %s", err)
+	}
+
+	rootUrl, _ := url.Parse(server.URL + "/")
+
+	// without the client certificate, we're turned away; without the CA, the server's certificate isn't trusted
+	for name, options := range map[string]TLSOptions{
+		"no client certificate": {RootCAs: rootCAs},
+		"no CA":                 {Certificates: []tls.Certificate{client}},
+	} {
+		fetcher := NewFetcher(FetchOptions{TLS: options, MaxAttempts: 1})
+		resp, err := fetcher.fetch(&HtmlPage{Url: rootUrl})
+		if err == nil {
+			// TLS 1.3 client certificate failures only show up once the response is read
+			_, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		fetcher.Close()
+		if err == nil {
+			t.Errorf("%s: the fetch succeeded", name)
+		}
+	}
+
+	root := Walk(rootUrl, Options{Fetch: FetchOptions{TLS: TLSOptions{RootCAs: rootCAs, Certificates: []tls.Certificate{client}}}})
+	for _, page := range ComputeDepths(root) {
+		if page.StatusCode == 0 {
+			t.Errorf("%s wasn't fetched: %s", page.Url, page.CrawlError)
+		}
+	}
+
+	if root.TLS == nil {
+		t.Fatal("no TLS details were recorded for the root")
+	}
+	expected := TLSInfo{Version: "TLS 1.3", CipherSuite: root.TLS.CipherSuite, CertSubject: "intranet.test", CertIssuer: "Test Internal CA", CertExpiry: expiry}
+	if *root.TLS != expected {
+		t.Fatalf("expected the root's TLS to be %+v, got %+v", expected, root.TLS)
+	}
+	if hosts := HostTLS(root); len(hosts) != 1 || hosts[rootUrl.Host] != expected {
+		t.Errorf("expected HostTLS to have just %s, got %+v", rootUrl.Host, hosts)
+	}
+
+	// the TLS details should survive saving and loading
+	var saved bytes.Buffer
+	if err := (&Crawl{Root: root, Seed: rootUrl.String()}).Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCrawl(&saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Root.TLS == nil || !loaded.Root.TLS.CertExpiry.Equal(expiry) || loaded.Root.TLS.Version != "TLS 1.3" {
+		t.Errorf("TLS details didn't survive a save and load: %+v", loaded.Root.TLS)
+	}
+}
+
+func TestFetchInsecureSkipVerify(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	fetcher := NewFetcher(FetchOptions{TLS: TLSOptions{InsecureSkipVerify: true}})
+	defer fetcher.Close()
+
+	pageUrl, _ := url.Parse(server.URL + "/")
+	resp, err := fetcher.fetch(&HtmlPage{Url: pageUrl})
+	if err != nil {
+		t.Fatalf("fetch with InsecureSkipVerify failed: %s", err)
+	}
+	resp.Body.Close()
+}
+
+func TestParseResolve(t *testing.T) {
+	for spec, expected := range map[string][2]string{
+		"www.example.com:443:203.0.113.7": {"www.example.com:443", "203.0.113.7"},
+		"WWW.Example.com:80:10.0.0.1":     {"www.example.com:80", "10.0.0.1"},
+		"example.com:443:[2001:db8::1]":   {"example.com:443", "2001:db8::1"},
+		"example.com:443:2001:db8::1":     {"example.com:443", "2001:db8::1"},
+	} {
+		hostPort, address, err := ParseResolve(spec)
+		if err != nil || hostPort != expected[0] || address != expected[1] {
+			t.Errorf("%s: expected %v, got %s %s (%v)", spec, expected, hostPort, address, err)
+		}
+	}
+
+	for _, spec := range []string{"example.com", "example.com:443", "example.com:https:10.0.0.1", "example.com:443:origin.example.com", ":443:10.0.0.1"} {
+		if _, _, err := ParseResolve(spec); err == nil {
+			t.Errorf("%s: expected an error", spec)
+		}
+	}
+}
+
+func TestFetchResolveOverride(t *testing.T) {
+	// crawl https://example.com as served by the test server, which has to see the right Host and SNI
+	// (httptest's certificate is for example.com, so it verifies, too)
+	var lock sync.Mutex
+	var wrong []string
+	handler, _ := genTestSiteHandler()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, port, _ := net.SplitHostPort(r.Host)
+		if r.Host != "example.com:"+port || r.TLS.ServerName != "example.com" {
+			lock.Lock()
+			wrong = append(wrong, r.Host+" / "+r.TLS.ServerName)
+			lock.Unlock()
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	server.StartTLS()
+	defer server.Close()
+
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	rootUrl, _ := url.Parse("https://example.com:" + port + "/")
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+	resolve := map[string]string{"example.com:" + port: "127.0.0.1"}
+
+	root := Walk(rootUrl, Options{Fetch: FetchOptions{Resolve: resolve, TLS: TLSOptions{RootCAs: rootCAs}}})
+	if pages := ComputeDepths(root); len(pages) != 6 {
+		t.Errorf("expected 6 pages crawled from the override address, got %d", len(pages))
+	}
+	lock.Lock()
+	if len(wrong) > 0 {
+		t.Errorf("requests arrived with the wrong Host / server name: %v", wrong)
+	}
+	lock.Unlock()
+
+	// the overrides go in the crawl's metadata
+	var saved bytes.Buffer
+	if err := (&Crawl{Root: root, Seed: rootUrl.String(), Resolve: resolve}).Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCrawl(&saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Resolve["example.com:"+port] != "127.0.0.1" {
+		t.Errorf("host overrides weren't saved: %v", loaded.Resolve)
+	}
+}
+
+func TestFetchResolver(t *testing.T) {
+	// a custom resolver should be asked about hosts without an override, and not about those with one
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	var lock sync.Mutex
+	lookups := 0
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			lock.Lock()
+			lookups++
+			lock.Unlock()
+			return nil, errors.New("no DNS here")
+		},
+	}
+	fetcher := NewFetcher(FetchOptions{Resolver: resolver, Resolve: map[string]string{"overridden.test:" + port: "127.0.0.1"}})
+	defer fetcher.Close()
+
+	overridden, _ := url.Parse("http://overridden.test:" + port + "/")
+	resp, err := fetcher.fetch(&HtmlPage{Url: overridden})
+	if err != nil {
+		t.Fatalf("fetching an overridden host failed: %s", err)
+	}
+	resp.Body.Close()
+	lock.Lock()
+	if lookups != 0 {
+		t.Errorf("the resolver was asked about an overridden host")
+	}
+	lock.Unlock()
+
+	other, _ := url.Parse("http://other.test:" + port + "/")
+	if resp, err := fetcher.fetch(&HtmlPage{Url: other}); err == nil {
+		resp.Body.Close()
+		t.Error("fetching a host the resolver can't find succeeded")
+	} else if kind := classifyError(err).Kind; kind != ErrorDNS {
+		t.Errorf("expected a DNS error, got %s: %s", kind, err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if lookups == 0 {
+		t.Error("the resolver wasn't used")
+	}
+}
@@ -9,6 +9,8 @@ import (
 	"net/url"
 	"sync"
 	"time"
+
+	"golang.org/x/net/html"
 )
 
 type HtmlPage struct {
@@ -28,17 +30,102 @@ type HtmlPage struct {
 	// Title is the HTML title of this page (where available)
 	Title string
 
+	// StatusCode is the HTTP status code the page was served with (0 if it was never fetched)
+	StatusCode int
+
+	// FetchedAt is when the page was fetched (the zero time if it never was)
+	FetchedAt time.Time
+
+	// Redirects lists every redirect hop followed while fetching this page, in order
+	// (so the last entry is where the content was actually served from). It's empty if the page didn't redirect.
+	Redirects []Redirect
+
 	// I'm undecided as to whether there should also be a Data element, that stores the entire body of the page
 	// this seems like a waste of memory though, as we're really only interested in mapping page relations
 
 	LinksTo []*HtmlPage
 
+	// Depth is the shortest number of clicks it takes to get to this page from the crawl root,
+	// and DepthParent is the page one click closer to the root along that path (nil for the root itself).
+	// Both are filled in by ComputeDepths once the crawl has finished.
+	Depth       int
+	DepthParent *HtmlPage
+
+	// MetaRobots is the content of the page's <meta name="robots"> tag (e.g "noindex, nofollow"), if it has one
+	MetaRobots string
+
+	// Description is the content of the page's <meta name="description"> tag, and Canonical is where its <link rel="canonical">
+	// points (resolved to an absolute URL if it can be), if it has them
+	Description string
+	Canonical   string
+
+	// TLS describes the TLS connection the page was served over (its version, and the certificate's expiry);
+	// it's nil for pages served over plain HTTP
+	TLS *TLSInfo
+
+	// Cache says whether the page came out of the HTTP cache (see Options.Cache) rather than being fetched and parsed in full
+	Cache CacheStatus
+
+	// History lists what each visit to the page found, oldest first. It's only kept up to date by recrawls (see Options.Recrawl);
+	// a page from a plain crawl starts off with none.
+	History []Change
+
+	// Fingerprint summarises the page's main text (with navigation, headers, footers, scripts and styles left out; see extract.go),
+	// so that duplicate pages can be found (see Duplicates). The text itself isn't kept on the page; see Options.Texts.
+	// It's zero for pages which weren't parsed, or had no text.
+	Fingerprint Fingerprint
+
+	// DuplicateOf is the URL of an earlier page whose content this one exactly duplicates, if its links weren't followed
+	// because of it (see Options.SkipDuplicates). It's "" otherwise.
+	DuplicateOf string
+
+	// Soft404 is why the page looks like a soft 404 (a "page not found" page served with a success status; see Options.Soft404),
+	// or "" if it doesn't. Soft 404s count as broken.
+	Soft404 string
+
+	// Trap is why the page was quarantined as a suspected crawler trap (see Options.Traps), or "" if it wasn't.
+	// Quarantined pages are never fetched.
+	Trap string
+
 	// IsParsed should be flipped to True if this page has been parsed for content (even if none was found).
 	// (this saves having to scrape a page twice)
 	IsParsed bool
 
-	// CrawlError is set if there was an issue crawling this page (for example, it threw a HTTP error, or scraping failed)
+	// Attempts lists every try at fetching this page, in order. There's more than one if earlier tries failed in a way
+	// that looked temporary (see FetchOptions.MaxAttempts).
+	Attempts []Attempt
+
+	// CrawlError is set if there was an issue crawling this page (for example, it threw a HTTP error, or scraping failed).
+	// Pages crawled by Walk always get a *CrawlError, which says what kind of problem it was.
+	// A page served with an HTTP error status gets one too, but its body is still parsed for links (so IsParsed is also set).
 	CrawlError error
+
+	// reloaded is set on copies of finished pages which a DiskStore read back off disk (see rejoinStoredPages)
+	reloaded bool
+}
+
+type Attempt struct {
+	// An 'Attempt' is a single try at fetching a page.
+
+	// At is when the attempt started, and Duration how long it took (including reading and parsing the body)
+	At       time.Time
+	Duration time.Duration
+
+	// StatusCode is the HTTP status the attempt got (0 if it didn't get that far)
+	StatusCode int
+
+	// Err is why the attempt failed (nil if it didn't)
+	Err error
+}
+
+type Redirect struct {
+	// A 'Redirect' is a single hop in a redirect chain.
+
+	// StatusCode is the redirect status (301, 302 etc.) of the response which pointed at Url
+	StatusCode int
+
+	// Url is where the redirect pointed to
+	Url *url.URL
 }
 
 type Page interface {
@@ -55,76 +142,246 @@ type Page interface {
 	getQueryUrl() string
 }
 
-func (p *HtmlPage) fetchAndParse(getPage chan *readPage, setPage chan *writePage) (error error) {
-	// we use a new HTTP client for each request because they're occurring async
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", p.getQueryUrl(), nil)
+func (p *HtmlPage) fetchAndParse(c *crawler) error {
+	// fetchAndParse fetches and parses the page, retrying with backoff for as long as it fails in a way that looks temporary.
+	// Every attempt is recorded in Attempts; the error returned (if any) is always a *CrawlError.
+	options := c.fetcher.options
 
-	if err != nil {
-		return err
+	for attempt := 1; ; attempt++ {
+		started := time.Now()
+		wait, err := p.fetchAndParseOnce(c, attempt)
+		p.Attempts = append(p.Attempts, Attempt{At: started, Duration: time.Since(started), StatusCode: p.StatusCode, Err: nilIfNoError(err)})
+
+		if err == nil {
+			return nil
+		}
+		if !err.Temporary || attempt >= options.MaxAttempts {
+			if attempt > 1 {
+				log.Printf("🚫 (%s) giving up after %d attempts: %s", p.Url.String(), attempt, err)
+			}
+			return err
+		}
+
+		delay := options.retryDelay(attempt, wait)
+		log.Printf("🔁 (%s) attempt %d of %d failed (%s), retrying in %s", p.Url.String(), attempt, options.MaxAttempts, err, delay.Round(time.Millisecond))
+		time.Sleep(delay)
 	}
+}
 
-	log.Printf("request: (%s)", p.getQueryUrl())
+func nilIfNoError(err *CrawlError) error {
+	// nilIfNoError stops a nil *CrawlError turning into a non-nil error interface.
+	if err == nil {
+		return nil
+	}
+	return err
+}
 
-	// set a User-Agent so that we properly identify as a crawler
-	// could make this configurable, but arguably out of scope
-	req.Header.Set("User-Agent", "Go_CreepyCrawler/1.0")
+func (p *HtmlPage) fetchAndParseOnce(c *crawler, attempt int) (time.Duration, *CrawlError) {
+	// fetchAndParseOnce makes a single attempt at fetching and parsing the page (attempt counts up from 1).
+	// Along with any error, it returns how long the server asked us to wait before trying again (0 if it didn't).
+
+	// wipe anything a previous failed attempt got partway through
+	// (a failed attempt never leaves pages behind in the store: links only go into it once the whole body has been read and extracted,
+	// and the only failures which are retried, temporary error statuses and bodies cut off partway, both come before that)
+	p.Title, p.MetaRobots, p.StatusCode, p.Redirects, p.LinksTo, p.TLS, p.Cache = "", "", 0, nil, nil, nil, CacheMiss
+	p.Description, p.Canonical, p.Fingerprint = "", "", Fingerprint{}
+
+	// if we've seen the page before, we might not need to fetch it at all, or can at least ask whether it's changed
+	var cached *cacheEntry
+	var conditional http.Header
+	if c.cache != nil {
+		cached = c.cache.get(p.Url, c.cacheIdentity)
+	}
+	if cached != nil {
+		if cached.fresh(time.Now()) {
+			log.Printf("📦 (%s) still fresh in the cache, not fetching it", p.Url.String())
+			p.StatusCode, p.Redirects, p.FetchedAt = cached.StatusCode, cached.redirects(), cached.StoredAt
+			return 0, p.useCached(c, cached, CacheHit)
+		}
+		conditional = cached.conditionalHeaders()
+	}
+
+	// every request goes through the crawl's shared Fetcher, so connections get reused between pages
+	log.Printf("request: (%s)", p.getQueryUrl())
 
 	// do the request
-	resp, err := client.Do(req)
+	resp, err := c.fetcher.fetchWithHeaders(p, conditional)
 	if err != nil {
-		return err
+		return 0, classifyError(err)
 	}
 
 	// connection must now be closed once we're done with it, so we add a deferred close function
 	defer resp.Body.Close()
 
+	p.StatusCode = resp.StatusCode
+	p.FetchedAt = time.Now()
+	p.TLS = newTLSInfo(resp.TLS)
+	c.fetcher.noteTLS(resp.Request.URL.Host, p.TLS)
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		log.Printf("📦 (%s) not modified since it was cached, reusing it", p.Url.String())
+		p.StatusCode = cached.StatusCode
+		if cached.updateFrom(resp, p.FetchedAt) {
+			if err := c.cache.put(p.Url, c.cacheIdentity, cached); err != nil {
+				log.Printf("⚠️ (%s) unable to update the cache: %s", p.Url.String(), err)
+			}
+		}
+		return 0, p.useCached(c, cached, CacheRevalidated)
+	}
+
+	var statusErr *CrawlError
+	if resp.StatusCode >= 400 {
+		statusErr = statusError(resp.StatusCode)
+		if statusErr.Temporary && attempt < c.fetcher.options.MaxAttempts {
+			// there's no point parsing a "try again later" page we're about to try again
+			return retryAfter(resp), statusErr
+		}
+	}
+
 	// now parse the body
-	err = p.parseHTML(&resp.Body, getPage, setPage)
+	// (error pages are parsed too; a 404 page's navigation links are still links)
+	// (with a DOMExtractor to feed, the whole document tree is built instead of streaming through it)
+	var info *pageInfo
+	var doc *html.Node
+	if c.dom != nil {
+		info, doc, err = p.parseDOM(&resp.Body, c.store, c.fetcher.options.MaxBodyBytes, c.fetcher.options.Auth.isLogout, c.traps)
+	} else {
+		info, err = p.parseHTML(&resp.Body, c.store, c.fetcher.options.MaxBodyBytes, c.fetcher.options.Auth.isLogout, c.traps)
+	}
 	if err != nil {
-		return err
+		return 0, classifyParseError(err)
 	}
 
 	// the HtmlPage is now populated; return
 	p.IsParsed = true
-	return
+	c.pageText(p, info.text)
+	if c.dom != nil {
+		c.dom.PageDOM(p, doc)
+	}
+	if c.cache != nil && resp.StatusCode == http.StatusOK {
+		p.storeInCache(c, resp, info)
+	}
+	return 0, statusErr
+}
+
+func (p *HtmlPage) useCached(c *crawler, entry *cacheEntry, status CacheStatus) *CrawlError {
+	// useCached fills the page in from its cache entry, instead of parsing a body.
+	p.Cache = status
+	info := entry.pageInfo()
+	if err := p.applyPageInfo(info, c.store, c.fetcher.options.MaxBodyBytes, c.fetcher.options.Auth.isLogout, c.traps); err != nil {
+		return classifyParseError(err)
+	}
+	p.IsParsed = true
+	c.pageText(p, info.text)
+	return nil
+}
+
+func (p *HtmlPage) storeInCache(c *crawler, resp *http.Response, info *pageInfo) {
+	// storeInCache caches what was parsed out of the page, if the response allows it.
+	entry := &cacheEntry{
+		Url:         p.Url.String(),
+		StatusCode:  resp.StatusCode,
+		Title:       info.title,
+		Base:        info.base,
+		Robots:      info.robots,
+		Description: info.description,
+		Canonical:   info.canonical,
+		Refresh:     info.refresh,
+		Links:       info.links,
+		Text:        info.text,
+	}
+	for _, redirect := range p.Redirects {
+		entry.Redirects = append(entry.Redirects, redirectRecord{StatusCode: redirect.StatusCode, Url: redirect.Url.String()})
+	}
+	if !entry.updateFrom(resp, p.FetchedAt) {
+		return
+	}
+	if err := c.cache.put(p.Url, c.cacheIdentity, entry); err != nil {
+		log.Printf("⚠️ (%s) unable to cache the page: %s", p.Url.String(), err)
+	}
+}
+
+func (p *HtmlPage) IsBroken() bool {
+	// IsBroken returns true if the page couldn't be crawled, was served with an HTTP error status, or is a soft 404.
+	return p.CrawlError != nil || p.StatusCode >= 400 || p.Soft404 != ""
+}
+
+func (p *HtmlPage) isDone() bool {
+	// isDone returns true if the page has been crawled, successfully or not (or was quarantined), and so shouldn't be fetched again.
+	// The caller must hold ParseLock (or know that nothing else is touching the page).
+	return p.IsParsed || p.CrawlError != nil || p.Trap != ""
+}
+
+func (p *HtmlPage) recordRedirect(req *http.Request, via []*http.Request) error {
+	// recordRedirect is called by the Fetcher's CheckRedirect hook, and notes down each redirect hop as it's followed.
+	// It keeps the default client behaviour of giving up after 10 redirects.
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+
+	p.Redirects = append(p.Redirects, Redirect{StatusCode: req.Response.StatusCode, Url: req.URL})
+	return nil
 }
 
-func (p *HtmlPage) fetchDataAndRecurse(getPage chan *readPage, setPage chan *writePage, complete chan<- bool) {
+func (p *HtmlPage) finalUrl() *url.URL {
+	// finalUrl returns where the page was actually served from: the last hop of its redirects, or its own URL if there weren't any.
+	if len(p.Redirects) > 0 && p.Redirects[len(p.Redirects)-1].Url != nil {
+		return p.Redirects[len(p.Redirects)-1].Url
+	}
+	return p.Url
+}
+
+func (p *HtmlPage) fetchDataAndRecurse(c *crawler, complete chan<- bool) {
 	// fetchDataAndRecurse simply runs fetchData() and then recurse() (to go even deeper) in order.
 	// It's here to be run as a goroutine (and returns on <-complete when finished)
 
 	defer func() { complete <- true }()
 
 	p.ParseLock.Lock()
-	err := p.fetchAndParse(getPage, setPage)
+	if p.isDone() {
+		// two pages linking here can both see it as not yet crawled before either worker gets the lock,
+		// so whichever worker comes second has nothing left to do (and mustn't append the links all over again)
+		p.ParseLock.Unlock()
+		return
+	}
+	err := p.fetchAndParse(c)
 	if err != nil {
 		p.CrawlError = err
-		log.Printf("Failed to parse a page: %s", err)
+		log.Printf("Failed to crawl a page (%s error): %s", ErrorKindOf(err), err)
 	}
+	c.flagIfSoft404(p)
+	c.skipIfDuplicate(p)
 	log.Printf("Unlocking %s", p.Url.String())
 	p.ParseLock.Unlock()
-	p.recurse(getPage, setPage)
+	p.recurse(c)
 
 	return
 }
 
-func (p *HtmlPage) recurse(getPage chan *readPage, setPage chan *writePage) (error error) {
+func (p *HtmlPage) recurse(c *crawler) (error error) {
+	// recurse crawls every page this one links to (and, in turn, every page they link to).
+	crawlPages(p.LinksTo, p.Url, c)
+	return
+}
+
+func crawlPages(pages []*HtmlPage, from *url.URL, c *crawler) {
+	// crawlPages fires off a worker for each page in pages that hasn't been crawled yet, and waits for them all to finish.
+	// from is only used for logging (it's the page the links were found on).
 	chComplete := make(chan bool)
 
 	// totalCalled tracks the number of worker routines we're firing, so that we can keep track of things
 	// I originally had the IsParsed check inside fetchDataAndRecurse(), but that caused some weird goroutine timeout issues
 	var totalCalled int
 
-	for _, value := range p.LinksTo {
+	for _, value := range pages {
 		log.Printf("Locking %s", value.Url.String())
 		value.ParseLock.Lock()
-		if !value.IsParsed {
+		if !value.isDone() {
 			// we unlock again after testing IsParsed because the goroutine could (theoretically) take a bit to launch
 			// plus this feels saner because fetchDataAndRecurse sets and releases its own lock instead of relying on this function's
 			value.ParseLock.Unlock()
 			log.Printf("%s not yet parsed, parsing", value.Url.String())
-			go value.fetchDataAndRecurse(getPage, setPage, chComplete)
+			go value.fetchDataAndRecurse(c, chComplete)
 			totalCalled++
 		} else {
 			log.Printf("%s already parsed, ignoring", value.Url.String())
@@ -142,15 +399,13 @@ func (p *HtmlPage) recurse(getPage chan *readPage, setPage chan *writePage) (err
 			waiting++
 		case <-time.After(5 * time.Second):
 			// print a warning after 5 seconds if all goroutines haven't returned yet
-			log.Printf("%s: 5s timeout! (waiting for %d of %d goroutine(s))\n", p.Url.String(), len(p.LinksTo)-waiting, len(p.LinksTo))
+			log.Printf("%s: 5s timeout! (waiting for %d of %d goroutine(s))\n", from.String(), totalCalled-waiting, totalCalled)
 			break
 		}
 	}
 
 	// finally, close up
 	close(chComplete)
-
-	return
 }
 
 func normaliseUrl(target *url.URL) *url.URL {
@@ -162,9 +417,9 @@ func normaliseUrl(target *url.URL) *url.URL {
 	return target
 }
 
-func (p *HtmlPage) createAbsoluteUrl(target *string) (targetUrl *url.URL, err error) {
+func absoluteUrl(base *url.URL, target *string) (targetUrl *url.URL, err error) {
 	// This function takes any relative or absolute URL, and converts it to absolute
-	// based on the information available from the present HtmlPage.
+	// by resolving it against base (usually the URL of the page it was found on).
 
 	// It also normalises it by removing things like anchor tags.
 
@@ -189,7 +444,7 @@ func (p *HtmlPage) createAbsoluteUrl(target *string) (targetUrl *url.URL, err er
 	}
 
 	// discovering url.ResolveReference is beautiful and makes me want to move in with Go full time
-	return p.Url.ResolveReference(urlTarget), nil
+	return base.ResolveReference(urlTarget), nil
 }
 
 func (p *HtmlPage) getQueryUrl() string {
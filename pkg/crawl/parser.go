@@ -3,91 +3,133 @@
 package crawl
 
 import (
-	"golang.org/x/net/html"
 	"io"
 	"log"
+	"net/url"
+
+	"golang.org/x/net/html"
 )
 
-func (p *HtmlPage) parseHTML(data *io.ReadCloser, getPage chan *readPage, setPage chan *writePage) (error error) {
+func (p *HtmlPage) parseHTML(data *io.ReadCloser, store Store, maxBytes int64, skip func(link *url.URL) bool, traps *trapDetector) (info *pageInfo, err error) {
 
 	// parseHTML parses HTML from the provided ReadCloser, and writes information about it to its HtmlPage.
-	// It uses the getPage and setPage channels to access the synchronous allpages data store, to check
-	// for entry duplication.
+	// It uses the page store to check for entry duplication. Only the first maxBytes bytes are read.
+	// Links which skip returns true for aren't added to the page at all (skip can be nil), and new pages which traps
+	// suspects of being a crawler trap are quarantined (traps can be nil too).
+	// It returns what was extracted, so that it can be cached.
 
 	// This could easily be refactored into a generic parseHTML function, but for the purposes of this scraper,
 	// it is bound against HtmlPage.
-	doc, err := html.Parse(*data)
+
+	// implementation note: this used to build the whole DOM with html.Parse and walk it, which gets memory hungry on huge pages,
+	// so it now streams through the tokens instead (see extract.go, where the DOM version still lives)
+	info, err = extractTokens(*data, maxBytes)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// f is a helper function that does the scraping for us (and can, as such, be called recursively)
-	// it must be defined explicitly because otherwise it's not available inside the function during creation
-	var f func(*html.Node)
-	f = func(n *html.Node) {
+	return info, p.applyPageInfo(info, store, maxBytes, skip, traps)
+}
 
-		if n.Type == html.ElementNode && n.Data == "title" {
-			// It's the page title! Let's set the HtmlPage title attribute.
-			p.Title = n.FirstChild.Data
-			log.Printf("ℹ️ (%s) title='%s'", p.Url.String(), p.Title)
-		}
-		if n.Type == html.ElementNode && n.Data == "a" {
-			// It's a hyperlink! Let's add this to the discovered links array.
-
-			// First, we need to get the thing it actually links to:
-			var href *string
-			for _, a := range n.Attr {
-				if a.Key == "href" {
-					href = &a.Val
-					break
-				}
-			}
-
-			// Next, check that it is part of our target
-			targetUrl, err := p.createAbsoluteUrl(href)
-
-			if err != nil {
-				log.Printf("⚠️ (%s) recoverable error occured during parsing of a URL, ignoring: %s", p.Url.String(), err)
-			// FIXME I'd probably replace this host==host lookup with more sensible hostIsValid and urlIsDuplicate calls
-			// FIXME mainly because that would make it easier to ignore URIs like mailto:, plus gives a single authority
-			} else if targetUrl.Host == p.Url.Host {
-				// Finally, do we already have it in our stack?
-
-				read := &readPage{key: *targetUrl, response: make(chan *HtmlPage)}
-
-				getPage <- read
-
-				response := <-read.response
-
-				if response == nil {
-					// Then we append a pointer to a new HtmlPage
-					targetPage := &HtmlPage{Url: targetUrl}
-					p.LinksTo = append(p.LinksTo, targetPage)
-					// and append this link to the discovered list
-					write := &writePage{key: *targetUrl, value: targetPage, response: make(chan bool)}
-					setPage <- write
-					<-write.response
-					log.Printf("🌍 (%s) href=%s stored as %p", p.Url.String(), targetUrl.String(), targetPage)
-				} else {
-					// Append this page to the linksTo array, because it _is_ still a link to a different page
-					// it won't be read again because fetchDataAndRecurse won't run on HtmlPages which have already been parsed
-					// (this is guaranteed with a Mutex lock on Page)
-					p.LinksTo = append(p.LinksTo, response)
-					log.Printf("ℹ️ (%s) href=%s already discovered: is %p", p.Url.String(), targetUrl.String(), &response)
-				}
-
-			}
+func (p *HtmlPage) parseDOM(data *io.ReadCloser, store Store, maxBytes int64, skip func(link *url.URL) bool, traps *trapDetector) (*pageInfo, *html.Node, error) {
+	// parseDOM is parseHTML, but building the whole document tree (which it returns too) rather than streaming through it,
+	// for when the crawl has a DOMExtractor to hand the tree to.
+	info, doc, err := extractDOM(*data, maxBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return info, doc, p.applyPageInfo(info, store, maxBytes, skip, traps)
+}
+
+func (p *HtmlPage) applyPageInfo(info *pageInfo, store Store, maxBytes int64, skip func(link *url.URL) bool, traps *trapDetector) error {
+	// applyPageInfo fills the HtmlPage in from what was extracted from its HTML,
+	// looking each link up in the page store (and adding it if it's new).
+	// It's only called once the extraction has finished, so a page whose body fails partway through adds nothing to the store.
+
+	if info.truncated {
+		log.Printf("⚠️ (%s) page is over %d bytes, so only the start of it was parsed", p.Url.String(), maxBytes)
+	}
+
+	// It's the page title! Let's set the HtmlPage title attribute.
+	p.Title = info.title
+	log.Printf("ℹ️ (%s) title='%s'", p.Url.String(), p.Title)
+
+	p.MetaRobots = info.robots
+	p.Description = info.description
+	p.Fingerprint = newFingerprint(info.text)
+
+	// relative links resolve against <base href> if the page has one, and otherwise against wherever the page ended up
+	// after any redirects (a page at /a which redirects to /dir/b links to /dir/c.html with href="c.html", not /c.html)
+	base := p.finalUrl()
+	if info.base != "" {
+		baseUrl, err := absoluteUrl(base, &info.base)
+		if err != nil {
+			log.Printf("⚠️ (%s) recoverable error occured during parsing of <base>, ignoring: %s", p.Url.String(), err)
+		} else {
+			base = baseUrl
 		}
-		// We don't return from the above if because it's (theoretically) possible to have A's inside A's (even though it's stupid and totally against spec)
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			// Let's recurse as far as possible!
-			f(c)
+	}
+
+	// the canonical URL is kept as written if it won't resolve, as that's worth seeing too
+	p.Canonical = info.canonical
+	if info.canonical != "" {
+		if canonicalUrl, err := absoluteUrl(base, &info.canonical); err == nil {
+			p.Canonical = canonicalUrl.String()
 		}
 	}
 
-	// Do the scrape!
-	f(doc)
+	// a meta refresh is as good as a link (if a rather slow one)
+	links := info.links
+	if info.refresh != "" {
+		links = append(links, info.refresh)
+	}
+
+	for i := range links {
+		// It's a hyperlink! Let's add this to the discovered links array.
+
+		// First, check that it is part of our target
+		targetUrl, err := absoluteUrl(base, &links[i])
+
+		if err != nil {
+			log.Printf("⚠️ (%s) recoverable error occured during parsing of a URL, ignoring: %s", p.Url.String(), err)
+			continue
+		}
+		// FIXME I'd probably replace this host==host lookup with more sensible hostIsValid and urlIsDuplicate calls
+		// FIXME mainly because that would make it easier to ignore URIs like mailto:, plus gives a single authority
+		if targetUrl.Host != p.Url.Host {
+			continue
+		}
+
+		// and that we're allowed to follow it (e.g it isn't going to log us out)
+		if skip != nil && skip(targetUrl) {
+			log.Printf("🚪 (%s) href=%s looks like a logout link, not following it", p.Url.String(), targetUrl.String())
+			continue
+		}
+
+		// Finally, do we already have it in our stack?
+		// looking it up and adding it if not is one step, so two parsers finding the same new URL at once can't both add it
+		// (new pages are checked for traps as they're created, so that each URL only counts towards the trap limits once)
+		response, created, err := store.GetOrCreate(*targetUrl, func() *HtmlPage { return &HtmlPage{Url: targetUrl, Trap: traps.check(targetUrl)} })
+
+		if err != nil {
+			// the store's broken, so there's no point carrying on
+			return err
+		}
+
+		// Either way, append this page to the linksTo array, because it _is_ still a link to a different page
+		// if it was already there, it won't be read again because fetchDataAndRecurse won't run on HtmlPages which have already been parsed
+		// (this is guaranteed with a Mutex lock on Page)
+		p.LinksTo = append(p.LinksTo, response)
+		if created && response.Trap != "" {
+			log.Printf("🪤 (%s) href=%s looks like a crawler trap, quarantined: %s", p.Url.String(), targetUrl.String(), response.Trap)
+		} else if created {
+			log.Printf("🌍 (%s) href=%s stored as %p", p.Url.String(), targetUrl.String(), response)
+		} else {
+			log.Printf("ℹ️ (%s) href=%s already discovered: is %p", p.Url.String(), targetUrl.String(), response)
+		}
+	}
 
-	return
+	return nil
 }
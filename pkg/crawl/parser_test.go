@@ -2,8 +2,11 @@ package crawl
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"net/url"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -24,15 +27,10 @@ func TestHtmlParsing(t *testing.T) {
 
 	testReader := ioutil.NopCloser(bytes.NewBufferString(testDataSingleAnchor))
 
-	allPages := make(map[url.URL]*HtmlPage)
-	getPage := make(chan *readPage)
-	setPage := make(chan *writePage)
+	store := NewMemoryStore()
+	defer store.Close()
 
-	// Start the Map Storage Provider on the getPage and setPage channels
-	// see the comments inside that function for why it's necessary
-	go mapStorageProvider(allPages, getPage, setPage)
-
-	err = testPage.parseHTML(&testReader, getPage, setPage)
+	_, err = testPage.parseHTML(&testReader, store, maxParseBytes, nil, nil)
 
 	if err != nil {
 		t.Errorf("HtmlPage.parseHTML() returned an error during parsing: %s", err)
@@ -68,15 +66,10 @@ func TestHtmlParseDeduplication(t *testing.T) {
 
 	testReader := ioutil.NopCloser(bytes.NewBufferString(testDataDuplicateAnchor))
 
-	allPages := make(map[url.URL]*HtmlPage)
-	getPage := make(chan *readPage)
-	setPage := make(chan *writePage)
-
-	// Start the Map Storage Provider on the getPage and setPage channels
-	// see the comments inside that function for why it's necessary
-	go mapStorageProvider(allPages, getPage, setPage)
+	store := NewMemoryStore()
+	defer store.Close()
 
-	err = testPage.parseHTML(&testReader, getPage, setPage)
+	_, err = testPage.parseHTML(&testReader, store, maxParseBytes, nil, nil)
 
 	if err != nil {
 		t.Errorf("HtmlPage.parseHTML() returned an error during parsing: %s", err)
@@ -113,15 +106,10 @@ func TestHtmlInvalidMarkup(t *testing.T) {
 
 	testReader := ioutil.NopCloser(bytes.NewBufferString(testDataInvalidAnchorMarkup))
 
-	allPages := make(map[url.URL]*HtmlPage)
-	getPage := make(chan *readPage)
-	setPage := make(chan *writePage)
-
-	// Start the Map Storage Provider on the getPage and setPage channels
-	// see the comments inside that function for why it's necessary
-	go mapStorageProvider(allPages, getPage, setPage)
+	store := NewMemoryStore()
+	defer store.Close()
 
-	err = testPage.parseHTML(&testReader, getPage, setPage)
+	_, err = testPage.parseHTML(&testReader, store, maxParseBytes, nil, nil)
 
 	if err != nil {
 		t.Errorf("HtmlPage.parseHTML() returned an error during parsing: %s", err)
@@ -131,3 +119,76 @@ func TestHtmlInvalidMarkup(t *testing.T) {
 		t.Errorf("Parsing of HTML <a/> tags returned an unexpected number of results: expected 0, got %d", len(testPage.LinksTo))
 	}
 }
+
+func TestConcurrentParseDeduplication(t *testing.T) {
+	// TestConcurrentParseDeduplication fires hundreds of parsers at once, over link sets which overlap heavily,
+	// and checks that every URL still ends up with exactly one HtmlPage (and that it's the one in the store).
+	const parsers, pool, linksEach = 300, 50, 20
+
+	for name, store := range genTestStores(t) {
+		pages := make([]*HtmlPage, parsers)
+		parseErrors := make([]error, parsers)
+
+		// start is closed once every parser is ready, so that they all go at the same time
+		start := make(chan bool)
+		var ready, done sync.WaitGroup
+
+		for i := 0; i < parsers; i++ {
+			// parser i links to a different (but overlapping) run of pages from the pool
+			var document strings.Builder
+			document.WriteString("<html><body>")
+			for j := 0; j < linksEach; j++ {
+				fmt.Fprintf(&document, `<a href="/page/%d">link</a>`, (i+j*7)%pool)
+			}
+			document.WriteString("</body></html>")
+
+			pages[i] = &HtmlPage{Url: &url.URL{Scheme: "http", Host: "testsite.test", Path: fmt.Sprintf("/parser/%d", i)}}
+			reader := ioutil.NopCloser(strings.NewReader(document.String()))
+
+			ready.Add(1)
+			done.Add(1)
+			go func(i int) {
+				defer done.Done()
+				ready.Done()
+				<-start
+				_, parseErrors[i] = pages[i].parseHTML(&reader, store, maxParseBytes, nil, nil)
+			}(i)
+		}
+
+		ready.Wait()
+		close(start)
+		done.Wait()
+
+		// every link to the same URL, from every parser, must be the same pointer
+		found := map[string]*HtmlPage{}
+		for i, page := range pages {
+			if parseErrors[i] != nil {
+				t.Fatalf("%s store: parser %d failed: %s", name, i, parseErrors[i])
+			}
+			if len(page.LinksTo) != linksEach {
+				t.Errorf("%s store: parser %d found %d links, expected %d", name, i, len(page.LinksTo), linksEach)
+			}
+			for _, link := range page.LinksTo {
+				key := link.Url.String()
+				if existing, seen := found[key]; seen && existing != link {
+					t.Errorf("%s store: %s has more than one HtmlPage (%p and %p)", name, key, existing, link)
+				}
+				found[key] = link
+			}
+		}
+
+		var stored int
+		store.Iterate(func(key url.URL, page *HtmlPage) bool {
+			stored++
+			if found[key.String()] != page {
+				t.Errorf("%s store: stored page for %s isn't the one the parsers linked to", name, key.String())
+			}
+			return true
+		})
+		if stored != pool || len(found) != pool {
+			t.Errorf("%s store: expected exactly %d pages, got %d stored and %d linked", name, pool, stored, len(found))
+		}
+
+		store.Close()
+	}
+}
@@ -0,0 +1,345 @@
+// persist saves finished crawls to disk and loads them back in, so that they can be displayed / exported / analysed
+// again later without going anywhere near the network.
+
+package crawl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// crawlFileVersion is bumped whenever the saved format changes in a way older versions can't read
+const crawlFileVersion = 1
+
+type Crawl struct {
+	// A 'Crawl' is a finished crawl: the page graph, plus everything we know about how it was made.
+
+	// Root is the page the crawl started from
+	Root *HtmlPage
+
+	// Seed is the target the crawl was started with, as it was given
+	Seed string
+
+	// StartedAt and FinishedAt bracket the crawl
+	StartedAt  time.Time
+	FinishedAt time.Time
+
+	// Config records the settings the crawl was run with, as name / value pairs
+	// (the command line fills this in from its flags)
+	Config map[string]string
+
+	// Resolve is the host overrides the crawl was run with (see FetchOptions.Resolve), so it's clear afterwards
+	// when a crawl wasn't of what DNS was pointing at
+	Resolve map[string]string
+}
+
+// The types below are the on-disk representation. Pages are flattened into a list (root first),
+// and links are stored as indexes into that list, which keeps cycles from being a problem.
+
+type crawlFile struct {
+	Version    int               `json:"version"`
+	Seed       string            `json:"seed"`
+	StartedAt  time.Time         `json:"startedAt"`
+	FinishedAt time.Time         `json:"finishedAt"`
+	Config     map[string]string `json:"config,omitempty"`
+	Resolve    map[string]string `json:"resolve,omitempty"`
+	Pages      []pageRecord      `json:"pages"`
+}
+
+type pageRecord struct {
+	Url         string             `json:"url"`
+	Title       string             `json:"title,omitempty"`
+	StatusCode  int                `json:"statusCode,omitempty"`
+	Redirects   []redirectRecord   `json:"redirects,omitempty"`
+	MetaRobots  string             `json:"metaRobots,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Canonical   string             `json:"canonical,omitempty"`
+	TLS         *tlsRecord         `json:"tls,omitempty"`
+	Cache       string             `json:"cache,omitempty"`
+	History     []changeRecord     `json:"history,omitempty"`
+	Fingerprint *fingerprintRecord `json:"fingerprint,omitempty"`
+	DuplicateOf string             `json:"duplicateOf,omitempty"`
+	Soft404     string             `json:"soft404,omitempty"`
+	Trap        string             `json:"trap,omitempty"`
+	IsParsed    bool               `json:"isParsed"`
+	CrawlError  string             `json:"crawlError,omitempty"`
+	ErrorKind   string             `json:"errorKind,omitempty"`
+	Attempts    []attemptRecord    `json:"attempts,omitempty"`
+	FetchedAt   time.Time          `json:"fetchedAt"`
+	Depth       int                `json:"depth"`
+	LinksTo     []int              `json:"linksTo,omitempty"`
+}
+
+type attemptRecord struct {
+	At         time.Time `json:"at"`
+	DurationMs int64     `json:"durationMs"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	ErrorKind  string    `json:"errorKind,omitempty"`
+}
+
+type tlsRecord struct {
+	Version     string    `json:"version"`
+	CipherSuite string    `json:"cipherSuite,omitempty"`
+	CertSubject string    `json:"certSubject,omitempty"`
+	CertIssuer  string    `json:"certIssuer,omitempty"`
+	CertExpiry  time.Time `json:"certExpiry"`
+}
+
+type changeRecord struct {
+	At     time.Time `json:"at"`
+	Change string    `json:"change"`
+}
+
+type fingerprintRecord struct {
+	Hash string `json:"hash"`
+	// SimHash is written out in hex, as JSON numbers can't be trusted with all 64 bits
+	SimHash string `json:"simHash"`
+}
+
+type redirectRecord struct {
+	StatusCode int    `json:"statusCode"`
+	Url        string `json:"url"`
+}
+
+func (c *Crawl) Save(w io.Writer) error {
+	// Save writes the crawl to w as JSON.
+
+	pages := ComputeDepths(c.Root)
+	index := map[*HtmlPage]int{}
+	for i, page := range pages {
+		index[page] = i
+	}
+
+	file := crawlFile{
+		Version:    crawlFileVersion,
+		Seed:       c.Seed,
+		StartedAt:  c.StartedAt,
+		FinishedAt: c.FinishedAt,
+		Config:     c.Config,
+		Resolve:    c.Resolve,
+	}
+
+	for _, page := range pages {
+		record := newPageRecord(page)
+		record.Depth = page.Depth
+		for _, link := range page.LinksTo {
+			record.LinksTo = append(record.LinksTo, index[link])
+		}
+		file.Pages = append(file.Pages, record)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(file)
+}
+
+func newPageRecord(page *HtmlPage) pageRecord {
+	// newPageRecord turns a page into its on-disk representation, apart from its depth and links
+	// (which only make sense as part of a whole saved crawl, so Save fills them in).
+	record := pageRecord{
+		Url:         page.Url.String(),
+		Title:       page.Title,
+		StatusCode:  page.StatusCode,
+		MetaRobots:  page.MetaRobots,
+		Description: page.Description,
+		Canonical:   page.Canonical,
+		DuplicateOf: page.DuplicateOf,
+		Soft404:     page.Soft404,
+		Trap:        page.Trap,
+		IsParsed:    page.IsParsed,
+		FetchedAt:   page.FetchedAt,
+	}
+	if !page.Fingerprint.IsZero() {
+		record.Fingerprint = &fingerprintRecord{Hash: page.Fingerprint.Hash, SimHash: strconv.FormatUint(page.Fingerprint.SimHash, 16)}
+	}
+	if page.TLS != nil {
+		record.TLS = (*tlsRecord)(page.TLS)
+	}
+	record.Cache = page.Cache.String()
+	for _, change := range page.History {
+		record.History = append(record.History, changeRecord{At: change.At, Change: change.Kind.String()})
+	}
+	record.CrawlError, record.ErrorKind = saveError(page.CrawlError)
+	for _, attempt := range page.Attempts {
+		attemptRecord := attemptRecord{At: attempt.At, DurationMs: attempt.Duration.Milliseconds(), StatusCode: attempt.StatusCode}
+		attemptRecord.Error, attemptRecord.ErrorKind = saveError(attempt.Err)
+		record.Attempts = append(record.Attempts, attemptRecord)
+	}
+	for _, redirect := range page.Redirects {
+		record.Redirects = append(record.Redirects, redirectRecord{StatusCode: redirect.StatusCode, Url: redirect.Url.String()})
+	}
+	return record
+}
+
+func (record pageRecord) page() (*HtmlPage, error) {
+	// page turns an on-disk page back into a HtmlPage, without any links (see newPageRecord).
+	pageUrl, err := url.Parse(record.Url)
+	if err != nil {
+		return nil, err
+	}
+	page := &HtmlPage{
+		Url:         pageUrl,
+		Title:       record.Title,
+		StatusCode:  record.StatusCode,
+		MetaRobots:  record.MetaRobots,
+		Description: record.Description,
+		Canonical:   record.Canonical,
+		DuplicateOf: record.DuplicateOf,
+		Soft404:     record.Soft404,
+		Trap:        record.Trap,
+		IsParsed:    record.IsParsed,
+		FetchedAt:   record.FetchedAt,
+	}
+	if record.Fingerprint != nil {
+		simHash, err := strconv.ParseUint(record.Fingerprint.SimHash, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("page %s has a bad fingerprint: %s", record.Url, err)
+		}
+		page.Fingerprint = Fingerprint{Hash: record.Fingerprint.Hash, SimHash: simHash}
+	}
+	if record.TLS != nil {
+		page.TLS = (*TLSInfo)(record.TLS)
+	}
+	if page.Cache, err = ParseCacheStatus(record.Cache); err != nil {
+		return nil, err
+	}
+	for _, change := range record.History {
+		kind, err := ParseChangeKind(change.Change)
+		if err != nil {
+			return nil, err
+		}
+		page.History = append(page.History, Change{At: change.At, Kind: kind})
+	}
+	page.CrawlError = loadError(record.CrawlError, record.ErrorKind, record.StatusCode)
+	for _, attempt := range record.Attempts {
+		page.Attempts = append(page.Attempts, Attempt{
+			At:         attempt.At,
+			Duration:   time.Duration(attempt.DurationMs) * time.Millisecond,
+			StatusCode: attempt.StatusCode,
+			Err:        loadError(attempt.Error, attempt.ErrorKind, attempt.StatusCode),
+		})
+	}
+	for _, redirect := range record.Redirects {
+		redirectUrl, err := url.Parse(redirect.Url)
+		if err != nil {
+			return nil, err
+		}
+		page.Redirects = append(page.Redirects, Redirect{StatusCode: redirect.StatusCode, Url: redirectUrl})
+	}
+	return page, nil
+}
+
+func LoadCrawl(r io.Reader) (*Crawl, error) {
+	// LoadCrawl reads a crawl written by Crawl.Save back into HtmlPage structures.
+	// Crawl errors come back as *CrawlErrors of the original kind, carrying the original message
+	// (or as plain errors, for crawls saved before errors had kinds).
+
+	var file crawlFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, err
+	}
+
+	if file.Version != crawlFileVersion {
+		return nil, fmt.Errorf("unsupported crawl file version %d (expected %d)", file.Version, crawlFileVersion)
+	}
+	if len(file.Pages) == 0 {
+		return nil, errors.New("crawl file has no pages in it")
+	}
+
+	// create every page first, so that links can point at pages further down the list
+	pages := make([]*HtmlPage, len(file.Pages))
+	for i, record := range file.Pages {
+		page, err := record.page()
+		if err != nil {
+			return nil, err
+		}
+		pages[i] = page
+	}
+
+	for i, record := range file.Pages {
+		for _, link := range record.LinksTo {
+			if link < 0 || link >= len(pages) {
+				return nil, fmt.Errorf("page %s links to page %d, which doesn't exist", record.Url, link)
+			}
+			pages[i].LinksTo = append(pages[i].LinksTo, pages[link])
+		}
+	}
+
+	// depths are saved for the benefit of anything else reading the file, but it's simpler to recompute them
+	// (and DepthParent along with them) than to trust them
+	ComputeDepths(pages[0])
+
+	return &Crawl{
+		Root:       pages[0],
+		Seed:       file.Seed,
+		StartedAt:  file.StartedAt,
+		FinishedAt: file.FinishedAt,
+		Config:     file.Config,
+		Resolve:    file.Resolve,
+	}, nil
+}
+
+func (c *Crawl) SaveFile(path string) error {
+	// SaveFile saves the crawl to the file at path, replacing it if it already exists.
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := c.Save(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func LoadCrawlFile(path string) (*Crawl, error) {
+	// LoadCrawlFile loads a crawl saved with SaveFile.
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return LoadCrawl(file)
+}
+
+func saveError(err error) (message string, kind string) {
+	// saveError splits a crawl error into its message and kind, for saving.
+	if err == nil {
+		return "", ""
+	}
+
+	var crawlError *CrawlError
+	if errors.As(err, &crawlError) {
+		return err.Error(), crawlError.Kind.String()
+	}
+	return err.Error(), ""
+}
+
+func loadError(message string, kind string, statusCode int) error {
+	// loadError puts a saved crawl error back together.
+	if message == "" {
+		return nil
+	}
+
+	errorKind, err := ParseErrorKind(kind)
+	if err != nil {
+		// older crawl files don't record the kind
+		return errors.New(message)
+	}
+
+	crawlError := &CrawlError{Kind: errorKind, Err: errors.New(message)}
+	if errorKind == ErrorHTTP {
+		crawlError.StatusCode = statusCode
+	}
+	return crawlError
+}
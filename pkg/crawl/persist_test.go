@@ -0,0 +1,139 @@
+package crawl
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func genTestCrawl() *Crawl {
+	// root -> a -> b -> root (a loop), with b redirected and a broken link c hanging off a
+	page := func(path string, title string) *HtmlPage {
+		return &HtmlPage{Url: &url.URL{Scheme: "http", Host: "testsite.test", Path: path}, Title: title, StatusCode: 200, IsParsed: true}
+	}
+	root, a, b := page("/", "Root"), page("/a", "A"), page("/b", "B")
+	c := &HtmlPage{Url: &url.URL{Scheme: "http", Host: "testsite.test", Path: "/c"}, CrawlError: errors.New("connection refused")}
+
+	root.LinksTo = []*HtmlPage{a}
+	a.LinksTo = []*HtmlPage{b, c, b}
+	b.LinksTo = []*HtmlPage{root}
+	b.Redirects = []Redirect{{StatusCode: 301, Url: &url.URL{Scheme: "http", Host: "testsite.test", Path: "/b/"}}}
+	b.FetchedAt = time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	a.MetaRobots, a.Description, a.Canonical = "noindex", "All about A", "http://testsite.test/a"
+
+	return &Crawl{
+		Root:       root,
+		Seed:       "testsite.test",
+		StartedAt:  time.Date(2018, 5, 1, 11, 59, 0, 0, time.UTC),
+		FinishedAt: time.Date(2018, 5, 1, 12, 1, 0, 0, time.UTC),
+		Config:     map[string]string{"format": "tree"},
+	}
+}
+
+func TestCrawlSaveLoad(t *testing.T) {
+	original := genTestCrawl()
+
+	var buffer bytes.Buffer
+	if err := original.Save(&buffer); err != nil {
+		t.Fatalf("Crawl.Save returned an error: %s", err)
+	}
+
+	loaded, err := LoadCrawl(&buffer)
+	if err != nil {
+		t.Fatalf("LoadCrawl returned an error: %s", err)
+	}
+
+	if loaded.Seed != original.Seed || !loaded.StartedAt.Equal(original.StartedAt) || !loaded.FinishedAt.Equal(original.FinishedAt) || loaded.Config["format"] != "tree" {
+		t.Errorf("crawl metadata didn't survive a round trip: got %+v", loaded)
+	}
+
+	root := loaded.Root
+	if root.Title != "Root" || len(root.LinksTo) != 1 {
+		t.Fatalf("root page didn't survive a round trip")
+	}
+
+	a := root.LinksTo[0]
+	if a.Title != "A" || len(a.LinksTo) != 3 || a.LinksTo[0] != a.LinksTo[2] {
+		t.Fatalf("page /a (or its duplicate links) didn't survive a round trip")
+	}
+	if a.MetaRobots != "noindex" || a.Description != "All about A" || a.Canonical != "http://testsite.test/a" {
+		t.Errorf("metadata on /a didn't survive a round trip: %q, %q, %q", a.MetaRobots, a.Description, a.Canonical)
+	}
+
+	b, c := a.LinksTo[0], a.LinksTo[1]
+	if len(b.LinksTo) != 1 || b.LinksTo[0] != root {
+		t.Errorf("loop from /b back to root wasn't restored as a pointer to the root")
+	}
+	if len(b.Redirects) != 1 || b.Redirects[0].StatusCode != 301 || b.Redirects[0].Url.Path != "/b/" {
+		t.Errorf("redirects on /b didn't survive a round trip")
+	}
+	if !b.FetchedAt.Equal(original.Root.LinksTo[0].LinksTo[0].FetchedAt) {
+		t.Errorf("fetch time on /b didn't survive a round trip: got %s", b.FetchedAt)
+	}
+	if c.IsParsed || c.CrawlError == nil || c.CrawlError.Error() != "connection refused" {
+		t.Errorf("crawl error on /c didn't survive a round trip")
+	}
+	if b.Depth != 2 || b.DepthParent != a {
+		t.Errorf("depths weren't recomputed on load: /b has depth %d", b.Depth)
+	}
+}
+
+func TestLoadCrawlRejectsBadFiles(t *testing.T) {
+	for name, data := range map[string]string{
+		"not json":      "this isn't json",
+		"wrong version": `{"version": 9999, "pages": [{"url": "http://testsite.test/"}]}`,
+		"no pages":      `{"version": 1, "pages": []}`,
+		"bad link":      `{"version": 1, "pages": [{"url": "http://testsite.test/", "linksTo": [5]}]}`,
+	} {
+		if _, err := LoadCrawl(strings.NewReader(data)); err == nil {
+			t.Errorf("LoadCrawl accepted a bad file (%s)", name)
+		}
+	}
+}
+
+func TestCrawlSaveLoadErrors(t *testing.T) {
+	// error kinds and attempt histories should survive saving and loading
+	original := genTestCrawl()
+	c := original.Root.LinksTo[0].LinksTo[1]
+	timeout := &CrawlError{Kind: ErrorTimeout, Temporary: true, Err: errors.New("timed out reading response body")}
+	c.CrawlError = &CrawlError{Kind: ErrorHTTP, StatusCode: 503, Temporary: true}
+	c.StatusCode = 503
+	c.Attempts = []Attempt{
+		{At: time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC), Duration: 1500 * time.Millisecond, Err: timeout},
+		{At: time.Date(2018, 5, 1, 12, 0, 2, 0, time.UTC), Duration: 20 * time.Millisecond, StatusCode: 503, Err: c.CrawlError},
+	}
+
+	var buffer bytes.Buffer
+	if err := original.Save(&buffer); err != nil {
+		t.Fatalf("Crawl.Save returned an error: %s", err)
+	}
+	loaded, err := LoadCrawl(&buffer)
+	if err != nil {
+		t.Fatalf("LoadCrawl returned an error: %s", err)
+	}
+
+	loadedC := loaded.Root.LinksTo[0].LinksTo[1]
+	var crawlError *CrawlError
+	if !errors.As(loadedC.CrawlError, &crawlError) || crawlError.Kind != ErrorHTTP || crawlError.StatusCode != 503 || crawlError.Error() != "HTTP 503 Service Unavailable" {
+		t.Errorf("crawl error didn't survive a round trip: %#v", loadedC.CrawlError)
+	}
+
+	if len(loadedC.Attempts) != 2 {
+		t.Fatalf("expected 2 attempts after loading, got %d", len(loadedC.Attempts))
+	}
+	first, second := loadedC.Attempts[0], loadedC.Attempts[1]
+	if !first.At.Equal(c.Attempts[0].At) || first.Duration != 1500*time.Millisecond || ErrorKindOf(first.Err) != ErrorTimeout || first.Err.Error() != timeout.Error() {
+		t.Errorf("first attempt didn't survive a round trip: %+v", first)
+	}
+	if second.StatusCode != 503 || ErrorKindOf(second.Err) != ErrorHTTP {
+		t.Errorf("second attempt didn't survive a round trip: %+v", second)
+	}
+
+	// errors from files saved before they had kinds still load, as plain errors
+	if loaded.Root.LinksTo[0].LinksTo[1] == nil || ErrorKindOf(loadError("connection refused", "", 0)) != ErrorOther {
+		t.Error("a crawl error without a kind should load as a plain error")
+	}
+}
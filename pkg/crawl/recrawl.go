@@ -0,0 +1,359 @@
+// recrawl brings a previous crawl up to date without fetching the whole site again. Its pages are revisited in order of
+// how likely they are to have changed (going by how old they are, how often they've changed before, and the site's sitemap),
+// and the rest are kept just as they were. Every page keeps a history of what each visit found.
+
+package crawl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type ChangeKind int
+
+// ChangeKind says what a visit to a page found
+const (
+	// ChangeNew is the page's first visit
+	ChangeNew ChangeKind = iota
+
+	// ChangeModified visits found the page different from last time (a new status, title, robots tag, redirect or set of links)
+	ChangeModified
+
+	// ChangeUnchanged visits found the page just as it was
+	ChangeUnchanged
+)
+
+var changeKindNames = []string{"new", "changed", "unchanged"}
+
+func (k ChangeKind) String() string {
+	if int(k) < len(changeKindNames) {
+		return changeKindNames[k]
+	}
+	return "unknown"
+}
+
+func ParseChangeKind(name string) (ChangeKind, error) {
+	// ParseChangeKind is the reverse of ChangeKind.String().
+	for i, kindName := range changeKindNames {
+		if name == kindName {
+			return ChangeKind(i), nil
+		}
+	}
+	return ChangeNew, errors.New("unknown change kind " + strconv.Quote(name))
+}
+
+type Change struct {
+	// A 'Change' is one visit in a page's History: when it was, and whether the page had changed.
+	At   time.Time
+	Kind ChangeKind
+}
+
+// maxHistory is how many visits a page's History holds on to (the oldest go first)
+const maxHistory = 50
+
+type RecrawlPolicy struct {
+	// RecrawlPolicy decides which pages of a previous crawl get revisited (see Options.Recrawl).
+	// Anything left unset uses DefaultRecrawlPolicy.
+
+	// MinAge is how old a page has to be before it's revisited. Pages fetched more recently are left alone,
+	// unless the sitemap says they've changed since.
+	MinAge time.Duration
+
+	// MaxAge is how old a page can get before it's revisited anyway, however rarely it's changed in the past
+	MaxAge time.Duration
+
+	// MaxPages caps how many of the previous crawl's pages are revisited, most likely to have changed first (0 for no limit).
+	// Pages discovered along the way don't count towards it.
+	MaxPages int
+
+	// NoSitemap stops /sitemap.xml being fetched for its <lastmod> dates
+	NoSitemap bool
+}
+
+// DefaultRecrawlPolicy is what a RecrawlPolicy's unset fields fall back to
+var DefaultRecrawlPolicy = RecrawlPolicy{
+	MinAge: time.Hour,
+	MaxAge: 7 * 24 * time.Hour,
+}
+
+func (p RecrawlPolicy) withDefaults() RecrawlPolicy {
+	// withDefaults fills in any unset fields from DefaultRecrawlPolicy.
+	if p.MinAge <= 0 {
+		p.MinAge = DefaultRecrawlPolicy.MinAge
+	}
+	if p.MaxAge <= 0 {
+		p.MaxAge = DefaultRecrawlPolicy.MaxAge
+	}
+	if p.MaxAge < p.MinAge {
+		p.MaxAge = p.MinAge
+	}
+	return p
+}
+
+func changeInterval(history []Change) (time.Duration, bool) {
+	// changeInterval estimates how often a page changes: the time its history covers, divided by the number of changes seen in it.
+	// A page which has never been seen to change is assumed to change about as rarely as it's been watched for, so each
+	// unchanged visit pushes the next one further out. It returns false if there isn't enough history to go on.
+	if len(history) < 2 {
+		return 0, false
+	}
+
+	span := history[len(history)-1].At.Sub(history[0].At)
+	changes := 0
+	for _, change := range history[1:] {
+		if change.Kind == ChangeModified {
+			changes++
+		}
+	}
+	if changes == 0 {
+		return span, true
+	}
+	return span / time.Duration(changes), true
+}
+
+func (p RecrawlPolicy) urgency(page *HtmlPage, lastModified map[string]time.Time, now time.Time) (float64, bool) {
+	// urgency decides whether a page is due a visit, and if so how urgently (higher first): roughly, how many times over
+	// it's likely to have changed since it was last fetched.
+
+	// quarantined pages stay that way
+	if page.Trap != "" {
+		return 0, false
+	}
+
+	// pages the last crawl never got to (or that the sitemap says have changed) can't wait
+	if !page.isDone() || page.FetchedAt.IsZero() {
+		return math.Inf(1), true
+	}
+	if modified, exists := lastModified[cacheKey(page.Url, "")]; exists && modified.After(page.FetchedAt) {
+		return math.Inf(1), true
+	}
+
+	age := now.Sub(page.FetchedAt)
+	if age < p.MinAge {
+		return 0, false
+	}
+
+	// broken pages are checked as often as we're allowed, in case they've been fixed
+	interval, known := changeInterval(page.History)
+	if !known || page.IsBroken() || interval < p.MinAge {
+		interval = p.MinAge
+	}
+	if interval > p.MaxAge {
+		interval = p.MaxAge
+	}
+	if age < interval {
+		return 0, false
+	}
+	return float64(age) / float64(interval), true
+}
+
+func (p *HtmlPage) signature() string {
+	// signature sums up everything we know about the page, so that two visits to it can be compared.
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d\n%s\n%s\n", p.StatusCode, p.Title, p.MetaRobots)
+	if !p.Fingerprint.IsZero() {
+		// (so a change to the text counts, even when the links and title stay the same)
+		fmt.Fprintf(hash, "# %s\n", p.Fingerprint.Hash)
+	}
+	if p.CrawlError != nil {
+		fmt.Fprintf(hash, "! %s\n", ErrorKindOf(p.CrawlError))
+	}
+	for _, redirect := range p.Redirects {
+		fmt.Fprintf(hash, "→ %d %s\n", redirect.StatusCode, redirect.Url.String())
+	}
+	for _, link := range p.LinksTo {
+		fmt.Fprintf(hash, "%s\n", link.Url.String())
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (p *HtmlPage) recordVisit(change Change) {
+	// recordVisit adds a visit to the page's History, dropping the oldest if it's full.
+	p.History = append(p.History, change)
+	if len(p.History) > maxHistory {
+		p.History = append([]Change(nil), p.History[len(p.History)-maxHistory:]...)
+	}
+}
+
+type recrawlPlan struct {
+	// recrawlPlan is what planRecrawl decided to do with a previous crawl.
+
+	// pages is every page in the previous crawl, as it was before anything was reset
+	pages []*HtmlPage
+
+	// due is the pages to revisit, most urgent first
+	due []*HtmlPage
+
+	// before is what each of the previous crawl's pages looked like, as a signature
+	before map[*HtmlPage]string
+}
+
+func planRecrawl(previous *Crawl, policy RecrawlPolicy, fetcher *Fetcher, now time.Time) *recrawlPlan {
+	// planRecrawl works out which pages of previous to revisit, then resets them ready to be fetched again.
+	// Everything else is left exactly as it was.
+	policy = policy.withDefaults()
+	plan := &recrawlPlan{pages: ComputeDepths(previous.Root), before: map[*HtmlPage]string{}}
+
+	var lastModified map[string]time.Time
+	if !policy.NoSitemap {
+		lastModified = fetchSitemapDates(fetcher, previous.Root.Url)
+	}
+
+	urgencies := map[*HtmlPage]float64{}
+	for _, page := range plan.pages {
+		// pages from crawls made before there was a history have still been visited once
+		if len(page.History) == 0 && !page.FetchedAt.IsZero() {
+			page.History = []Change{{At: page.FetchedAt, Kind: ChangeNew}}
+		}
+		plan.before[page] = page.signature()
+
+		if urgency, due := policy.urgency(page, lastModified, now); due {
+			urgencies[page] = urgency
+			plan.due = append(plan.due, page)
+		}
+	}
+
+	// ties go the way of the shallower page, as that's how a normal crawl would have got to them
+	sort.SliceStable(plan.due, func(i, j int) bool { return urgencies[plan.due[i]] > urgencies[plan.due[j]] })
+	skipped := len(plan.pages) - len(plan.due)
+	if policy.MaxPages > 0 && len(plan.due) > policy.MaxPages {
+		log.Printf("✂️ %d pages are due a visit, but only the %d most likely to have changed will get one", len(plan.due), policy.MaxPages)
+		skipped += len(plan.due) - policy.MaxPages
+		plan.due = plan.due[:policy.MaxPages]
+	}
+
+	for _, page := range plan.due {
+		page.IsParsed, page.CrawlError, page.Attempts, page.LinksTo = false, nil, nil, nil
+		page.DuplicateOf, page.Soft404 = "", ""
+	}
+
+	log.Printf("🔄 recrawling %s: revisiting %d of %d pages, leaving %d as they were", previous.Seed, len(plan.due), len(plan.pages), skipped)
+	return plan
+}
+
+func (plan *recrawlPlan) record(root *HtmlPage) {
+	// record adds a visit to the History of every page fetched by the recrawl, noting whether it had changed.
+	revisited := map[*HtmlPage]bool{}
+	for _, page := range plan.due {
+		revisited[page] = true
+	}
+
+	var changed, unchanged, added int
+	for _, page := range ComputeDepths(root) {
+		before, known := plan.before[page]
+		if !page.isDone() || page.FetchedAt.IsZero() || page.Cache == CacheHit {
+			// (a page which was still fresh in the cache wasn't really visited, so there's nothing new to say about it)
+			continue
+		}
+
+		switch {
+		case !known:
+			page.recordVisit(Change{At: page.FetchedAt, Kind: ChangeNew})
+			added++
+		case !revisited[page]:
+			// left as it was
+		case page.signature() == before:
+			page.recordVisit(Change{At: page.FetchedAt, Kind: ChangeUnchanged})
+			unchanged++
+		default:
+			page.recordVisit(Change{At: page.FetchedAt, Kind: ChangeModified})
+			changed++
+		}
+	}
+	log.Printf("🔄 recrawl finished: %d pages changed, %d unchanged, %d new", changed, unchanged, added)
+}
+
+// maxSitemaps is how many sitemap files (counting those listed in sitemap indexes) are read for a recrawl
+const maxSitemaps = 50
+
+type sitemapFile struct {
+	// sitemapFile is either kind of sitemap: a <urlset> of pages, or a <sitemapindex> of more sitemaps.
+	Urls     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+func fetchSitemapDates(fetcher *Fetcher, root *url.URL) map[string]time.Time {
+	// fetchSitemapDates reads /sitemap.xml on root's site (along with any sitemaps it lists) for the <lastmod> date of each page,
+	// keyed by cacheKey (so that trivially different spellings of a URL still match). A missing or broken sitemap just means no dates.
+	dates := map[string]time.Time{}
+	queue := []*url.URL{root.ResolveReference(&url.URL{Path: "/sitemap.xml"})}
+	seen := map[string]bool{queue[0].String(): true}
+
+	for len(queue) > 0 && len(seen) <= maxSitemaps {
+		sitemapUrl := queue[0]
+		queue = queue[1:]
+
+		sitemap, err := fetchSitemap(fetcher, sitemapUrl)
+		if err != nil {
+			log.Printf("ℹ️ (%s) no sitemap to go on: %s", sitemapUrl.String(), err)
+			continue
+		}
+
+		for _, entry := range sitemap.Urls {
+			pageUrl, err := url.Parse(entry.Loc)
+			if err != nil {
+				continue
+			}
+			if modified, ok := parseLastMod(entry.LastMod); ok {
+				dates[cacheKey(pageUrl, "")] = modified
+			}
+		}
+		for _, entry := range sitemap.Sitemaps {
+			// only sitemaps on the same site; anything else isn't ours to read
+			nestedUrl, err := url.Parse(entry.Loc)
+			if err != nil || nestedUrl.Host != root.Host || seen[nestedUrl.String()] {
+				continue
+			}
+			seen[nestedUrl.String()] = true
+			queue = append(queue, nestedUrl)
+		}
+	}
+
+	log.Printf("🗺️ the sitemap has dates for %d pages", len(dates))
+	return dates
+}
+
+func fetchSitemap(fetcher *Fetcher, sitemapUrl *url.URL) (*sitemapFile, error) {
+	// fetchSitemap fetches and parses a single sitemap file.
+	resp, err := fetcher.fetch(&HtmlPage{Url: sitemapUrl})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+
+	var sitemap sitemapFile
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, fetcher.options.MaxBodyBytes)).Decode(&sitemap); err != nil {
+		return nil, err
+	}
+	return &sitemap, nil
+}
+
+func parseLastMod(value string) (time.Time, bool) {
+	// parseLastMod parses a sitemap <lastmod>, which is a W3C datetime: anything from just a date to a full timestamp.
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04Z07:00", "2006-01-02"} {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed, true
+		}
+	}
+	return time.Time{}, false
+}
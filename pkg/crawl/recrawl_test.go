@@ -0,0 +1,269 @@
+package crawl
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type changingTestSite struct {
+	// changingTestSite is a little site whose links can be changed between crawls, with a sitemap (an index, pointing at
+	// the real one) giving lastmod dates for whichever pages have them.
+	lock    sync.Mutex
+	links   map[string][]string
+	lastMod map[string]time.Time
+	hits    map[string]int
+}
+
+func (s *changingTestSite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.hits[r.URL.Path]++
+
+	switch r.URL.Path {
+	case "/sitemap.xml":
+		fmt.Fprintf(w, `<?xml version="1.0"?><sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"><sitemap><loc>http://%s/pages.xml</loc></sitemap></sitemapindex>`, r.Host)
+		return
+	case "/pages.xml":
+		fmt.Fprint(w, `<?xml version="1.0"?><urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`)
+		for path, modified := range s.lastMod {
+			fmt.Fprintf(w, "<url><loc>http://%s%s</loc><lastmod>%s</lastmod></url>", r.Host, path, modified.Format(time.RFC3339))
+		}
+		fmt.Fprint(w, "</urlset>")
+		return
+	}
+
+	pageLinks, exists := s.links[r.URL.Path]
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	fmt.Fprintf(w, "<html><head><title>Page %s</title></head><body>", r.URL.Path)
+	for _, link := range pageLinks {
+		fmt.Fprintf(w, `<a href="%s">%s</a>`, link, link)
+	}
+	fmt.Fprint(w, "</body></html>")
+}
+
+func (s *changingTestSite) takeHits() string {
+	// takeHits lists the pages fetched since it was last called, sorted (ignoring the sitemaps).
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var paths []string
+	for path := range s.hits {
+		if !strings.HasSuffix(path, ".xml") {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+	s.hits = map[string]int{}
+	return strings.Join(paths, " ")
+}
+
+func describeHistory(page *HtmlPage) string {
+	var kinds []string
+	for _, change := range page.History {
+		kinds = append(kinds, change.Kind.String())
+	}
+	return strings.Join(kinds, ", ")
+}
+
+func TestRecrawl(t *testing.T) {
+	site := &changingTestSite{
+		links: map[string][]string{"/": {"/a", "/b"}, "/a": {"/c"}, "/b": {}, "/c": {}},
+		hits:  map[string]int{},
+	}
+	server := httptest.NewServer(site)
+	defer server.Close()
+	rootUrl, _ := url.Parse(server.URL + "/")
+
+	previous := &Crawl{Root: Walk(rootUrl, Options{}), Seed: rootUrl.String()}
+	site.takeHits()
+
+	// pretend the crawl was a couple of hours ago, apart from /c, which was only just fetched
+	now := time.Now()
+	pages := map[string]*HtmlPage{}
+	for _, page := range ComputeDepths(previous.Root) {
+		pages[page.Url.Path] = page
+		page.FetchedAt = now.Add(-2 * time.Hour)
+	}
+	pages["/c"].FetchedAt = now.Add(-10 * time.Minute)
+
+	// since then, /b has started linking to a new page
+	site.lock.Lock()
+	site.links["/b"] = []string{"/d"}
+	site.links["/d"] = []string{}
+	site.lock.Unlock()
+
+	root := Walk(rootUrl, Options{Recrawl: previous, RecrawlPolicy: RecrawlPolicy{MinAge: time.Hour}})
+	if root != previous.Root {
+		t.Error("recrawl should update the previous crawl's graph, not start a new one")
+	}
+	if hits := site.takeHits(); hits != "/ /a /b /d" {
+		t.Errorf("expected the stale pages and the new one to be fetched, got %s", hits)
+	}
+
+	expected := map[string]string{
+		"/":  "new, unchanged",
+		"/a": "new, unchanged",
+		"/b": "new, changed",
+		"/c": "new",
+		"/d": "new",
+	}
+	results := map[string]*HtmlPage{}
+	for _, page := range ComputeDepths(root) {
+		results[page.Url.Path] = page
+		if history := describeHistory(page); history != expected[page.Url.Path] {
+			t.Errorf("%s has history %q, expected %q", page.Url.Path, history, expected[page.Url.Path])
+		}
+	}
+	if len(results) != len(expected) {
+		t.Errorf("expected %d pages after the recrawl, got %d", len(expected), len(results))
+	}
+	if results["/c"] != pages["/c"] || !results["/c"].IsParsed || results["/c"].Title != "Page /c" {
+		t.Error("/c should have been left exactly as it was")
+	}
+
+	// the history is saved along with the crawl
+	var saved bytes.Buffer
+	if err := (&Crawl{Root: root, Seed: rootUrl.String()}).Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCrawl(&saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, page := range ComputeDepths(loaded.Root) {
+		if history := describeHistory(page); history != expected[page.Url.Path] {
+			t.Errorf("%s came back with history %q, expected %q", page.Url.Path, history, expected[page.Url.Path])
+		}
+	}
+}
+
+func TestRecrawlPriority(t *testing.T) {
+	site := &changingTestSite{
+		links:   map[string][]string{"/": {"/a", "/b", "/c"}, "/a": {}, "/b": {}, "/c": {}},
+		lastMod: map[string]time.Time{},
+		hits:    map[string]int{},
+	}
+	server := httptest.NewServer(site)
+	defer server.Close()
+	rootUrl, _ := url.Parse(server.URL + "/")
+
+	now := time.Now()
+	fetcher := NewFetcher(FetchOptions{})
+	defer fetcher.Close()
+
+	crawlAndAge := func() (*Crawl, map[string]*HtmlPage) {
+		// crawlAndAge crawls the site, then rewrites its history (planning a recrawl resets pages, so each plan needs a fresh crawl)
+		previous := &Crawl{Root: Walk(rootUrl, Options{}), Seed: rootUrl.String()}
+		pages := map[string]*HtmlPage{}
+		for _, page := range ComputeDepths(previous.Root) {
+			pages[page.Url.Path] = page
+		}
+
+		// the root has no history to go on, and was fetched 90 minutes ago
+		pages["/"].FetchedAt = now.Add(-90 * time.Minute)
+
+		// /a changes about once an hour, and was fetched 2 hours ago
+		pages["/a"].FetchedAt = now.Add(-2 * time.Hour)
+		pages["/a"].History = []Change{
+			{At: now.Add(-6 * time.Hour), Kind: ChangeNew},
+			{At: now.Add(-5 * time.Hour), Kind: ChangeModified},
+			{At: now.Add(-4 * time.Hour), Kind: ChangeModified},
+			{At: now.Add(-3 * time.Hour), Kind: ChangeModified},
+			{At: now.Add(-2 * time.Hour), Kind: ChangeModified},
+		}
+
+		// /b hasn't changed in a month
+		pages["/b"].FetchedAt = now.Add(-2 * time.Hour)
+		pages["/b"].History = []Change{
+			{At: now.Add(-30 * 24 * time.Hour), Kind: ChangeNew},
+			{At: now.Add(-2 * time.Hour), Kind: ChangeUnchanged},
+		}
+
+		// /c was only just fetched, but the sitemap says it's changed since
+		pages["/c"].FetchedAt = now.Add(-10 * time.Minute)
+		return previous, pages
+	}
+	site.lock.Lock()
+	site.lastMod["/c"] = now.Add(-time.Minute)
+	site.lock.Unlock()
+
+	previous, pages := crawlAndAge()
+	plan := planRecrawl(previous, RecrawlPolicy{MinAge: time.Hour}, fetcher, now)
+	var due []string
+	for _, page := range plan.due {
+		due = append(due, page.Url.Path)
+	}
+	if strings.Join(due, " ") != "/c /a /" {
+		t.Errorf("expected /c, /a and / to be due in that order, got %v", due)
+	}
+	if !pages["/b"].IsParsed || pages["/a"].IsParsed || len(pages["/a"].LinksTo) != 0 {
+		t.Error("only the pages due a visit should have been reset")
+	}
+
+	// with a budget, the least urgent pages miss out
+	previous, pages = crawlAndAge()
+	plan = planRecrawl(previous, RecrawlPolicy{MinAge: time.Hour, MaxPages: 2}, fetcher, now)
+	if len(plan.due) != 2 || plan.due[0] != pages["/c"] || plan.due[1] != pages["/a"] || !pages["/"].IsParsed {
+		t.Errorf("expected only /c and /a to be due with a budget of 2, got %d pages", len(plan.due))
+	}
+
+	// and without the sitemap, /c is left alone
+	previous, pages = crawlAndAge()
+	plan = planRecrawl(previous, RecrawlPolicy{MinAge: time.Hour, NoSitemap: true}, fetcher, now)
+	for _, page := range plan.due {
+		if page == pages["/c"] {
+			t.Error("/c shouldn't be due without the sitemap")
+		}
+	}
+}
+
+func TestChangeInterval(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	visits := func(kinds ...ChangeKind) []Change {
+		// one visit a day
+		var history []Change
+		for i, kind := range kinds {
+			history = append(history, Change{At: start.Add(time.Duration(i) * 24 * time.Hour), Kind: kind})
+		}
+		return history
+	}
+
+	for _, c := range []struct {
+		history  []Change
+		interval time.Duration
+		known    bool
+	}{
+		{nil, 0, false},
+		{visits(ChangeNew), 0, false},
+		{visits(ChangeNew, ChangeUnchanged, ChangeUnchanged), 48 * time.Hour, true},
+		{visits(ChangeNew, ChangeModified, ChangeModified), 24 * time.Hour, true},
+		{visits(ChangeNew, ChangeModified, ChangeUnchanged, ChangeUnchanged, ChangeModified), 48 * time.Hour, true},
+	} {
+		interval, known := changeInterval(c.history)
+		if interval != c.interval || known != c.known {
+			t.Errorf("%s: expected %s (%t), got %s (%t)", describeHistory(&HtmlPage{History: c.history}), c.interval, c.known, interval, known)
+		}
+	}
+
+	for value, expected := range map[string]time.Time{
+		"2020-01-02":                start.Add(24 * time.Hour),
+		"2020-01-01T06:30Z":         start.Add(6*time.Hour + 30*time.Minute),
+		"2020-01-01T12:00:00+01:00": start.Add(11 * time.Hour),
+	} {
+		if parsed, ok := parseLastMod(value); !ok || !parsed.Equal(expected) {
+			t.Errorf("lastmod %s parsed as %s (%t)", value, parsed, ok)
+		}
+	}
+	if _, ok := parseLastMod("last tuesday"); ok {
+		t.Error("nonsense lastmod shouldn't parse")
+	}
+}
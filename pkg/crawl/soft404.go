@@ -0,0 +1,219 @@
+// soft404 spots soft 404s: pages which are served with a success status, but are really the site's "page not found" page
+// (plenty of CMSs do this for missing pages). A status check can't catch those, so we learn what each host's error page looks like
+// by asking it for a URL which can't possibly exist, and compare pages against that, as well as against some tell-tale titles.
+
+package crawl
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Soft404Options struct {
+	// Soft404Options tune soft 404 detection. Anything left unset uses DefaultSoft404Options.
+
+	// Disabled turns soft 404 detection off altogether
+	Disabled bool
+
+	// Probe, if set, fetches a made up URL from each host to learn what its error page looks like, and flags pages which look
+	// the same. It's off by default (here and on the command line, where it's -soft404-probe), as it's an extra request
+	// (for a page which doesn't exist) the site didn't ask for; without it, only TitlePatterns are checked.
+	Probe bool
+
+	// TitlePatterns are matched against the titles of pages served with a success status:
+	// any page whose title matches one of them is a soft 404
+	TitlePatterns []*regexp.Regexp
+
+	// MaxDistance is how many bits a page's SimHash can differ from the error page's by (see Fingerprint)
+	// for it to count as the same page
+	MaxDistance int
+}
+
+// DefaultSoft404Options is what a Soft404Options' unset fields fall back to.
+// The title patterns are phrases rather than words, as a bare "404" turns up in plenty of real titles (Route 404, 404 Days in Tokyo...).
+var DefaultSoft404Options = Soft404Options{
+	TitlePatterns: []*regexp.Regexp{
+		regexp.MustCompile(`(?i)\bnot found\b`),
+		regexp.MustCompile(`(?i)\b(error|http) 404\b`),
+		regexp.MustCompile(`(?i)\b404 (error|page)\b`),
+		regexp.MustCompile(`(?i)\b(does ?n[o']t|no longer) exists?\b`),
+		regexp.MustCompile(`(?i)\bno longer available\b`),
+	},
+	MaxDistance: DefaultNearDuplicateDistance,
+}
+
+func (o Soft404Options) withDefaults() Soft404Options {
+	// withDefaults fills in any unset fields from DefaultSoft404Options.
+	if len(o.TitlePatterns) == 0 {
+		o.TitlePatterns = DefaultSoft404Options.TitlePatterns
+	}
+	if o.MaxDistance <= 0 {
+		o.MaxDistance = DefaultSoft404Options.MaxDistance
+	}
+	return o
+}
+
+func ParseTitlePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	// ParseTitlePatterns compiles a list of soft 404 title patterns (regular expressions, matched without regard to case).
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		expression, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bad soft 404 title pattern %q: %s", pattern, err)
+		}
+		compiled = append(compiled, expression)
+	}
+	return compiled, nil
+}
+
+type errorPage struct {
+	// errorPage is what a host sent back for a URL which doesn't exist.
+
+	// url is the made up URL that was asked for
+	url string
+
+	// soft is set if the host served it with a success status (otherwise it does proper 404s, and there's nothing to compare against)
+	soft bool
+
+	title       string
+	fingerprint Fingerprint
+
+	// ambiguous is set if the first real page checked on the host looked just like the error page, which means the host
+	// serves the same page whatever the URL (a single page app, most likely), so pages can't be told apart from it by their content
+	ambiguous bool
+}
+
+type soft404Detector struct {
+	// soft404Detector checks pages for soft 404s as they're crawled. It's safe for concurrent use.
+	// A nil detector (detection is disabled) never finds any.
+	options Soft404Options
+
+	lock sync.Mutex
+
+	// hosts holds each host's error page; the per host lock is held while it's being fetched, and while the first page is compared
+	hosts map[string]*hostErrorPage
+}
+
+type hostErrorPage struct {
+	lock    sync.Mutex
+	page    *errorPage
+	checked bool
+}
+
+func newSoft404Detector(options Soft404Options) *soft404Detector {
+	if options.Disabled {
+		return nil
+	}
+	return &soft404Detector{options: options.withDefaults(), hosts: map[string]*hostErrorPage{}}
+}
+
+func (d *soft404Detector) check(c *crawler, p *HtmlPage) string {
+	// check returns why p looks like a soft 404, or "" if it doesn't. Only parsed pages with a success status are checked.
+	// When probing, the first page checked on each host fetches the host's error page first. The caller must hold p's ParseLock.
+	if d == nil || !p.IsParsed || p.StatusCode < 200 || p.StatusCode >= 300 {
+		return ""
+	}
+
+	for _, pattern := range d.options.TitlePatterns {
+		if pattern.MatchString(p.Title) {
+			return fmt.Sprintf("title %q matches /%s/", p.Title, strings.TrimPrefix(pattern.String(), "(?i)"))
+		}
+	}
+
+	if !d.options.Probe {
+		return ""
+	}
+
+	d.lock.Lock()
+	host, exists := d.hosts[p.Url.Host]
+	if !exists {
+		host = &hostErrorPage{}
+		d.hosts[p.Url.Host] = host
+	}
+	d.lock.Unlock()
+
+	host.lock.Lock()
+	defer host.lock.Unlock()
+	first := !host.checked
+	if first {
+		host.checked = true
+		host.page = fetchErrorPage(c, p.Url)
+	}
+
+	errorPage := host.page
+	if errorPage == nil || !errorPage.soft || errorPage.ambiguous || !d.looksLike(p, errorPage) {
+		return ""
+	}
+	if first {
+		log.Printf("⚠️ (%s) looks just like what %s serves for missing pages, so soft 404s can only be spotted by their titles there", p.Url.String(), p.Url.Host)
+		errorPage.ambiguous = true
+		return ""
+	}
+	return fmt.Sprintf("looks like the page served for missing URLs (such as %s)", errorPage.url)
+}
+
+func (d *soft404Detector) looksLike(p *HtmlPage, errorPage *errorPage) bool {
+	// looksLike returns true if the page is (near enough) the same as the error page.
+	if p.Fingerprint.IsZero() || errorPage.fingerprint.IsZero() {
+		// without any text to go on, all we can compare is the title
+		return p.Fingerprint.IsZero() && errorPage.fingerprint.IsZero() && p.Title != "" && p.Title == errorPage.title
+	}
+	return p.Fingerprint.Distance(errorPage.fingerprint) <= d.options.MaxDistance
+}
+
+func fetchErrorPage(c *crawler, target *url.URL) *errorPage {
+	// fetchErrorPage asks target's host for a made up URL, and returns what it served (or nil if fetching it failed).
+	random := make([]byte, 8)
+	rand.Read(random)
+	probeUrl := &url.URL{Scheme: target.Scheme, Host: target.Host, Path: "/" + hex.EncodeToString(random) + "-creepycrawler-404-check"}
+	probe := &HtmlPage{Url: probeUrl}
+
+	resp, err := c.fetcher.fetch(probe)
+	if err != nil {
+		log.Printf("⚠️ unable to fetch %s to learn what %s's error page looks like, so soft 404s can only be spotted by their titles there: %s", probeUrl.String(), target.Host, err)
+		return nil
+	}
+	defer resp.Body.Close()
+
+	result := &errorPage{url: probeUrl.String(), soft: resp.StatusCode >= 200 && resp.StatusCode < 300}
+	if !result.soft {
+		log.Printf("👻 %s served %s with a %d, so it does proper 404s", target.Host, probeUrl.String(), resp.StatusCode)
+		return result
+	}
+
+	info, err := extractTokens(resp.Body, c.fetcher.options.MaxBodyBytes)
+	if err != nil {
+		log.Printf("⚠️ unable to parse %s's error page %s: %s", target.Host, probeUrl.String(), err)
+		return nil
+	}
+	result.title, result.fingerprint = info.title, newFingerprint(info.text)
+	log.Printf("👻 %s served %s with a %d (title='%s'), so it does soft 404s; pages like it will be flagged", target.Host, probeUrl.String(), resp.StatusCode, result.title)
+	return result
+}
+
+func Soft404s(root *HtmlPage) []*HtmlPage {
+	// Soft404s lists every page in the crawl rooted at root which looks like a soft 404, sorted by URL.
+	var soft404s []*HtmlPage
+	for _, page := range Pages(root) {
+		if page.Soft404 != "" {
+			soft404s = append(soft404s, page)
+		}
+	}
+	sort.Slice(soft404s, func(i, j int) bool { return soft404s[i].Url.String() < soft404s[j].Url.String() })
+	return soft404s
+}
+
+func (c *crawler) flagIfSoft404(p *HtmlPage) {
+	// flagIfSoft404 sets the page's Soft404 if it looks like one. The caller must hold p's ParseLock.
+	if reason := c.soft404s.check(c, p); reason != "" {
+		log.Printf("👻 (%s) looks like a soft 404: %s", p.Url.String(), reason)
+		p.Soft404 = reason
+	}
+}
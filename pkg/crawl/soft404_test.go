@@ -0,0 +1,142 @@
+package crawl
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSoft404Titles(t *testing.T) {
+	detector := newSoft404Detector(Soft404Options{})
+	for title, soft404 := range map[string]bool{
+		"Welcome to Acme":               false,
+		"Page Not Found | Acme":         true,
+		"404 Not Found":                 true,
+		"Error 404":                     true,
+		"HTTP 404 - Acme":               true,
+		"404 error | Acme":              true,
+		"404 Page":                      true,
+		"404 - Acme":                    false,
+		"Route 404 timetable":           false,
+		"404 Days in Tokyo":             false,
+		"This page doesn't exist":       true,
+		"That product no longer exists": true,
+		"Notfound Records":              false,
+		"Room 4040":                     false,
+		"Offer no longer available":     true,
+	} {
+		pageUrl, _ := url.Parse("http://testsite.test/")
+		page := &HtmlPage{Url: pageUrl, Title: title, IsParsed: true, StatusCode: 200}
+		if reason := detector.check(nil, page); (reason != "") != soft404 {
+			t.Errorf("%q: expected soft 404 to be %t, got %q", title, soft404, reason)
+		}
+	}
+
+	// only pages served with a success status are checked; a real 404 is already broken
+	pageUrl, _ := url.Parse("http://testsite.test/")
+	if reason := detector.check(nil, &HtmlPage{Url: pageUrl, Title: "404", IsParsed: true, StatusCode: 404}); reason != "" {
+		t.Errorf("a page served with a 404 shouldn't be a soft 404 as well: %q", reason)
+	}
+
+	// custom patterns replace the default ones
+	patterns, err := ParseTitlePatterns([]string{"^oops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom := newSoft404Detector(Soft404Options{TitlePatterns: patterns})
+	if custom.check(nil, &HtmlPage{Url: pageUrl, Title: "OOPS! Nothing here", IsParsed: true, StatusCode: 200}) == "" {
+		t.Error("custom title patterns should match without regard to case")
+	}
+	if reason := custom.check(nil, &HtmlPage{Url: pageUrl, Title: "404", IsParsed: true, StatusCode: 200}); reason != "" {
+		t.Errorf("custom title patterns should replace the defaults: %q", reason)
+	}
+	if _, err := ParseTitlePatterns([]string{"("}); err == nil {
+		t.Error("ParseTitlePatterns accepted a bad pattern")
+	}
+
+	if newSoft404Detector(Soft404Options{Disabled: true}).check(nil, &HtmlPage{Url: pageUrl, Title: "404", IsParsed: true, StatusCode: 200}) != "" {
+		t.Error("disabled soft 404 detection still found one")
+	}
+}
+
+func TestCrawlFindsSoft404s(t *testing.T) {
+	// a CMS which serves its "not found" template with a 200 for anything it doesn't know about
+	var lock sync.Mutex
+	probes := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><head><title>Acme</title></head><body><nav><a href="/">Home</a></nav>`+
+				`<p>Welcome to Acme, makers of fine anvils since 1949.</p><a href="/anvils">Anvils</a> <a href="/old-product">Old</a> <a href="/gone">Gone</a></body></html>`)
+		case "/anvils":
+			fmt.Fprint(w, `<html><head><title>Acme</title></head><body><nav><a href="/">Home</a></nav>`+
+				`<p>Our anvils come in three sizes: large, larger and falling from a great height.</p></body></html>`)
+		case "/gone":
+			fmt.Fprint(w, `<html><head><title>Page Not Found | Acme</title></head><body><p>It's gone.</p></body></html>`)
+		default:
+			if strings.HasSuffix(r.URL.Path, "-404-check") {
+				lock.Lock()
+				probes++
+				lock.Unlock()
+			}
+			fmt.Fprint(w, `<html><head><title>Acme</title></head><body><nav><a href="/">Home</a></nav>`+
+				`<p>Sorry, we couldn't find the page you were looking for. Why not try searching for it, or head back to the home page?</p></body></html>`)
+		}
+	}))
+	defer server.Close()
+	rootUrl, _ := url.Parse(server.URL + "/")
+
+	root := Walk(rootUrl, Options{Soft404: Soft404Options{Probe: true}})
+
+	soft404s := Soft404s(root)
+	if len(soft404s) != 2 {
+		t.Fatalf("expected 2 soft 404s, got %d", len(soft404s))
+	}
+	if soft404s[0].Url.Path != "/gone" || !strings.Contains(soft404s[0].Soft404, "title") {
+		t.Errorf("expected /gone to be caught by its title, got %s (%s)", soft404s[0].Url.String(), soft404s[0].Soft404)
+	}
+	if soft404s[1].Url.Path != "/old-product" || !strings.Contains(soft404s[1].Soft404, "missing URLs") {
+		t.Errorf("expected /old-product to be caught by its content, got %s (%s)", soft404s[1].Url.String(), soft404s[1].Soft404)
+	}
+	if !soft404s[0].IsBroken() || root.IsBroken() {
+		t.Error("soft 404s (and only soft 404s) should count as broken")
+	}
+	lock.Lock()
+	if probes != 1 {
+		t.Errorf("expected the error page to be fetched once, got %d", probes)
+	}
+	lock.Unlock()
+
+	// they're saved as such
+	var saved bytes.Buffer
+	if err := (&Crawl{Root: root, Seed: rootUrl.String()}).Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCrawl(&saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loadedSoft404s := Soft404s(loaded.Root); len(loadedSoft404s) != 2 || loadedSoft404s[1].Soft404 != soft404s[1].Soft404 {
+		t.Error("soft 404s didn't survive saving")
+	}
+}
+
+func TestCrawlSoft404SinglePageApp(t *testing.T) {
+	// a single page app serves the same page for every URL, so content can't tell its missing pages from the rest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head><title>App</title></head><body><p>Loading the app, please wait a moment...</p>`+
+			`<a href="/one">One</a><a href="/two">Two</a></body></html>`)
+	}))
+	defer server.Close()
+	rootUrl, _ := url.Parse(server.URL + "/")
+
+	root := Walk(rootUrl, Options{Soft404: Soft404Options{Probe: true}})
+	if soft404s := Soft404s(root); len(soft404s) != 0 {
+		t.Errorf("expected no soft 404s on a single page app, got %d (the first is %s)", len(soft404s), soft404s[0].Url.String())
+	}
+}
@@ -0,0 +1,160 @@
+// texts keeps the pages' main text out of the crawl graph. Holding on to every page's text would make the graph (and every
+// checkpoint and saved crawl) many times bigger, and the only thing which needs it is the search index, so instead each page's
+// text is handed to a TextSink as the page is parsed, and the graph only keeps its Fingerprint.
+// TextFile is the usual sink: a file of JSON lines, one per page, saved alongside the crawl.
+
+package crawl
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type TextSink interface {
+	// A 'TextSink' is handed the main text of every page the crawl parses (see Options.Texts). It's called by every worker at once,
+	// so it must be safe for concurrent use. A page which is fetched more than once (say, by a resumed crawl) is handed over each time.
+	PageText(page *HtmlPage, text string)
+}
+
+type pageText struct {
+	// pageText is a line of a text file.
+	Url  string `json:"url"`
+	Text string `json:"text"`
+}
+
+type TextFile struct {
+	// A 'TextFile' is a TextSink writing each page's text to a file, as a line of JSON. Lines are written as they come in
+	// (rather than buffered), so that a crawl which dies partway through still leaves the texts it got behind, for -resume.
+	lock    sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+	err     error
+}
+
+func CreateTextFile(path string) (*TextFile, error) {
+	// CreateTextFile starts a new text file at path, replacing any that's already there.
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &TextFile{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func AppendTextFile(path string) (*TextFile, error) {
+	// AppendTextFile carries on writing the text file at path (creating it if need be). Where a page turns up more than once,
+	// the last of its lines is the one that counts, so a resumed crawl or recrawl can simply add to the file it had before.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &TextFile{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (t *TextFile) PageText(page *HtmlPage, text string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// (there's nowhere to report an error from here, so the first one is kept for Close)
+	if t.err == nil {
+		t.err = t.encoder.Encode(pageText{Url: page.Url.String(), Text: text})
+	}
+}
+
+func (t *TextFile) Close() error {
+	// Close closes the file, returning the first error there was writing to it, if there was one.
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if err := t.file.Close(); t.err == nil {
+		t.err = err
+	}
+	return t.err
+}
+
+func ReadTexts(r io.ReadSeeker, fn func(pageUrl string, text string) error) error {
+	// ReadTexts calls fn with the text of each page in a text file, in the order they were written, stopping at the first error fn returns.
+	// A page with more than one line in the file is only passed to fn once, with its last text.
+	// The file is read twice (the first time just to find each page's last line), so only its URLs are ever held in memory.
+	last := map[string]int{}
+	decoder := json.NewDecoder(r)
+	for line := 0; ; line++ {
+		var entry pageText
+		if err := decoder.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		last[entry.Url] = line
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	decoder = json.NewDecoder(r)
+	for line := 0; ; line++ {
+		var entry pageText
+		if err := decoder.Decode(&entry); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if last[entry.Url] != line {
+			continue
+		}
+		if err := fn(entry.Url, entry.Text); err != nil {
+			return err
+		}
+	}
+}
+
+func ReadTextFile(path string, fn func(pageUrl string, text string) error) error {
+	// ReadTextFile is ReadTexts for the file at path.
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return ReadTexts(file, fn)
+}
+
+func CompactTextFile(path string, keep func(pageUrl string) bool) error {
+	// CompactTextFile rewrites the text file at path with only the last text of each page, and only of the pages keep returns true for
+	// (say, the ones still in the crawl), so that a file which is appended to crawl after crawl doesn't grow forever.
+	compacted, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	// (CreateTemp makes the file private to us, but it's replacing one which wasn't)
+	err = compacted.Chmod(0644)
+	encoder := json.NewEncoder(compacted)
+	if err == nil {
+		err = ReadTextFile(path, func(pageUrl string, text string) error {
+			if !keep(pageUrl) {
+				return nil
+			}
+			return encoder.Encode(pageText{Url: pageUrl, Text: text})
+		})
+	}
+	if closeErr := compacted.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(compacted.Name(), path)
+	}
+	if err != nil {
+		os.Remove(compacted.Name())
+	}
+	return err
+}
+
+func TextPath(crawlPath string) string {
+	// TextPath is where the page texts of the crawl saved at crawlPath go: alongside it, with .text before its extension
+	// (so crawl.json's texts are in crawl.text.json).
+	extension := filepath.Ext(crawlPath)
+	return strings.TrimSuffix(crawlPath, extension) + ".text" + extension
+}
@@ -0,0 +1,136 @@
+package crawl
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type textCollector struct {
+	// textCollector is a TextSink which keeps every page's text in a map, keyed by path.
+	lock  sync.Mutex
+	texts map[string]string
+}
+
+func (c *textCollector) PageText(page *HtmlPage, text string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.texts[page.Url.Path] = text
+}
+
+func readTestTexts(t *testing.T, path string) map[string]string {
+	texts := map[string]string{}
+	err := ReadTextFile(path, func(pageUrl string, text string) error {
+		if _, exists := texts[pageUrl]; exists {
+			t.Errorf("ReadTextFile passed %s on more than once", pageUrl)
+		}
+		texts[pageUrl] = text
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return texts
+}
+
+func TestTextFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crawl.text.json")
+	page := func(path string) *HtmlPage {
+		return &HtmlPage{Url: &url.URL{Scheme: "https", Host: "testsite.test", Path: path}}
+	}
+
+	texts, err := CreateTextFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts.PageText(page("/"), "Welcome")
+	texts.PageText(page("/a"), "First go")
+	if err := texts.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// carrying on with the file (as a resumed crawl would), the last text for a page is the one that counts
+	texts, err = AppendTextFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	texts.PageText(page("/a"), "Second go")
+	texts.PageText(page("/b"), "Line one\nline two")
+	if err := texts.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"https://testsite.test/": "Welcome", "https://testsite.test/a": "Second go", "https://testsite.test/b": "Line one\nline two"}
+	actual := readTestTexts(t, path)
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d texts, got %v", len(expected), actual)
+	}
+	for pageUrl, text := range expected {
+		if actual[pageUrl] != text {
+			t.Errorf("%s: expected %q, got %q", pageUrl, text, actual[pageUrl])
+		}
+	}
+
+	// an error from the callback stops the read
+	stop := errors.New("stop")
+	seen := 0
+	if err := ReadTextFile(path, func(string, string) error { seen++; return stop }); err != stop || seen != 1 {
+		t.Errorf("expected ReadTextFile to stop at the first error, got %v after %d texts", err, seen)
+	}
+
+	// compacting drops the old lines, and any pages which aren't wanted
+	if err := CompactTextFile(path, func(pageUrl string) bool { return !strings.HasSuffix(pageUrl, "/b") }); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 2 || strings.Contains(string(data), "First go") {
+		t.Errorf("expected the compacted file to have just 2 lines, got:\n%s", data)
+	}
+	if compacted := readTestTexts(t, path); compacted["https://testsite.test/a"] != "Second go" || len(compacted) != 2 {
+		t.Errorf("compacting lost the wrong texts: %v", compacted)
+	}
+	if leftovers, _ := filepath.Glob(path + ".*.tmp"); len(leftovers) != 0 {
+		t.Errorf("compacting left temporary files behind: %v", leftovers)
+	}
+
+	if err := ReadTextFile(filepath.Join(t.TempDir(), "missing.json"), func(string, string) error { return nil }); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a missing text file to be reported as such, got %v", err)
+	}
+}
+
+func TestCrawlTexts(t *testing.T) {
+	// every page parsed, whether fetched or reused from the cache, has its text handed over
+	site := &cachingTestSite{versions: map[string]int{}}
+	server := httptest.NewServer(site.handler())
+	defer server.Close()
+	rootUrl, _ := url.Parse(server.URL + "/")
+	cache, err := OpenHTTPCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, crawl := range []string{"first", "revalidated"} {
+		texts := &textCollector{texts: map[string]string{}}
+		Walk(rootUrl, Options{Cache: cache, Texts: texts})
+		if len(texts.texts) != 6 {
+			t.Errorf("%s crawl: expected the texts of 6 pages, got %d: %v", crawl, len(texts.texts), texts.texts)
+		}
+	}
+}
+
+func TestTextPath(t *testing.T) {
+	for crawlPath, expected := range map[string]string{
+		"crawl.json":           "crawl.text.json",
+		"/tmp/site/crawl.json": "/tmp/site/crawl.text.json",
+		"crawl":                "crawl.text",
+	} {
+		if actual := TextPath(crawlPath); actual != expected {
+			t.Errorf("TextPath(%q): expected %q, got %q", crawlPath, expected, actual)
+		}
+	}
+}
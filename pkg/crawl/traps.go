@@ -0,0 +1,198 @@
+// traps spots crawler traps: URLs which a site can generate without end (a calendar's "next month" link, a session ID
+// stuck into every URL, relative links which keep nesting deeper), any one of which would keep a crawl going forever.
+// Links which look like a trap are quarantined: they stay in the graph, so that they can be reported, but are never fetched.
+
+package crawl
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type TrapOptions struct {
+	// TrapOptions tune the crawler trap heuristics. Anything left unset uses DefaultTrapOptions.
+
+	// Disabled turns trap detection off altogether, so every link is followed
+	Disabled bool
+
+	// MaxUrlLength is how long a URL can get before it's suspected of being generated
+	MaxUrlLength int
+
+	// MaxSegmentRepeats is how many times the same path segment can appear in a URL (e.g /a/b/a/b/a/b has "a" three times).
+	// Plenty of real sites repeat a segment once or twice (/docs/v1/api/v1, /en/blog/en), so only runaway nesting should go over it.
+	MaxSegmentRepeats int
+
+	// MaxQueryVariants is how many different query strings a single path can have. Sortable, filterable listings and
+	// search pages can legitimately have a lot, so it's there to stop the endless ones rather than the big ones.
+	MaxQueryVariants int
+
+	// MaxPagesPerPattern is how many pages can share a URL pattern, which is the URL with numbers, dates and IDs in its path
+	// wildcarded (so /calendar/2024/05/01 and /calendar/2031/12/25 are both /calendar/*/*/*)
+	MaxPagesPerPattern int
+
+	// SessionParams are query (or ;path) parameter names which carry a session ID, so that every visit looks like a different URL.
+	// They're matched without regard to case.
+	SessionParams []string
+}
+
+// DefaultTrapOptions is what a TrapOptions' unset fields fall back to
+var DefaultTrapOptions = TrapOptions{
+	MaxUrlLength:       2048,
+	MaxSegmentRepeats:  4,
+	MaxQueryVariants:   1000,
+	MaxPagesPerPattern: 1000,
+	SessionParams:      []string{"jsessionid", "phpsessid", "aspsessionid", "sessionid", "session_id", "sid", "cfid", "cftoken", "zenid", "oscsid"},
+}
+
+func (o TrapOptions) withDefaults() TrapOptions {
+	// withDefaults fills in any unset fields from DefaultTrapOptions.
+	if o.MaxUrlLength <= 0 {
+		o.MaxUrlLength = DefaultTrapOptions.MaxUrlLength
+	}
+	if o.MaxSegmentRepeats <= 0 {
+		o.MaxSegmentRepeats = DefaultTrapOptions.MaxSegmentRepeats
+	}
+	if o.MaxQueryVariants <= 0 {
+		o.MaxQueryVariants = DefaultTrapOptions.MaxQueryVariants
+	}
+	if o.MaxPagesPerPattern <= 0 {
+		o.MaxPagesPerPattern = DefaultTrapOptions.MaxPagesPerPattern
+	}
+	if o.SessionParams == nil {
+		o.SessionParams = DefaultTrapOptions.SessionParams
+	}
+	return o
+}
+
+type trapDetector struct {
+	// trapDetector applies the trap heuristics to every new URL the crawl discovers. It's shared by every worker,
+	// as the per-path and per-pattern counts are for the whole crawl.
+	options       TrapOptions
+	sessionParams map[string]bool
+
+	lock          sync.Mutex
+	queryVariants map[string]int
+	patterns      map[string]int
+}
+
+func newTrapDetector(options TrapOptions) *trapDetector {
+	// newTrapDetector returns a detector for options, or nil if trap detection is disabled (a nil detector lets everything through).
+	if options.Disabled {
+		return nil
+	}
+	options = options.withDefaults()
+
+	detector := &trapDetector{
+		options:       options,
+		sessionParams: map[string]bool{},
+		queryVariants: map[string]int{},
+		patterns:      map[string]int{},
+	}
+	for _, name := range options.SessionParams {
+		detector.sessionParams[strings.ToLower(name)] = true
+	}
+	return detector
+}
+
+func (d *trapDetector) check(target *url.URL) string {
+	// check decides whether a newly discovered URL looks like a trap, returning why if so (or "" if it looks fine).
+	// It must only be called once per URL, as it counts them towards the per-path and per-pattern limits.
+	if d == nil {
+		return ""
+	}
+
+	if length := len(target.String()); length > d.options.MaxUrlLength {
+		return fmt.Sprintf("URL is %d characters long (more than %d)", length, d.options.MaxUrlLength)
+	}
+	if param := d.sessionParam(target); param != "" {
+		return fmt.Sprintf("URL carries a session ID (%s)", param)
+	}
+	if segment, repeats := repeatedSegment(target.Path); repeats > d.options.MaxSegmentRepeats {
+		return fmt.Sprintf("path segment %q repeats %d times", segment, repeats)
+	}
+
+	// the rest are about how many URLs like this one we've seen
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if target.RawQuery != "" {
+		path := target.Host + target.Path
+		d.queryVariants[path]++
+		if d.queryVariants[path] > d.options.MaxQueryVariants {
+			return fmt.Sprintf("more than %d query string variations of %s", d.options.MaxQueryVariants, target.Path)
+		}
+	}
+
+	pattern := target.Host + urlPattern(target.Path)
+	d.patterns[pattern]++
+	if d.patterns[pattern] > d.options.MaxPagesPerPattern {
+		return fmt.Sprintf("more than %d pages like %s", d.options.MaxPagesPerPattern, urlPattern(target.Path))
+	}
+	return ""
+}
+
+func (d *trapDetector) sessionParam(target *url.URL) string {
+	// sessionParam returns the name of the session ID parameter in target, if it has one, either in the query string
+	// or as a path parameter (as in Java's /page;jsessionid=...).
+	for name := range target.Query() {
+		if d.sessionParams[strings.ToLower(name)] {
+			return name
+		}
+	}
+	for _, segment := range strings.Split(target.Path, "/") {
+		params := strings.Split(segment, ";")
+		for _, param := range params[1:] {
+			name, _, _ := strings.Cut(param, "=")
+			if d.sessionParams[strings.ToLower(name)] {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+func repeatedSegment(path string) (string, int) {
+	// repeatedSegment returns the path segment which appears most often in path, and how many times it does.
+	counts := map[string]int{}
+	var most string
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" {
+			continue
+		}
+		counts[segment]++
+		if counts[segment] > counts[most] {
+			most = segment
+		}
+	}
+	return most, counts[most]
+}
+
+// variableSegment matches path segments which are probably generated: numbers, dates, and hex or UUID style IDs
+var variableSegment = regexp.MustCompile(`^(\d+|\d{4}-\d{2}(-\d{2})?|[0-9a-fA-F]{8,}|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12})$`)
+
+func urlPattern(path string) string {
+	// urlPattern wildcards the segments of path which look generated, so that pages which only differ by them group together.
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if variableSegment.MatchString(segment) {
+			segments[i] = "*"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func Traps(root *HtmlPage) []*HtmlPage {
+	// Traps lists every page in the crawl rooted at root which was quarantined as a suspected trap, sorted by URL.
+	var traps []*HtmlPage
+	for _, page := range Pages(root) {
+		if page.Trap != "" {
+			traps = append(traps, page)
+		}
+	}
+	sort.Slice(traps, func(i, j int) bool { return traps[i].Url.String() < traps[j].Url.String() })
+	return traps
+}
@@ -0,0 +1,168 @@
+package crawl
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTrapCheck(t *testing.T) {
+	for rawUrl, trapped := range map[string]bool{
+		"https://testsite.test/":                                     false,
+		"https://testsite.test/docs/api/v1":                          false,
+		"https://testsite.test/a/b/a/b/a/b":                          false,
+		"https://testsite.test/docs/v1/api/v1/":                      false,
+		"https://testsite.test/en/blog/en/":                          false,
+		"https://testsite.test/x/x/x/x":                              false,
+		"https://testsite.test/a/b/a/b/a/b/a/b/a/b":                  true,
+		"https://testsite.test/x/x/x/x/x":                            true,
+		"https://testsite.test/shop?PHPSESSID=0123abcd":              true,
+		"https://testsite.test/shop?sort=price&sid=99":               true,
+		"https://testsite.test/shop;jsessionid=0123abcd":             true,
+		"https://testsite.test/shop?side=left":                       false,
+		"https://testsite.test/" + strings.Repeat("long-path/", 250): true,
+	} {
+		target, _ := url.Parse(rawUrl)
+		reason := newTrapDetector(TrapOptions{}).check(target)
+		if (reason != "") != trapped {
+			t.Errorf("%s: expected trapped to be %t, got %q", rawUrl, trapped, reason)
+		}
+	}
+
+	// a nil detector (trap detection disabled) lets everything through
+	target, _ := url.Parse("https://testsite.test/x/x/x?PHPSESSID=0123abcd")
+	if detector := newTrapDetector(TrapOptions{Disabled: true}); detector != nil || detector.check(target) != "" {
+		t.Error("disabled trap detection still caught a trap")
+	}
+}
+
+func TestTrapLimits(t *testing.T) {
+	detector := newTrapDetector(TrapOptions{MaxQueryVariants: 3, MaxPagesPerPattern: 3})
+	check := func(rawUrl string) string {
+		target, _ := url.Parse(rawUrl)
+		return detector.check(target)
+	}
+
+	for i := 1; i <= 3; i++ {
+		if reason := check(fmt.Sprintf("https://testsite.test/search?q=%d", i)); reason != "" {
+			t.Errorf("query variant %d shouldn't be a trap yet: %s", i, reason)
+		}
+	}
+	if check("https://testsite.test/search?q=4") == "" {
+		t.Error("the 4th query variant should be a trap")
+	}
+	if reason := check("https://testsite.test/other?q=4"); reason != "" {
+		t.Errorf("query variants are counted per path: %s", reason)
+	}
+
+	// /calendar/2024/05/01 and friends are all /calendar/*/*/*
+	for day := 1; day <= 3; day++ {
+		if reason := check(fmt.Sprintf("https://testsite.test/calendar/2024/05/%02d", day)); reason != "" {
+			t.Errorf("calendar day %d shouldn't be a trap yet: %s", day, reason)
+		}
+	}
+	if check("https://testsite.test/calendar/2031/12/25") == "" {
+		t.Error("the 4th calendar page should be a trap")
+	}
+	if reason := check("https://testsite.test/calendar/2031/12"); reason != "" {
+		t.Errorf("/calendar/*/* is a different pattern: %s", reason)
+	}
+}
+
+func TestUrlPattern(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/":                     "/",
+		"/calendar/2024/05/01":  "/calendar/*/*/*",
+		"/archive/2024-05":      "/archive/*",
+		"/user/5f0c9a21e3/edit": "/user/*/edit",
+		"/item/123e4567-e89b-12d3-a456-426614174000": "/item/*",
+		"/blog/my-first-post":                        "/blog/my-first-post",
+		"/docs/v2":                                   "/docs/v2",
+	} {
+		if pattern := urlPattern(path); pattern != expected {
+			t.Errorf("%s: expected pattern %s, got %s", path, expected, pattern)
+		}
+	}
+}
+
+func TestCrawlQuarantinesTraps(t *testing.T) {
+	// a site with an endless calendar (each month links to the next), and a link with a session ID in it
+	var lock sync.Mutex
+	hits := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		hits[r.URL.Path]++
+		lock.Unlock()
+
+		fmt.Fprint(w, "<html><head><title>Calendar</title></head><body>")
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/calendar/1">calendar</a> <a href="/account?PHPSESSID=0123abcd">account</a>`)
+		} else if month, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/calendar/")); err == nil {
+			fmt.Fprintf(w, `<a href="/calendar/%d">next month</a>`, month+1)
+		}
+		fmt.Fprint(w, "</body></html>")
+	}))
+	defer server.Close()
+	rootUrl, _ := url.Parse(server.URL + "/")
+
+	root := Walk(rootUrl, Options{Traps: TrapOptions{MaxPagesPerPattern: 5}})
+
+	traps := Traps(root)
+	if len(traps) != 2 {
+		t.Fatalf("expected 2 suspected traps, got %d", len(traps))
+	}
+	if traps[0].Url.Path != "/account" || !strings.Contains(traps[0].Trap, "PHPSESSID") {
+		t.Errorf("expected the session ID link to be quarantined, got %s (%s)", traps[0].Url.String(), traps[0].Trap)
+	}
+	if traps[1].Url.Path != "/calendar/6" {
+		t.Errorf("expected the 6th month to be quarantined, got %s (%s)", traps[1].Url.String(), traps[1].Trap)
+	}
+
+	lock.Lock()
+	if hits["/account"] != 0 || hits["/calendar/5"] != 1 || hits["/calendar/6"] != 0 {
+		t.Errorf("quarantined pages shouldn't be fetched: %v", hits)
+	}
+	lock.Unlock()
+
+	// quarantined pages are saved as such
+	var saved bytes.Buffer
+	if err := (&Crawl{Root: root, Seed: rootUrl.String()}).Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCrawl(&saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loadedTraps := Traps(loaded.Root); len(loadedTraps) != 2 || loadedTraps[1].Trap != traps[1].Trap {
+		t.Error("suspected traps didn't survive saving")
+	}
+}
+
+func TestTrapsLeavesDepthsAlone(t *testing.T) {
+	// listing the traps is read only: it mustn't recompute (or otherwise touch) the pages' depths
+	page := func(path string) *HtmlPage {
+		return &HtmlPage{Url: &url.URL{Scheme: "https", Host: "testsite.test", Path: path}}
+	}
+	root, a, trap := page("/"), page("/a"), page("/a/a/a/a/a")
+	root.LinksTo = []*HtmlPage{a}
+	a.LinksTo = []*HtmlPage{trap}
+	trap.Trap = `path segment "a" repeats 5 times`
+	for _, p := range []*HtmlPage{root, a, trap} {
+		p.Depth, p.DepthParent = 42, root
+	}
+
+	if traps := Traps(root); len(traps) != 1 || traps[0] != trap {
+		t.Fatalf("expected just the one trap, got %v", traps)
+	}
+	for _, p := range []*HtmlPage{root, a, trap} {
+		if p.Depth != 42 || p.DepthParent != root {
+			t.Errorf("Traps changed the depth of %s to %d", p.Url.Path, p.Depth)
+		}
+	}
+}
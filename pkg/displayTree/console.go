@@ -3,67 +3,43 @@ package displayTree
 import (
 	"github.com/disiqueira/gotree"
 	"github.com/luaduck/creepycrawler/pkg/crawl"
-	"net/url"
 	"fmt"
 )
 
-type stackElement struct {
-	htmlPage *crawl.HtmlPage
-	tree *gotree.Tree
-}
-
 func pageTree(page *crawl.HtmlPage, displayBackrefs *bool) gotree.Tree {
-	// This isn't the most performant thing on the planet (it's not async, for one), but it's only here
-	// because we need some way to dump the data in a human readable format.
-
-	// It could easily be made way more performant by doing async with data storage similar to how I do it in crawl.
-
-	// you get the idea
+	// pageTree renders the tree in discovery order (the default view) as a gotree tree, ready for printing to console.
+	return viewTree(page, TreeOptions{DisplayBackrefs: *displayBackrefs})
+}
 
-	tree := gotree.New(page.Url.String()+" ("+page.Title+")")
+func viewTree(page *crawl.HtmlPage, opts TreeOptions) gotree.Tree {
+	// viewTree renders the tree arranged as opts.View asks as a gotree tree.
+	if opts.View == PathView {
+		return pathTree(page, opts)
+	}
 
-	// a map is absolutely overkill here, but it saves having to write a custom array search function
-	// hell, you could probably do this as map[*url.URL]bool
+	result := walkTree(page, opts)
 
-	// map key is a pointer because it saves memory, plus we never deal with creating new url.URL entries here
-	// and stuff is deduplicated for us beforehand (hence it's safe to do lookups)
-	// if you wanted to be super safe you could make this a resolved lookup with duplication testing
-	// but that feels overkill
-	allPages := map[*url.URL]*crawl.HtmlPage{
-		page.Url: page,
-	}
+	tree := gotree.New(page.Url.String()+" ("+page.Title+")"+depthSuffix(result.root, opts))
 
-	var f func(r *crawl.HtmlPage, src gotree.Tree)
-	f = func(r *crawl.HtmlPage, src gotree.Tree) {
-		// iterateStack is an array of recursion targets
-		// this will be run after we have finished dealing with everything on the current layer
-		var iterateStack []*stackElement
-		for _, elem := range r.LinksTo {
-			_, ok := allPages[elem.Url]
-			if ok {
-				// we have already found this index, so do not recurse
-				if *displayBackrefs {
-					src.Add(elem.Url.String()+" ("+elem.Title+") (🔙 lower or already parsed page)")
-				}
+	var f func(node *treeNode, src gotree.Tree)
+	f = func(node *treeNode, src gotree.Tree) {
+		for _, elem := range node.children {
+			if elem.backref {
+				src.Add(elem.page.Url.String()+" ("+elem.page.Title+")"+depthSuffix(elem, opts)+" (🔙 lower or already parsed page)")
+			} else if elem.page.Trap != "" {
+				src.Add(fmt.Sprintf("%s (🪤 suspected trap: %s)%s", elem.page.Url.String(), elem.page.Trap, depthSuffix(elem, opts)))
+			} else if elem.page.IsParsed {
+				f(elem, src.Add(elem.page.Url.String()+" ("+elem.page.Title+")"+soft404Suffix(elem.page)+depthSuffix(elem, opts)))
 			} else {
-				// we haven't yet found this index, add it to the stack and keep recursing
-				// (but only if we were able to parse it)
-				if elem.IsParsed {
-					subpage := src.Add(elem.Url.String()+" ("+elem.Title+")")
-					allPages[elem.Url] = elem
-					iterateStack = append(iterateStack, &stackElement{htmlPage: elem, tree: &subpage})
-				} else {
-					src.Add(fmt.Sprintf("%s (parse error: %s)", elem.Url.String(), elem.CrawlError))
-				}
-
+				src.Add(elem.page.Url.String()+unparsedSuffix(elem.page)+depthSuffix(elem, opts))
 			}
 		}
+	}
+	f(result.root, tree)
 
-		for _, elem := range iterateStack {
-			f(elem.htmlPage, *elem.tree)
-		}
+	if result.omitted > 0 {
+		tree.Add(fmt.Sprintf("... %d more pages not shown", result.omitted))
 	}
-	f(page, tree)
 
 	return tree
 }
@@ -79,4 +55,9 @@ func StringPageTree(page *crawl.HtmlPage, displayBackrefs *bool) *string {
 	treeString := treeObject.Print()
 
 	return &treeString
-}
\ No newline at end of file
+}
+
+func StringTree(page *crawl.HtmlPage, opts TreeOptions) string {
+	// StringTree is StringPageTree with the full set of TreeOptions, including the choice of view.
+	return viewTree(page, opts).Print()
+}
@@ -42,6 +42,9 @@ func genTestTree() *crawl.HtmlPage {
 	// element2 also links back to root
 	testElement2.LinksTo = append(testElement2.LinksTo, testRoot)
 
+	// the crawler works out every page's depth once it's finished, and the trees rely on it
+	crawl.ComputeDepths(testRoot)
+
 	return testRoot
 }
 
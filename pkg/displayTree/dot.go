@@ -0,0 +1,79 @@
+// dot writes HtmlPage graphs out in Graphviz DOT format, ready for `dot -Tsvg` and friends.
+
+package displayTree
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+func WriteDOT(w io.Writer, root *crawl.HtmlPage, opts GraphOptions) error {
+	// WriteDOT writes the full graph reachable from root as a Graphviz digraph.
+	// Node attributes carry the URL, title, HTTP status, depth and any crawl error;
+	// pages which failed to crawl are drawn in red so they stand out.
+
+	graph := collectGraph(root, opts)
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintln(out, "digraph creepycrawler {")
+	fmt.Fprintln(out, "\tnode [shape=box];")
+
+	if len(graph.clusters) > 0 {
+		// DOT only treats subgraphs as clusters if their name starts with "cluster"
+		for i, cluster := range graph.clusters {
+			fmt.Fprintf(out, "\tsubgraph cluster_%d {\n", i)
+			fmt.Fprintf(out, "\t\tlabel=%s;\n", strconv.Quote(cluster))
+			for _, node := range graph.nodes {
+				if node.cluster == cluster {
+					writeDOTNode(out, "\t\t", node)
+				}
+			}
+			fmt.Fprintln(out, "\t}")
+		}
+	} else {
+		for _, node := range graph.nodes {
+			writeDOTNode(out, "\t", node)
+		}
+	}
+
+	for _, edge := range graph.edges {
+		fmt.Fprintf(out, "\t%s -> %s;\n", edge.source.id, edge.target.id)
+	}
+
+	fmt.Fprintln(out, "}")
+
+	return out.Flush()
+}
+
+func writeDOTNode(out io.Writer, indent string, node *graphNode) {
+	// writeDOTNode writes a single node statement.
+	// strconv.Quote is close enough to DOT's own quoting rules (double quotes, backslash escapes) to use here.
+	fmt.Fprintf(out, "%s%s [label=%s, URL=%s, title=%s, status=%d, depth=%d",
+		indent, node.id,
+		strconv.Quote(nodeLabel(node.page)),
+		strconv.Quote(node.page.Url.String()),
+		strconv.Quote(node.page.Title),
+		node.page.StatusCode,
+		node.depth)
+
+	if node.cluster != "" {
+		fmt.Fprintf(out, ", cluster=%s", strconv.Quote(node.cluster))
+	}
+
+	if node.metrics != nil {
+		for _, attribute := range metricAttributes {
+			fmt.Fprintf(out, ", %s=%s", attribute.name, attribute.format(node.metrics))
+		}
+	}
+
+	// (soft 404s have no CrawlError, but they're just as broken)
+	if err := nodeError(node.page); err != "" {
+		fmt.Fprintf(out, ", error=%s, color=red", strconv.Quote(err))
+	}
+
+	fmt.Fprintln(out, "];")
+}
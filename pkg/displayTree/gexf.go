@@ -0,0 +1,116 @@
+// gexf writes HtmlPage graphs out as GEXF, which is Gephi's native format.
+
+package displayTree
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+type gexfDocument struct {
+	XMLName xml.Name  `xml:"gexf"`
+	Xmlns   string    `xml:"xmlns,attr"`
+	Version string    `xml:"version,attr"`
+	Graph   gexfGraph `xml:"graph"`
+}
+
+type gexfGraph struct {
+	DefaultEdgeType string           `xml:"defaultedgetype,attr"`
+	Attributes      gexfAttributeSet `xml:"attributes"`
+	Nodes           []gexfNode       `xml:"nodes>node"`
+	Edges           []gexfEdge       `xml:"edges>edge"`
+}
+
+type gexfAttributeSet struct {
+	Class      string          `xml:"class,attr"`
+	Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfNode struct {
+	ID        string          `xml:"id,attr"`
+	Label     string          `xml:"label,attr"`
+	AttValues []gexfAttrValue `xml:"attvalues>attvalue"`
+}
+
+type gexfAttrValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type gexfEdge struct {
+	ID     string `xml:"id,attr"`
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+func WriteGEXF(w io.Writer, root *crawl.HtmlPage, opts GraphOptions) error {
+	// WriteGEXF writes the full graph reachable from root as a GEXF 1.2 document.
+	// Node attributes are the same as the GraphML export, so Gephi can partition or colour by status, depth or cluster.
+
+	graph := collectGraph(root, opts)
+
+	attributes := []gexfAttribute{
+		{ID: "url", Title: "url", Type: "string"},
+		{ID: "title", Title: "title", Type: "string"},
+		{ID: "status", Title: "status", Type: "integer"},
+		{ID: "depth", Title: "depth", Type: "integer"},
+		{ID: "error", Title: "error", Type: "string"},
+	}
+	if len(graph.clusters) > 0 {
+		attributes = append(attributes, gexfAttribute{ID: "cluster", Title: "cluster", Type: "string"})
+	}
+	if opts.Analysis != nil {
+		for _, attribute := range metricAttributes {
+			attrType := "double"
+			if attribute.integer {
+				attrType = "integer"
+			}
+			attributes = append(attributes, gexfAttribute{ID: attribute.name, Title: attribute.name, Type: attrType})
+		}
+	}
+
+	doc := gexfDocument{
+		Xmlns:   "http://www.gexf.net/1.2draft",
+		Version: "1.2",
+		Graph: gexfGraph{
+			DefaultEdgeType: "directed",
+			Attributes:      gexfAttributeSet{Class: "node", Attributes: attributes},
+		},
+	}
+
+	for _, node := range graph.nodes {
+		values := []gexfAttrValue{
+			{For: "url", Value: node.page.Url.String()},
+			{For: "title", Value: node.page.Title},
+			{For: "status", Value: strconv.Itoa(node.page.StatusCode)},
+			{For: "depth", Value: strconv.Itoa(node.depth)},
+		}
+		if err := nodeError(node.page); err != "" {
+			values = append(values, gexfAttrValue{For: "error", Value: err})
+		}
+		if node.cluster != "" {
+			values = append(values, gexfAttrValue{For: "cluster", Value: node.cluster})
+		}
+		if node.metrics != nil {
+			for _, attribute := range metricAttributes {
+				values = append(values, gexfAttrValue{For: attribute.name, Value: attribute.format(node.metrics)})
+			}
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, gexfNode{ID: node.id, Label: nodeLabel(node.page), AttValues: values})
+	}
+
+	for _, edge := range graph.edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, gexfEdge{ID: edge.id, Source: edge.source.id, Target: edge.target.id})
+	}
+
+	return writeXML(w, doc)
+}
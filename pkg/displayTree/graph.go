@@ -0,0 +1,189 @@
+// graph contains the shared plumbing for the full-graph exporters (DOT, GraphML and GEXF).
+// Unlike pageTree, these don't throw away back references - every page is a node and every link is an edge,
+// loops and all.
+
+package displayTree
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/luaduck/creepycrawler/pkg/analysis"
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+type GraphOptions struct {
+	// GraphOptions controls how a HtmlPage graph is exported.
+
+	// ClusterDepth groups nodes by the first ClusterDepth segments of their URL path
+	// (so with a ClusterDepth of 1, /docs/api and /docs/faq both end up in the /docs cluster).
+	// 0 turns clustering off.
+	ClusterDepth int
+
+	// Analysis, if set, adds each page's link graph metrics (degrees, PageRank, HITS scores, component) as node attributes.
+	Analysis *analysis.Report
+}
+
+type metricAttribute struct {
+	// metricAttribute is a single link graph metric exported as a node attribute.
+	name string
+
+	// integer is set for whole number metrics, and unset for scores
+	integer bool
+
+	value func(*analysis.NodeMetrics) float64
+}
+
+// metricAttributes are the node attributes added when GraphOptions.Analysis is set, in the order they're written.
+var metricAttributes = []metricAttribute{
+	{"indegree", true, func(m *analysis.NodeMetrics) float64 { return float64(m.InDegree) }},
+	{"outdegree", true, func(m *analysis.NodeMetrics) float64 { return float64(m.OutDegree) }},
+	{"pagerank", false, func(m *analysis.NodeMetrics) float64 { return m.PageRank }},
+	{"hub", false, func(m *analysis.NodeMetrics) float64 { return m.HubScore }},
+	{"authority", false, func(m *analysis.NodeMetrics) float64 { return m.AuthorityScore }},
+	{"component", true, func(m *analysis.NodeMetrics) float64 { return float64(m.Component) }},
+}
+
+func (a metricAttribute) format(metrics *analysis.NodeMetrics) string {
+	// format returns the attribute's value for a page as a string.
+	if a.integer {
+		return strconv.Itoa(int(a.value(metrics)))
+	}
+	return strconv.FormatFloat(a.value(metrics), 'g', 6, 64)
+}
+
+type graphNode struct {
+	// graphNode is a single page in an exported graph.
+	id      string
+	page    *crawl.HtmlPage
+	depth   int
+	cluster string
+
+	// metrics are the page's link graph metrics (nil unless GraphOptions.Analysis is set)
+	metrics *analysis.NodeMetrics
+
+	// parent is the node this page was first reached from during the breadth first walk (nil for the root)
+	parent *graphNode
+}
+
+type graphEdge struct {
+	// graphEdge is a single link from one page to another.
+	id     string
+	source *graphNode
+	target *graphNode
+
+	// tree is set on the edge each page was first reached through, so tree edges form a shortest click path tree
+	tree bool
+}
+
+type exportGraph struct {
+	// exportGraph is a flattened copy of a HtmlPage graph, with stable IDs for every node and edge.
+	nodes []*graphNode
+	edges []*graphEdge
+
+	// clusters is the list of cluster names in the order they were first seen (empty if clustering is off)
+	clusters []string
+}
+
+func collectGraph(root *crawl.HtmlPage, opts GraphOptions) *exportGraph {
+	// collectGraph walks the graph breadth first from root, giving every page a node ID in the order it was found.
+	// Going breadth first means depth is the number of clicks from the root, rather than whatever
+	// order the crawler's goroutines happened to finish in.
+
+	graph := &exportGraph{}
+
+	// seen maps each page to its node; pages are deduplicated by the crawler, so the pointer is a safe key
+	seen := map[*crawl.HtmlPage]*graphNode{}
+	seenClusters := map[string]bool{}
+
+	addNode := func(page *crawl.HtmlPage, depth int) *graphNode {
+		node := &graphNode{id: "n" + strconv.Itoa(len(graph.nodes)), page: page, depth: depth}
+		if opts.ClusterDepth > 0 {
+			node.cluster = pathCluster(page, opts.ClusterDepth)
+			if !seenClusters[node.cluster] {
+				seenClusters[node.cluster] = true
+				graph.clusters = append(graph.clusters, node.cluster)
+			}
+		}
+		if opts.Analysis != nil {
+			node.metrics = opts.Analysis.Metrics[page]
+		}
+		seen[page] = node
+		graph.nodes = append(graph.nodes, node)
+		return node
+	}
+
+	queue := []*graphNode{addNode(root, 0)}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, link := range current.page.LinksTo {
+			edge := &graphEdge{id: "e" + strconv.Itoa(len(graph.edges)), source: current}
+
+			target, ok := seen[link]
+			if !ok {
+				target = addNode(link, current.depth+1)
+				target.parent = current
+				edge.tree = true
+				queue = append(queue, target)
+			}
+
+			edge.target = target
+			graph.edges = append(graph.edges, edge)
+		}
+	}
+
+	return graph
+}
+
+func pathCluster(page *crawl.HtmlPage, depth int) string {
+	// pathCluster returns the first depth segments of page's URL path, e.g "/docs/api" for "/docs/api/v2/index.html" at depth 2.
+	// Pages shallower than depth are clustered by their parent directory, so / and /about both land in "/".
+	segments := strings.Split(strings.Trim(page.Url.Path, "/"), "/")
+
+	// the last segment is the page itself rather than a directory, unless the path ends in a slash
+	if !strings.HasSuffix(page.Url.Path, "/") {
+		segments = segments[:len(segments)-1]
+	}
+	if len(segments) > depth {
+		segments = segments[:depth]
+	}
+
+	return "/" + strings.Join(segments, "/")
+}
+
+func nodeLabel(page *crawl.HtmlPage) string {
+	// nodeLabel is the text used to label a node: its title where we have one, and its URL otherwise.
+	if page.Title != "" {
+		return page.Title
+	}
+	return page.Url.String()
+}
+
+func nodeError(page *crawl.HtmlPage) string {
+	// nodeError returns the page's crawl error as a string (or why it looks like a soft 404), or an empty string if there wasn't one.
+	if page.CrawlError == nil {
+		if page.Soft404 != "" {
+			return "soft 404: " + page.Soft404
+		}
+		return ""
+	}
+	return page.CrawlError.Error()
+}
+
+func unparsedSuffix(page *crawl.HtmlPage) string {
+	// unparsedSuffix is what's added to the URL of a page which couldn't be parsed, so that every tree format labels them the same way.
+	if page.CrawlError == nil {
+		return " (not crawled)"
+	}
+	return " (parse error: " + page.CrawlError.Error() + ")"
+}
+
+func soft404Suffix(page *crawl.HtmlPage) string {
+	// soft404Suffix is what's added to a page's label in the trees if it looks like a soft 404.
+	if page.Soft404 == "" {
+		return ""
+	}
+	return " (👻 soft 404: " + page.Soft404 + ")"
+}
@@ -0,0 +1,202 @@
+package displayTree
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/luaduck/creepycrawler/pkg/analysis"
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+func TestCollectGraph(t *testing.T) {
+	graph := collectGraph(genTestTree(), GraphOptions{})
+
+	// root, elem1, elem2, elem3 - the backref from elem2 to root must not produce a second root node
+	if len(graph.nodes) != 4 {
+		t.Errorf("collectGraph returned the wrong number of nodes: expected %d, got %d", 4, len(graph.nodes))
+	}
+
+	// root->1, root->2, 1->3, 2->root
+	if len(graph.edges) != 4 {
+		t.Errorf("collectGraph returned the wrong number of edges: expected %d, got %d", 4, len(graph.edges))
+	}
+
+	expectedDepths := map[string]int{
+		"https://testsite.test/":  0,
+		"https://testsite.test/1": 1,
+		"https://testsite.test/2": 1,
+		"https://testsite.test/3": 2,
+	}
+	for _, node := range graph.nodes {
+		if node.depth != expectedDepths[node.page.Url.String()] {
+			t.Errorf("%s has the wrong depth: expected %d, got %d", node.page.Url.String(), expectedDepths[node.page.Url.String()], node.depth)
+		}
+	}
+}
+
+func TestPathCluster(t *testing.T) {
+	tests := []struct {
+		path     string
+		depth    int
+		expected string
+	}{
+		{"", 1, "/"},
+		{"/", 1, "/"},
+		{"/about", 1, "/"},
+		{"/docs/", 1, "/docs"},
+		{"/docs/api/v2/index.html", 1, "/docs"},
+		{"/docs/api/v2/index.html", 2, "/docs/api"},
+	}
+
+	for _, test := range tests {
+		page := &crawl.HtmlPage{Url: &url.URL{Scheme: "https", Host: "testsite.test", Path: test.path}}
+		if result := pathCluster(page, test.depth); result != test.expected {
+			t.Errorf("pathCluster(%q, %d) returned an unexpected result: expected %s, got %s", test.path, test.depth, test.expected, result)
+		}
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteDOT(&out, genTestTree(), GraphOptions{ClusterDepth: 1}); err != nil {
+		t.Fatalf("WriteDOT returned an error: %s", err)
+	}
+
+	dot := out.String()
+	for _, expected := range []string{
+		"digraph creepycrawler {",
+		"subgraph cluster_0 {",
+		`n0 [label="TestRoot", URL="https://testsite.test/"`,
+		`error="test", color=red`,
+		"n2 -> n0;",
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Errorf("DOT output is missing %q:\n%s", expected, dot)
+		}
+	}
+}
+
+func TestWriteGraphML(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteGraphML(&out, genTestTree(), GraphOptions{}); err != nil {
+		t.Fatalf("WriteGraphML returned an error: %s", err)
+	}
+
+	// read it back in to make sure it's well formed
+	var doc graphMLDocument
+	if err := xml.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("GraphML output is not valid XML: %s", err)
+	}
+
+	if len(doc.Graph.Nodes) != 4 || len(doc.Graph.Edges) != 4 {
+		t.Errorf("GraphML output has the wrong shape: expected 4 nodes and 4 edges, got %d and %d", len(doc.Graph.Nodes), len(doc.Graph.Edges))
+	}
+}
+
+func TestWriteGEXF(t *testing.T) {
+	var out bytes.Buffer
+	if err := WriteGEXF(&out, genTestTree(), GraphOptions{ClusterDepth: 1}); err != nil {
+		t.Fatalf("WriteGEXF returned an error: %s", err)
+	}
+
+	var doc gexfDocument
+	if err := xml.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("GEXF output is not valid XML: %s", err)
+	}
+
+	if len(doc.Graph.Nodes) != 4 || len(doc.Graph.Edges) != 4 {
+		t.Errorf("GEXF output has the wrong shape: expected 4 nodes and 4 edges, got %d and %d", len(doc.Graph.Nodes), len(doc.Graph.Edges))
+	}
+
+	if doc.Graph.Nodes[0].Label != "TestRoot" {
+		t.Errorf("GEXF root node has the wrong label: expected %s, got %s", "TestRoot", doc.Graph.Nodes[0].Label)
+	}
+}
+
+func TestGraphExportSoft404s(t *testing.T) {
+	// a soft 404 has no CrawlError, but it's broken all the same, so every export should mark it as an error
+	root := genTestTree()
+	root.LinksTo[1].Soft404 = "title matches /not found/"
+	expected := "soft 404: title matches /not found/"
+
+	var dot bytes.Buffer
+	if err := WriteDOT(&dot, root, GraphOptions{}); err != nil {
+		t.Fatalf("WriteDOT returned an error: %s", err)
+	}
+	if !strings.Contains(dot.String(), `error="`+expected+`", color=red`) {
+		t.Errorf("DOT output doesn't mark the soft 404 as an error:\n%s", dot.String())
+	}
+
+	var graphML bytes.Buffer
+	if err := WriteGraphML(&graphML, root, GraphOptions{}); err != nil {
+		t.Fatalf("WriteGraphML returned an error: %s", err)
+	}
+	var graphMLDoc graphMLDocument
+	if err := xml.Unmarshal(graphML.Bytes(), &graphMLDoc); err != nil {
+		t.Fatalf("GraphML output is not valid XML: %s", err)
+	}
+	var graphMLErrors []string
+	for _, node := range graphMLDoc.Graph.Nodes {
+		for _, data := range node.Data {
+			if data.Key == "error" {
+				graphMLErrors = append(graphMLErrors, data.Value)
+			}
+		}
+	}
+	if strings.Join(graphMLErrors, "|") != "test|"+expected && strings.Join(graphMLErrors, "|") != expected+"|test" {
+		t.Errorf("GraphML output has the wrong errors: %v", graphMLErrors)
+	}
+
+	var gexf bytes.Buffer
+	if err := WriteGEXF(&gexf, root, GraphOptions{}); err != nil {
+		t.Fatalf("WriteGEXF returned an error: %s", err)
+	}
+	var gexfDoc gexfDocument
+	if err := xml.Unmarshal(gexf.Bytes(), &gexfDoc); err != nil {
+		t.Fatalf("GEXF output is not valid XML: %s", err)
+	}
+	found := false
+	for _, node := range gexfDoc.Graph.Nodes {
+		for _, value := range node.AttValues {
+			found = found || (value.For == "error" && value.Value == expected)
+		}
+	}
+	if !found {
+		t.Errorf("GEXF output doesn't mark the soft 404 as an error:\n%s", gexf.String())
+	}
+}
+
+func TestGraphExportMetrics(t *testing.T) {
+	root := genTestTree()
+	opts := GraphOptions{Analysis: analysis.Analyse(root)}
+
+	var dot bytes.Buffer
+	if err := WriteDOT(&dot, root, opts); err != nil {
+		t.Fatalf("WriteDOT returned an error: %s", err)
+	}
+	// root is linked from elem2 only, and links to elem1 and elem2
+	if !strings.Contains(dot.String(), "indegree=1, outdegree=2, pagerank=") {
+		t.Errorf("DOT output is missing link metrics:\n%s", dot.String())
+	}
+
+	var graphml bytes.Buffer
+	if err := WriteGraphML(&graphml, root, opts); err != nil {
+		t.Fatalf("WriteGraphML returned an error: %s", err)
+	}
+	var doc graphMLDocument
+	if err := xml.Unmarshal(graphml.Bytes(), &doc); err != nil {
+		t.Fatalf("GraphML output is not valid XML: %s", err)
+	}
+	found := false
+	for _, data := range doc.Graph.Nodes[0].Data {
+		if data.Key == "pagerank" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("GraphML root node is missing its pagerank attribute")
+	}
+}
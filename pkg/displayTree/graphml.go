@@ -0,0 +1,125 @@
+// graphml writes HtmlPage graphs out as GraphML, which most graph tools (yEd, Cytoscape, Gephi, networkx) can read.
+
+package displayTree
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+// The types below map directly onto GraphML elements, so encoding/xml can do the heavy lifting for us.
+
+type graphMLDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	ID     string `xml:"id,attr"`
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+func WriteGraphML(w io.Writer, root *crawl.HtmlPage, opts GraphOptions) error {
+	// WriteGraphML writes the full graph reachable from root as a directed GraphML document.
+	// Each node carries url, title, status, depth and error attributes (plus cluster, if clustering is on).
+
+	graph := collectGraph(root, opts)
+
+	doc := graphMLDocument{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "url", For: "node", AttrName: "url", AttrType: "string"},
+			{ID: "title", For: "node", AttrName: "title", AttrType: "string"},
+			{ID: "status", For: "node", AttrName: "status", AttrType: "int"},
+			{ID: "depth", For: "node", AttrName: "depth", AttrType: "int"},
+			{ID: "error", For: "node", AttrName: "error", AttrType: "string"},
+		},
+		Graph: graphMLGraph{ID: "creepycrawler", EdgeDefault: "directed"},
+	}
+
+	if len(graph.clusters) > 0 {
+		doc.Keys = append(doc.Keys, graphMLKey{ID: "cluster", For: "node", AttrName: "cluster", AttrType: "string"})
+	}
+	if opts.Analysis != nil {
+		for _, attribute := range metricAttributes {
+			attrType := "double"
+			if attribute.integer {
+				attrType = "int"
+			}
+			doc.Keys = append(doc.Keys, graphMLKey{ID: attribute.name, For: "node", AttrName: attribute.name, AttrType: attrType})
+		}
+	}
+
+	for _, node := range graph.nodes {
+		data := []graphMLData{
+			{Key: "url", Value: node.page.Url.String()},
+			{Key: "title", Value: node.page.Title},
+			{Key: "status", Value: strconv.Itoa(node.page.StatusCode)},
+			{Key: "depth", Value: strconv.Itoa(node.depth)},
+		}
+		if err := nodeError(node.page); err != "" {
+			data = append(data, graphMLData{Key: "error", Value: err})
+		}
+		if node.cluster != "" {
+			data = append(data, graphMLData{Key: "cluster", Value: node.cluster})
+		}
+		if node.metrics != nil {
+			for _, attribute := range metricAttributes {
+				data = append(data, graphMLData{Key: attribute.name, Value: attribute.format(node.metrics)})
+			}
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{ID: node.id, Data: data})
+	}
+
+	for _, edge := range graph.edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{ID: edge.id, Source: edge.source.id, Target: edge.target.id})
+	}
+
+	return writeXML(w, doc)
+}
+
+func writeXML(w io.Writer, doc interface{}) error {
+	// writeXML writes doc to w as an indented XML document, header and all.
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,67 @@
+// markdown renders HtmlPage trees as nested Markdown lists, for pasting into PRs and wiki pages.
+
+package displayTree
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+func StringMarkdown(page *crawl.HtmlPage, opts TreeOptions) string {
+	// StringMarkdown renders the tree from walkTree as a nested Markdown list, with every page linked.
+	// Entries are annotated the same way as the console tree.
+
+	result := walkTree(page, opts)
+
+	var out strings.Builder
+
+	var f func(node *treeNode, depth int)
+	f = func(node *treeNode, depth int) {
+		indent := strings.Repeat("  ", depth)
+		switch {
+		case node.backref:
+			fmt.Fprintf(&out, "%s- %s%s (🔙 lower or already parsed page)\n", indent, markdownLink(node.page), depthSuffix(node, opts))
+		case node.page.Trap != "":
+			fmt.Fprintf(&out, "%s- <%s> (🪤 suspected trap: %s)%s\n", indent, node.page.Url.String(), markdownEscape(node.page.Trap), depthSuffix(node, opts))
+		case !node.page.IsParsed:
+			fmt.Fprintf(&out, "%s- <%s>%s%s\n", indent, node.page.Url.String(), markdownEscape(unparsedSuffix(node.page)), depthSuffix(node, opts))
+		case node.page.Soft404 != "":
+			fmt.Fprintf(&out, "%s- %s (👻 soft 404: %s)%s\n", indent, markdownLink(node.page), markdownEscape(node.page.Soft404), depthSuffix(node, opts))
+		default:
+			fmt.Fprintf(&out, "%s- %s%s\n", indent, markdownLink(node.page), depthSuffix(node, opts))
+		}
+
+		for _, elem := range node.children {
+			f(elem, depth+1)
+		}
+	}
+	f(result.root, 0)
+
+	if result.omitted > 0 {
+		fmt.Fprintf(&out, "\n_... %d more pages not shown_\n", result.omitted)
+	}
+
+	return out.String()
+}
+
+func markdownLink(page *crawl.HtmlPage) string {
+	// markdownLink returns a Markdown link to page, using its title as the link text where available.
+	return fmt.Sprintf("[%s](<%s>)", markdownEscape(nodeLabel(page)), page.Url.String())
+}
+
+func markdownEscape(text string) string {
+	// markdownEscape backslash escapes the characters which would otherwise break out of a list item or link text.
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		`[`, `\[`,
+		`]`, `\]`,
+		`*`, `\*`,
+		`_`, `\_`,
+		"`", "\\`",
+		"<", `\<`,
+		"\n", " ",
+	)
+	return replacer.Replace(text)
+}
@@ -0,0 +1,56 @@
+package displayTree
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+func TestStringMarkdown(t *testing.T) {
+	markdown := StringMarkdown(genTestTree(), TreeOptions{DisplayBackrefs: true})
+
+	expected := "- [TestRoot](<https://testsite.test/>)\n" +
+		"  - [TestElem1](<https://testsite.test/1>)\n" +
+		"    - <https://testsite.test/3> (parse error: test)\n" +
+		"  - [TestElem2](<https://testsite.test/2>)\n" +
+		"    - [TestRoot](<https://testsite.test/>) (🔙 lower or already parsed page)\n"
+
+	if markdown != expected {
+		t.Errorf("Markdown output changed: expected\n%s\ngot\n%s", expected, markdown)
+	}
+}
+
+func TestStringMarkdownMaxNodes(t *testing.T) {
+	markdown := StringMarkdown(genTestTree(), TreeOptions{MaxNodes: 3})
+
+	expected := "- [TestRoot](<https://testsite.test/>)\n" +
+		"  - [TestElem1](<https://testsite.test/1>)\n" +
+		"  - [TestElem2](<https://testsite.test/2>)\n" +
+		"\n_... 1 more pages not shown_\n"
+
+	if markdown != expected {
+		t.Errorf("Markdown output changed: expected\n%s\ngot\n%s", expected, markdown)
+	}
+}
+
+func TestUnparsedLabelsMatch(t *testing.T) {
+	// pages which couldn't be parsed are labelled the same way in the console tree and the Markdown list,
+	// whether or not there's an error to go with them
+	root := genTestTree()
+	unfetched := &crawl.HtmlPage{Url: &url.URL{Scheme: "https", Host: "testsite.test", Path: "/4"}}
+	root.LinksTo = append(root.LinksTo, unfetched)
+
+	console := StringTree(root, TreeOptions{})
+	markdown := StringMarkdown(root, TreeOptions{})
+	for _, label := range []string{"https://testsite.test/3 (parse error: test)", "https://testsite.test/4 (not crawled)"} {
+		if !strings.Contains(console, label) {
+			t.Errorf("console tree is missing %q:\n%s", label, console)
+		}
+		markdownLabel := strings.Replace(label, " ", "> ", 1)
+		if !strings.Contains(markdown, "<"+markdownLabel) {
+			t.Errorf("Markdown list is missing %q:\n%s", "<"+markdownLabel, markdown)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// mermaid renders HtmlPage trees as Mermaid flowcharts, which GitHub / GitLab / most wikis render inline.
+
+package displayTree
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+func StringMermaid(page *crawl.HtmlPage, opts TreeOptions) string {
+	// StringMermaid renders the tree from walkTree as a Mermaid `graph TD` diagram.
+	// Since Mermaid draws a graph rather than a list, back references are drawn as dotted arrows
+	// pointing at the page's existing node, rather than as a duplicate entry.
+
+	result := walkTree(page, opts)
+
+	var out strings.Builder
+	out.WriteString("graph TD\n")
+
+	// ids maps each page to its Mermaid node ID, so back references can point at the right node
+	ids := map[*crawl.HtmlPage]string{}
+	var errorNodes []string
+
+	addNode := func(node *treeNode) {
+		page := node.page
+		id := "n" + strconv.Itoa(len(ids))
+		ids[page] = id
+
+		label := nodeLabel(page)
+		if page.Trap != "" {
+			label = fmt.Sprintf("%s (🪤 suspected trap: %s)", page.Url.String(), page.Trap)
+		} else if !page.IsParsed {
+			label = page.Url.String() + unparsedSuffix(page)
+			if page.CrawlError != nil {
+				errorNodes = append(errorNodes, id)
+			}
+		} else if page.Soft404 != "" {
+			label += soft404Suffix(page)
+			errorNodes = append(errorNodes, id)
+		}
+		label += depthSuffix(node, opts)
+		fmt.Fprintf(&out, "    %s[\"%s\"]\n", id, mermaidEscape(label))
+		fmt.Fprintf(&out, "    click %s \"%s\"\n", id, mermaidEscape(page.Url.String()))
+	}
+
+	// every node gets its ID before any edges are written, as a back reference can point at a page which is rendered
+	// later on (in the shortest path view, a page's BFS parent can be a sibling further down the tree)
+	var addNodes func(node *treeNode)
+	addNodes = func(node *treeNode) {
+		addNode(node)
+		for _, elem := range node.children {
+			if !elem.backref {
+				addNodes(elem)
+			}
+		}
+	}
+	addNodes(result.root)
+
+	var addEdges func(node *treeNode)
+	addEdges = func(node *treeNode) {
+		for _, elem := range node.children {
+			if elem.backref {
+				// (a back reference to a page which didn't make it into the tree, say past MaxNodes, has nothing to point at)
+				if target, found := ids[elem.page]; found {
+					fmt.Fprintf(&out, "    %s -.-> %s\n", ids[node.page], target)
+				}
+				continue
+			}
+			fmt.Fprintf(&out, "    %s --> %s\n", ids[node.page], ids[elem.page])
+			addEdges(elem)
+		}
+	}
+	addEdges(result.root)
+
+	if result.omitted > 0 {
+		// hang the note off wherever the tree was cut short, so it isn't left floating on its own
+		parent := result.root
+		if result.truncatedAt != nil {
+			parent = result.truncatedAt
+		}
+		fmt.Fprintf(&out, "    more[\"... %d more pages not shown\"]\n", result.omitted)
+		fmt.Fprintf(&out, "    %s -.-> more\n", ids[parent.page])
+	}
+
+	if len(errorNodes) > 0 {
+		out.WriteString("    classDef crawlError fill:#fdd,stroke:#c00\n")
+		fmt.Fprintf(&out, "    class %s crawlError\n", strings.Join(errorNodes, ","))
+	}
+
+	return out.String()
+}
+
+func mermaidEscape(text string) string {
+	// mermaidEscape makes text safe to put inside a double quoted Mermaid label.
+	// Mermaid doesn't support backslash escapes, but it does understand HTML entity codes.
+	replacer := strings.NewReplacer(
+		`"`, "#quot;",
+		"\n", " ",
+	)
+	return replacer.Replace(text)
+}
@@ -0,0 +1,73 @@
+package displayTree
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+func TestStringMermaid(t *testing.T) {
+	mermaid := StringMermaid(genTestTree(), TreeOptions{DisplayBackrefs: true})
+
+	for _, expected := range []string{
+		"graph TD\n",
+		`n0["TestRoot"]`,
+		`click n0 "https://testsite.test/"`,
+		"n0 --> n1",
+		// nodes are numbered depth first, so elem3 (under elem1) comes before elem2
+		`n2["https://testsite.test/3 (parse error: test)"]`,
+		"class n2 crawlError",
+		// elem2 -> root is a backref, so it should point at the existing root node with a dotted line
+		"n3 -.-> n0",
+	} {
+		if !strings.Contains(mermaid, expected) {
+			t.Errorf("Mermaid output is missing %q:\n%s", expected, mermaid)
+		}
+	}
+}
+
+func TestMermaidEscape(t *testing.T) {
+	if escaped := mermaidEscape(`Say "hi"`); escaped != "Say #quot;hi#quot;" {
+		t.Errorf("mermaidEscape returned an unexpected result: expected %s, got %s", "Say #quot;hi#quot;", escaped)
+	}
+}
+
+func TestStringMermaidMaxNodes(t *testing.T) {
+	mermaid := StringMermaid(genTestTree(), TreeOptions{MaxNodes: 3})
+
+	// the tree is cut short while elem1's links are being added, so that's where the note goes
+	for _, expected := range []string{
+		`more["... 1 more pages not shown"]`,
+		"n1 -.-> more",
+	} {
+		if !strings.Contains(mermaid, expected) {
+			t.Errorf("Mermaid output is missing %q:\n%s", expected, mermaid)
+		}
+	}
+}
+
+func TestStringMermaidShortestPathBackrefs(t *testing.T) {
+	// root -> a, root -> b and a -> b: b's shortest path is straight from the root, so a's link to it is a back reference
+	// to a node which is only rendered after a's
+	page := func(path string) *crawl.HtmlPage {
+		return &crawl.HtmlPage{Url: &url.URL{Scheme: "https", Host: "testsite.test", Path: path}, Title: path, IsParsed: true}
+	}
+	root, a, b := page("/"), page("/a"), page("/b")
+	root.LinksTo = []*crawl.HtmlPage{a, b}
+	a.LinksTo = []*crawl.HtmlPage{b}
+	crawl.ComputeDepths(root)
+
+	mermaid := StringMermaid(root, TreeOptions{View: ShortestPathView, DisplayBackrefs: true})
+	for _, expected := range []string{"n0 --> n1", "n0 --> n2", `n2["/b`, "n1 -.-> n2"} {
+		if !strings.Contains(mermaid, expected) {
+			t.Errorf("Mermaid output is missing %q:\n%s", expected, mermaid)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(mermaid), "\n") {
+		if strings.HasSuffix(line, "-.-> ") || strings.HasSuffix(line, "--> ") {
+			t.Errorf("Mermaid output has an edge without a target: %q", line)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+// pathtree arranges pages by their URL path rather than by the links between them, like a file browser.
+// Each directory is annotated with how many pages (and how many broken pages) live underneath it.
+
+package displayTree
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/disiqueira/gotree"
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+type pathNode struct {
+	// pathNode is a single path segment (a "directory" or a "file").
+	name string
+
+	// pages are the pages whose path ends at this segment (there can be several if they differ by query string)
+	pages []*crawl.HtmlPage
+
+	children map[string]*pathNode
+
+	// pageCount and errorCount cover this segment and everything beneath it
+	pageCount  int
+	errorCount int
+}
+
+func buildPathTree(root *crawl.HtmlPage) *pathNode {
+	// buildPathTree files every page reachable from root under its URL path segments.
+	top := &pathNode{name: root.Url.Scheme + "://" + root.Url.Host + "/", children: map[string]*pathNode{}}
+
+	for _, node := range collectGraph(root, GraphOptions{}).nodes {
+		current := top
+		current.pageCount++
+		if node.page.IsBroken() {
+			current.errorCount++
+		}
+
+		for _, segment := range strings.Split(strings.Trim(node.page.Url.Path, "/"), "/") {
+			if segment == "" {
+				// this is the root path
+				continue
+			}
+
+			next, ok := current.children[segment]
+			if !ok {
+				next = &pathNode{name: segment, children: map[string]*pathNode{}}
+				current.children[segment] = next
+			}
+			current = next
+
+			current.pageCount++
+			if node.page.IsBroken() {
+				current.errorCount++
+			}
+		}
+
+		current.pages = append(current.pages, node.page)
+	}
+
+	return top
+}
+
+func pathTree(root *crawl.HtmlPage, opts TreeOptions) gotree.Tree {
+	// pathTree renders buildPathTree's output as a gotree tree.
+	// Like the other views, it stops adding entries once it hits opts.MaxNodes, and says how many pages were left out.
+	top := buildPathTree(root)
+	tree := gotree.New(pathNodeText(top))
+
+	shown := 1
+	// displayed counts the pages which made it into the tree (a directory without a page of its own doesn't count)
+	displayed := 0
+	if len(top.pages) == 1 {
+		displayed++
+	}
+	full := func() bool {
+		return opts.MaxNodes > 0 && shown >= opts.MaxNodes
+	}
+
+	var f func(node *pathNode, src gotree.Tree)
+	f = func(node *pathNode, src gotree.Tree) {
+		// several pages on one path (i.e different query strings) are listed individually
+		if len(node.pages) > 1 {
+			for _, page := range node.pages {
+				if full() {
+					return
+				}
+				src.Add(pathPageText("?"+page.Url.RawQuery, page))
+				shown++
+				displayed++
+			}
+		}
+
+		// children are sorted by name, like a file browser would
+		var names []string
+		for name := range node.children {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if full() {
+				return
+			}
+			child := node.children[name]
+			branch := src.Add(pathNodeText(child))
+			shown++
+			if len(child.pages) == 1 {
+				displayed++
+			}
+			f(child, branch)
+		}
+	}
+	f(top, tree)
+
+	if omitted := top.pageCount - displayed; full() && omitted > 0 {
+		tree.Add(fmt.Sprintf("... %d more pages not shown", omitted))
+	}
+
+	return tree
+}
+
+func pathNodeText(node *pathNode) string {
+	// pathNodeText is the label for a path segment: its name, its page (if it has exactly one),
+	// and page / error counts if it's a directory.
+	text := node.name
+	if len(node.children) > 0 && !strings.HasSuffix(text, "/") {
+		text += "/"
+	}
+
+	if len(node.pages) == 1 {
+		text = pathPageText(text, node.pages[0])
+	}
+
+	if len(node.children) > 0 {
+		text += fmt.Sprintf(" [%d pages, %d errors]", node.pageCount, node.errorCount)
+	}
+
+	return text
+}
+
+func pathPageText(name string, page *crawl.HtmlPage) string {
+	// pathPageText is the label for a single page in the path tree.
+	// error pages are still parsed (and carry an HTTP CrawlError), so the status is checked first
+	if page.StatusCode >= 400 && page.IsParsed {
+		return fmt.Sprintf("%s (%s) (HTTP %d)", name, page.Title, page.StatusCode)
+	}
+	if page.CrawlError != nil {
+		return fmt.Sprintf("%s (parse error: %s)", name, page.CrawlError)
+	}
+	if page.Trap != "" {
+		return fmt.Sprintf("%s (🪤 suspected trap: %s)", name, page.Trap)
+	}
+	return fmt.Sprintf("%s (%s)%s", name, page.Title, soft404Suffix(page))
+}
@@ -0,0 +1,106 @@
+package displayTree
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+func genTestPathTree() *crawl.HtmlPage {
+	// genTestPathTree builds a small site where the link structure and the path structure disagree
+	page := func(path string, title string) *crawl.HtmlPage {
+		return &crawl.HtmlPage{Url: &url.URL{Scheme: "https", Host: "testsite.test", Path: path}, Title: title, IsParsed: true}
+	}
+
+	root := page("/", "Root")
+	docs := page("/docs/", "Docs")
+	api := page("/docs/api/", "API")
+	v2 := page("/docs/api/v2", "API v2")
+	about := page("/about", "About")
+	broken := page("/docs/missing", "")
+	broken.IsParsed = false
+	broken.CrawlError = errors.New("test")
+
+	// v2 is linked straight from root, even though it lives two directories down
+	root.LinksTo = []*crawl.HtmlPage{v2, about, docs}
+	docs.LinksTo = []*crawl.HtmlPage{api, broken}
+	api.LinksTo = []*crawl.HtmlPage{v2}
+
+	crawl.ComputeDepths(root)
+	return root
+}
+
+func TestPathTree(t *testing.T) {
+	tree := pathTree(genTestPathTree(), TreeOptions{})
+
+	if tree.Text() != "https://testsite.test/ (Root) [6 pages, 1 errors]" {
+		t.Errorf("formatting on root node changed: expected %s, got %s", "https://testsite.test/ (Root) [6 pages, 1 errors]", tree.Text())
+	}
+
+	// children are sorted by name: about, then docs
+	items := tree.Items()
+	if len(items) != 2 {
+		t.Fatalf("root has the wrong number of sub entries: expected %d, got %d", 2, len(items))
+	}
+	if items[0].Text() != "about (About)" {
+		t.Errorf("unexpected first entry: expected %s, got %s", "about (About)", items[0].Text())
+	}
+	if items[1].Text() != "docs/ (Docs) [4 pages, 1 errors]" {
+		t.Errorf("unexpected second entry: expected %s, got %s", "docs/ (Docs) [4 pages, 1 errors]", items[1].Text())
+	}
+
+	docs := items[1].Items()
+	if len(docs) != 2 || docs[0].Text() != "api/ (API) [2 pages, 0 errors]" || docs[1].Text() != "missing (parse error: test)" {
+		t.Errorf("docs/ has unexpected sub entries")
+	}
+}
+
+func TestPathTreeMaxNodes(t *testing.T) {
+	// with room for three entries, the tree stops after docs/ and counts what it didn't get to
+	tree := pathTree(genTestPathTree(), TreeOptions{MaxNodes: 3})
+
+	items := tree.Items()
+	if len(items) != 3 || items[0].Text() != "about (About)" || items[1].Text() != "docs/ (Docs) [4 pages, 1 errors]" {
+		t.Fatalf("unexpected entries under the root with MaxNodes set: got %d", len(items))
+	}
+	if len(items[1].Items()) != 0 {
+		t.Errorf("docs/ shouldn't have any entries once the tree is full, got %d", len(items[1].Items()))
+	}
+	if items[2].Text() != "... 3 more pages not shown" {
+		t.Errorf("expected a note about the pages left out, got %s", items[2].Text())
+	}
+
+	// a cap bigger than the tree doesn't leave anything out
+	if items := pathTree(genTestPathTree(), TreeOptions{MaxNodes: 100}).Items(); len(items) != 2 {
+		t.Errorf("expected the whole tree with a generous MaxNodes, got %d entries under the root", len(items))
+	}
+}
+
+func TestShortestTree(t *testing.T) {
+	// in discovery order, v2 is reached straight from root; it should stay there in the shortest view,
+	// and api -> v2 should become a backref
+	result := walkTree(genTestPathTree(), TreeOptions{View: ShortestPathView, DisplayBackrefs: true})
+
+	if len(result.root.children) != 3 || result.root.children[0].page.Title != "API v2" {
+		t.Fatalf("root has unexpected children in shortest view")
+	}
+
+	api := result.root.children[2].children[0]
+	if api.page.Title != "API" || len(api.children) != 1 || !api.children[0].backref {
+		t.Errorf("API -> API v2 should be a backref in the shortest view")
+	}
+}
+
+func TestParseTreeView(t *testing.T) {
+	for name, expected := range map[string]TreeView{"discovery": DiscoveryView, "shortest": ShortestPathView, "path": PathView} {
+		if view, err := ParseTreeView(name); err != nil || view != expected {
+			t.Errorf("ParseTreeView(%q) returned an unexpected result: expected %d, got %d (%v)", name, expected, view, err)
+		}
+	}
+
+	if _, err := ParseTreeView("nonsense"); err == nil {
+		t.Error("ParseTreeView accepted an unknown view")
+	}
+}
@@ -0,0 +1,345 @@
+// report writes a self-contained HTML crawl report: summary statistics, a collapsible site tree,
+// a sortable page table, broken links and redirects, all in a single file with no external assets
+// (so it can be attached to tickets or kept as a CI artifact).
+
+package displayTree
+
+import (
+	"html/template"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+type reportData struct {
+	// reportData is everything the report template needs, worked out ahead of time so that the template stays dumb.
+	Root       string
+	Stats      reportStats
+	Tree       *reportTreeNode
+	Pages      []*reportPage
+	Broken     []*reportPage
+	Redirects  []*reportPage
+	Traps      []*reportPage
+	Duplicates []reportDuplicates
+	TLS        []reportTLSHost
+}
+
+type reportStats struct {
+	Pages       int
+	Parsed      int
+	Broken      int
+	Soft404s    int
+	Redirected  int
+	Quarantined int
+	Cached      int
+	Revalidated int
+	Links       int
+	MaxDepth    int
+	Statuses    []reportStatusCount
+	ErrorKinds  []reportErrorKindCount
+}
+
+type reportStatusCount struct {
+	Status int
+	Count  int
+}
+
+type reportErrorKindCount struct {
+	Kind  string
+	Count int
+}
+
+type reportPage struct {
+	// reportPage is a single row in the page table (and the broken link / redirect lists).
+	Url       string
+	Title     string
+	Status    int
+	Depth     int
+	Inlinks   int
+	Error     string
+	ErrorKind string
+	Attempts  int
+	Trap      string
+	Sources   []string
+	Redirects []crawl.Redirect
+}
+
+type reportDuplicates struct {
+	// reportDuplicates is a row in the duplicate content table: a cluster of pages with the same (or nearly the same) text.
+	Exact bool
+	Pages []*reportPage
+}
+
+type reportTLSHost struct {
+	// reportTLSHost is a row in the TLS table: what a host's connection and certificate looked like.
+	Host       string
+	Version    string
+	CertIssuer string
+	CertExpiry string
+	DaysLeft   int
+	Expiring   bool
+}
+
+// tlsExpiryWarning is how soon a certificate has to expire to be highlighted in the report
+const tlsExpiryWarning = 30 * 24 * time.Hour
+
+type reportTreeNode struct {
+	// reportTreeNode mirrors treeNode, with exported fields for the template.
+	Url         string
+	Title       string
+	Backref     bool
+	Trap        string
+	DuplicateOf string
+	Error       string
+	Children    []*reportTreeNode
+}
+
+func WriteReport(w io.Writer, root *crawl.HtmlPage) error {
+	// WriteReport writes a single page HTML report about the crawl rooted at root.
+	// The site tree is built with walkTree, with back references shown, so it matches the console output.
+
+	graph := collectGraph(root, GraphOptions{})
+	data := &reportData{Root: root.Url.String()}
+
+	// rows maps each page to its table row, so that edges can fill in inlinks and sources
+	rows := map[*crawl.HtmlPage]*reportPage{}
+	statuses := map[int]int{}
+	errorKinds := map[crawl.ErrorKind]int{}
+
+	for _, node := range graph.nodes {
+		row := &reportPage{
+			Url:       node.page.Url.String(),
+			Title:     node.page.Title,
+			Status:    node.page.StatusCode,
+			Depth:     node.depth,
+			Error:     nodeError(node.page),
+			Attempts:  len(node.page.Attempts),
+			Trap:      node.page.Trap,
+			Redirects: node.page.Redirects,
+		}
+		if node.page.CrawlError != nil {
+			kind := crawl.ErrorKindOf(node.page.CrawlError)
+			row.ErrorKind = kind.String()
+			errorKinds[kind]++
+		} else if node.page.Soft404 != "" {
+			row.ErrorKind = "soft 404"
+			data.Stats.Soft404s++
+		}
+		rows[node.page] = row
+		data.Pages = append(data.Pages, row)
+
+		statuses[node.page.StatusCode]++
+		if node.page.IsParsed {
+			data.Stats.Parsed++
+		}
+		if node.depth > data.Stats.MaxDepth {
+			data.Stats.MaxDepth = node.depth
+		}
+		if node.page.IsBroken() {
+			data.Broken = append(data.Broken, row)
+		}
+		if len(node.page.Redirects) > 0 {
+			data.Redirects = append(data.Redirects, row)
+		}
+		if node.page.Trap != "" {
+			data.Traps = append(data.Traps, row)
+		}
+		switch node.page.Cache {
+		case crawl.CacheHit:
+			data.Stats.Cached++
+		case crawl.CacheRevalidated:
+			data.Stats.Revalidated++
+		}
+	}
+
+	for _, edge := range graph.edges {
+		target := rows[edge.target.page]
+		target.Inlinks++
+		target.Sources = append(target.Sources, edge.source.page.Url.String())
+	}
+
+	data.Stats.Pages = len(graph.nodes)
+	data.Stats.Links = len(graph.edges)
+	data.Stats.Broken = len(data.Broken)
+	data.Stats.Redirected = len(data.Redirects)
+	data.Stats.Quarantined = len(data.Traps)
+
+	for status, count := range statuses {
+		data.Stats.Statuses = append(data.Stats.Statuses, reportStatusCount{Status: status, Count: count})
+	}
+	sort.Slice(data.Stats.Statuses, func(i, j int) bool {
+		return data.Stats.Statuses[i].Status < data.Stats.Statuses[j].Status
+	})
+
+	// error kinds are listed in the order they're declared in, which runs roughly from the network up to the page
+	for kind := crawl.ErrorOther; kind <= crawl.ErrorParse; kind++ {
+		if errorKinds[kind] > 0 {
+			data.Stats.ErrorKinds = append(data.Stats.ErrorKinds, reportErrorKindCount{Kind: kind.String(), Count: errorKinds[kind]})
+		}
+	}
+
+	for _, cluster := range crawl.Duplicates(root, crawl.DefaultNearDuplicateDistance) {
+		duplicates := reportDuplicates{Exact: cluster.Exact}
+		for _, page := range cluster.Pages {
+			if row, exists := rows[page]; exists {
+				duplicates.Pages = append(duplicates.Pages, row)
+			}
+		}
+		data.Duplicates = append(data.Duplicates, duplicates)
+	}
+
+	for host, info := range crawl.HostTLS(root) {
+		remaining := time.Until(info.CertExpiry)
+		data.TLS = append(data.TLS, reportTLSHost{
+			Host:       host,
+			Version:    info.Version,
+			CertIssuer: info.CertIssuer,
+			CertExpiry: info.CertExpiry.Format("2006-01-02"),
+			DaysLeft:   int(remaining.Hours() / 24),
+			Expiring:   remaining < tlsExpiryWarning,
+		})
+	}
+	sort.Slice(data.TLS, func(i, j int) bool {
+		return data.TLS[i].Host < data.TLS[j].Host
+	})
+
+	data.Tree = reportTree(walkTree(root, TreeOptions{DisplayBackrefs: true}).root)
+
+	return reportTemplate.Execute(w, data)
+}
+
+func reportTree(node *treeNode) *reportTreeNode {
+	// reportTree converts a walkTree tree into one the report template can read.
+	result := &reportTreeNode{
+		Url:         node.page.Url.String(),
+		Title:       node.page.Title,
+		Backref:     node.backref,
+		Trap:        node.page.Trap,
+		DuplicateOf: node.page.DuplicateOf,
+		Error:       nodeError(node.page),
+	}
+	for _, child := range node.children {
+		result.Children = append(result.Children, reportTree(child))
+	}
+	return result
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Crawl report: {{.Root}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.2em; margin-top: 2em; border-bottom: 1px solid #ccc; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 0.25em 0.5em; border-bottom: 1px solid #eee; vertical-align: top; }
+th { cursor: pointer; background: #f4f4f4; user-select: none; }
+th.sorted-asc::after { content: " \25B2"; }
+th.sorted-desc::after { content: " \25BC"; }
+.stats td:first-child { font-weight: bold; }
+.error { color: #c00; }
+.backref { color: #888; }
+details { margin-left: 1.2em; }
+details.leaf > summary { list-style: none; }
+summary { cursor: pointer; }
+ul { margin: 0; }
+</style>
+</head>
+<body>
+<h1>Crawl report: <a href="{{.Root}}">{{.Root}}</a></h1>
+
+<h2>Summary</h2>
+<table class="stats">
+<tr><td>Pages</td><td>{{.Stats.Pages}}</td></tr>
+<tr><td>Parsed</td><td>{{.Stats.Parsed}}</td></tr>
+<tr><td>Links</td><td>{{.Stats.Links}}</td></tr>
+<tr><td>Broken</td><td>{{.Stats.Broken}}</td></tr>
+{{if .Stats.Soft404s}}<tr><td>Soft 404s (error pages served as 200)</td><td>{{.Stats.Soft404s}}</td></tr>
+{{end}}<tr><td>Redirected</td><td>{{.Stats.Redirected}}</td></tr>
+{{if .Stats.Quarantined}}<tr><td>Quarantined (suspected traps)</td><td>{{.Stats.Quarantined}}</td></tr>
+{{end}}{{if or .Stats.Cached .Stats.Revalidated}}<tr><td>From cache</td><td>{{.Stats.Cached}}</td></tr>
+<tr><td>Revalidated (not modified)</td><td>{{.Stats.Revalidated}}</td></tr>
+{{end}}<tr><td>Maximum depth</td><td>{{.Stats.MaxDepth}}</td></tr>
+{{range .Stats.Statuses}}<tr><td>Status {{if .Status}}{{.Status}}{{else}}(not fetched){{end}}</td><td>{{.Count}}</td></tr>
+{{end}}{{range .Stats.ErrorKinds}}<tr><td>Errors ({{.Kind}})</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+
+<h2>Site tree</h2>
+{{template "node" .Tree}}
+
+<h2>Pages</h2>
+<table class="sortable">
+<thead><tr><th>URL</th><th>Title</th><th data-type="number">Status</th><th data-type="number">Depth</th><th data-type="number">Inlinks</th></tr></thead>
+<tbody>
+{{range .Pages}}<tr{{if .Error}} class="error"{{end}}><td><a href="{{.Url}}">{{.Url}}</a></td><td>{{.Title}}</td><td>{{.Status}}</td><td>{{.Depth}}</td><td>{{.Inlinks}}</td></tr>
+{{end}}</tbody>
+</table>
+
+<h2>Broken links</h2>
+{{if .Broken}}<table>
+<thead><tr><th>URL</th><th>Status</th><th>Kind</th><th>Attempts</th><th>Error</th><th>Linked from</th></tr></thead>
+<tbody>
+{{range .Broken}}<tr><td><a href="{{.Url}}">{{.Url}}</a></td><td>{{.Status}}</td><td>{{.ErrorKind}}</td><td>{{.Attempts}}</td><td class="error">{{.Error}}</td><td><ul>{{range .Sources}}<li><a href="{{.}}">{{.}}</a></li>{{end}}</ul></td></tr>
+{{end}}</tbody>
+</table>{{else}}<p>None! 🎉</p>{{end}}
+
+<h2>Redirects</h2>
+{{if .Redirects}}<table>
+<thead><tr><th>URL</th><th>Redirect chain</th><th>Final status</th></tr></thead>
+<tbody>
+{{range .Redirects}}<tr><td><a href="{{.Url}}">{{.Url}}</a></td><td><ul>{{range .Redirects}}<li>{{.StatusCode}} &rarr; <a href="{{.Url}}">{{.Url}}</a></li>{{end}}</ul></td><td>{{.Status}}</td></tr>
+{{end}}</tbody>
+</table>{{else}}<p>None.</p>{{end}}
+
+{{if .Traps}}<h2>Suspected crawler traps 🪤</h2>
+<p>These links looked like they'd lead the crawl round in circles forever, so they weren't followed.</p>
+<table>
+<thead><tr><th>URL</th><th>Why</th><th>Linked from</th></tr></thead>
+<tbody>
+{{range .Traps}}<tr><td><a href="{{.Url}}">{{.Url}}</a></td><td>{{.Trap}}</td><td><ul>{{range .Sources}}<li><a href="{{.}}">{{.}}</a></li>{{end}}</ul></td></tr>
+{{end}}</tbody>
+</table>
+{{end}}
+{{if .Duplicates}}<h2>Duplicate content ♊</h2>
+<p>These pages have exactly the same main text, or (for near duplicates) very nearly the same.</p>
+<table>
+<thead><tr><th>Match</th><th>Pages</th></tr></thead>
+<tbody>
+{{range .Duplicates}}<tr><td>{{if .Exact}}exact{{else}}near{{end}}</td><td><ul>{{range .Pages}}<li><a href="{{.Url}}">{{.Url}}</a>{{if .Title}} ({{.Title}}){{end}}</li>{{end}}</ul></td></tr>
+{{end}}</tbody>
+</table>
+{{end}}
+{{if .TLS}}<h2>TLS</h2>
+<table class="sortable">
+<thead><tr><th>Host</th><th>Version</th><th>Certificate issuer</th><th>Certificate expires</th><th data-type="number">Days left</th></tr></thead>
+<tbody>
+{{range .TLS}}<tr{{if .Expiring}} class="error"{{end}}><td>{{.Host}}</td><td>{{.Version}}</td><td>{{.CertIssuer}}</td><td>{{.CertExpiry}}</td><td>{{.DaysLeft}}</td></tr>
+{{end}}</tbody>
+</table>
+{{end}}
+<script>
+// sort a table by whichever column header is clicked (clicking again reverses it)
+document.querySelectorAll("table.sortable th").forEach(function (th, column) {
+	th.addEventListener("click", function () {
+		var tbody = th.closest("table").querySelector("tbody");
+		var ascending = !th.classList.contains("sorted-asc");
+		var numeric = th.dataset.type === "number";
+		th.parentNode.querySelectorAll("th").forEach(function (other) { other.classList.remove("sorted-asc", "sorted-desc"); });
+		th.classList.add(ascending ? "sorted-asc" : "sorted-desc");
+		Array.from(tbody.rows).sort(function (a, b) {
+			var x = a.cells[column].textContent, y = b.cells[column].textContent;
+			var result = numeric ? Number(x) - Number(y) : x.localeCompare(y);
+			return ascending ? result : -result;
+		}).forEach(function (row) { tbody.appendChild(row); });
+	});
+});
+</script>
+</body>
+</html>
+{{define "node"}}<details open{{if not .Children}} class="leaf"{{end}}><summary{{if .Backref}} class="backref"{{else if .Error}} class="error"{{end}}><a href="{{.Url}}">{{.Url}}</a> {{if .Title}}({{.Title}}){{end}}{{if .Backref}} (🔙 lower or already parsed page){{end}}{{if .Trap}} (🪤 suspected trap: {{.Trap}}){{end}}{{if .DuplicateOf}} (♊ duplicate of {{.DuplicateOf}}, links not followed){{end}}{{if .Error}} (error: {{.Error}}){{end}}</summary>
+{{range .Children}}{{template "node" .}}{{end}}</details>
+{{end}}`))
@@ -0,0 +1,109 @@
+package displayTree
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+func TestWriteReport(t *testing.T) {
+	root := genTestTree()
+
+	// make element2 a redirect, so the redirect table has something in it
+	element2 := root.LinksTo[1]
+	element2.Redirects = []crawl.Redirect{{StatusCode: 301, Url: &url.URL{Scheme: "https", Host: "testsite.test", Path: "/2/"}}}
+
+	// and give the root a certificate which is about to expire
+	expiry := time.Now().Add(10*24*time.Hour + time.Hour)
+	root.TLS = &crawl.TLSInfo{Version: "TLS 1.3", CertIssuer: "Test CA", CertExpiry: expiry}
+
+	var out bytes.Buffer
+	if err := WriteReport(&out, root); err != nil {
+		t.Fatalf("WriteReport returned an error: %s", err)
+	}
+
+	report := out.String()
+	for _, expected := range []string{
+		"<tr><td>Pages</td><td>4</td></tr>",
+		"<tr><td>Broken</td><td>1</td></tr>",
+		"<tr><td>Redirected</td><td>1</td></tr>",
+		// elem3 is broken, and linked from elem1
+		`<td class="error">test</td><td><ul><li><a href="https://testsite.test/1">https://testsite.test/1</a></li></ul></td>`,
+		`<li>301 &rarr; <a href="https://testsite.test/2/">https://testsite.test/2/</a></li>`,
+		// root is linked to by elem2
+		`<td><a href="https://testsite.test/">https://testsite.test/</a></td><td>TestRoot</td><td>0</td><td>0</td><td>1</td>`,
+		"(🔙 lower or already parsed page)",
+		`<tr class="error"><td>testsite.test</td><td>TLS 1.3</td><td>Test CA</td><td>` + expiry.Format("2006-01-02") + `</td><td>10</td></tr>`,
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("report is missing %q", expected)
+		}
+	}
+
+	// suspected traps get a table of their own, which isn't there at all when there aren't any
+	if strings.Contains(report, "Suspected crawler traps") {
+		t.Error("report has a traps table without any traps")
+	}
+	trap := &crawl.HtmlPage{Url: &url.URL{Scheme: "https", Host: "testsite.test", Path: "/a/a/a"}, Trap: `path segment "a" repeats 3 times`}
+	root.LinksTo = append(root.LinksTo, trap)
+	out.Reset()
+	if err := WriteReport(&out, root); err != nil {
+		t.Fatalf("WriteReport returned an error: %s", err)
+	}
+	for _, expected := range []string{
+		"<tr><td>Quarantined (suspected traps)</td><td>1</td></tr>",
+		`<td><a href="https://testsite.test/a/a/a">https://testsite.test/a/a/a</a></td><td>path segment &#34;a&#34; repeats 3 times</td><td><ul><li><a href="https://testsite.test/">https://testsite.test/</a></li></ul></td>`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("report is missing %q", expected)
+		}
+	}
+
+	// as do pages with duplicate content
+	if strings.Contains(out.String(), "Duplicate content") {
+		t.Error("report has a duplicate content table without any duplicates")
+	}
+	element1 := root.LinksTo[0]
+	element1.Fingerprint = crawl.Fingerprint{Hash: "same", SimHash: 0xff00}
+	element2.Fingerprint = crawl.Fingerprint{Hash: "same", SimHash: 0xff00}
+	element2.DuplicateOf = element1.Url.String()
+	out.Reset()
+	if err := WriteReport(&out, root); err != nil {
+		t.Fatalf("WriteReport returned an error: %s", err)
+	}
+	for _, expected := range []string{
+		`<tr><td>exact</td><td><ul><li><a href="https://testsite.test/1">https://testsite.test/1</a> (TestElem1)</li><li><a href="https://testsite.test/2">https://testsite.test/2</a> (TestElem2)</li></ul></td></tr>`,
+		"(♊ duplicate of https://testsite.test/1, links not followed)",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("report is missing %q", expected)
+		}
+	}
+
+	// soft 404s count as broken, and say why
+	element1.Soft404 = `title "Page not found" matches /not found/`
+	out.Reset()
+	if err := WriteReport(&out, root); err != nil {
+		t.Fatalf("WriteReport returned an error: %s", err)
+	}
+	for _, expected := range []string{
+		"<tr><td>Broken</td><td>2</td></tr>",
+		"<tr><td>Soft 404s (error pages served as 200)</td><td>1</td></tr>",
+		`<td>soft 404</td><td>0</td><td class="error">soft 404: title &#34;Page not found&#34; matches /not found/</td>`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("report is missing %q", expected)
+		}
+	}
+
+	// the whole point is that it's self contained
+	for _, unexpected := range []string{"<link ", "<script src", "<img "} {
+		if strings.Contains(out.String(), unexpected) {
+			t.Errorf("report references an external asset (%q)", unexpected)
+		}
+	}
+}
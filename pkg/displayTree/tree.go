@@ -0,0 +1,209 @@
+// tree contains the walk shared by all of the tree-shaped renderers (console, Mermaid and Markdown).
+// It turns a HtmlPage graph into a plain tree once, so that each renderer only has to worry about formatting.
+
+package displayTree
+
+import (
+	"fmt"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+type TreeView int
+
+const (
+	// DiscoveryView arranges pages in the order the crawler found them (this is the original tree).
+	DiscoveryView TreeView = iota
+
+	// ShortestPathView arranges pages by their shortest click path from the root, found with a breadth first search.
+	ShortestPathView
+
+	// PathView arranges pages by their URL path segments, like a file browser. Only the console tree supports it.
+	PathView
+)
+
+type TreeOptions struct {
+	// TreeOptions controls which pages make it into a rendered tree, and how they're arranged.
+
+	// View picks how the tree is arranged (DiscoveryView if unset).
+	View TreeView
+
+	// DisplayBackrefs adds entries for links to pages which have already been displayed elsewhere in the tree.
+	DisplayBackrefs bool
+
+	// ShowDepth annotates each entry with its shortest click depth from the root.
+	ShowDepth bool
+
+	// MaxNodes stops the tree growing past this many entries, so that large sites stay readable.
+	// 0 means no limit.
+	MaxNodes int
+}
+
+type treeNode struct {
+	// treeNode is a single entry in a rendered tree.
+	page *crawl.HtmlPage
+
+	// backref is set if this entry is a link to a page which is displayed elsewhere in the tree
+	backref bool
+
+	// depth is the page's shortest click depth from the root (which isn't necessarily how deep it is in this tree)
+	depth int
+
+	children []*treeNode
+}
+
+type pageTreeResult struct {
+	// pageTreeResult is the output of walkTree.
+	root *treeNode
+
+	// omitted is the number of pages which were left out because the tree hit TreeOptions.MaxNodes
+	omitted int
+
+	// truncatedAt is the entry whose links were being added when the tree hit TreeOptions.MaxNodes
+	// (so it's where the missing pages would have started), or nil if nothing was left out
+	truncatedAt *treeNode
+}
+
+func ParseTreeView(view string) (TreeView, error) {
+	// ParseTreeView turns the name of a view, as used on the command line, into a TreeView.
+	switch view {
+	case "discovery":
+		return DiscoveryView, nil
+	case "shortest":
+		return ShortestPathView, nil
+	case "path":
+		return PathView, nil
+	}
+	return DiscoveryView, fmt.Errorf("unknown view %q (expected discovery, shortest or path)", view)
+}
+
+func walkTree(page *crawl.HtmlPage, opts TreeOptions) *pageTreeResult {
+	// walkTree builds a tree from the given HtmlPage, arranged as opts.View asks.
+	// Depths (and the shortest path tree) come from each page's Depth and DepthParent, as worked out by crawl.ComputeDepths
+	// when the crawl finished (or was loaded), so that the trees always agree with what the crawl recorded.
+	// PathView isn't a tree of links at all, so it's handled separately by pathTree, and falls back to discovery order here.
+	if opts.View == ShortestPathView {
+		return shortestTree(page, opts)
+	}
+	return discoveryTree(page, opts)
+}
+
+func discoveryTree(page *crawl.HtmlPage, opts TreeOptions) *pageTreeResult {
+	// discoveryTree builds a tree from the given HtmlPage in link order, visiting every successfully parsed page once.
+
+	// This isn't the most performant thing on the planet (it's not async, for one), but it's only here
+	// because we need some way to dump the data in a human readable format.
+
+	// a map is absolutely overkill here, but it saves having to write a custom array search function
+	// stuff is deduplicated for us beforehand by the crawler (hence it's safe to do lookups by pointer)
+	allPages := map[*crawl.HtmlPage]bool{
+		page: true,
+	}
+
+	// displayed tracks every page with an entry in the tree (including ones we couldn't parse), for counting what got cut off
+	displayed := map[*crawl.HtmlPage]bool{
+		page: true,
+	}
+
+	result := &pageTreeResult{root: &treeNode{page: page}}
+	shown := 1
+
+	// full returns true once we've hit the node cap
+	full := func() bool {
+		return opts.MaxNodes > 0 && shown >= opts.MaxNodes
+	}
+
+	var f func(src *treeNode)
+	f = func(src *treeNode) {
+		// iterateStack is an array of recursion targets
+		// this will be run after we have finished dealing with everything on the current layer
+		var iterateStack []*treeNode
+		for _, elem := range src.page.LinksTo {
+			if full() {
+				if result.truncatedAt == nil {
+					result.truncatedAt = src
+				}
+				break
+			}
+			if allPages[elem] {
+				// we have already found this index, so do not recurse
+				if opts.DisplayBackrefs {
+					src.children = append(src.children, &treeNode{page: elem, backref: true, depth: elem.Depth})
+					shown++
+				}
+				continue
+			}
+
+			// we haven't yet found this index, add it to the stack and keep recursing
+			// (but only if we were able to parse it)
+			subpage := &treeNode{page: elem, depth: elem.Depth}
+			src.children = append(src.children, subpage)
+			displayed[elem] = true
+			shown++
+
+			if elem.IsParsed {
+				allPages[elem] = true
+				iterateStack = append(iterateStack, subpage)
+			}
+		}
+
+		for _, elem := range iterateStack {
+			f(elem)
+		}
+	}
+	f(result.root)
+
+	if full() {
+		// count how many pages we never got to, so renderers can say so
+		result.omitted = len(crawl.Pages(page)) - len(displayed)
+	}
+
+	return result
+}
+
+func shortestTree(page *crawl.HtmlPage, opts TreeOptions) *pageTreeResult {
+	// shortestTree builds a tree where every page hangs off its DepthParent (the page the crawler's breadth first search
+	// first reached it from), so each page's position in the tree is its shortest click path from the root.
+	// Links which aren't part of the tree are shown as back references (if asked for).
+
+	result := &pageTreeResult{root: &treeNode{page: page, depth: page.Depth}}
+	shown := 1
+	displayed := map[*crawl.HtmlPage]bool{page: true}
+
+	// the tree is filled in breadth first, so it fills up a layer at a time, and the node cap cuts off the deepest pages first
+	queue := []*treeNode{result.root}
+	for len(queue) > 0 && result.truncatedAt == nil {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, link := range current.page.LinksTo {
+			if opts.MaxNodes > 0 && shown >= opts.MaxNodes {
+				result.truncatedAt = current
+				break
+			}
+
+			if link.DepthParent == current.page && !displayed[link] {
+				child := &treeNode{page: link, depth: link.Depth}
+				current.children = append(current.children, child)
+				displayed[link] = true
+				queue = append(queue, child)
+				shown++
+			} else if opts.DisplayBackrefs {
+				current.children = append(current.children, &treeNode{page: link, backref: true, depth: link.Depth})
+				shown++
+			}
+		}
+	}
+
+	result.omitted = len(crawl.Pages(page)) - len(displayed)
+
+	return result
+}
+
+func depthSuffix(node *treeNode, opts TreeOptions) string {
+	// depthSuffix returns the depth annotation for an entry, or an empty string if opts.ShowDepth is off.
+	if !opts.ShowDepth {
+		return ""
+	}
+	return fmt.Sprintf(" [depth %d]", node.depth)
+}
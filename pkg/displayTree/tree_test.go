@@ -0,0 +1,87 @@
+package displayTree
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+func TestWalkTreeBackrefs(t *testing.T) {
+	result := walkTree(genTestTree(), TreeOptions{DisplayBackrefs: true})
+
+	if len(result.root.children) != 2 {
+		t.Fatalf("root has the wrong number of children: expected %d, got %d", 2, len(result.root.children))
+	}
+
+	// element2 links back to root, which should be marked as a backref rather than recursed into
+	backref := result.root.children[1].children[0]
+	if !backref.backref || backref.page.Title != "TestRoot" {
+		t.Errorf("TestElem2->TestRoot was not marked as a backref")
+	}
+
+	result = walkTree(genTestTree(), TreeOptions{DisplayBackrefs: false})
+	if len(result.root.children[1].children) != 0 {
+		t.Errorf("backrefs were displayed even though DisplayBackrefs was off")
+	}
+}
+
+func TestWalkTreeMaxNodes(t *testing.T) {
+	result := walkTree(genTestTree(), TreeOptions{MaxNodes: 2})
+
+	if len(result.root.children) != 1 {
+		t.Errorf("node cap was not respected: expected %d child, got %d", 1, len(result.root.children))
+	}
+
+	// elem2 and elem3 never made it in
+	if result.omitted != 2 {
+		t.Errorf("omitted count is wrong: expected %d, got %d", 2, result.omitted)
+	}
+}
+
+func TestStringTreeShowDepth(t *testing.T) {
+	tree := StringTree(genTestPathTree(), TreeOptions{View: ShortestPathView, ShowDepth: true})
+
+	// v2 is linked from root, so it's at depth 1 despite living at /docs/api/v2
+	for _, expected := range []string{
+		"https://testsite.test/ (Root) [depth 0]",
+		"https://testsite.test/docs/api/v2 (API v2) [depth 1]",
+		"https://testsite.test/docs/api/ (API) [depth 2]",
+		"https://testsite.test/docs/missing (parse error: test) [depth 2]",
+	} {
+		if !strings.Contains(tree, expected) {
+			t.Errorf("tree output is missing %q:\n%s", expected, tree)
+		}
+	}
+}
+
+func TestShortestTreeUsesRecordedParents(t *testing.T) {
+	// c is one click from both a and b, so either could be its parent; the tree should go with the one the crawl recorded,
+	// rather than working it out again (and maybe disagreeing)
+	page := func(path string, depth int) *crawl.HtmlPage {
+		return &crawl.HtmlPage{Url: &url.URL{Scheme: "https", Host: "testsite.test", Path: path}, Title: path, IsParsed: true, Depth: depth}
+	}
+	root, a, b, c := page("/", 0), page("/a", 1), page("/b", 1), page("/c", 2)
+	root.LinksTo = []*crawl.HtmlPage{a, b}
+	a.LinksTo = []*crawl.HtmlPage{c}
+	b.LinksTo = []*crawl.HtmlPage{c}
+	a.DepthParent, b.DepthParent, c.DepthParent = root, root, b
+
+	result := walkTree(root, TreeOptions{View: ShortestPathView, DisplayBackrefs: true})
+	if len(result.root.children) != 2 {
+		t.Fatalf("root has the wrong number of children: expected %d, got %d", 2, len(result.root.children))
+	}
+	underA, underB := result.root.children[0].children, result.root.children[1].children
+	if len(underA) != 1 || !underA[0].backref || len(underB) != 1 || underB[0].backref || underB[0].page != c {
+		t.Errorf("expected c to hang off its recorded parent b (with a backref from a)")
+	}
+	if underB[0].depth != 2 {
+		t.Errorf("expected c's recorded depth of 2, got %d", underB[0].depth)
+	}
+
+	// the discovery view reports the recorded depths too
+	if depth := walkTree(root, TreeOptions{}).root.children[0].children[0].depth; depth != 2 {
+		t.Errorf("expected the discovery view to report c's recorded depth of 2, got %d", depth)
+	}
+}
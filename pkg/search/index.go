@@ -0,0 +1,291 @@
+// search builds a full text index over a crawl's pages, so that a crawled site can be searched offline.
+// The index is an inverted index (each word, with the pages it's in and how many times), and pages are ranked with BM25.
+
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+// indexFileVersion is bumped whenever the saved format changes in a way older versions can't read
+const indexFileVersion = 1
+
+// the usual BM25 settings: k1 is how quickly repeats of a word stop counting for more,
+// and b is how much long pages are marked down for being long
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+type Document struct {
+	// A 'Document' is a page in the index.
+	Url   string `json:"url"`
+	Title string `json:"title,omitempty"`
+
+	// Length is how many words the page has (its title included)
+	Length int `json:"length"`
+}
+
+type posting struct {
+	// posting is an entry in a word's list of pages: which page (an index into Index.Documents), and how many times the word is in it.
+	document  int
+	frequency int
+}
+
+type Index struct {
+	// An 'Index' is a full text index of a crawl's pages. Build one with Build, and save it alongside the crawl with SaveFile.
+
+	// Seed and FinishedAt are copied from the crawl the index was built from, so that it can be matched back up to it
+	Seed       string
+	FinishedAt time.Time
+
+	// Documents are the indexed pages, in breadth first order
+	Documents []Document
+
+	// postings maps each word to the pages it's in, in document order
+	postings map[string][]posting
+
+	// totalLength is the sum of the documents' lengths
+	totalLength int
+}
+
+type Result struct {
+	// A 'Result' is a page matching a search, and how well it matched.
+	Document
+	Score float64
+}
+
+func Build(c *crawl.Crawl, textPath string) (*Index, error) {
+	// Build indexes the words in the titles and main text of every page in the crawl which was parsed and isn't broken
+	// (error pages and soft 404s would only clutter up the results). The pages' text comes from the text file at textPath
+	// (see crawl.TextPath), which is streamed through rather than read in all at once; if textPath is "", only titles are indexed.
+	index := &Index{Seed: c.Seed, FinishedAt: c.FinishedAt, postings: map[string][]posting{}}
+
+	pages := crawl.Pages(c.Root)
+	indexed := map[string]bool{}
+	for _, page := range pages {
+		if page.IsParsed && !page.IsBroken() {
+			indexed[page.Url.String()] = true
+		}
+	}
+
+	// as the text is read, each page's words are counted up, and the text itself thrown away
+	counts := map[string]*wordCounts{}
+	if textPath != "" {
+		err := crawl.ReadTextFile(textPath, func(pageUrl string, text string) error {
+			if indexed[pageUrl] {
+				counts[pageUrl] = countWords(crawl.Words(text))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// pages go in breadth first order, whatever order their text was written in
+	for _, page := range pages {
+		pageUrl := page.Url.String()
+		if !indexed[pageUrl] {
+			continue
+		}
+		pageCounts := counts[pageUrl]
+		delete(counts, pageUrl)
+		if pageCounts == nil {
+			pageCounts = countWords(nil)
+		}
+		pageCounts.add(crawl.Words(page.Title))
+		if pageCounts.length == 0 {
+			continue
+		}
+
+		document := len(index.Documents)
+		index.Documents = append(index.Documents, Document{Url: pageUrl, Title: page.Title, Length: pageCounts.length})
+		index.totalLength += pageCounts.length
+
+		for word, frequency := range pageCounts.frequencies {
+			index.postings[word] = append(index.postings[word], posting{document: document, frequency: frequency})
+		}
+	}
+
+	return index, nil
+}
+
+type wordCounts struct {
+	// wordCounts is how many times each word is in a page, and how many words it has altogether.
+	frequencies map[string]int
+	length      int
+}
+
+func countWords(words []string) *wordCounts {
+	counts := &wordCounts{frequencies: map[string]int{}}
+	counts.add(words)
+	return counts
+}
+
+func (c *wordCounts) add(words []string) {
+	for _, word := range words {
+		c.frequencies[word]++
+	}
+	c.length += len(words)
+}
+
+func (index *Index) Search(query string, limit int) []Result {
+	// Search returns the pages matching any of the words in query, best match first, ranked with BM25.
+	// Pages matching more of the words (and rarer ones) rank higher. Results are cut off after limit (0 means no limit).
+	if len(index.Documents) == 0 {
+		return nil
+	}
+	averageLength := float64(index.totalLength) / float64(len(index.Documents))
+
+	scores := map[int]float64{}
+	seen := map[string]bool{}
+	for _, word := range crawl.Words(query) {
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+
+		postings := index.postings[word]
+		// (this form of IDF never goes negative, even for words which are on nearly every page)
+		idf := math.Log(1 + (float64(len(index.Documents))-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+		for _, posting := range postings {
+			frequency := float64(posting.frequency)
+			length := float64(index.Documents[posting.document].Length)
+			scores[posting.document] += idf * frequency * (k1 + 1) / (frequency + k1*(1-b+b*length/averageLength))
+		}
+	}
+
+	var results []Result
+	for document, score := range scores {
+		results = append(results, Result{Document: index.Documents[document], Score: score})
+	}
+	// ties go to the page closest to the root, which is the order the documents are in
+	order := map[string]int{}
+	for i, document := range index.Documents {
+		order[document.Url] = i
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return order[results[i].Url] < order[results[j].Url]
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+func (index *Index) Words() int {
+	// Words returns how many different words are in the index.
+	return len(index.postings)
+}
+
+// indexFile is the on-disk representation of an Index. Postings are stored as [document, frequency] pairs.
+type indexFile struct {
+	Version    int                 `json:"version"`
+	Seed       string              `json:"seed"`
+	FinishedAt time.Time           `json:"finishedAt"`
+	Documents  []Document          `json:"documents"`
+	Postings   map[string][][2]int `json:"postings"`
+}
+
+func (index *Index) Save(w io.Writer) error {
+	// Save writes the index to w as JSON.
+	file := indexFile{
+		Version:    indexFileVersion,
+		Seed:       index.Seed,
+		FinishedAt: index.FinishedAt,
+		Documents:  index.Documents,
+		Postings:   map[string][][2]int{},
+	}
+	if file.Documents == nil {
+		file.Documents = []Document{}
+	}
+	for word, postings := range index.postings {
+		pairs := make([][2]int, len(postings))
+		for i, posting := range postings {
+			pairs[i] = [2]int{posting.document, posting.frequency}
+		}
+		file.Postings[word] = pairs
+	}
+
+	return json.NewEncoder(w).Encode(file)
+}
+
+func LoadIndex(r io.Reader) (*Index, error) {
+	// LoadIndex reads an index written by Index.Save.
+	var file indexFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return nil, err
+	}
+	if file.Version != indexFileVersion {
+		return nil, fmt.Errorf("unsupported search index version %d (expected %d)", file.Version, indexFileVersion)
+	}
+
+	index := &Index{Seed: file.Seed, FinishedAt: file.FinishedAt, Documents: file.Documents, postings: map[string][]posting{}}
+	for _, document := range index.Documents {
+		index.totalLength += document.Length
+	}
+	for word, pairs := range file.Postings {
+		postings := make([]posting, len(pairs))
+		for i, pair := range pairs {
+			if pair[0] < 0 || pair[0] >= len(index.Documents) {
+				return nil, fmt.Errorf("search index entry for %q points at document %d, but there are only %d", word, pair[0], len(index.Documents))
+			}
+			postings[i] = posting{document: pair[0], frequency: pair[1]}
+		}
+		index.postings[word] = postings
+	}
+	return index, nil
+}
+
+func (index *Index) SaveFile(path string) error {
+	// SaveFile saves the index to the file at path, replacing it if it already exists.
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := index.Save(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+func LoadIndexFile(path string) (*Index, error) {
+	// LoadIndexFile loads an index saved with SaveFile.
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return LoadIndex(file)
+}
+
+func IndexPath(crawlPath string) string {
+	// IndexPath is where the index of the crawl saved at crawlPath goes: alongside it, with .index before its extension
+	// (so crawl.json's index is crawl.index.json).
+	extension := filepath.Ext(crawlPath)
+	return strings.TrimSuffix(crawlPath, extension) + ".index" + extension
+}
+
+func (index *Index) Matches(c *crawl.Crawl) bool {
+	// Matches returns true if the index was built from (this version of) the crawl.
+	return index.Seed == c.Seed && index.FinishedAt.Equal(c.FinishedAt)
+}
@@ -0,0 +1,196 @@
+package search
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+func genTestCrawl(t *testing.T) (*crawl.Crawl, string) {
+	// root -> anvils, rockets, broken, soft 404; anvils -> rockets (which is already linked, so it stays put)
+	// The pages' texts are written to a text file (whose path is returned too), as a crawl would.
+	textPath := filepath.Join(t.TempDir(), "crawl.text.json")
+	texts, err := crawl.CreateTextFile(textPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := func(path string, title string, text string) *crawl.HtmlPage {
+		page := &crawl.HtmlPage{Url: &url.URL{Scheme: "https", Host: "testsite.test", Path: path}, Title: title, IsParsed: true, StatusCode: 200}
+		texts.PageText(page, text)
+		return page
+	}
+
+	// (the texts are written in a different order to the pages, as they would be by a crawl's workers)
+	rockets := page("/rockets", "Rocket skates", "Strap on a pair of rocket skates and you'll catch anything. Some assembly required.")
+	anvils := page("/anvils", "Anvils", "Our anvils come in three sizes: large, larger and falling from a great height. "+
+		"Every anvil is forged by hand, and anvils are our pride and joy.")
+	root := page("/", "Acme", "Welcome to Acme, makers of anvils and rockets since 1949.")
+	broken := page("/broken", "Anvils", "anvils anvils anvils")
+	broken.StatusCode = 500
+	broken.CrawlError = errors.New("HTTP 500")
+	soft404 := page("/gone", "Anvils", "anvils anvils anvils")
+	soft404.Soft404 = "title matches /404/"
+	empty := page("/empty", "", "")
+	if err := texts.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	root.LinksTo = []*crawl.HtmlPage{anvils, rockets, broken, soft404, empty}
+	anvils.LinksTo = []*crawl.HtmlPage{rockets}
+
+	return &crawl.Crawl{Root: root, Seed: "https://testsite.test/", FinishedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}, textPath
+}
+
+func buildTestIndex(t *testing.T) *Index {
+	index, err := Build(genTestCrawl(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return index
+}
+
+func TestBuild(t *testing.T) {
+	index := buildTestIndex(t)
+
+	// broken pages, soft 404s and pages without any words are left out
+	var urls []string
+	for _, document := range index.Documents {
+		urls = append(urls, document.Url)
+	}
+	if strings.Join(urls, " ") != "https://testsite.test/ https://testsite.test/anvils https://testsite.test/rockets" {
+		t.Errorf("Build indexed the wrong pages: %v", urls)
+	}
+	if index.Documents[0].Length != 11 {
+		t.Errorf("expected the root page to be 11 words long (title included), got %d", index.Documents[0].Length)
+	}
+	if postings := index.postings["anvils"]; len(postings) != 2 || postings[1].document != 1 || postings[1].frequency != 3 {
+		t.Errorf("expected anvils to be in the root and (three times) in the anvils page, got %+v", postings)
+	}
+
+	// without any text, there are only the titles to go on
+	saved, _ := genTestCrawl(t)
+	titles, err := Build(saved, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(titles.Documents) != 3 || titles.Documents[0].Length != 1 || len(titles.Search("anvils", 0)) != 1 {
+		t.Errorf("expected an index of just the titles, got %+v", titles.Documents)
+	}
+	if _, err := Build(saved, filepath.Join(t.TempDir(), "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a missing text file to be reported, got %v", err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	index := buildTestIndex(t)
+
+	results := index.Search("anvils", 0)
+	if len(results) != 2 || results[0].Url != "https://testsite.test/anvils" || results[1].Url != "https://testsite.test/" {
+		t.Fatalf("expected the anvils page to rank above the root for anvils, got %+v", results)
+	}
+	if results[0].Score <= results[1].Score || results[1].Score <= 0 {
+		t.Errorf("expected descending, positive scores, got %f and %f", results[0].Score, results[1].Score)
+	}
+
+	// matching more of the query counts for more, and case and punctuation don't matter
+	results = index.Search("ROCKET skates!", 0)
+	if len(results) != 1 || results[0].Url != "https://testsite.test/rockets" || results[0].Title != "Rocket skates" {
+		t.Errorf("expected only the rockets page for rocket skates, got %+v", results)
+	}
+	results = index.Search("acme rockets", 0)
+	if len(results) != 1 || results[0].Url != "https://testsite.test/" {
+		t.Errorf("expected only the root for acme rockets (rocket isn't rockets), got %+v", results)
+	}
+
+	// a word repeated in the query doesn't count twice
+	once, twice := index.Search("anvils", 0), index.Search("anvils anvils", 0)
+	if once[0].Score != twice[0].Score {
+		t.Errorf("repeating a query word changed the score from %f to %f", once[0].Score, twice[0].Score)
+	}
+
+	if results := index.Search("anvils", 1); len(results) != 1 {
+		t.Errorf("expected the limit to cut the results down to 1, got %d", len(results))
+	}
+	if results := index.Search("nothing matches this", 0); len(results) != 0 {
+		t.Errorf("expected no results, got %+v", results)
+	}
+	if empty, _ := Build(&crawl.Crawl{Root: &crawl.HtmlPage{Url: &url.URL{Path: "/"}}}, ""); len(empty.Search("anvils", 0)) != 0 {
+		t.Error("expected an empty index to find nothing")
+	}
+}
+
+func TestSaveIndex(t *testing.T) {
+	saved, textPath := genTestCrawl(t)
+	index, err := Build(saved, textPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buffer bytes.Buffer
+	if err := index.Save(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadIndex(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !loaded.Matches(saved) || loaded.Words() != index.Words() || len(loaded.Documents) != len(index.Documents) {
+		t.Error("the index didn't survive saving")
+	}
+	expected, actual := index.Search("anvils rockets", 0), loaded.Search("anvils rockets", 0)
+	if len(expected) != len(actual) {
+		t.Fatalf("expected %d results from the loaded index, got %d", len(expected), len(actual))
+	}
+	for i := range expected {
+		if expected[i] != actual[i] {
+			t.Errorf("result %d changed after saving: %+v became %+v", i, expected[i], actual[i])
+		}
+	}
+
+	// an index only matches the crawl it was built from
+	recrawled, _ := genTestCrawl(t)
+	recrawled.FinishedAt = recrawled.FinishedAt.Add(time.Hour)
+	if loaded.Matches(recrawled) {
+		t.Error("an index shouldn't match a newer version of its crawl")
+	}
+
+	// and to disk
+	path := filepath.Join(t.TempDir(), "crawl.index.json")
+	if err := index.SaveFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if fromFile, err := LoadIndexFile(path); err != nil || fromFile.Words() != index.Words() {
+		t.Errorf("the index didn't survive saving to a file: %v", err)
+	}
+
+	if _, err := LoadIndex(strings.NewReader(`{"version": 99}`)); err == nil {
+		t.Error("LoadIndex accepted an index from the future")
+	}
+	if _, err := LoadIndex(strings.NewReader(`{"version": 1, "documents": [], "postings": {"anvils": [[3, 1]]}}`)); err == nil {
+		t.Error("LoadIndex accepted a posting for a document which doesn't exist")
+	}
+	if _, err := LoadIndexFile(filepath.Join(t.TempDir(), "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a missing index file to be reported as such, got %v", err)
+	}
+}
+
+func TestIndexPath(t *testing.T) {
+	for crawlPath, expected := range map[string]string{
+		"crawl.json":           "crawl.index.json",
+		"/tmp/site/crawl.json": "/tmp/site/crawl.index.json",
+		"crawl":                "crawl.index",
+		"site.v2/crawl":        "site.v2/crawl.index",
+	} {
+		if actual := IndexPath(crawlPath); actual != expected {
+			t.Errorf("IndexPath(%q): expected %q, got %q", crawlPath, expected, actual)
+		}
+	}
+}
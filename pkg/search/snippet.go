@@ -0,0 +1,95 @@
+package search
+
+import (
+	"strings"
+
+	"github.com/luaduck/creepycrawler/pkg/crawl"
+)
+
+// DefaultSnippetWords is how long a snippet is, in words
+const DefaultSnippetWords = 30
+
+func Snippet(text string, query string, length int) string {
+	// Snippet picks the run of (at most) length words from text which contains the most of the query's words,
+	// so that a search result can show why it matched. The earliest run wins a tie, and if none of the query's words
+	// are in the text (it matched on its title), it's just the start of the text. Cut off ends are marked with an ellipsis.
+	if length <= 0 {
+		length = DefaultSnippetWords
+	}
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	wanted := map[string]bool{}
+	for _, word := range crawl.Words(query) {
+		wanted[word] = true
+	}
+	// which of the query's words each field has in it (a field like "crawler's" can be more than one word)
+	matches := make([][]string, len(fields))
+	for i, field := range fields {
+		for _, word := range crawl.Words(field) {
+			if wanted[word] {
+				matches[i] = append(matches[i], word)
+			}
+		}
+	}
+
+	// slide a window along the fields, keeping count of how many times each query word is in it
+	best, bestDistinct := 0, 0
+	counts := map[string]int{}
+	distinct := 0
+	for end := 0; end < len(fields); end++ {
+		for _, word := range matches[end] {
+			if counts[word] == 0 {
+				distinct++
+			}
+			counts[word]++
+		}
+		start := end - length + 1
+		if start > 0 {
+			for _, word := range matches[start-1] {
+				counts[word]--
+				if counts[word] == 0 {
+					distinct--
+				}
+			}
+		}
+		if start < 0 {
+			start = 0
+		}
+		if distinct > bestDistinct {
+			best, bestDistinct = start, distinct
+		}
+	}
+
+	// the window which first reached the most query words ends on one of them, so move it along to lead with them instead
+	// (with a little of what comes before, for context), pulling it back if that runs off the end of the text
+	for first := best; first < best+length && first < len(fields); first++ {
+		if len(matches[first]) > 0 {
+			if lead := first - length/4; lead > best {
+				best = lead
+			}
+			break
+		}
+	}
+	if best+length > len(fields) {
+		best = len(fields) - length
+		if best < 0 {
+			best = 0
+		}
+	}
+
+	end := best + length
+	if end > len(fields) {
+		end = len(fields)
+	}
+	snippet := strings.Join(fields[best:end], " ")
+	if best > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(fields) {
+		snippet += "…"
+	}
+	return snippet
+}
@@ -0,0 +1,46 @@
+package search
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSnippet(t *testing.T) {
+	text := "one two three four five six seven eight nine ten. Eleven twelve thirteen, fourteen fifteen."
+
+	for _, test := range []struct {
+		query    string
+		length   int
+		expected string
+	}{
+		// the run with the most (different) query words wins, with an ellipsis wherever the text is cut off
+		{"seven", 3, "…seven eight nine…"},
+		{"two nine ten", 3, "…nine ten. Eleven…"},
+		{"one", 3, "one two three…"},
+		{"FOURTEEN", 3, "…thirteen, fourteen fifteen."},
+		// the earliest run wins a tie
+		{"two eleven", 3, "…two three four…"},
+		// nothing matching (or an empty query) just gives the start of the text
+		{"anvils", 3, "one two three…"},
+		{"", 3, "one two three…"},
+		// if the whole text fits, it's all there
+		{"seven", 100, text},
+		{"fifteen", 16, text},
+		// a longer snippet leads with a little context before the first match
+		{"seven", 8, "…five six seven eight nine ten. Eleven twelve…"},
+	} {
+		if actual := Snippet(text, test.query, test.length); actual != test.expected {
+			t.Errorf("Snippet(%q, %d): expected %q, got %q", test.query, test.length, test.expected, actual)
+		}
+	}
+
+	if Snippet("", "anything", 3) != "" || Snippet("   ", "anything", 3) != "" {
+		t.Error("expected no snippet for a page without any text")
+	}
+
+	// the default length kicks in without one
+	long := strings.Repeat("word ", 100)
+	if words := len(strings.Fields(Snippet(long, "word", 0))); words != DefaultSnippetWords {
+		t.Errorf("expected a snippet of %d words by default, got %d", DefaultSnippetWords, words)
+	}
+}